@@ -0,0 +1,242 @@
+/*
+Low-level scanning primitives used by the tokenizer of
+"github.com/mitranim/sqlp", exported for tools which need to scan SQL-like text
+in the same way, such as log parsers and migration tools, without depending on
+the full tokenizer. All functions operate on bytes, never allocate, and never
+panic. All delimiters are ASCII, which means scanning is correct for UTF-8
+text, since ASCII bytes never occur inside multi-byte characters.
+
+Functions with the "Prefix" prefix return the longest matching prefix of the
+input, which may be empty. Functions with the "Between" prefix scan a
+delimited span at the start of the input, such as a quoted string or a block
+comment, and return its length, including the delimiters, and true if the
+span is terminated. When the input doesn't begin with the opening delimiter,
+they return 0 and false. When the closing delimiter is missing, they return
+the length of the input and false.
+
+Example:
+
+	src := `'it''s' rest`
+	size, ok := scan.Between(src, '\'', '\'')
+	// 4, true: the quote "'it'", followed by another quote "'s'"
+*/
+package scan
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Set of bytes, used for fast classification of characters. The predefined sets,
+such as `Ident`, must not be modified. The functions of this package and the
+tokenizer use private copies of the sets, which means modifying them doesn't
+affect scanning, but may affect other code using them. To make a custom set,
+start with `new(Charset)` and use the "Add" methods, or copy a predefined set
+via `Charset.AddSet`.
+*/
+type Charset [256]bool
+
+// True if the byte is in the set.
+func (self *Charset) Has(val byte) bool { return self[val] }
+
+// Adds the bytes of the string. Returns the same set, for chaining.
+func (self *Charset) AddStr(vals string) *Charset {
+	for i := 0; i < len(vals); i++ {
+		self[vals[i]] = true
+	}
+	return self
+}
+
+// Adds the bytes between `min` and `max`, inclusive. Returns the same set, for
+// chaining.
+func (self *Charset) AddRange(min, max byte) *Charset {
+	for i := int(min); i <= int(max); i++ {
+		self[i] = true
+	}
+	return self
+}
+
+// Adds the bytes of another set. Returns the same set, for chaining.
+func (self *Charset) AddSet(vals *Charset) *Charset {
+	for i, val := range vals {
+		if val {
+			self[i] = true
+		}
+	}
+	return self
+}
+
+// Predefined sets. Must not be modified.
+var (
+	// Decimal digits.
+	DigitDec = new(Charset).AddStr(`0123456789`)
+
+	// Octal digits.
+	DigitOct = new(Charset).AddStr(`01234567`)
+
+	// Hexadecimal digits in either case.
+	DigitHex = new(Charset).AddSet(DigitDec).AddStr(`ABCDEFabcdef`)
+
+	// Characters which may begin an unquoted identifier: ASCII letters and "_".
+	IdentStart = new(Charset).AddStr(`ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_`)
+
+	// Characters which may continue an unquoted identifier: `IdentStart` and
+	// decimal digits.
+	Ident = new(Charset).AddSet(IdentStart).AddSet(DigitDec)
+
+	// Like `Ident`, and "$", which is allowed in identifiers in some dialects,
+	// such as Postgres and Oracle.
+	IdentDollar = new(Charset).AddSet(Ident).AddStr(`$`)
+
+	// Bytes of non-ASCII characters in UTF-8, and of non-ASCII characters in
+	// single-byte encodings.
+	NonASCII = new(Charset).AddRange(0x80, 0xff)
+
+	// Characters which may occur inside an identifier in any dialect:
+	// `IdentDollar` and `NonASCII`.
+	IdentLike = new(Charset).AddSet(IdentDollar).AddSet(NonASCII)
+
+	// Whitespace other than newlines: space, tab, vertical tab.
+	Space = new(Charset).AddStr(" \t\v")
+
+	// Newline characters.
+	Newline = new(Charset).AddStr("\r\n")
+
+	// Whitespace recognized by the tokenizer: `Space` and `Newline`.
+	Whitespace = new(Charset).AddSet(Space).AddSet(Newline)
+)
+
+// Private copies of the predefined sets used by this package. See `Charset`.
+var (
+	digitDec   = *new(Charset).AddSet(DigitDec)
+	identStart = *new(Charset).AddSet(IdentStart)
+	ident      = *new(Charset).AddSet(Ident)
+)
+
+// Returns the longest prefix consisting of bytes in the set.
+func PrefixWith(str string, chars *Charset) string {
+	for i := 0; i < len(str); i++ {
+		if !chars.Has(str[i]) {
+			return str[:i]
+		}
+	}
+	return str
+}
+
+// Returns the longest prefix consisting of decimal digits.
+func PrefixDigits(str string) string { return PrefixWith(str, &digitDec) }
+
+/*
+Returns the unquoted identifier at the start of the string, such as "some_name"
+in "some_name = 10", or an empty string. The identifier must begin with a
+character from `IdentStart`, and may continue with characters from `Ident`.
+*/
+func PrefixIdent(str string) string { return PrefixIdentWith(str, &ident) }
+
+/*
+Similar to `PrefixIdent`, but uses the given set for characters other than the
+first, for example `IdentDollar` for dialects which allow "$" in identifiers.
+*/
+func PrefixIdentWith(str string, chars *Charset) string {
+	if str == `` || !identStart.Has(str[0]) {
+		return ``
+	}
+	return str[:1+len(PrefixWith(str[1:], chars))]
+}
+
+/*
+Returns the opening delimiter of a Postgres dollar-quoted string, such as "$$"
+or "$tag$", at the start of the string, or an empty string. The tag follows the
+rules of `PrefixIdent`. The closing delimiter is the same as the opening one;
+see `BetweenStrings`.
+*/
+func DollarDelim(str string) string {
+	if !(len(str) >= 2 && str[0] == '$') {
+		return ``
+	}
+	size := 1 + len(PrefixIdent(str[1:]))
+	if size < len(str) && str[size] == '$' {
+		return str[:size+1]
+	}
+	return ``
+}
+
+// True if the string consists only of ASCII characters.
+func IsASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Scans a span which begins with `open` and ends with the next `close`, such as
+a quoted identifier "some name", or 'text' in dialects where a doubled quote
+is an escape, which is the same as two adjacent spans. See the package
+description for the return values.
+*/
+func Between(str string, open, close byte) (int, bool) {
+	if str == `` || str[0] != open {
+		return 0, false
+	}
+	index := strings.IndexByte(str[1:], close)
+	if index < 0 {
+		return len(str), false
+	}
+	return index + 2, true
+}
+
+/*
+Similar to `Between`, but skips any byte preceded by a backslash, for strings
+with C-style escapes such as 'it\'s' in MySQL and BigQuery.
+*/
+func BetweenEscaped(str string, open, close byte) (int, bool) {
+	if str == `` || str[0] != open {
+		return 0, false
+	}
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case close:
+			return i + 1, true
+		case '\\':
+			i++
+		}
+	}
+	return len(str), false
+}
+
+// Similar to `Between`, but with multi-byte delimiters, such as a block
+// comment "/* text */" or a dollar-quoted string. The closing delimiter is
+// searched for after the opening one, which means they may not overlap.
+func BetweenStrings(str, open, close string) (int, bool) {
+	if open == `` || !strings.HasPrefix(str, open) {
+		return 0, false
+	}
+	index := strings.Index(str[len(open):], close)
+	if index < 0 {
+		return len(str), false
+	}
+	return len(open) + index + len(close), true
+}
+
+/*
+Similar to `BetweenStrings`, but skips any byte preceded by a backslash, for
+strings with C-style escapes such as BigQuery triple-quoted strings.
+*/
+func BetweenStringsEscaped(str, open, close string) (int, bool) {
+	if open == `` || !strings.HasPrefix(str, open) {
+		return 0, false
+	}
+	for i := len(open); i < len(str); i++ {
+		if strings.HasPrefix(str[i:], close) {
+			return i + len(close), true
+		}
+		if str[i] == '\\' {
+			i++
+		}
+	}
+	return len(str), false
+}
@@ -0,0 +1,114 @@
+package scan
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCharset(_ *testing.T) {
+	set := new(Charset).AddStr(`ab`).AddRange('0', '2').AddSet(Space)
+	eq(true, set.Has('a'))
+	eq(true, set.Has('b'))
+	eq(false, set.Has('c'))
+	eq(true, set.Has('1'))
+	eq(false, set.Has('3'))
+	eq(true, set.Has(' '))
+	eq(false, set.Has('\n'))
+
+	eq(true, NonASCII.Has(0x80))
+	eq(true, NonASCII.Has(0xff))
+	eq(false, NonASCII.Has(0x7f))
+	eq(true, IdentLike.Has('$'))
+	eq(false, Ident.Has('$'))
+	eq(true, Whitespace.Has('\r'))
+}
+
+func TestCharset_modified(_ *testing.T) {
+	prevDigits, prevIdent := *DigitDec, *Ident
+	defer func() { *DigitDec, *Ident = prevDigits, prevIdent }()
+
+	DigitDec.AddStr(`a`)
+	Ident.AddStr(`-`)
+
+	eq(`1`, PrefixDigits(`1a`))
+	eq(`one`, PrefixIdent(`one-two`))
+}
+
+func TestPrefix(_ *testing.T) {
+	eq(``, PrefixDigits(``))
+	eq(``, PrefixDigits(`a1`))
+	eq(`123`, PrefixDigits(`123a`))
+	eq(`123`, PrefixDigits(`123`))
+
+	eq(``, PrefixIdent(``))
+	eq(``, PrefixIdent(`1a`))
+	eq(``, PrefixIdent(`$a`))
+	eq(`a`, PrefixIdent(`a`))
+	eq(`some_name1`, PrefixIdent(`some_name1 = 10`))
+	eq(`some`, PrefixIdent(`some$name`))
+	eq(`some$name`, PrefixIdentWith(`some$name`, IdentDollar))
+	eq(`_один`, PrefixIdentWith(`_один.два`, IdentLike))
+	eq(`ab`, PrefixWith(`abc`, new(Charset).AddStr(`ab`)))
+
+	eq(``, DollarDelim(``))
+	eq(``, DollarDelim(`$`))
+	eq(``, DollarDelim(`$1`))
+	eq(``, DollarDelim(`$tag`))
+	eq(`$$`, DollarDelim(`$$text$$`))
+	eq(`$tag$`, DollarDelim(`$tag$text$tag$`))
+
+	eq(true, IsASCII(``))
+	eq(true, IsASCII(`select 1`))
+	eq(false, IsASCII(`select 'один'`))
+}
+
+func TestBetween(_ *testing.T) {
+	test := func(expSize int, expOk bool) func(int, bool) {
+		return func(size int, ok bool) {
+			eq(expSize, size)
+			eq(expOk, ok)
+		}
+	}
+
+	test(0, false)(Between(``, '\'', '\''))
+	test(0, false)(Between(`text`, '\'', '\''))
+	test(2, true)(Between(`''`, '\'', '\''))
+	test(4, true)(Between(`'it''s'`, '\'', '\''))
+	test(10, true)(Between(`'один' rest`, '\'', '\''))
+	test(4, false)(Between(`'one`, '\'', '\''))
+	test(5, true)(Between(`[one]`, '[', ']'))
+
+	test(0, false)(BetweenEscaped(`text`, '\'', '\''))
+	test(7, true)(BetweenEscaped(`'it\'s' rest`, '\'', '\''))
+	test(4, true)(BetweenEscaped(`'\\'`, '\'', '\''))
+	test(5, false)(BetweenEscaped(`'one\`, '\'', '\''))
+	test(6, false)(BetweenEscaped(`'one\'`, '\'', '\''))
+
+	test(0, false)(BetweenStrings(`text`, `/*`, `*/`))
+	test(0, false)(BetweenStrings(`text`, ``, `*/`))
+	test(4, true)(BetweenStrings(`/**/`, `/*`, `*/`))
+	test(3, false)(BetweenStrings(`/*/`, `/*`, `*/`))
+	test(9, true)(BetweenStrings(`/* one */ rest */`, `/*`, `*/`))
+	test(14, true)(BetweenStrings(`$a$ $$ one $a$ rest`, `$a$`, `$a$`))
+
+	test(0, false)(BetweenStringsEscaped(`text`, `'''`, `'''`))
+	test(12, true)(BetweenStringsEscaped(`'''a\'''b''' rest`, `'''`, `'''`))
+	test(10, false)(BetweenStringsEscaped(`'''a\''' b`, `'''`, `'''`))
+	test(9, true)(BetweenStringsEscaped(`'''a\\''' rest`, `'''`, `'''`))
+}
+
+func eq(exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		panic(fmt.Errorf(`
+expected (detailed):
+	%#[1]v
+actual (detailed):
+	%#[2]v
+expected (simple):
+	%[1]s
+actual (simple):
+	%[2]s
+`, exp, act))
+	}
+}
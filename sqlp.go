@@ -15,6 +15,8 @@ parameter placeholders: `$1` or `:ident`, or code encased in delimiters: `()`
 
 • :: : Postgres-style cast operator (non-standard).
 
+• $$ : Postgres-style dollar quotes, optionally tagged: $tag$ $tag$.
+
 In addition, it supports the following:
 
 • ()          : content in parens.
@@ -37,6 +39,16 @@ This library supports incremental parsing token by token, via `Tokenizer`. It
 also lets you convert a sequence of tokens into a fully-built AST via `Parser`.
 Choose the approach that better suits your use case.
 
+Deterministic Output
+
+Functions which accept maps, such as `BindNamed`, `FillSlots`, `ExpandEach`,
+`WithMeta`, `WithComment`, and `FilterSoftDeleted`, never depend on the
+iteration order of those maps. Their output, including error messages, is
+determined by the AST and by the sorted keys, which keeps generated SQL stable
+across runs. This matters for prepared statement caches, which are keyed by
+query text, and for golden tests. This is a permanent guarantee, which also
+applies to any such functions added in the future.
+
 Usage
 
 Oversimplified example:
@@ -151,6 +163,26 @@ func DeepWalkNode(val Node, fun func(Node)) {
 	fun(val)
 }
 
+/*
+Similar to `DeepWalkNode`, but invokes the function for node pointers rather
+than node values. Allows AST editing.
+*/
+func DeepWalkNodePtr(val *Node, fun func(*Node)) {
+	if val == nil || *val == nil || fun == nil {
+		return
+	}
+
+	impl, _ := (*val).(PtrWalker)
+	if impl != nil {
+		impl.WalkNodePtr(func(val *Node) {
+			DeepWalkNodePtr(val, fun)
+		})
+		return
+	}
+
+	fun(val)
+}
+
 // Makes a copy that should be safe to modify without affecting the original.
 func CopyNode(node Node) Node {
 	impl, _ := node.(Copier)
@@ -0,0 +1,257 @@
+package sqlp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Returns the names by which tables in FROM and JOIN clauses of SELECT statements
+are referred to in qualified column references: the alias, or for tables
+without an alias, the table name without the schema. Includes subqueries and
+CTEs, in the same order as `Tables`, without duplicates. Unquoted names are
+lowercased, and quoted names are preserved, like in Postgres. Derived tables
+without an alias are not included. Returns an error if a FROM clause has an
+unsupported syntax.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users u join public.posts on true`)
+	...
+	aliases, err := sqlp.Aliases(nodes)
+	// []string{"u", "posts"}
+*/
+func Aliases(nodes Nodes) (_ []string, err error) {
+	defer rec(&err)
+
+	var out []string
+	var walk func(Nodes)
+	walk = func(nodes Nodes) {
+		normalized := normalizeFrom(nodes)
+		for _, item := range fromItems(normalized) {
+			name := item.qualName(normalized)
+			if name != `` && !hasString(out, name) {
+				out = append(out, name)
+			}
+		}
+		for _, node := range nodes {
+			if paren, ok := node.(ParenNodes); ok {
+				walk(Nodes(paren))
+			}
+		}
+	}
+
+	walk(nodes)
+	return out, nil
+}
+
+/*
+Renames a table alias, as reported by `Aliases`, in its declaration and in all
+qualified column references such as "t.id" and `"t".id` within the statement
+that declares it, including subqueries, except for subqueries which declare
+the same alias, shadowing the outer one. For a table without an alias, such as
+"from users", adds the alias, as in "from users as u", and renames references
+such as "users.id". If the alias is declared in several sibling subqueries,
+renames it in each of them. The alias must be given as reported by `Aliases`,
+with unquoted names lowercased. The new name is quoted when it's not a plain
+lowercase identifier. Doesn't modify the input.
+
+Unqualified references to a table, such as "row_to_json(t)", are not renamed,
+since they're indistinguishable from column names.
+
+Returns an error if the alias is not found, if the new name is empty, if the new
+name is already used in the same FROM clause, or if a FROM clause has an
+unsupported syntax.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select u.id from users u where u.name = $1`)
+	...
+	nodes, err = sqlp.RenameAlias(nodes, `u`, `users_1`)
+	// select users_1.id from users users_1 where users_1.name = $1
+*/
+func RenameAlias(nodes Nodes, from, to string) (_ Nodes, err error) {
+	defer rec(&err)
+
+	if to == `` {
+		panic(fmt.Errorf(`[sqlp] can't rename alias %q to empty name`, from))
+	}
+
+	self := aliasRenamer{from: from, to: to}
+	out := self.search(nodes.CopyNodes())
+	if !self.found {
+		panic(fmt.Errorf(`[sqlp] alias %q not found`, from))
+	}
+	return out, nil
+}
+
+// Describes an alias renamed by `FillSlotsRenaming` to avoid a collision.
+type AliasRename struct {
+	Slot string
+	From string
+	To   string
+}
+
+// Returns the alias with the first numeric suffix not used by any of the lists.
+func freeAlias(alias string, lists ...[]string) string {
+outer:
+	for i := 2; ; i++ {
+		out := alias + `_` + strconv.Itoa(i)
+		for _, list := range lists {
+			if hasString(list, out) {
+				continue outer
+			}
+		}
+		return out
+	}
+}
+
+type aliasRenamer struct {
+	from  string
+	to    string
+	found bool
+}
+
+// Finds the levels which declare the alias, and renames it in them.
+func (self *aliasRenamer) search(nodes Nodes) Nodes {
+	nodes = normalizeFrom(nodes)
+	items := fromItems(nodes)
+
+	for _, item := range items {
+		if item.qualName(nodes) != self.from {
+			continue
+		}
+		for _, other := range items {
+			if other.qualName(nodes) == self.to {
+				panic(fmt.Errorf(`[sqlp] can't rename alias %q to %q: name already used`, self.from, self.to))
+			}
+		}
+
+		self.found = true
+		return self.refs(self.declare(nodes, item))
+	}
+
+	for i, node := range nodes {
+		if paren, ok := node.(ParenNodes); ok {
+			nodes[i] = ParenNodes(self.search(Nodes(paren)))
+		}
+	}
+	return nodes
+}
+
+func (self *aliasRenamer) declare(nodes Nodes, item fromItem) Nodes {
+	if item.aliasIndex > 0 {
+		nodes[item.aliasIndex] = aliasNode(self.to)
+		return nodes
+	}
+
+	out := make(Nodes, 0, len(nodes)+4)
+	out = append(out, nodes[:item.nameEnd]...)
+	out = append(out, nodeWhitespaceSingle, NodeText(`as`), nodeWhitespaceSingle, aliasNode(self.to))
+	return append(out, nodes[item.nameEnd:]...)
+}
+
+// Renames qualified references, skipping subqueries which shadow the alias.
+func (self *aliasRenamer) refs(nodes Nodes) Nodes {
+	out := make(Nodes, 0, len(nodes))
+
+	for i, node := range nodes {
+		switch node := node.(type) {
+		case NodeText:
+			out = self.text(out, string(node))
+		case NodeKeyword, NodeIdent:
+			if name, _ := identName(node); name == self.from && strings.HasPrefix(nodeText(nodeAt(nodes, i+1)), `.`) && !strings.HasSuffix(nodeText(nodeAt(nodes, i-1)), `.`) {
+				out = append(out, aliasNode(self.to))
+			} else {
+				out = append(out, node)
+			}
+		case NodeQuoteDouble:
+			if name, _ := identName(node); name == self.from && strings.HasPrefix(nodeText(nodeAt(nodes, i+1)), `.`) {
+				out = append(out, aliasNode(self.to))
+			} else {
+				out = append(out, node)
+			}
+		case ParenNodes:
+			if self.shadows(Nodes(node)) {
+				out = append(out, node)
+			} else {
+				out = append(out, ParenNodes(self.refs(Nodes(node))))
+			}
+		case BracketNodes:
+			out = append(out, BracketNodes(self.refs(Nodes(node))))
+		default:
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func (self *aliasRenamer) shadows(nodes Nodes) bool {
+	nodes = normalizeFrom(nodes)
+	for _, item := range fromItems(nodes) {
+		if item.qualName(nodes) == self.from {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Renames qualifiers such as "t." in text, which may contain several references
+and operators, as in "t.a=t.b". Identifiers preceded by a dot, as in "s.t.a",
+are not qualifiers of column references.
+*/
+func (self *aliasRenamer) text(out Nodes, str string) Nodes {
+	alias := aliasNode(self.to)
+	plain, _ := alias.(NodeText)
+	var buf strings.Builder
+	var prev int
+
+	for i := 0; i < len(str); i++ {
+		if i > 0 && (charsetIdentLike.has(str[i-1]) || str[i-1] == '.') {
+			continue
+		}
+
+		ident := prefixIdentWith(str[i:], charsetIdentLike)
+		end := i + len(ident)
+		if ident == `` || end >= len(str) || str[end] != '.' || strings.ToLower(ident) != self.from {
+			continue
+		}
+
+		buf.WriteString(str[prev:i])
+		if plain != `` {
+			buf.WriteString(string(plain))
+		} else {
+			if buf.Len() > 0 {
+				out = append(out, NodeText(buf.String()))
+				buf.Reset()
+			}
+			out = append(out, alias)
+		}
+		prev = end
+		i = end
+	}
+
+	if prev == 0 {
+		return append(out, NodeText(str))
+	}
+	buf.WriteString(str[prev:])
+	if buf.Len() > 0 {
+		out = append(out, NodeText(buf.String()))
+	}
+	return out
+}
+
+// Alias as an identifier node, quoted unless it's a plain lowercase identifier.
+func aliasNode(name string) Node {
+	if val, ok := identName(NodeText(name)); ok && val == name {
+		return NodeText(name)
+	}
+	return quoteIdentPart(name)
+}
+
+func nodeText(node Node) string {
+	text, _ := node.(NodeText)
+	return string(text)
+}
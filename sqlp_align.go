@@ -0,0 +1,227 @@
+package sqlp
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Vertically aligns casts `::` and comparison operators such as `=`, `<>`, and
+IS, across consecutive lines, by adjusting the whitespace which precedes them.
+Intended for hand-formatted queries with many parameters, where each line of a
+WHERE block repeats the same pattern, for example:
+
+	and (:one   :: text is null or col_one   = :one)
+	and (:two   :: int  is null or col_two   = :two)
+	and (:three :: date is null or col_three = :three)
+
+A block is a run of consecutive lines, where every line has at least one
+operator preceded by whitespace on the same line. Operators without preceding
+whitespace, such as in "a::int", are left as-is. Within a block, the Nth
+operator on each line is aligned with the Nth operator on other lines, as long
+as the preceding operators on those lines are of the same kinds. Existing
+padding is normalized: an aligned operator is preceded by at least one space.
+
+Columns are counted in characters, and tabs count as one character, so lines in
+a block should have the same indentation. Only whitespace is changed; the
+result is otherwise identical to the input. Doesn't modify the input.
+*/
+func Align(nodes Nodes) Nodes {
+	out := nodes.CopyNodes()
+
+	var aligner aligner
+	for i := range out {
+		aligner.walk(&out[i])
+	}
+	aligner.align()
+	return out
+}
+
+const (
+	alignNone byte = iota
+	alignCast
+	alignCompare
+)
+
+// Leaf node or collection delimiter, in serialization order.
+type alignItem struct {
+	ptr  *Node
+	text string
+	kind byte
+}
+
+type aligner struct {
+	items []alignItem
+	cols  []int
+}
+
+func (self *aligner) walk(ptr *Node) {
+	node := *ptr
+	if node == nil {
+		return
+	}
+
+	impl, _ := node.(PtrWalker)
+	if impl == nil {
+		self.items = append(self.items, alignItem{ptr, node.String(), alignKind(node)})
+		return
+	}
+
+	str := node.String()
+	start := nodeInnerOffset(node)
+	end := start
+	self.items = append(self.items, alignItem{text: str[:start]})
+
+	impl.WalkNodePtr(func(ptr *Node) {
+		end += nodeLen(*ptr)
+		self.walk(ptr)
+	})
+	self.items = append(self.items, alignItem{text: str[end:]})
+}
+
+func (self *aligner) align() {
+	for _, block := range self.blocks() {
+		for pos := 0; ; pos++ {
+			self.layout()
+			if !self.alignAt(block, pos) {
+				break
+			}
+		}
+	}
+}
+
+/*
+Aligns the anchors at the given position on each line of the block, grouping
+lines by the kinds of anchors up to that position. Returns false if no line has
+an anchor at that position.
+*/
+func (self *aligner) alignAt(block [][]int, pos int) bool {
+	groups := map[string][]int{}
+	var keys []string
+
+	for _, line := range block {
+		if pos >= len(line) {
+			continue
+		}
+
+		buf := make([]byte, pos+1)
+		for i := range buf {
+			buf[i] = self.items[line[i]].kind
+		}
+
+		key := string(buf)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], line[pos])
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+
+		// Columns of the anchors after normalizing their padding to one space.
+		cols := make([]int, len(group))
+		target := 0
+		for i, index := range group {
+			cols[i] = self.cols[index] - utf8.RuneCountInString(self.items[index-1].text) + 1
+			if cols[i] > target {
+				target = cols[i]
+			}
+		}
+
+		for i, index := range group {
+			*self.items[index-1].ptr = NodeWhitespace(strings.Repeat(` `, target-cols[i]+1))
+		}
+	}
+	return len(keys) > 0
+}
+
+/*
+Groups anchors into blocks of consecutive lines, where each line has at least
+one anchor. Anchors are represented by item indexes.
+*/
+func (self *aligner) blocks() (out [][][]int) {
+	var block [][]int
+	var line []int
+
+	flushLine := func() {
+		if len(line) > 0 {
+			block = append(block, line)
+			line = nil
+			return
+		}
+		if len(block) > 0 {
+			out = append(out, block)
+			block = nil
+		}
+	}
+
+	for index, item := range self.items {
+		if self.isAnchor(index) {
+			line = append(line, index)
+		}
+		for i := strings.Count(item.text, "\n"); i > 0; i-- {
+			flushLine()
+		}
+	}
+
+	flushLine()
+	flushLine()
+	return
+}
+
+// Computes the starting column of every item, after whitespace changes.
+func (self *aligner) layout() {
+	self.cols = self.cols[:0]
+	col := 0
+
+	for index := range self.items {
+		item := &self.items[index]
+		if item.ptr != nil {
+			item.text = (*item.ptr).String()
+		}
+		self.cols = append(self.cols, col)
+
+		newline := strings.LastIndexByte(item.text, '\n')
+		if newline >= 0 {
+			col = utf8.RuneCountInString(item.text[newline+1:])
+		} else {
+			col += utf8.RuneCountInString(item.text)
+		}
+	}
+}
+
+// True if the item is an operator preceded by whitespace on the same line.
+func (self *aligner) isAnchor(index int) bool {
+	if index == 0 || self.items[index].kind == alignNone {
+		return false
+	}
+
+	prev := self.items[index-1]
+	if prev.ptr == nil {
+		return false
+	}
+
+	space, ok := (*prev.ptr).(NodeWhitespace)
+	return ok && len(space) > 0 && !strings.ContainsAny(string(space), "\r\n")
+}
+
+func alignKind(node Node) byte {
+	switch node := node.(type) {
+	case NodeDoubleColon:
+		return alignCast
+	case NodeText:
+		if hasString(alignOperators, string(node)) || isKeyword(node, alignKeywords...) {
+			return alignCompare
+		}
+	}
+	return alignNone
+}
+
+var (
+	alignOperators = []string{`=`, `<>`, `!=`, `<`, `>`, `<=`, `>=`, `@@`}
+	alignKeywords  = []string{`is`, `in`, `like`, `ilike`}
+)
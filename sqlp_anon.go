@@ -0,0 +1,299 @@
+package sqlp
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+Returns a copy of the AST with schema details and data removed, while keeping
+its structure, for sharing problematic queries in bug reports or with vendors.
+Best-effort: the result is meant for humans and query planners, and may not
+be accepted by the database as-is.
+
+Identifiers are replaced with stable placeholders: tables and their aliases
+become "t1", "t2", and so on, and other identifiers, such as columns, become
+"c1", "c2", and so on. The same identifier always gets the same placeholder.
+Unquoted identifiers are matched ignoring case, and quoted identifiers are
+matched exactly and lose their quotes. Identifiers following FROM, JOIN,
+UPDATE, INTO, and TABLE, and qualifiers such as "u" in "u.id", are considered
+tables. Keywords, type names, and function names, which are identifiers
+followed by parens without whitespace, are kept as-is.
+
+Named parameters become ":p1", ":p2", and so on. String literals become empty,
+numbers become "0", and comments are replaced with whitespace. Other nodes,
+such as ordinal parameters and casts, are kept.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select u.email from users u where u.name = 'Alice' and age > 30 -- find alice`)
+	...
+	sqlp.Anonymize(nodes)
+	// select t1.c1 from t2 t1 where t1.c2 = '' and c3 > 0
+*/
+func Anonymize(nodes Nodes) Nodes {
+	anon := anonymizer{tables: map[string]bool{}}
+	anon.nodes(nodes)
+
+	anon.collected = true
+	anon.names = map[string]string{}
+	return anon.nodes(nodes)
+}
+
+/*
+Walks the AST twice. The first walk only collects the keys of identifiers
+considered tables, which allows to use table placeholders for identifiers that
+occur before the clauses that make them tables, such as qualifiers in the
+select list. The second walk produces the output.
+*/
+type anonymizer struct {
+	collected bool
+	tables    map[string]bool
+	names     map[string]string
+	counts    [3]int
+}
+
+// State of one nesting level.
+type anonLevel struct{ from bool }
+
+func (self *anonymizer) nodes(src Nodes) Nodes {
+	var level anonLevel
+	out := make(Nodes, 0, len(src))
+
+	for i, node := range src {
+		var next Node
+		if i+1 < len(src) {
+			next = src[i+1]
+		}
+
+		switch node := node.(type) {
+		case NodeText:
+			out = append(out, NodeText(self.text(&level, string(node), next)))
+
+		case NodeIdent:
+			out = append(out, NodeIdent(self.text(&level, string(node), next)))
+
+		case NodeKeyword:
+			level.keyword(strings.ToLower(string(node)))
+			out = append(out, node)
+
+		case NodeQuoteDouble:
+			out = append(out, NodeText(self.quoted(&level, `"`+string(node), next)))
+
+		case NodeQuoteGrave:
+			out = append(out, NodeText(self.quoted(&level, `"`+string(node), next)))
+
+		case NodeQuoteSingle:
+			// Adjacent single-quoted nodes represent one string with doubled
+			// quotes.
+			if i > 0 {
+				if _, ok := src[i-1].(NodeQuoteSingle); ok {
+					continue
+				}
+			}
+			out = append(out, NodeQuoteSingle(``))
+
+		case NodeQuoteDollar:
+			out = append(out, NodeQuoteDollar{Tag: node.Tag})
+
+		case NodeQuoteSingleTriple, NodeQuoteDoubleTriple:
+			out = append(out, NodeQuoteSingle(``))
+
+		case NodeQuoteNational:
+			out = append(out, NodeQuoteNational{Lower: node.Lower})
+
+		case NodeQuoteEscape:
+			out = append(out, NodeQuoteEscape{Lower: node.Lower})
+
+		case NodeCommentLine:
+			out = append(out, anonLineComment(string(node)))
+
+		case NodeCommentHash:
+			out = append(out, anonLineComment(string(node)))
+
+		case NodeCommentBlock:
+			out = append(out, nodeWhitespaceSingle)
+
+		case NodeNamedParam:
+			out = append(out, NodeNamedParam(self.name(anonParam, `:`+string(node))))
+
+		case NodeAtParam:
+			out = append(out, NodeAtParam(self.name(anonParam, `:`+string(node))))
+
+		case NodeDollarParam:
+			out = append(out, NodeDollarParam(self.name(anonParam, `:`+string(node))))
+
+		case Nodes:
+			out = append(out, self.nodes(node))
+
+		case ParenNodes:
+			out = append(out, ParenNodes(self.nodes(Nodes(node))))
+
+		case BracketNodes:
+			out = append(out, BracketNodes(self.nodes(Nodes(node))))
+
+		case BraceNodes:
+			out = append(out, BraceNodes(self.nodes(Nodes(node))))
+
+		case DelimNodes:
+			out = append(out, DelimNodes{node.Delim, self.nodes(node.Inner)})
+
+		default:
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func anonLineComment(val string) Node {
+	if strings.HasSuffix(val, "\n") {
+		return NodeWhitespace("\n")
+	}
+	return nodeWhitespaceSingle
+}
+
+/*
+Rewrites identifiers and numbers in a text node. The next node is used to
+detect function calls and qualifiers which span nodes, such as `"Users".id`.
+*/
+func (self *anonymizer) text(level *anonLevel, src string, next Node) string {
+	var buf strings.Builder
+
+	for len(src) > 0 {
+		char := src[0]
+
+		if charsetDigitDec.has(char) {
+			size := 1
+			for size < len(src) && (charsetIdent.has(src[size]) || src[size] == '.') {
+				size++
+			}
+			buf.WriteString(`0`)
+			src = src[size:]
+			continue
+		}
+
+		if !charsetIdentStart.has(char) && !charsetNonASCII.has(char) {
+			buf.WriteByte(char)
+			src = src[1:]
+			continue
+		}
+
+		size := 1
+		for size < len(src) && charsetIdentLike.has(src[size]) {
+			size++
+		}
+		word, rest := src[:size], src[size:]
+		src = rest
+
+		lower := strings.ToLower(word)
+		if anonKeywords[lower] {
+			level.keyword(lower)
+			buf.WriteString(word)
+			continue
+		}
+
+		if !level.from && rest == `` && isParenNodes(next) {
+			buf.WriteString(word)
+			continue
+		}
+
+		buf.WriteString(self.ident(lower, level.from || isQualifier(rest, next)))
+	}
+	return buf.String()
+}
+
+func (self *anonymizer) quoted(level *anonLevel, key string, next Node) string {
+	return self.ident(key, level.from || isQualifier(``, next))
+}
+
+func (self *anonymizer) ident(key string, table bool) string {
+	if !self.collected {
+		if table {
+			self.tables[key] = true
+		}
+		return key
+	}
+	if self.tables[key] {
+		return self.name(anonTable, key)
+	}
+	return self.name(anonColumn, key)
+}
+
+const (
+	anonTable = iota
+	anonColumn
+	anonParam
+)
+
+var anonPrefixes = [...]string{anonTable: `t`, anonColumn: `c`, anonParam: `p`}
+
+func (self *anonymizer) name(kind int, key string) string {
+	if !self.collected {
+		return key
+	}
+
+	key = anonPrefixes[kind] + key
+	out, ok := self.names[key]
+	if !ok {
+		self.counts[kind]++
+		out = anonPrefixes[kind] + strconv.Itoa(self.counts[kind])
+		self.names[key] = out
+	}
+	return out
+}
+
+/*
+Tracks whether the level is in a clause where identifiers are tables. Keywords
+which may occur between tables, such as "as" and "left", keep the state.
+*/
+func (self *anonLevel) keyword(lower string) {
+	switch lower {
+	case `from`, `join`, `update`, `into`, `table`:
+		self.from = true
+	case `as`, `only`, `lateral`, `left`, `right`, `inner`, `outer`, `full`, `cross`, `natural`:
+	default:
+		self.from = false
+	}
+}
+
+// True if the identifier followed by the given text and node is a qualifier.
+func isQualifier(rest string, next Node) bool {
+	if rest != `` {
+		return rest[0] == '.'
+	}
+	text, ok := next.(NodeText)
+	return ok && strings.HasPrefix(string(text), `.`)
+}
+
+func isParenNodes(node Node) bool {
+	_, ok := node.(ParenNodes)
+	return ok
+}
+
+/*
+Keywords and type names kept by `Anonymize`. Not exhaustive; other words are
+treated as identifiers, which is the safer failure mode.
+*/
+var anonKeywords = func() map[string]bool {
+	out := map[string]bool{}
+	for _, val := range strings.Fields(`
+		all alter analyze and any array as asc between by case cast check
+		collate column conflict constraint create cross current_date
+		current_time current_timestamp default delete desc distinct do drop
+		else end escape except exists explain false fetch filter first for
+		foreign from full group having ilike in index inner insert intersect
+		interval into is join key last lateral left like limit materialized
+		natural not nothing null nulls offset on only or order outer over
+		partition primary recursive references returning right row rows
+		select set similar some table then true union unique update using
+		values when where window with
+
+		bigint bigserial bit bool boolean bytea char character date decimal
+		double float float4 float8 int int2 int4 int8 integer json jsonb
+		numeric precision real serial smallint text time timestamp
+		timestamptz uuid varchar varying zone
+	`) {
+		out[val] = true
+	}
+	return out
+}()
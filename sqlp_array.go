@@ -0,0 +1,311 @@
+package sqlp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+Converts a Go slice or array into a Postgres array literal in the text form,
+such as '{one,"two three",NULL}'. Elements are quoted and escaped as needed.
+Nested slices produce multi-dimensional arrays. Supported elements are strings,
+booleans, numbers, nil, and pointers to them; nil pointers and interfaces
+produce NULL. Returns an error for other types, and for a nil input. The
+result usually needs a cast, such as "::text[]", unless the type of the array
+can be inferred from the context.
+
+Example:
+
+	node, err := sqlp.ArrayLiteral([]string{`one`, `two three`})
+	// '{one,"two three"}'
+*/
+func ArrayLiteral(val interface{}) (_ NodeQuoteSingle, err error) {
+	defer rec(&err)
+	return NodeQuoteSingle(escapeQuoteSingle(arrayText(reqArray(val)))), nil
+}
+
+/*
+Converts a Go slice or array into a Postgres ARRAY constructor, such as
+"array['one', 'two']", with elements rendered as SQL literals. Nested slices
+produce multi-dimensional arrays, such as "array[[1, 2], [3, 4]]". Supports the
+same elements as `ArrayLiteral`. An empty array requires a cast, such as
+"array[]::text[]", which is not added automatically.
+*/
+func ArrayConstructor(val interface{}) (_ Node, err error) {
+	defer rec(&err)
+	return Nodes{NodeText(`array`), arrayConstructor(reqArray(val))}, nil
+}
+
+/*
+Parses the text form of a Postgres array, such as `{one,"two three",NULL}`, as
+returned by the database or found inside an array literal. Returns a slice
+whose elements are strings, nil for NULL, or nested slices of the same form
+for multi-dimensional arrays. Only the comma delimiter is supported, and
+explicit dimensions such as "[1:2]={...}" are not supported.
+*/
+func ParseArray(src string) (_ []interface{}, err error) {
+	defer rec(&err)
+
+	parser := arrayParser{src: src}
+	out := parser.array()
+	parser.skipSpace()
+	if parser.more() {
+		panic(parser.err(`unexpected trailing text`))
+	}
+	return out, nil
+}
+
+func reqArray(val interface{}) reflect.Value {
+	rval := reflect.ValueOf(val)
+	if !isArrayValue(rval) {
+		panic(fmt.Errorf(`[sqlp] expected slice or array, got %T`, val))
+	}
+	return rval
+}
+
+func isArrayValue(val reflect.Value) bool {
+	kind := val.Kind()
+	return kind == reflect.Array || kind == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8
+}
+
+func arrayText(val reflect.Value) string {
+	return string(appendArrayText(nil, val))
+}
+
+func appendArrayText(buf []byte, val reflect.Value) []byte {
+	buf = append(buf, braceOpen)
+	for i := 0; i < val.Len(); i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		elem := derefValue(val.Index(i))
+		if !elem.IsValid() {
+			buf = append(buf, `NULL`...)
+		} else if isArrayValue(elem) {
+			buf = appendArrayText(buf, elem)
+		} else {
+			buf = appendArrayElem(buf, scalarText(elem))
+		}
+	}
+	return append(buf, braceClose)
+}
+
+// Appends an array element, quoting it if necessary.
+func appendArrayElem(buf []byte, str string) []byte {
+	if !arrayElemNeedsQuotes(str) {
+		return append(buf, str...)
+	}
+
+	buf = append(buf, quoteDouble)
+	for i := 0; i < len(str); i++ {
+		char := str[i]
+		if char == quoteDouble || char == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, char)
+	}
+	return append(buf, quoteDouble)
+}
+
+func arrayElemNeedsQuotes(str string) bool {
+	return str == `` ||
+		strings.EqualFold(str, `null`) ||
+		strings.ContainsAny(str, "{}\",\\ \t\n\r\v\f")
+}
+
+func arrayConstructor(val reflect.Value) BracketNodes {
+	out := make(BracketNodes, 0, val.Len()*3)
+	for i := 0; i < val.Len(); i++ {
+		if i > 0 {
+			out = append(out, NodeText(`,`), nodeWhitespaceSingle)
+		}
+
+		elem := derefValue(val.Index(i))
+		if !elem.IsValid() {
+			out = append(out, NodeText(`null`))
+		} else if isArrayValue(elem) {
+			out = append(out, arrayConstructor(elem))
+		} else {
+			out = append(out, scalarNode(elem))
+		}
+	}
+	return out
+}
+
+// Dereferences pointers and interfaces. Returns an invalid value for nil.
+func derefValue(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Value{}
+		}
+		val = val.Elem()
+	}
+	return val
+}
+
+// Text form of a scalar value, as used in array literals.
+func scalarText(val reflect.Value) string {
+	switch val.Kind() {
+	case reflect.String:
+		return val.String()
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return formatFloat(val.Float(), val.Type().Bits())
+	default:
+		panic(fmt.Errorf(`[sqlp] unsupported array element type %v`, val.Type()))
+	}
+}
+
+// SQL literal of a scalar value, as used in array constructors.
+func scalarNode(val reflect.Value) Node {
+	switch val.Kind() {
+	case reflect.String:
+		return NodeQuoteSingle(escapeQuoteSingle(val.String()))
+	case reflect.Float32, reflect.Float64:
+		// Special values such as "NaN" are only valid as strings.
+		if math.IsNaN(val.Float()) || math.IsInf(val.Float(), 0) {
+			return NodeQuoteSingle(scalarText(val))
+		}
+	}
+	return NodeText(scalarText(val))
+}
+
+// Formats floats in the form understood by Postgres, including special values.
+func formatFloat(val float64, bits int) string {
+	switch {
+	case math.IsNaN(val):
+		return `NaN`
+	case math.IsInf(val, 1):
+		return `Infinity`
+	case math.IsInf(val, -1):
+		return `-Infinity`
+	default:
+		return strconv.FormatFloat(val, 'g', -1, bits)
+	}
+}
+
+// Doubles single quotes, for use in `NodeQuoteSingle`.
+func escapeQuoteSingle(str string) string {
+	return strings.ReplaceAll(str, `'`, `''`)
+}
+
+type arrayParser struct {
+	src    string
+	cursor int
+}
+
+func (self *arrayParser) array() []interface{} {
+	self.skipSpace()
+	self.req(braceOpen)
+
+	out := []interface{}{}
+	self.skipSpace()
+	if self.maybe(braceClose) {
+		return out
+	}
+
+	for {
+		self.skipSpace()
+		out = append(out, self.elem())
+		self.skipSpace()
+
+		if self.maybe(braceClose) {
+			return out
+		}
+		self.req(',')
+	}
+}
+
+func (self *arrayParser) elem() interface{} {
+	if !self.more() {
+		panic(self.err(`unexpected end of input`))
+	}
+
+	switch self.src[self.cursor] {
+	case braceOpen:
+		return self.array()
+	case quoteDouble:
+		return self.quoted()
+	default:
+		return self.unquoted()
+	}
+}
+
+func (self *arrayParser) quoted() string {
+	self.cursor++
+
+	var buf []byte
+	for self.more() {
+		char := self.src[self.cursor]
+		self.cursor++
+
+		switch char {
+		case quoteDouble:
+			return string(buf)
+		case '\\':
+			if !self.more() {
+				panic(self.err(`unexpected end of input`))
+			}
+			buf = append(buf, self.src[self.cursor])
+			self.cursor++
+		default:
+			buf = append(buf, char)
+		}
+	}
+	panic(self.err(`unterminated quoted element`))
+}
+
+func (self *arrayParser) unquoted() interface{} {
+	start := self.cursor
+	for self.more() && !strings.ContainsRune(`,{}"`, rune(self.src[self.cursor])) {
+		self.cursor++
+	}
+
+	str := strings.TrimSpace(self.src[start:self.cursor])
+	if str == `` {
+		panic(self.err(`unexpected empty element`))
+	}
+	if strings.EqualFold(str, `null`) {
+		return nil
+	}
+	return str
+}
+
+func (self *arrayParser) skipSpace() {
+	for self.more() && isArraySpace(self.src[self.cursor]) {
+		self.cursor++
+	}
+}
+
+func (self *arrayParser) maybe(char byte) bool {
+	if self.more() && self.src[self.cursor] == char {
+		self.cursor++
+		return true
+	}
+	return false
+}
+
+func (self *arrayParser) req(char byte) {
+	if !self.maybe(char) {
+		panic(self.err(fmt.Sprintf(`expected %q`, char)))
+	}
+}
+
+func (self *arrayParser) more() bool { return self.cursor < len(self.src) }
+
+func (self *arrayParser) err(msg string) error {
+	return fmt.Errorf(`[sqlp] invalid array %q at offset %v: %v`, self.src, self.cursor, msg)
+}
+
+func isArraySpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == '\v' || char == '\f'
+}
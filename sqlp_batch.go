@@ -0,0 +1,204 @@
+package sqlp
+
+import (
+	"fmt"
+)
+
+// One statement produced by `SplitInsert`, with its own arguments.
+type InsertBatch struct {
+	Nodes Nodes
+	Args  []interface{}
+}
+
+/*
+Splits a multi-row INSERT statement into several statements, each with at most
+`maxRows` rows in its VALUES clause, and requiring at most `maxPlaceholders`
+arguments, as counted by `CountPlaceholders`. A non-positive limit is ignored.
+Intended for bulk loading, where a single statement would exceed the limits
+of the database, such as `MaxPlaceholdersPostgres`. Each batch contains as
+many rows as fit. Doesn't modify the inputs.
+
+The statement must use ordinal parameters such as "$1", which are resolved
+against the given arguments; use `BindNamed` to convert named parameters
+first. In each batch, parameters are renumbered from "$1" in order of
+occurrence, and the batch gets only the arguments which it uses. Parameters
+may be repeated, and may occur in any order. Parameters before the VALUES
+clause or after the rows, for example in "on conflict ... do update", are
+repeated in every batch, counting toward its limit.
+
+Example:
+
+	nodes, err := sqlp.Parse(`insert into users (id, name) values ($1, $2), ($3, $4), ($5, $6) on conflict do nothing`)
+	...
+	batches, err := sqlp.SplitInsert(nodes, []interface{}{10, `one`, 20, `two`, 30, `three`}, 4, 0)
+	...
+	// batches[0].Nodes: insert into users (id, name) values ($1, $2), ($3, $4) on conflict do nothing
+	// batches[0].Args:  []interface{}{10, `one`, 20, `two`}
+	// batches[1].Nodes: insert into users (id, name) values ($1, $2) on conflict do nothing
+	// batches[1].Args:  []interface{}{30, `three`}
+
+Returns an error if the AST is not a single INSERT statement with a VALUES
+clause, if it contains other kinds of parameters, if a parameter has no
+corresponding argument, or if a single row doesn't fit under the placeholder
+limit.
+*/
+func SplitInsert(nodes Nodes, args []interface{}, maxPlaceholders, maxRows int) (_ []InsertBatch, err error) {
+	defer rec(&err)
+
+	if len(splitStatements(topLevel(nodes))) > 1 {
+		return nil, fmt.Errorf(`[sqlp] unsupported multiple statements`)
+	}
+	if kind := ClassifyStmt(nodes); kind != StmtKindInsert {
+		return nil, fmt.Errorf(`[sqlp] expected insert statement, got %v statement`, kind)
+	}
+
+	var self insertSplitter
+	self.init(nodes, args)
+	return self.batches(maxPlaceholders, maxRows), nil
+}
+
+type insertSplitter struct {
+	nodes  Nodes
+	args   []interface{}
+	rows   []insertRow
+	shared []NodeOrdinalParam
+	suffix int
+}
+
+// Row of a VALUES clause. The separator begins after the previous row.
+type insertRow struct {
+	sep    int
+	index  int
+	params []NodeOrdinalParam
+}
+
+func (self *insertSplitter) init(nodes Nodes, args []interface{}) {
+	self.nodes = nodes
+	self.args = args
+
+	index := indexKeyword(nodes, 0, `values`)
+	if index < 0 {
+		panic(fmt.Errorf(`[sqlp] expected insert statement with VALUES clause`))
+	}
+
+	sep := index + 1
+	index = skipTrivia(nodes, sep)
+
+	for {
+		if _, ok := nodeAt(nodes, index).(ParenNodes); !ok {
+			panic(fmt.Errorf(`[sqlp] expected row in parens after VALUES, got %v`, nodeAt(nodes, index)))
+		}
+		self.rows = append(self.rows, insertRow{sep, index, self.params(nodes[index])})
+		self.suffix = index + 1
+
+		comma := skipTrivia(nodes, index+1)
+		if nodeAt(nodes, comma) != NodeText(`,`) {
+			break
+		}
+		next := skipTrivia(nodes, comma+1)
+		if _, ok := nodeAt(nodes, next).(ParenNodes); !ok {
+			break
+		}
+		sep, index = index+1, next
+	}
+
+	self.shared = self.params(append(nodes[:self.rows[0].index:self.rows[0].index], nodes[self.suffix:]...))
+}
+
+// Distinct ordinal parameters in order of occurrence.
+func (self *insertSplitter) params(node Node) (out []NodeOrdinalParam) {
+	found := map[NodeOrdinalParam]bool{}
+
+	DeepWalkNode(node, func(node Node) {
+		switch node := node.(type) {
+		case NodeOrdinalParam:
+			if int(node) < 1 || int(node) > len(self.args) {
+				panic(fmt.Errorf(`[sqlp] missing argument for parameter %v: got %v arguments`, node, len(self.args)))
+			}
+			if !found[node] {
+				found[node] = true
+				out = append(out, node)
+			}
+		case NodeNamedParam, NodeNumericParam, NodeQuestionParam, NodeAtParam, NodeDollarParam:
+			panic(fmt.Errorf(`[sqlp] can't split insert with parameter %v: expected ordinal parameters`, node))
+		}
+	})
+	return
+}
+
+func (self *insertSplitter) batches(maxPlaceholders, maxRows int) []InsertBatch {
+	var out []InsertBatch
+
+	for start := 0; start < len(self.rows); {
+		used := map[NodeOrdinalParam]bool{}
+		for _, val := range self.shared {
+			used[val] = true
+		}
+
+		end := start
+		for end < len(self.rows) && !(maxRows > 0 && end-start >= maxRows) {
+			var added []NodeOrdinalParam
+			for _, val := range self.rows[end].params {
+				if !used[val] {
+					added = append(added, val)
+				}
+			}
+
+			if maxPlaceholders > 0 && len(used)+len(added) > maxPlaceholders {
+				if end == start {
+					panic(fmt.Errorf(
+						`[sqlp] row %v requires %v arguments, exceeding the limit of %v placeholders`,
+						end+1, len(used)+len(added), maxPlaceholders,
+					))
+				}
+				break
+			}
+
+			for _, val := range added {
+				used[val] = true
+			}
+			end++
+		}
+
+		out = append(out, self.batch(start, end))
+		start = end
+	}
+	return out
+}
+
+func (self *insertSplitter) batch(start, end int) InsertBatch {
+	nodes := self.nodes
+	out := make(Nodes, 0, len(nodes))
+	out = append(out, nodes[:self.rows[0].index]...)
+
+	for i := start; i < end; i++ {
+		row := self.rows[i]
+		if i > start {
+			out = append(out, nodes[row.sep:row.index]...)
+		}
+		out = append(out, nodes[row.index])
+	}
+	out = append(out, nodes[self.suffix:]...)
+	out = out.CopyNodes()
+
+	var args []interface{}
+	indexes := map[NodeOrdinalParam]NodeOrdinalParam{}
+
+	for i := range out {
+		DeepWalkNodePtr(&out[i], func(ptr *Node) {
+			val, ok := (*ptr).(NodeOrdinalParam)
+			if !ok {
+				return
+			}
+
+			index, ok := indexes[val]
+			if !ok {
+				args = append(args, self.args[val-1])
+				index = NodeOrdinalParam(len(args))
+				indexes[val] = index
+			}
+			*ptr = index
+		})
+	}
+	return InsertBatch{out, args}
+}
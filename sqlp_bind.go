@@ -0,0 +1,151 @@
+package sqlp
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
+
+/*
+Converts named parameters such as `:name` into ordinal parameters such as `$1`,
+and returns the corresponding arguments in order, ready for `database/sql`.
+Shortcut for `Binder.BindNamed` with the default options; see `Binder` for the
+details, including the handling of nil values and missing arguments.
+
+Example:
+
+	nodes, args, err := sqlp.BindNamed(nodes, map[string]interface{}{
+		`id`:   10,
+		`name`: `one`,
+	})
+*/
+func BindNamed(nodes Nodes, args map[string]interface{}) (Nodes, []interface{}, error) {
+	return Binder{}.BindNamed(nodes, args)
+}
+
+/*
+Options for binding named parameters; see `Binder.BindNamed`. The zero value
+is the default used by `BindNamed`.
+
+Arguments are converted as follows, before being returned:
+
+	nil                           : nil
+	nil pointer                   : nil
+	driver.Valuer                 : result of the method, see `DriverValue`
+	sql.NullString and friends    : nil when invalid, otherwise the inner value
+	other                         : as-is
+
+Nil pointers become untyped nil, which every driver understands as NULL.
+`sql.NullString` and other nullable types from "database/sql" are handled by
+`driver.Valuer`. Non-nil pointers are passed as-is, and are dereferenced by
+`database/sql`.
+*/
+type Binder struct {
+	/*
+		When true, a named parameter without a corresponding key in the
+		arguments is bound to NULL. When false, it's an error. The default is
+		false, because a missing key is usually a typo.
+	*/
+	MissingAsNull bool
+}
+
+/*
+Converts named parameters such as `:name` into ordinal parameters such as `$1`,
+and returns the corresponding arguments in order, converted as described in
+`Binder`. "At" parameters such as `@name`, used in MSSQL and MySQL, are bound
+the same way, and share arguments with `:name` of the same name. Repeated
+occurrences of the same name share one ordinal parameter and one argument.
+Arguments are ordered by the first occurrence of each parameter in the AST,
+regardless of the map. To render the result with another placeholder style,
+such as `?`, see `RenderParams`.
+
+Returns an error if a parameter has no corresponding argument, unless
+`Binder.MissingAsNull` is set, if converting an argument fails, or if the AST
+already contains ordinal or numeric parameters. Doesn't modify the input.
+*/
+func (self Binder) BindNamed(nodes Nodes, args map[string]interface{}) (_ Nodes, _ []interface{}, err error) {
+	defer rec(&err)
+
+	out := nodes.CopyNodes()
+	var vals []interface{}
+	indexes := map[string]int{}
+
+	for i := range out {
+		DeepWalkNodePtr(&out[i], func(ptr *Node) {
+			switch node := (*ptr).(type) {
+			case NodeNamedParam:
+				*ptr = self.bind(args, node, string(node), indexes, &vals)
+
+			case NodeAtParam:
+				*ptr = self.bind(args, node, string(node), indexes, &vals)
+
+			case NodeOrdinalParam, NodeNumericParam, NodeQuestionParam:
+				panic(fmt.Errorf(`[sqlp] can't bind named parameters in a query with parameter %q`, node))
+			}
+		})
+	}
+	return out, vals, nil
+}
+
+func (self Binder) bind(args map[string]interface{}, node Node, name string, indexes map[string]int, vals *[]interface{}) NodeOrdinalParam {
+	index, ok := indexes[name]
+	if !ok {
+		*vals = append(*vals, self.arg(args, node, name))
+		index = len(*vals)
+		indexes[name] = index
+	}
+	return NodeOrdinalParam(index)
+}
+
+func (self Binder) arg(args map[string]interface{}, node Node, name string) interface{} {
+	val, ok := args[name]
+	if !ok && !self.MissingAsNull {
+		panic(fmt.Errorf(`[sqlp] missing argument for named parameter %q`, node))
+	}
+
+	val = driverValue(val)
+	rval := reflect.ValueOf(val)
+	if rval.Kind() == reflect.Ptr && rval.IsNil() {
+		return nil
+	}
+	return val
+}
+
+/*
+Converts a value implementing `driver.Valuer` by calling the method, repeating
+while the result is also a `driver.Valuer`, up to a limit which detects
+cycles. Like `database/sql`, converts nil pointers to nil without calling the
+method, unless the method is defined on the pointer type. Returns other values
+as-is. Used by `BindNamed`, `Literal`, and `Interpolate`.
+*/
+func DriverValue(val interface{}) (_ interface{}, err error) {
+	defer rec(&err)
+	return driverValue(val), nil
+}
+
+const driverValueMaxDepth = 16
+
+var typeValuer = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+func driverValue(val interface{}) interface{} {
+	for depth := 0; ; depth++ {
+		impl, _ := val.(driver.Valuer)
+		if impl == nil {
+			return val
+		}
+		if depth >= driverValueMaxDepth {
+			panic(fmt.Errorf(`[sqlp] too many nested driver.Valuer conversions for %T, possible cycle`, val))
+		}
+
+		rval := reflect.ValueOf(impl)
+		if rval.Kind() == reflect.Ptr && rval.IsNil() && rval.Type().Elem().Implements(typeValuer) {
+			return nil
+		}
+
+		out, err := impl.Value()
+		if err != nil {
+			panic(fmt.Errorf(`[sqlp] failed to convert %T via driver.Valuer: %w`, val, err))
+		}
+		val = out
+	}
+}
@@ -0,0 +1,136 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Finds the body of a `CREATE FUNCTION`, `CREATE PROCEDURE`, or `CREATE TRIGGER`
+statement, and returns its region in the given source. The body may be either:
+
+	dollar-quoted  : create function ... as $$ <body> $$
+	BEGIN ... END  : create procedure ... begin <body> end
+
+For dollar-quoted bodies, the region excludes the quotes. For BEGIN ... END
+bodies, the region includes both keywords, and ends at the last END in the
+statement. Returns an error if the source is not such a statement, or if the
+body can't be found. Bodies in single-quoted strings are not supported, because
+they're escaped and can't be represented as a sub-source.
+
+The region allows to map positions in the body, for example from diagnostics,
+back to the original source. See `ParseFunctionBody` for parsing the body.
+*/
+func FunctionBody(src string) (_ Region, err error) {
+	defer rec(&err)
+
+	var body functionBody
+	body.init(src)
+	return body.region(), nil
+}
+
+/*
+Shortcut for finding the body of a function, procedure, or trigger via
+`FunctionBody`, and parsing it via `Parse`. Positions in the resulting AST are
+relative to the body, rather than to the original source. To map them, add the
+start of the region returned by `FunctionBody`.
+*/
+func ParseFunctionBody(src string) (Nodes, error) {
+	region, err := FunctionBody(src)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(region.Slice(src))
+}
+
+type functionBody struct {
+	Tokenizer
+	create bool
+	kind   bool
+	begin  int
+	end    int
+}
+
+func (self *functionBody) init(src string) {
+	self.Source = src
+	self.begin = -1
+	self.end = -1
+}
+
+func (self *functionBody) region() Region {
+	for {
+		tok := self.Token()
+		if tok.IsInvalid() {
+			break
+		}
+
+		switch tok.Type {
+		case TypeWhitespace, TypeCommentLine, TypeCommentBlock:
+		case TypeText:
+			self.text(tok.Region)
+		case TypeQuoteDollar:
+			if self.kind && self.begin < 0 {
+				delim := len(dollarDelim(tok.Slice(self.Source)))
+				return Region{tok.Region[0] + delim, tok.Region[1] - delim}
+			}
+			self.reqCreate()
+		default:
+			self.reqCreate()
+		}
+	}
+
+	self.reqCreate()
+	if !self.kind {
+		panic(fmt.Errorf(`[sqlp] expected CREATE FUNCTION, CREATE PROCEDURE, or CREATE TRIGGER`))
+	}
+	if self.begin < 0 || self.end < self.begin {
+		panic(fmt.Errorf(`[sqlp] failed to find function body: expected a dollar-quoted string or BEGIN ... END`))
+	}
+	return Region{self.begin, self.end}
+}
+
+func (self *functionBody) text(region Region) {
+	src := self.Source
+
+	for pos := region[0]; pos < region[1]; {
+		char := src[pos]
+		if !charsetIdentStart.has(char) || (pos > 0 && charsetIdent.has(src[pos-1])) {
+			self.reqCreate()
+			pos++
+			continue
+		}
+
+		word := prefixIdent(src[pos:region[1]])
+		self.word(word, pos)
+		pos += len(word)
+	}
+}
+
+func (self *functionBody) word(word string, pos int) {
+	if !self.create {
+		if !strings.EqualFold(word, `create`) {
+			panic(fmt.Errorf(`[sqlp] expected CREATE, found %q`, word))
+		}
+		self.create = true
+		return
+	}
+
+	if !self.kind {
+		self.kind = hasWordFold(functionKindWords, word)
+		return
+	}
+
+	if self.begin < 0 && strings.EqualFold(word, `begin`) {
+		self.begin = pos
+	} else if self.begin >= 0 && strings.EqualFold(word, `end`) {
+		self.end = pos + len(word)
+	}
+}
+
+func (self *functionBody) reqCreate() {
+	if !self.create {
+		panic(fmt.Errorf(`[sqlp] expected CREATE at the start of the statement`))
+	}
+}
+
+var functionKindWords = []string{`function`, `procedure`, `trigger`}
@@ -0,0 +1,113 @@
+package sqlp
+
+/*
+Returns a hash of the query suitable for keying caches of prepared statements
+or query plans. Queries which differ only in whitespace and comments have the
+same key; other differences, including placeholders and their positions,
+letter case, and the contents of strings, produce different keys, since they
+may affect the plan or the required arguments. The dialect is included in the
+hash, so the same query has different keys in different dialects.
+
+Whitespace is ignored only where it doesn't separate tokens: "a,b" and "a, b"
+have the same key, while "a b" and "ab" don't. Similarly, whitespace between
+operator characters is preserved, since "< =" and "<=" may be lexed
+differently, and whitespace between quotes is preserved.
+
+The hash is 64-bit FNV-1a over a normalized rendering of the query. It's
+stable across processes and platforms, and can be persisted, but may change
+between major versions of this package. Collisions are possible, though
+unlikely; caches which can't tolerate them should also compare the queries.
+*/
+func CacheKey(nodes Nodes, dialect Dialect) uint64 {
+	hash := cacheKeyHasher{sum: fnvOffset64}
+	hash.byte(byte(dialect))
+	hash.byte(0)
+	hash.nodes(nodes)
+	return hash.sum
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+type cacheKeyHasher struct {
+	sum   uint64
+	last  byte
+	space bool
+	buf   []byte
+}
+
+func (self *cacheKeyHasher) nodes(nodes Nodes) {
+	for _, node := range nodes {
+		self.node(node)
+	}
+}
+
+func (self *cacheKeyHasher) node(node Node) {
+	switch node := node.(type) {
+	case nil:
+	case NodeWhitespace, NodeCommentLine, NodeCommentBlock, NodeCommentHash:
+		self.space = true
+	case NodeText:
+		self.string(string(node))
+	case Nodes:
+		self.nodes(node)
+	case ParenNodes:
+		self.string(`(`)
+		self.nodes(Nodes(node))
+		self.string(`)`)
+	case BracketNodes:
+		self.string(`[`)
+		self.nodes(Nodes(node))
+		self.string(`]`)
+	case BraceNodes:
+		self.string(`{`)
+		self.nodes(Nodes(node))
+		self.string(`}`)
+	case DelimNodes:
+		self.string(node.Open)
+		self.nodes(node.Inner)
+		self.string(node.Close)
+	default:
+		self.buf = node.AppendTo(self.buf[:0])
+		self.string(bytesToMutableString(self.buf))
+	}
+}
+
+func (self *cacheKeyHasher) string(str string) {
+	if str == `` {
+		return
+	}
+	if self.space && self.last != 0 && cacheKeySeparated(self.last, str[0]) {
+		self.byte(' ')
+	}
+	self.space = false
+
+	for i := 0; i < len(str); i++ {
+		self.byte(str[i])
+	}
+	self.last = str[len(str)-1]
+}
+
+func (self *cacheKeyHasher) byte(char byte) {
+	self.sum ^= uint64(char)
+	self.sum *= fnvPrime64
+}
+
+/*
+True if whitespace between the characters may separate tokens, and must be
+preserved in the normalized rendering used by `CacheKey`. Quotes are included
+because in some dialects, such as Postgres, adjacent strings separated by a
+newline are concatenated, while without whitespace, a doubled quote is an
+escape.
+*/
+func cacheKeySeparated(prev, next byte) bool {
+	return charsetCacheKeyWord.has(prev) && charsetCacheKeyWord.has(next) ||
+		charsetCacheKeyOperator.has(prev) && charsetCacheKeyOperator.has(next)
+}
+
+var (
+	charsetCacheKeyWord     = new(charset).addSet(charsetIdentLike).addStr("'\"`")
+	charsetCacheKeyOperator = new(charset).addStr(`+-*/<>=~!@#%^&|?:`)
+)
@@ -0,0 +1,383 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind of SQL statement, as determined by `ClassifyStmt`.
+type StmtKind byte
+
+const (
+	StmtKindUnknown StmtKind = iota
+	StmtKindSelect
+	StmtKindInsert
+	StmtKindUpdate
+	StmtKindDelete
+	StmtKindMerge
+	StmtKindDDL
+	StmtKindOther
+	StmtKindBegin
+	StmtKindCommit
+	StmtKindRollback
+	StmtKindSavepoint
+	StmtKindRelease
+	StmtKindRollbackTo
+	StmtKindSetTransaction
+)
+
+// Implement `fmt.Stringer` for debug purposes.
+func (self StmtKind) String() string {
+	switch self {
+	case StmtKindUnknown:
+		return `unknown`
+	case StmtKindSelect:
+		return `select`
+	case StmtKindInsert:
+		return `insert`
+	case StmtKindUpdate:
+		return `update`
+	case StmtKindDelete:
+		return `delete`
+	case StmtKindMerge:
+		return `merge`
+	case StmtKindDDL:
+		return `ddl`
+	case StmtKindOther:
+		return `other`
+	case StmtKindBegin:
+		return `begin`
+	case StmtKindCommit:
+		return `commit`
+	case StmtKindRollback:
+		return `rollback`
+	case StmtKindSavepoint:
+		return `savepoint`
+	case StmtKindRelease:
+		return `release`
+	case StmtKindRollbackTo:
+		return `rollback_to`
+	case StmtKindSetTransaction:
+		return `set_transaction`
+	default:
+		return fmt.Sprintf(`StmtKind(%d)`, byte(self))
+	}
+}
+
+/*
+True for kinds of transaction control statements, such as `StmtKindBegin` and
+`StmtKindSavepoint`, which allows to track transaction state from a stream of
+statements.
+*/
+func (self StmtKind) IsTransaction() bool {
+	return self >= StmtKindBegin && self <= StmtKindSetTransaction
+}
+
+/*
+Classifies the first statement in the given AST by its main verb. For
+statements beginning with WITH, the verb follows the CTEs. SELECT, VALUES, and
+TABLE are classified as `StmtKindSelect`. CREATE, ALTER, DROP, TRUNCATE,
+RENAME, COMMENT, GRANT, and REVOKE are classified as `StmtKindDDL`. Returns
+`StmtKindUnknown` if there's no statement, and `StmtKindOther` for anything
+else. A statement beginning with parens, such as "(select ...) union ...", is
+classified by the content of the parens.
+
+Transaction control statements are classified as follows, including MSSQL
+variants such as "BEGIN TRAN" and "SAVE TRAN":
+
+	BEGIN, START TRANSACTION            : StmtKindBegin
+	COMMIT, END                         : StmtKindCommit
+	ROLLBACK, ABORT                     : StmtKindRollback
+	SAVEPOINT name                      : StmtKindSavepoint
+	RELEASE [SAVEPOINT] name            : StmtKindRelease
+	ROLLBACK [WORK] TO [SAVEPOINT] name : StmtKindRollbackTo
+	SET TRANSACTION ...                 : StmtKindSetTransaction
+
+Two-phase commit statements such as "COMMIT PREPARED" don't affect the current
+transaction, and are classified as `StmtKindOther`.
+*/
+func ClassifyStmt(nodes Nodes) StmtKind {
+	stmts := splitStatements(topLevel(nodes))
+	if len(stmts) == 0 {
+		return StmtKindUnknown
+	}
+	return classifyStmt(stmts[0])
+}
+
+/*
+True if every statement in the given AST only reads data, which means it may
+be sent to a read replica. A statement is read-only if it's classified as
+`StmtKindSelect`, and doesn't contain any of the following at any nesting
+level:
+
+	INSERT, UPDATE, DELETE, MERGE : for example in CTEs
+	SELECT ... INTO               : creates a table in Postgres
+	FOR UPDATE, FOR SHARE, ...    : locks rows
+
+Functions with side effects, such as "nextval", are not detected. Returns
+false if there are no statements.
+*/
+func IsReadOnly(nodes Nodes) bool {
+	stmts := splitStatements(topLevel(nodes))
+	if len(stmts) == 0 {
+		return false
+	}
+
+	for _, stmt := range stmts {
+		if classifyStmt(stmt) != StmtKindSelect {
+			return false
+		}
+	}
+
+	readOnly := true
+	walkLevels(nodes, func(level []regionNode) {
+		if readOnly && !isReadOnlyLevel(level) {
+			readOnly = false
+		}
+	})
+	return readOnly
+}
+
+/*
+Returns an error unless every statement in the given AST is a SELECT which
+doesn't contain any keyword which may indicate writing, at any nesting level,
+including CTEs and subqueries. Rejected keywords include INSERT, UPDATE,
+DELETE, MERGE, INTO, DDL keywords such as CREATE and DROP, and others such as
+COPY, CALL, and EXECUTE. Intended for gating user-supplied queries, such as
+analytics queries, which must never modify data.
+
+Unlike `IsReadOnly`, this is deliberately conservative, and prefers false
+positives over false negatives. It doesn't try to understand the context of
+keywords, and rejects any text containing one of them as a word, even where
+it would be harmless, such as a column named "update". Strings, quoted
+identifiers, and comments are exempt, except when different dialects may
+disagree about where they end. Strings and identifiers containing backslashes
+are rejected, since backslash escapes are supported in some dialects but not
+in others; this includes Postgres escape strings such as E'\n'. Dollar-quoted
+strings, identifiers quoted with grave accents, BigQuery triple-quoted strings,
+hash comments, line comments not followed by whitespace such as "--x", and
+block comments beginning with "/*!", which MySQL executes, are checked like
+other text.
+
+Directives and template nodes are always rejected. Functions with side effects,
+such as "nextval", are not detected; for full protection, also execute such
+queries in a read-only transaction.
+*/
+func AssertReadOnly(nodes Nodes) (err error) {
+	defer rec(&err)
+
+	stmts := splitStatements(topLevel(nodes))
+	if len(stmts) == 0 {
+		return fmt.Errorf(`[sqlp] expected read-only statement, got no statements`)
+	}
+	for _, stmt := range stmts {
+		kind := classifyStmt(stmt)
+		if kind != StmtKindSelect {
+			return fmt.Errorf(`[sqlp] expected read-only statement, got %v statement`, kind)
+		}
+	}
+
+	DeepWalkNode(nodes, assertReadOnlyNode)
+	return nil
+}
+
+func assertReadOnlyNode(node Node) {
+	if text, ok := wordText(node); ok {
+		assertReadOnlyText(text)
+		return
+	}
+
+	switch node := node.(type) {
+	case NodeQuoteDollar, NodeQuoteGrave, NodeQuoteSingleTriple, NodeQuoteDoubleTriple, NodeCommentHash:
+		assertReadOnlyText(node.String())
+	case NodeQuoteSingle, NodeQuoteDouble, NodeQuoteNational, NodeQuoteEscape:
+		if strings.Contains(node.String(), `\`) {
+			panic(fmt.Errorf(`[sqlp] expected read-only statement, found ambiguous backslash in %q`, node))
+		}
+	case NodeCommentLine:
+		if node != `` && !charsetWhitespace.has(node[0]) {
+			assertReadOnlyText(string(node))
+		}
+	case NodeCommentBlock:
+		if strings.HasPrefix(string(node), `!`) {
+			assertReadOnlyText(string(node))
+		}
+	case NodeDirective, NodeTemplateAction, NodeTemplateStatement, NodeTemplateComment:
+		panic(fmt.Errorf(`[sqlp] expected read-only statement, found %q`, node))
+	}
+}
+
+// Panics if the text contains any of `writeKeywords` as a word, ignoring case.
+func assertReadOnlyText(str string) {
+	for len(str) > 0 {
+		word := prefixIdentWith(str, charsetIdentLike)
+		if word == `` {
+			str = str[1:]
+			continue
+		}
+		for _, keyword := range writeKeywords {
+			if strings.EqualFold(word, keyword) {
+				panic(fmt.Errorf(`[sqlp] expected read-only statement, found keyword %q`, word))
+			}
+		}
+		str = str[len(word):]
+	}
+}
+
+func classifyStmt(stmt []regionNode) StmtKind {
+	index := indexStatementVerb(stmt)
+	if index < 0 {
+		return StmtKindOther
+	}
+
+	node := stmt[index].node
+	if paren, ok := node.(ParenNodes); ok {
+		return classifyStmt(topLevel(Nodes(paren)))
+	}
+
+	kind := classifyTransaction(stmt[index:])
+	if kind != StmtKindUnknown {
+		return kind
+	}
+
+	switch {
+	case isKeyword(node, `select`, `values`, `table`):
+		return StmtKindSelect
+	case isKeyword(node, `insert`):
+		return StmtKindInsert
+	case isKeyword(node, `update`):
+		return StmtKindUpdate
+	case isKeyword(node, `delete`):
+		return StmtKindDelete
+	case isKeyword(node, `merge`):
+		return StmtKindMerge
+	case isKeyword(node, ddlKeywords...):
+		return StmtKindDDL
+	default:
+		return StmtKindOther
+	}
+}
+
+func classifyTransaction(stmt []regionNode) StmtKind {
+	word := func(index int) string {
+		if index < len(stmt) {
+			text, _ := wordText(stmt[index].node)
+			return strings.ToLower(strings.TrimRight(text, `;`))
+		}
+		return ``
+	}
+
+	switch word(0) {
+	case `begin`:
+		if len(stmt) == 1 || hasString(beginTransactionWords, word(1)) {
+			return StmtKindBegin
+		}
+	case `start`:
+		if word(1) == `transaction` {
+			return StmtKindBegin
+		}
+	case `commit`, `end`:
+		if word(1) == `prepared` {
+			return StmtKindOther
+		}
+		return StmtKindCommit
+	case `rollback`, `abort`:
+		if word(1) == `prepared` {
+			return StmtKindOther
+		}
+		for i := 1; i < len(stmt) && i <= 2; i++ {
+			if word(i) == `to` {
+				return StmtKindRollbackTo
+			}
+		}
+		return StmtKindRollback
+	case `savepoint`:
+		return StmtKindSavepoint
+	case `save`:
+		if word(1) == `tran` || word(1) == `transaction` {
+			return StmtKindSavepoint
+		}
+	case `release`:
+		return StmtKindRelease
+	case `set`:
+		if word(1) == `transaction` || word(1) == `session` && word(2) == `characteristics` {
+			return StmtKindSetTransaction
+		}
+	}
+	return StmtKindUnknown
+}
+
+func isReadOnlyLevel(level []regionNode) bool {
+	for _, stmt := range splitStatements(level) {
+		index := indexStatementVerb(stmt)
+		if index >= 0 && isKeyword(stmt[index].node, `insert`, `update`, `delete`, `merge`) {
+			return false
+		}
+
+		for i, val := range stmt {
+			if isKeyword(val.node, `into`) {
+				return false
+			}
+			if isKeyword(val.node, `for`) && i+1 < len(stmt) && isKeyword(stmt[i+1].node, lockingKeywords...) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Top-level nodes without regions, excluding whitespace and comments.
+func topLevel(nodes Nodes) []regionNode {
+	var out []regionNode
+	for _, node := range nodes {
+		if !isTrivia(node) {
+			out = append(out, regionNode{node: node})
+		}
+	}
+	return out
+}
+
+/*
+Splits the level into statements separated by semicolons or T-SQL batch
+separators. A text node containing a semicolon is included in the statement
+which it ends, while batch separators are omitted. Empty statements are
+omitted.
+*/
+func splitStatements(level []regionNode) (out [][]regionNode) {
+	start := 0
+	for i, val := range level {
+		if _, ok := val.node.(NodeBatchSeparator); ok {
+			out = appendStatement(out, level[start:i])
+			start = i + 1
+		} else if isSemicolonEnd(val.node) {
+			out = appendStatement(out, level[start:i+1])
+			start = i + 1
+		}
+	}
+	return appendStatement(out, level[start:])
+}
+
+func appendStatement(out [][]regionNode, stmt []regionNode) [][]regionNode {
+	if len(stmt) == 0 || len(stmt) == 1 && isSemicolons(stmt[0].node) {
+		return out
+	}
+	return append(out, stmt)
+}
+
+var (
+	ddlKeywords = []string{
+		`create`, `alter`, `drop`, `truncate`, `rename`, `comment`, `grant`, `revoke`,
+	}
+
+	lockingKeywords = []string{`update`, `share`, `no`, `key`}
+
+	writeKeywords = []string{
+		`insert`, `update`, `delete`, `merge`, `upsert`, `replace`, `into`,
+		`create`, `alter`, `drop`, `truncate`, `rename`, `comment`, `grant`,
+		`revoke`, `copy`, `call`, `do`, `exec`, `execute`, `prepare`,
+		`deallocate`, `lock`, `set`, `reset`, `refresh`, `reindex`, `vacuum`,
+		`cluster`, `analyze`, `import`, `load`, `attach`, `detach`, `pragma`,
+		`begin`, `commit`, `rollback`, `savepoint`, `release`, `listen`,
+		`notify`, `unlisten`, `discard`, `handler`, `share`,
+	}
+)
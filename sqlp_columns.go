@@ -0,0 +1,126 @@
+package sqlp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+Generates a comma-separated list of quoted column names from the "db" tags of
+the fields of the given struct, for use in SELECT instead of "*", which keeps
+the list in sync with the Go type that the rows are scanned into. Names are
+quoted like in `QuoteIdent`. The input may be a struct, a pointer to a struct,
+including a nil pointer, or a `reflect.Type` of either.
+
+Fields are included in declaration order. Fields without a "db" tag, with the
+tag "-", or unexported are skipped. Tag options after a comma, as in
+`db:"name,omitempty"`, are ignored. Embedded structs without a "db" tag are
+flattened, as if their fields were declared in the outer struct. Panics if the
+input is not a struct.
+
+Example:
+
+	type User struct {
+		Id   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+
+	sqlp.Columns((*User)(nil))
+	// "id", "name"
+
+Also see `TableColumns` for qualified names.
+*/
+func Columns(val interface{}) Nodes { return TableColumns(``, val) }
+
+/*
+Like `Columns`, but qualifies each column with the given table name or alias,
+quoted like in `QuoteIdent`. When the table is empty, this is equivalent to
+`Columns`.
+
+Example:
+
+	sqlp.TableColumns(`users`, (*User)(nil))
+	// "users"."id", "users"."name"
+*/
+func TableColumns(table string, val interface{}) Nodes {
+	cols := structColumns(columnsType(val))
+
+	var prefix Nodes
+	if table != `` {
+		prefix = append(nodesOf(QuoteIdent(table)), NodeText(`.`))
+	}
+
+	out := make(Nodes, 0, len(cols)*(len(prefix)+3))
+	for i, col := range cols {
+		if i > 0 {
+			out = append(out, NodeText(`,`), nodeWhitespaceSingle)
+		}
+		out = append(out, prefix...)
+		out = append(out, quoteIdentPart(col))
+	}
+	return out
+}
+
+func nodesOf(node Node) Nodes {
+	val, ok := node.(Nodes)
+	if ok {
+		return val
+	}
+	return Nodes{node}
+}
+
+func columnsType(val interface{}) reflect.Type {
+	typ, ok := val.(reflect.Type)
+	if !ok {
+		typ = reflect.TypeOf(val)
+	}
+
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		panic(fmt.Errorf(`[sqlp] expected struct for column list, got %v`, typ))
+	}
+	return typ
+}
+
+// Column names are cached per type, since struct fields never change.
+var structColumnsCache sync.Map
+
+func structColumns(typ reflect.Type) []string {
+	cached, ok := structColumnsCache.Load(typ)
+	if ok {
+		return cached.([]string)
+	}
+
+	out := appendStructColumns(nil, typ)
+	structColumnsCache.Store(typ, out)
+	return out
+}
+
+func appendStructColumns(out []string, typ reflect.Type) []string {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, tagged := field.Tag.Lookup(`db`)
+		name := strings.SplitN(tag, `,`, 2)[0]
+
+		if field.Anonymous && !tagged {
+			inner := field.Type
+			if inner.Kind() == reflect.Ptr {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				out = appendStructColumns(out, inner)
+			}
+			continue
+		}
+
+		if field.PkgPath != `` || name == `` || name == `-` {
+			continue
+		}
+		out = append(out, name)
+	}
+	return out
+}
@@ -0,0 +1,306 @@
+package sqlp
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+Attaches structured metadata to the query as a leading block comment, whose
+content begins with "sqlp:" and consists of "key=value" pairs separated by
+spaces. Keys are sorted, and both keys and values are URL-encoded, which keeps
+the comment valid regardless of content. If the query already begins with such
+a comment, possibly after whitespace, the existing metadata is merged with the
+new metadata, which takes priority, and the comment is replaced. Otherwise the
+comment is inserted at the very start. Returns an error for empty keys, or if
+the existing comment is malformed. Doesn't modify the input.
+
+Use `Meta` to parse the metadata back out, for example in middleware that
+applies per-query settings.
+
+Example:
+
+	nodes, err := sqlp.WithMeta(nodes, map[string]string{`app`: `checkout`, `timeout`: `5s`})
+	...
+	// The query begins with a block comment: "sqlp: app=checkout timeout=5s".
+*/
+func WithMeta(nodes Nodes, meta map[string]string) (_ Nodes, err error) {
+	defer rec(&err)
+
+	index := indexMeta(nodes)
+	if index >= 0 {
+		prev := parseMeta(string(nodes[index].(NodeCommentBlock)))
+		for key, val := range meta {
+			prev[key] = val
+		}
+		meta = prev
+	}
+
+	comment := NodeCommentBlock(formatMeta(meta))
+
+	if index >= 0 {
+		out := append(Nodes(nil), nodes...)
+		out[index] = comment
+		return out, nil
+	}
+
+	out := make(Nodes, 0, len(nodes)+2)
+	out = append(out, comment)
+	if len(nodes) > 0 {
+		out = append(out, nodeWhitespaceSingle)
+	}
+	return append(out, nodes...), nil
+}
+
+/*
+Parses the metadata attached by `WithMeta`. Only the leading tokens of the
+query are examined: the metadata comment must be the first token other than
+whitespace. Returns nil if there's no such comment, and an error if it's
+malformed.
+*/
+func Meta(src string) (_ map[string]string, err error) {
+	defer rec(&err)
+
+	tokenizer := Tokenizer{Source: src}
+	for {
+		tok := tokenizer.Token()
+		switch tok.Type {
+		case TypeWhitespace:
+			continue
+		case TypeCommentBlock:
+			comment := tok.NodeCommentBlock(src)
+			if isMeta(string(comment)) {
+				return parseMeta(string(comment)), nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+const metaPrefix = `sqlp:`
+
+// Index of the leading metadata comment, or -1.
+func indexMeta(nodes Nodes) int {
+	for i, node := range nodes {
+		if isWhitespace(node) {
+			continue
+		}
+		comment, ok := node.(NodeCommentBlock)
+		if ok && isMeta(string(comment)) {
+			return i
+		}
+		break
+	}
+	return -1
+}
+
+/*
+True if the comment content begins with "sqlp:" followed by whitespace or the
+end of the comment, which distinguishes it from other comments beginning with
+"sqlp:", such as the suppression comments of `Diagnostics.Suppress`.
+*/
+func isMeta(str string) bool {
+	str = strings.TrimSpace(str)
+	return strings.HasPrefix(str, metaPrefix) &&
+		(len(str) == len(metaPrefix) || charsetWhitespace.has(str[len(metaPrefix)]))
+}
+
+func formatMeta(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		if key == `` {
+			panic(fmt.Errorf(`[sqlp] unexpected empty metadata key`))
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	buf.WriteString(` `)
+	buf.WriteString(metaPrefix)
+	for _, key := range keys {
+		buf.WriteString(` `)
+		buf.WriteString(url.QueryEscape(key))
+		buf.WriteString(`=`)
+		buf.WriteString(url.QueryEscape(meta[key]))
+	}
+	buf.WriteString(` `)
+	return buf.String()
+}
+
+func parseMeta(str string) map[string]string {
+	str = strings.TrimPrefix(strings.TrimSpace(str), metaPrefix)
+	out := map[string]string{}
+
+	for _, pair := range strings.Fields(str) {
+		index := strings.IndexByte(pair, '=')
+		if index <= 0 {
+			panic(fmt.Errorf(`[sqlp] malformed metadata pair %q: expected "key=value"`, pair))
+		}
+
+		out[tryQueryUnescape(pair[:index])] = tryQueryUnescape(pair[index+1:])
+	}
+	return out
+}
+
+func tryQueryUnescape(str string) string {
+	out, err := url.QueryUnescape(str)
+	if err != nil {
+		panic(fmt.Errorf(`[sqlp] malformed metadata: %w`, err))
+	}
+	return out
+}
+
+/*
+Appends a trailing block comment in the sqlcommenter format, used by
+observability tools to correlate queries with traces. Keys are sorted. Keys and
+values are URL-encoded, which also encodes any quotes, and values are wrapped
+in single quotes. The comment is placed at the end of the statement body,
+before any trailing whitespace, comments, and semicolons, which keeps it inside
+the statement it annotates. Returns the input as-is if the map is empty.
+Doesn't modify the input.
+
+See https://google.github.io/sqlcommenter/spec/ for the format.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users;`)
+	...
+	nodes = sqlp.WithComment(nodes, map[string]string{`action`: `list`, `traceparent`: `00-abc-def-01`})
+	// The comment "action='list',traceparent='00-abc-def-01'" precedes ";".
+*/
+func WithComment(nodes Nodes, kv map[string]string) Nodes {
+	if len(kv) == 0 {
+		return nodes
+	}
+
+	comment := NodeCommentBlock(formatSQLCommenter(kv))
+	body, tail := splitTrailing(nodes)
+
+	out := make(Nodes, 0, len(nodes)+2)
+	out = append(out, body...)
+	if len(body) > 0 {
+		out = append(out, nodeWhitespaceSingle)
+	}
+	out = append(out, comment)
+	return append(out, tail...)
+}
+
+func formatSQLCommenter(kv map[string]string) string {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(`,`)
+		}
+		buf.WriteString(sqlCommenterEscape(key))
+		buf.WriteString(`='`)
+		buf.WriteString(sqlCommenterEscape(kv[key]))
+		buf.WriteString(`'`)
+	}
+	return buf.String()
+}
+
+// URL-encodes the string like `url.QueryEscape`, but encodes spaces as "%20"
+// rather than "+", as required by sqlcommenter.
+func sqlCommenterEscape(str string) string {
+	return strings.ReplaceAll(url.QueryEscape(str), `+`, `%20`)
+}
+
+/*
+Removes top-level block comments in the sqlcommenter format, such as those
+added by `WithComment`, along with the whitespace that separates them from the
+preceding node, where possible without joining adjacent tokens. Such comments
+typically carry per-request data such as trace IDs, and stripping them allows
+to group otherwise identical queries, for example in caches and metrics. Other
+comments are preserved. `Fingerprint` uses this automatically. Doesn't modify
+the input.
+*/
+func StripSQLCommenter(nodes Nodes) Nodes {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(nodes))
+
+	for i, node := range nodes {
+		comment, ok := node.(NodeCommentBlock)
+		if !ok || !isSQLCommenter(string(comment)) {
+			out = append(out, node)
+			continue
+		}
+
+		var next Node
+		if i+1 < len(nodes) {
+			next = nodes[i+1]
+		}
+		separated := next == nil || isWhitespace(next) || isSemicolons(next)
+
+		if len(out) > 0 && isWhitespace(out[len(out)-1]) {
+			if separated {
+				out = out[:len(out)-1]
+			}
+		} else if len(out) > 0 && !separated {
+			out = append(out, nodeWhitespaceSingle)
+		}
+	}
+	return out
+}
+
+/*
+True if the content of a block comment is in the sqlcommenter format: one or
+more comma-separated pairs of the form "key='value'", where single quotes in
+the value may be escaped with a backslash.
+*/
+func isSQLCommenter(str string) bool {
+	str = strings.TrimSpace(str)
+	if str == `` {
+		return false
+	}
+
+	for {
+		key := strings.IndexByte(str, '=')
+		if key <= 0 || strings.ContainsAny(str[:key], ",' \t\r\n") {
+			return false
+		}
+		str = str[key+1:]
+
+		if !strings.HasPrefix(str, `'`) {
+			return false
+		}
+		str = str[1:]
+
+		end := indexUnescapedQuote(str)
+		if end < 0 {
+			return false
+		}
+		str = str[end+1:]
+
+		if str == `` {
+			return true
+		}
+		if str[0] != ',' {
+			return false
+		}
+		str = str[1:]
+	}
+}
+
+func indexUnescapedQuote(str string) int {
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '\'':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,124 @@
+package sqlp
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Diagnostic codes used by `VerifyCorpus`.
+const (
+	CodeCorpusRead = `E_CORPUS_READ`
+	CodeParse      = `E_PARSE`
+	CodeCoverage   = `E_COVERAGE`
+	CodeRoundTrip  = `E_ROUND_TRIP`
+)
+
+/*
+Verifies every file with the ".sql" extension in the given file system,
+recursively, and returns diagnostics for all failures, in order of file paths.
+Intended to be called from tests, to catch regressions over a corpus of real
+queries:
+
+	func TestQueries(t *testing.T) {
+		err := sqlp.VerifyCorpus(os.DirFS(`queries`)).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+Each file is checked for the following, with the corresponding codes:
+
+	CodeCorpusRead : the file or directory can be read
+	CodeParse      : the file can be tokenized and parsed via `Parse`
+	CodeCoverage   : the tokens pass `CoverageCheck`
+	CodeRoundTrip  : formatting the parsed AST reproduces the file exactly
+
+The region of each diagnostic refers to the content of its file. Since
+`Diagnostic` doesn't have a file path, the message begins with the path, the
+line, and the column, such as "one/two.sql:3:7: ", where the line and the
+column are 1-based, and the column is in bytes. For parse errors, the position
+is where the error was detected, which may be past the actual problem, such as
+the end of the file for an unclosed paren.
+*/
+func VerifyCorpus(fsys fs.FS) Diagnostics {
+	var out Diagnostics
+
+	walkErr := fs.WalkDir(fsys, `.`, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			out = append(out, corpusDiagnostic(filePath, ``, CodeCorpusRead, Region{}, err.Error()))
+			return nil
+		}
+		if entry.IsDir() || !strings.EqualFold(path.Ext(filePath), `.sql`) {
+			return nil
+		}
+
+		src, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			out = append(out, corpusDiagnostic(filePath, ``, CodeCorpusRead, Region{}, err.Error()))
+			return nil
+		}
+
+		out = append(out, verifyCorpusFile(filePath, string(src))...)
+		return nil
+	})
+
+	if walkErr != nil {
+		out = append(out, corpusDiagnostic(`.`, ``, CodeCorpusRead, Region{}, walkErr.Error()))
+	}
+	return out
+}
+
+func verifyCorpusFile(filePath, src string) Diagnostics {
+	parser := Parser{Tokenizer: Tokenizer{Source: src}}
+	nodes, err := parser.Parse()
+	if err != nil {
+		pos := parser.Cursor()
+		return Diagnostics{corpusDiagnostic(filePath, src, CodeParse, Region{pos, pos}, err.Error())}
+	}
+
+	tokens, err := Tokenize(src)
+	if err == nil {
+		err = CoverageCheck(src, tokens)
+	}
+	if err != nil {
+		return Diagnostics{corpusDiagnostic(filePath, src, CodeCoverage, Region{0, len(src)}, err.Error())}
+	}
+
+	out := nodes.String()
+	if out != src {
+		pos := commonPrefixLen(out, src)
+		return Diagnostics{corpusDiagnostic(
+			filePath, src, CodeRoundTrip, Region{pos, len(src)},
+			fmt.Sprintf(`formatted AST differs from source: expected %q, got %q`, corpusExcerpt(src, pos), corpusExcerpt(out, pos)),
+		)}
+	}
+	return nil
+}
+
+func corpusDiagnostic(filePath, src, code string, region Region, msg string) Diagnostic {
+	return Diagnostic{
+		Code:    code,
+		Region:  region,
+		Message: fmt.Sprintf(`%v:%v: %v`, filePath, PositionAt(src, region[0]), msg),
+	}
+}
+
+func commonPrefixLen(one, two string) int {
+	var index int
+	for index < len(one) && index < len(two) && one[index] == two[index] {
+		index++
+	}
+	return index
+}
+
+// Short part of the text at the given position, for error messages.
+func corpusExcerpt(str string, pos int) string {
+	const size = 32
+	str = str[pos:]
+	if len(str) > size {
+		return str[:size] + `...`
+	}
+	return str
+}
@@ -0,0 +1,374 @@
+package sqlp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Describes a problem found in SQL source by an analysis function such as
+`CheckANSI`. The code is a short stable identifier such as "E_GRAVE_QUOTE",
+suitable for filtering and suppression. The region refers to the analyzed
+source text. The zero severity is `SeverityError`.
+*/
+type Diagnostic struct {
+	Code     string
+	Region   Region
+	Message  string
+	Severity Severity
+}
+
+// Implement `fmt.Stringer`, formatting the diagnostic for humans.
+func (self Diagnostic) String() string {
+	return fmt.Sprintf(`%v: %v [%v] %v`, self.Region[0], self.Severity, self.Code, self.Message)
+}
+
+/*
+Severity of a `Diagnostic`. Lower values are more severe, which makes
+`SeverityError` the zero value. `SeverityOff` is used by `SeverityConfig` to
+disable diagnostics with a particular code.
+*/
+type Severity byte
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+	SeverityOff
+)
+
+// Implement `fmt.Stringer` for debug purposes.
+func (self Severity) String() string {
+	switch self {
+	case SeverityError:
+		return `error`
+	case SeverityWarning:
+		return `warning`
+	case SeverityInfo:
+		return `info`
+	case SeverityOff:
+		return `off`
+	default:
+		return fmt.Sprintf(`Severity(%d)`, byte(self))
+	}
+}
+
+/*
+List of diagnostics returned by analysis functions such as `CheckANSI`. Also
+implements `error`, allowing to return all diagnostics of a batch operation as
+one error; see `Diagnostics.Err`.
+*/
+type Diagnostics []Diagnostic
+
+/*
+Returns a new list combining the diagnostics of the receiver and the given
+lists, sorted via `Diagnostics.Sort`. Doesn't modify the inputs.
+*/
+func (self Diagnostics) Merge(vals ...Diagnostics) Diagnostics {
+	size := len(self)
+	for _, val := range vals {
+		size += len(val)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	out := make(Diagnostics, 0, size)
+	out = append(out, self...)
+	for _, val := range vals {
+		out = append(out, val...)
+	}
+	out.Sort()
+	return out
+}
+
+/*
+Sorts the diagnostics in place by position in the source, then by code. The
+sort is stable, preserving the relative order of otherwise equal diagnostics.
+*/
+func (self Diagnostics) Sort() {
+	sort.SliceStable(self, func(one, two int) bool {
+		return self[one].less(self[two])
+	})
+}
+
+/*
+Returns a new list of the diagnostics which are at least as severe as the
+given severity. For example, `SeverityWarning` keeps errors and warnings, and
+drops infos. Doesn't modify the receiver.
+*/
+func (self Diagnostics) Filter(severity Severity) Diagnostics {
+	var out Diagnostics
+	for _, val := range self {
+		if val.Severity <= severity {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+// True if any of the diagnostics has `SeverityError`.
+func (self Diagnostics) HasErrors() bool {
+	for _, val := range self {
+		if val.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the receiver as `error` if it's non-empty, or nil otherwise.
+func (self Diagnostics) Err() error {
+	if len(self) == 0 {
+		return nil
+	}
+	return self
+}
+
+// Implement `error`. Same as `Diagnostics.String`.
+func (self Diagnostics) Error() string { return self.String() }
+
+// Implement `fmt.Stringer`, rendering one diagnostic per line.
+func (self Diagnostics) String() string {
+	var buf strings.Builder
+	for i, val := range self {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(val.String())
+	}
+	return buf.String()
+}
+
+func (self Diagnostic) less(other Diagnostic) bool {
+	if self.Region[0] != other.Region[0] {
+		return self.Region[0] < other.Region[0]
+	}
+	if self.Region[1] != other.Region[1] {
+		return self.Region[1] < other.Region[1]
+	}
+	return self.Code < other.Code
+}
+
+// Diagnostic codes used by `CheckANSI`.
+const (
+	CodeGraveQuote   = `E_GRAVE_QUOTE`
+	CodeDoubleColon  = `E_DOUBLE_COLON`
+	CodeHash         = `E_HASH`
+	CodeBrace        = `E_BRACE`
+	CodeOrdinalParam = `E_ORDINAL_PARAM`
+	CodeAtParam      = `E_AT_PARAM`
+	CodeNotEqual     = `E_NOT_EQUAL`
+)
+
+/*
+Strict ANSI mode. Tokenizes the given SQL and reports constructs that are not
+part of standard SQL, such as grave quotes, `::` casts, `#` comments, braces,
+`$N` and `@name` placeholders, and the `!=` operator. Returns an error only if
+tokenization fails. Intended for teams that keep their SQL portable between
+databases.
+*/
+func CheckANSI(src string) (out Diagnostics, err error) {
+	defer rec(&err)
+
+	tokenizer := Tokenizer{Source: src}
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			return
+		}
+
+		switch tok.Type {
+		case TypeQuoteGrave:
+			out = append(out, Diagnostic{CodeGraveQuote, tok.Region, `grave quotes are not standard SQL; use double quotes for identifiers`, SeverityError})
+		case TypeDoubleColon:
+			out = append(out, Diagnostic{CodeDoubleColon, tok.Region, `"::" casts are not standard SQL; use "cast(<expr> as <type>)"`, SeverityError})
+		case TypeBraceOpen:
+			out = append(out, Diagnostic{CodeBrace, tok.Region, `braces are not standard SQL`, SeverityError})
+		case TypeOrdinalParam:
+			out = append(out, Diagnostic{CodeOrdinalParam, tok.Region, `"$N" placeholders are not standard SQL; use "?" or ":name"`, SeverityError})
+		case TypeText:
+			out = appendTextDiagnostics(out, tok.Slice(src), tok.Region[0])
+		}
+	}
+}
+
+func appendTextDiagnostics(out Diagnostics, text string, offset int) Diagnostics {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '#':
+			out = append(out, Diagnostic{CodeHash, Region{offset + i, offset + i + 1}, `"#" is not standard SQL; use "--" for comments`, SeverityError})
+
+		case '!':
+			if strings.HasPrefix(text[i:], `!=`) {
+				out = append(out, Diagnostic{CodeNotEqual, Region{offset + i, offset + i + 2}, `"!=" is not standard SQL; use "<>"`, SeverityError})
+				i++
+			}
+
+		case '@':
+			if (i == 0 || !charsetIdent.has(text[i-1]) && text[i-1] != '@') && len(prefixIdent(text[i+1:])) > 0 {
+				size := 1 + len(prefixIdent(text[i+1:]))
+				out = append(out, Diagnostic{CodeAtParam, Region{offset + i, offset + i + size}, `"@name" placeholders are not standard SQL; use ":name"`, SeverityError})
+				i += size - 1
+			}
+		}
+	}
+	return out
+}
+
+/*
+Per-code severity configuration, allowing to adjust diagnostics to team
+preferences. See `SeverityConfig.Apply`.
+*/
+type SeverityConfig map[string]Severity
+
+/*
+Returns a new list where the severity of each diagnostic is replaced with the
+configured severity for its code, if any. Diagnostics configured with
+`SeverityOff` are dropped. Doesn't modify the input.
+*/
+func (self SeverityConfig) Apply(diags Diagnostics) Diagnostics {
+	var out Diagnostics
+	for _, val := range diags {
+		severity, ok := self[val.Code]
+		if ok {
+			val.Severity = severity
+		}
+		if val.Severity != SeverityOff {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+/*
+Returns a new list without the diagnostics suppressed by comments in the given
+source, which must be the source the diagnostics refer to. A suppression
+comment begins with "sqlp:ignore", optionally followed by a list of codes
+separated by spaces or commas. Without codes, it suppresses all diagnostics.
+Both line and block comments are supported, for example:
+
+	select * from one -- sqlp:ignore E_SELECT_STAR
+	select * from one -- sqlp:ignore E_SELECT_STAR, E_NO_LIMIT
+
+A comment which follows other content on the same line suppresses diagnostics
+starting on that line. A comment which is the only content on its line also
+suppresses diagnostics starting on the next line. Block comments don't count
+as content, which allows a suppression comment to follow the metadata comment
+of `WithMeta`. Returns an error if the
+source can't be tokenized. Doesn't modify the input.
+*/
+func (self Diagnostics) Suppress(src string) (_ Diagnostics, err error) {
+	defer rec(&err)
+
+	if len(self) == 0 {
+		return self, nil
+	}
+
+	ignores := findIgnores(src)
+	if len(ignores) == 0 {
+		return self, nil
+	}
+
+	lines := lineStarts(src)
+	var out Diagnostics
+
+outer:
+	for _, val := range self {
+		line := lineAt(lines, val.Region[0])
+		for _, ignore := range ignores {
+			if ignore.covers(line, val.Code) {
+				continue outer
+			}
+		}
+		out = append(out, val)
+	}
+	return out, nil
+}
+
+const ignorePrefix = `sqlp:ignore`
+
+type diagIgnore struct {
+	lines [2]int
+	codes []string
+}
+
+func (self diagIgnore) covers(line int, code string) bool {
+	if line < self.lines[0] || line > self.lines[1] {
+		return false
+	}
+	return len(self.codes) == 0 || hasString(self.codes, code)
+}
+
+func findIgnores(src string) (out []diagIgnore) {
+	tokenizer := Tokenizer{Source: src}
+	lines := lineStarts(src)
+	own := true
+
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			return
+		}
+
+		var text string
+		switch tok.Type {
+		case TypeWhitespace:
+			if strings.ContainsAny(tok.Slice(src), "\r\n") {
+				own = true
+			}
+			continue
+		case TypeCommentLine:
+			text = string(tok.NodeCommentLine(src))
+		case TypeCommentBlock:
+			text = string(tok.NodeCommentBlock(src))
+		}
+
+		text = strings.TrimSpace(text)
+		if strings.HasPrefix(text, ignorePrefix) {
+			line := lineAt(lines, tok.Region[0])
+			ignore := diagIgnore{lines: [2]int{line, line}}
+			if own {
+				ignore.lines[1]++
+			}
+			ignore.codes = strings.FieldsFunc(text[len(ignorePrefix):], isIgnoreSep)
+			out = append(out, ignore)
+		}
+
+		// A line comment includes the newline, which makes the next token
+		// the first on its line.
+		if tok.Type != TypeCommentBlock {
+			own = tok.Type == TypeCommentLine
+		}
+	}
+}
+
+func isIgnoreSep(char rune) bool {
+	return char == ',' || char == ' ' || char == '\t' || char == '\r' || char == '\n'
+}
+
+/*
+Returns the offsets of the beginnings of all lines in the source. Like in the
+tokenizer, lines end with LF, CRLF, or a lone CR.
+*/
+func lineStarts(src string) []int {
+	out := []int{0}
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '\n':
+			out = append(out, i+1)
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				continue
+			}
+			out = append(out, i+1)
+		}
+	}
+	return out
+}
+
+// Returns the zero-based index of the line containing the given offset.
+func lineAt(lines []int, offset int) int {
+	return sort.SearchInts(lines, offset+1) - 1
+}
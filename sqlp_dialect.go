@@ -0,0 +1,618 @@
+package sqlp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+SQL dialect. Used by various functions that need to know which syntax is valid
+or preferred for a particular database. The zero value `DialectAny` represents
+the superset of syntax supported by this package by default, without
+dialect-specific restrictions.
+*/
+type Dialect byte
+
+const (
+	DialectAny Dialect = iota
+	DialectPostgres
+	DialectMySQL
+	DialectSQLite
+	DialectMSSQL
+	DialectANSI
+	DialectOracle
+	DialectBigQuery
+)
+
+// Implement `fmt.Stringer` for debug purposes.
+func (self Dialect) String() string {
+	switch self {
+	case DialectAny:
+		return `any`
+	case DialectPostgres:
+		return `postgres`
+	case DialectMySQL:
+		return `mysql`
+	case DialectSQLite:
+		return `sqlite`
+	case DialectMSSQL:
+		return `mssql`
+	case DialectANSI:
+		return `ansi`
+	case DialectOracle:
+		return `oracle`
+	case DialectBigQuery:
+		return `bigquery`
+	default:
+		return fmt.Sprintf(`Dialect(%d)`, byte(self))
+	}
+}
+
+/*
+Implemented by nodes whose syntax is valid only in some dialects, such as
+`NodeQuoteGrave` and `NodeDoubleColon`. Used by `RenderDialect`. Custom node
+types may implement this too. `DialectAny` should always be considered valid.
+*/
+type DialectNode interface {
+	Node
+	ValidIn(Dialect) bool
+}
+
+/*
+Serializes the node like `Node.String`, but first walks the AST deeply and
+returns an error if any node implementing `DialectNode` is not valid in the
+given dialect. This prevents silently emitting invalid SQL, for example when an
+AST parsed from MySQL source is used with Postgres. Also returns an error for
+identifiers in brackets, such as "[some name]", in dialects other than MSSQL
+and SQLite. Such brackets are parsed as `BracketNodes`, like array subscripts,
+and are detected heuristically; see `GuessDialect`. To convert between
+dialects, see `TranspileQuotes`.
+*/
+func RenderDialect(node Node, dialect Dialect) (string, error) {
+	if node == nil {
+		return ``, nil
+	}
+
+	var err error
+	DeepWalkNode(node, func(node Node) {
+		impl, _ := node.(DialectNode)
+		if err == nil && impl != nil && !impl.ValidIn(dialect) {
+			err = fmt.Errorf(`[sqlp] node %q of type %T is not valid in dialect %q`, node, node, dialect)
+		}
+	})
+	if err != nil {
+		return ``, err
+	}
+
+	out := node.String()
+	if !isDialect(dialect, DialectMSSQL, DialectSQLite) {
+		if ident, _ := findBracketIdent(out); ident != `` {
+			return ``, fmt.Errorf(`[sqlp] bracketed identifier %q is not valid in dialect %q`, ident, dialect)
+		}
+	}
+	return out, nil
+}
+
+func isDialect(val Dialect, dialects ...Dialect) bool {
+	if val == DialectAny {
+		return true
+	}
+	for _, dialect := range dialects {
+		if val == dialect {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Heuristically guesses the dialect of the given SQL from token evidence, such as
+grave quotes (MySQL), `::` casts and `$N` placeholders (Postgres), `?`
+placeholders (MySQL, SQLite), `@name` placeholders and `@@` variables (MSSQL,
+MySQL), and identifiers in brackets (MSSQL). Quotes and comments are ignored.
+
+Returns the most likely dialect and a confidence score between 0 and 1, which
+is the share of evidence pointing to that dialect. Returns `DialectAny` and 0
+if there's no evidence, or if the source can't be tokenized.
+*/
+func GuessDialect(src string) (Dialect, float64) {
+	var scores dialectScores
+	if scores.addSource(src) != nil {
+		return DialectAny, 0
+	}
+	return scores.best()
+}
+
+type dialectScores [DialectBigQuery + 1]float64
+
+func (self *dialectScores) addSource(src string) (err error) {
+	defer rec(&err)
+
+	tokenizer := Tokenizer{Source: src}
+	var prev Token
+
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			return
+		}
+
+		switch tok.Type {
+		case TypeQuoteGrave:
+			self[DialectMySQL] += 2
+			self[DialectSQLite]++
+		case TypeDoubleColon, TypeOrdinalParam:
+			self[DialectPostgres] += 2
+		case TypeBracketOpen:
+			if isBracketIdent(src, prev, tokenizer.Rest()) {
+				self[DialectMSSQL] += 2
+			}
+		case TypeText:
+			self.addText(tok.Slice(src))
+		}
+
+		if tok.Type != TypeWhitespace {
+			prev = tok
+		}
+	}
+}
+
+func (self *dialectScores) addText(text string) {
+	switch strings.ToLower(text) {
+	case `ilike`, `returning`:
+		self[DialectPostgres]++
+	case `top`:
+		self[DialectMSSQL]++
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '?':
+			self[DialectMySQL]++
+			self[DialectSQLite]++
+		case '#':
+			self[DialectMySQL]++
+		case '@':
+			if i+1 < len(text) && text[i+1] == '@' {
+				self[DialectMSSQL]++
+				self[DialectMySQL]++
+				i += len(prefixIdent(text[i+2:])) + 1
+			} else if len(prefixIdent(text[i+1:])) > 0 {
+				self[DialectMSSQL] += 2
+				self[DialectMySQL]++
+			}
+		}
+	}
+}
+
+func (self *dialectScores) best() (Dialect, float64) {
+	var total float64
+	var best Dialect
+
+	for i, val := range self {
+		total += val
+		if val > self[best] {
+			best = Dialect(i)
+		}
+	}
+
+	if total == 0 {
+		return DialectAny, 0
+	}
+	return best, self[best] / total
+}
+
+/*
+Heuristic for MSSQL-style identifiers in brackets, such as "[some name]". In
+Postgres, brackets are used for array subscripts and array types, which
+directly follow an expression, such as "val[1]" or "int[]".
+*/
+func isBracketIdent(src string, prev Token, rest string) bool {
+	if prev.Type == TypeText {
+		text := prev.Slice(src)
+		if text != `` && charsetIdent.has(text[len(text)-1]) && prev.Region[1] == len(src)-len(rest)-1 {
+			return false
+		}
+	}
+	if prev.Type == TypeBracketClose || prev.Type == TypeParenClose {
+		return false
+	}
+
+	end := strings.IndexByte(rest, bracketClose)
+	return end > 0 && len(prefixIdent(rest)) > 0 && !strings.ContainsAny(rest[:end], `[(:'"`)
+}
+
+// Returns the first identifier in brackets in the source, as determined by
+// `isBracketIdent`, or an empty string.
+func findBracketIdent(src string) (_ string, err error) {
+	defer rec(&err)
+
+	tokenizer := Tokenizer{Source: src}
+	var prev Token
+
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			return ``, nil
+		}
+
+		if tok.Type == TypeBracketOpen {
+			rest := tokenizer.Rest()
+			if isBracketIdent(src, prev, rest) {
+				return src[tok.Region[0] : tok.Region[1]+strings.IndexByte(rest, bracketClose)+1], nil
+			}
+		}
+
+		if tok.Type != TypeWhitespace {
+			prev = tok
+		}
+	}
+}
+
+/*
+Converts quoted identifiers and strings from one dialect to another. For
+example, when converting from MySQL to Postgres, grave-quoted identifiers
+become double-quoted, and double-quoted strings become single-quoted. Escape
+sequences are converted as needed, including doubled quotes and MySQL and
+BigQuery backslash escapes. BigQuery triple-quoted strings and national strings
+such as N'text' become regular strings, and hash comments become regular line
+comments, in dialects which don't support them. Walks the AST deeply. Doesn't
+modify the input.
+
+Returns an error for constructs that can't be converted, such as grave quotes
+in a dialect where they're not valid, or MySQL escape sequences that have no
+equivalent in standard strings. Both dialects must be specific; `DialectAny`
+is not supported. In MSSQL, brackets are treated as quoted identifiers.
+*/
+func TranspileQuotes(nodes Nodes, from, to Dialect) (_ Nodes, err error) {
+	defer rec(&err)
+	reqSpecificDialect(from)
+	reqSpecificDialect(to)
+	return transpileQuotes(nodes, from, to), nil
+}
+
+func transpileQuotes(nodes Nodes, from, to Dialect) Nodes {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(nodes))
+
+	for i := 0; i < len(nodes); i++ {
+		switch node := nodes[i].(type) {
+		case NodeQuoteSingle:
+			var val string
+			val, i = mergeQuoted(nodes, i, `'`)
+			out = append(out, quoteString(to, unescapeString(from, val)))
+
+		case NodeQuoteDouble:
+			var val string
+			val, i = mergeQuoted(nodes, i, `"`)
+			if from == DialectMySQL || from == DialectBigQuery {
+				out = append(out, quoteString(to, unescapeString(from, val)))
+			} else {
+				out = append(out, quoteIdent(to, val))
+			}
+
+		case NodeQuoteGrave:
+			if !node.ValidIn(from) {
+				panic(fmt.Errorf(`[sqlp] grave quotes are not valid in dialect %q: %v`, from, node))
+			}
+			var val string
+			val, i = mergeQuoted(nodes, i, "`")
+			if from == DialectBigQuery {
+				val = unescapeString(from, val)
+			}
+			out = append(out, quoteIdent(to, val))
+
+		case NodeQuoteSingleTriple:
+			out = append(out, transpileTriple(node, string(node), to))
+
+		case NodeQuoteDoubleTriple:
+			out = append(out, transpileTriple(node, string(node), to))
+
+		case NodeQuoteNational:
+			if node.ValidIn(to) {
+				out = append(out, node)
+			} else {
+				out = append(out, quoteString(to, unescapeString(from, strings.ReplaceAll(node.Text, `''`, `'`))))
+			}
+
+		case NodeQuoteEscape:
+			if node.ValidIn(to) {
+				out = append(out, node)
+			} else {
+				out = append(out, quoteString(to, unescapePostgres(node.Text)))
+			}
+
+		case NodeCommentHash:
+			if node.ValidIn(to) {
+				out = append(out, node)
+			} else {
+				out = append(out, NodeCommentLine(node))
+			}
+
+		case BracketNodes:
+			if from == DialectMSSQL {
+				out = append(out, quoteIdent(to, node.Nodes().String()))
+			} else {
+				out = append(out, BracketNodes(transpileQuotes(Nodes(node), from, to)))
+			}
+
+		case ParenNodes:
+			out = append(out, ParenNodes(transpileQuotes(Nodes(node), from, to)))
+
+		case BraceNodes:
+			out = append(out, BraceNodes(transpileQuotes(Nodes(node), from, to)))
+
+		case DelimNodes:
+			node.Inner = transpileQuotes(node.Inner, from, to)
+			out = append(out, node)
+
+		case Nodes:
+			out = append(out, transpileQuotes(node, from, to))
+
+		default:
+			out = append(out, node)
+		}
+	}
+
+	return out
+}
+
+// Triple quotes are generated only for BigQuery.
+func transpileTriple(node Node, val string, to Dialect) Node {
+	if to == DialectBigQuery {
+		return node
+	}
+	return quoteString(to, unescapeString(DialectBigQuery, val))
+}
+
+/*
+Merges a run of adjacent quoted nodes of the same type, which the tokenizer
+produces for quotes escaped by doubling. Returns the unescaped content and the
+index of the last merged node.
+*/
+func mergeQuoted(nodes Nodes, index int, quote string) (string, int) {
+	var buf strings.Builder
+	buf.WriteString(quotedContent(nodes[index]))
+
+	for index+1 < len(nodes) && sameType(nodes[index], nodes[index+1]) {
+		index++
+		buf.WriteString(quote)
+		buf.WriteString(quotedContent(nodes[index]))
+	}
+	return buf.String(), index
+}
+
+func quotedContent(node Node) string {
+	switch node := node.(type) {
+	case NodeQuoteSingle:
+		return string(node)
+	case NodeQuoteDouble:
+		return string(node)
+	case NodeQuoteGrave:
+		return string(node)
+	default:
+		return ``
+	}
+}
+
+func sameType(one, two Node) bool {
+	switch one.(type) {
+	case NodeQuoteSingle:
+		_, ok := two.(NodeQuoteSingle)
+		return ok
+	case NodeQuoteDouble:
+		_, ok := two.(NodeQuoteDouble)
+		return ok
+	case NodeQuoteGrave:
+		_, ok := two.(NodeQuoteGrave)
+		return ok
+	default:
+		return false
+	}
+}
+
+func quoteIdent(dialect Dialect, val string) Node {
+	switch dialect {
+	case DialectMySQL:
+		return NodeQuoteGrave(strings.ReplaceAll(val, "`", "``"))
+	case DialectBigQuery:
+		return NodeQuoteGrave(bigQueryIdentEscaper.Replace(val))
+	default:
+		return quoteIdentPart(val)
+	}
+}
+
+func quoteString(dialect Dialect, val string) Node {
+	switch dialect {
+	case DialectMySQL:
+		val = strings.ReplaceAll(val, `\`, `\\`)
+	case DialectBigQuery:
+		// BigQuery doesn't support doubled quotes, or newlines in strings other
+		// than triple-quoted.
+		return NodeQuoteSingle(bigQueryStringEscaper.Replace(val))
+	}
+	return NodeQuoteSingle(strings.ReplaceAll(val, `'`, `''`))
+}
+
+var (
+	bigQueryStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+	bigQueryIdentEscaper  = strings.NewReplacer(`\`, `\\`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+)
+
+/*
+Converts MySQL and BigQuery backslash escapes to the characters they represent.
+For other dialects, returns the input as-is.
+*/
+func unescapeString(dialect Dialect, val string) string {
+	if !(dialect == DialectMySQL || dialect == DialectBigQuery) || !strings.Contains(val, `\`) {
+		return val
+	}
+	if dialect == DialectBigQuery {
+		return unescapeBigQuery(val)
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(val); i++ {
+		char := val[i]
+		if char != '\\' {
+			buf.WriteByte(char)
+			continue
+		}
+
+		i++
+		if i >= len(val) {
+			panic(fmt.Errorf(`[sqlp] unexpected trailing backslash in %q`, val))
+		}
+
+		switch val[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'Z':
+			buf.WriteByte('\x1a')
+		case '%', '_':
+			buf.WriteByte('\\')
+			buf.WriteByte(val[i])
+		case '0':
+			panic(fmt.Errorf(`[sqlp] can't convert escape sequence %q in %q`, `\0`, val))
+		default:
+			buf.WriteByte(val[i])
+		}
+	}
+	return buf.String()
+}
+
+/*
+BigQuery escape sequences are the same as in Go string literals, with the
+addition of "\`" and "\?", and with "\'" and "\"" allowed in any quotes.
+*/
+func unescapeBigQuery(src string) string {
+	var buf strings.Builder
+	val := src
+
+	for len(val) > 0 {
+		if val[0] != '\\' {
+			buf.WriteByte(val[0])
+			val = val[1:]
+			continue
+		}
+
+		if len(val) > 1 && strings.IndexByte("'\"`?", val[1]) >= 0 {
+			buf.WriteByte(val[1])
+			val = val[2:]
+			continue
+		}
+
+		char, multibyte, tail, err := strconv.UnquoteChar(val, 0)
+		if err != nil {
+			panic(fmt.Errorf(`[sqlp] invalid escape sequence in %q: %w`, src, err))
+		}
+		if multibyte {
+			buf.WriteRune(char)
+		} else {
+			buf.WriteByte(byte(char))
+		}
+		val = tail
+	}
+	return buf.String()
+}
+
+/*
+Unescapes the content of a Postgres escape string such as E'it\'s'. Supports
+quotes escaped by doubling, and the following escape sequences: \b, \f, \n,
+\r, \t, octal \o to \ooo, hex \xh and \xhh, Unicode \uXXXX and \UXXXXXXXX.
+Any other escaped character stands for itself.
+*/
+func unescapePostgres(src string) string {
+	var buf strings.Builder
+	val := src
+
+	for len(val) > 0 {
+		char := val[0]
+		if char == quoteSingle && len(val) > 1 && val[1] == quoteSingle {
+			buf.WriteByte(quoteSingle)
+			val = val[2:]
+			continue
+		}
+		if char != '\\' {
+			buf.WriteByte(char)
+			val = val[1:]
+			continue
+		}
+		if len(val) < 2 {
+			panic(fmt.Errorf(`[sqlp] unexpected trailing backslash in %q`, src))
+		}
+
+		char = val[1]
+		val = val[2:]
+
+		switch char {
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			size := 1
+			for size < 3 && size-1 < len(val) && charsetDigitOct.has(val[size-1]) {
+				size++
+			}
+			buf.WriteByte(byte(parseEscapeCode(src, string(char)+val[:size-1], 8)))
+			val = val[size-1:]
+		case 'x':
+			size := 0
+			for size < 2 && size < len(val) && charsetDigitHex.has(val[size]) {
+				size++
+			}
+			if size == 0 {
+				buf.WriteByte(char)
+				continue
+			}
+			buf.WriteByte(byte(parseEscapeCode(src, val[:size], 16)))
+			val = val[size:]
+		case 'u', 'U':
+			size := 4
+			if char == 'U' {
+				size = 8
+			}
+			if len(val) < size {
+				panic(fmt.Errorf(`[sqlp] invalid Unicode escape sequence in %q`, src))
+			}
+			buf.WriteRune(rune(parseEscapeCode(src, val[:size], 16)))
+			val = val[size:]
+		default:
+			buf.WriteByte(char)
+		}
+	}
+	return buf.String()
+}
+
+func parseEscapeCode(src string, digits string, base int) uint64 {
+	val, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		panic(fmt.Errorf(`[sqlp] invalid escape sequence in %q: %w`, src, err))
+	}
+	return val
+}
+
+func reqSpecificDialect(val Dialect) {
+	switch val {
+	case DialectPostgres, DialectMySQL, DialectSQLite, DialectMSSQL, DialectANSI, DialectOracle, DialectBigQuery:
+	default:
+		panic(fmt.Errorf(`[sqlp] expected a specific dialect, got %q`, val))
+	}
+}
@@ -0,0 +1,158 @@
+package sqlp
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	eachKeyword = `each`
+	eachElem    = `.`
+)
+
+/*
+Expands repeated fragments, once per element of the list with the given name.
+A repeated fragment is a brace node beginning with the keyword "each", followed
+by the list name, an optional separator in single quotes, a colon, and the
+fragment. Inside the fragment, "{.}" stands for the current element:
+
+	insert into users (id, name) values ($1, $2)
+	on conflict (id) do update set {each cols: {.} = excluded.{.}}
+
+	select * from users where {each conds ' and ': {.}}
+
+The default separator is ", ". An empty list produces no output. Fragments may
+contain other repeated fragments and slots, and may occur in parens and other
+collections. Elements are inserted as-is; to insert identifiers, use `Idents`,
+which quotes them. Returns an error if a fragment refers to a missing list, or
+if the lists include names which don't match any fragment, reported in sorted
+order. Doesn't modify the input.
+
+Example:
+
+	nodes, err := sqlp.ExpandEach(nodes, map[string][]sqlp.Node{
+		`cols`: sqlp.Idents(`name`, `email`),
+	})
+	// ... do update set "name" = excluded."name", "email" = excluded."email"
+*/
+func ExpandEach(nodes Nodes, lists map[string][]Node) (_ Nodes, err error) {
+	defer rec(&err)
+
+	expander := eachExpander{lists: lists, used: map[string]bool{}}
+	out := expander.nodes(nodes, nil)
+
+	var unknown []string
+	for name := range lists {
+		if !expander.used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf(`[sqlp] unknown lists %q`, unknown)
+	}
+	return out, nil
+}
+
+/*
+Converts identifiers, such as column names, into nodes quoted via `QuoteIdent`,
+for use with `ExpandEach`.
+*/
+func Idents(names ...string) []Node {
+	out := make([]Node, len(names))
+	for i, name := range names {
+		out[i] = QuoteIdent(name)
+	}
+	return out
+}
+
+type eachExpander struct {
+	lists map[string][]Node
+	used  map[string]bool
+}
+
+// Expands fragments, replacing "{.}" with the given element when non-nil.
+func (self *eachExpander) nodes(nodes Nodes, elem Node) Nodes {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(nodes))
+	for _, node := range nodes {
+		if elem != nil && isEachElem(node) {
+			out = append(out, CopyNode(elem))
+			continue
+		}
+
+		name, sep, fragment, ok := parseEach(node)
+		if !ok {
+			out = append(out, self.node(node, elem))
+			continue
+		}
+
+		list, ok := self.lists[name]
+		if !ok {
+			panic(fmt.Errorf(`[sqlp] missing list %q`, name))
+		}
+		self.used[name] = true
+
+		for i, val := range list {
+			if i > 0 {
+				out = append(out, sep...)
+			}
+			out = append(out, self.nodes(fragment, val)...)
+		}
+	}
+	return out
+}
+
+func (self *eachExpander) node(node Node, elem Node) Node {
+	switch node := node.(type) {
+	case Nodes:
+		return self.nodes(node, elem)
+	case ParenNodes:
+		return ParenNodes(self.nodes(Nodes(node), elem))
+	case BracketNodes:
+		return BracketNodes(self.nodes(Nodes(node), elem))
+	case BraceNodes:
+		return BraceNodes(self.nodes(Nodes(node), elem))
+	case DelimNodes:
+		node.Inner = self.nodes(node.Inner, elem)
+		return node
+	default:
+		return CopyNode(node)
+	}
+}
+
+/*
+If the node is a repeated fragment, returns the list name, the separator, and
+the fragment. Panics if the node begins with the keyword but is malformed.
+*/
+func parseEach(node Node) (string, Nodes, Nodes, bool) {
+	name, rest, ok := parseBraceDirective(node, eachKeyword)
+	if !ok {
+		return ``, nil, nil, false
+	}
+
+	sep := Nodes{NodeText(`,`), nodeWhitespaceSingle}
+	index := skipTrivia(rest, 0)
+	if quote, ok := nodeAt(rest, index).(NodeQuoteSingle); ok {
+		sep = Nodes{NodeText(quote)}
+		rest = rest[index+1:]
+	}
+
+	fragment, ok := afterColon(rest)
+	if !ok {
+		panic(fmt.Errorf(`[sqlp] invalid each %q: expected ":" before fragment`, node))
+	}
+	return name, sep, fragment, true
+}
+
+func isEachElem(node Node) bool {
+	brace, ok := node.(BraceNodes)
+	if !ok {
+		return false
+	}
+	mid, _, _ := trimWhitespace(Nodes(brace))
+	return len(mid) == 1 && mid[0] == NodeText(eachElem)
+}
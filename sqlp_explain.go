@@ -0,0 +1,127 @@
+package sqlp
+
+import "strings"
+
+// Options for `Explained`.
+type ExplainOptions struct {
+	// Dialect which determines the EXPLAIN syntax.
+	Dialect Dialect
+
+	// Actually execute the statement and report runtime statistics.
+	Analyze bool
+
+	// Report buffer usage. Postgres only.
+	Buffers bool
+
+	// Output format such as "json", "text", "yaml". Postgres and MySQL only.
+	Format string
+
+	// When true, writing statements with `ExplainOptions.Analyze` are wrapped
+	// into a transaction which is rolled back. When false, they're skipped.
+	WrapDML bool
+}
+
+/*
+Returns the given statement prefixed with EXPLAIN in the syntax of the
+configured dialect:
+
+	DialectPostgres : explain (analyze, buffers, format json) <stmt>
+	DialectMySQL    : explain analyze format=json <stmt>
+	DialectSQLite   : explain query plan <stmt>
+	DialectOracle   : explain plan for <stmt>
+
+`DialectAny` and `DialectANSI` use the Postgres syntax. Options not supported
+by the dialect are ignored. MSSQL has no EXPLAIN statement, and is not
+supported.
+
+EXPLAIN ANALYZE executes the statement. To avoid accidental writes, statements
+which are not read-only according to `IsReadOnly`, such as INSERT, UPDATE,
+DELETE, or SELECT with data-modifying CTEs, are either wrapped into a
+transaction which is rolled back, or skipped, depending on
+`ExplainOptions.WrapDML`:
+
+	begin; explain analyze <stmt>; rollback;
+
+Returns nil when skipping, and for unsupported dialects. Trailing whitespace,
+comments, and semicolons are preserved, except when wrapping. Doesn't modify
+the input.
+*/
+func Explained(nodes Nodes, opts ExplainOptions) Nodes {
+	prefix := explainPrefix(opts)
+	if prefix == nil {
+		return nil
+	}
+
+	body, tail := splitTrailing(nodes)
+	_, lead, _ := trimWhitespace(body)
+	if len(lead) > 0 {
+		body = body[len(lead):]
+	}
+
+	if !opts.Analyze || IsReadOnly(body) {
+		out := make(Nodes, 0, len(prefix)+len(nodes))
+		out = append(out, lead...)
+		out = append(out, prefix...)
+		out = append(out, body...)
+		return append(out, tail...)
+	}
+
+	if !opts.WrapDML {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(prefix)+len(body)+6)
+	out = append(out, lead...)
+	out = append(out, NodeText(`begin;`), nodeWhitespaceSingle)
+	out = append(out, prefix...)
+	out = append(out, body...)
+	out = append(out, NodeText(`;`), nodeWhitespaceSingle, NodeText(`rollback;`))
+	return out
+}
+
+func explainPrefix(opts ExplainOptions) Nodes {
+	out := Nodes{NodeText(`explain`), nodeWhitespaceSingle}
+
+	switch opts.Dialect {
+	case DialectAny, DialectPostgres, DialectANSI:
+		var list Nodes
+		add := func(vals ...Node) {
+			if len(list) > 0 {
+				list = append(list, NodeText(`,`), nodeWhitespaceSingle)
+			}
+			list = append(list, vals...)
+		}
+
+		if opts.Analyze {
+			add(NodeText(`analyze`))
+		}
+		if opts.Buffers {
+			add(NodeText(`buffers`))
+		}
+		if opts.Format != `` {
+			add(NodeText(`format`), nodeWhitespaceSingle, NodeText(strings.ToLower(opts.Format)))
+		}
+		if len(list) > 0 {
+			out = append(out, ParenNodes(list), nodeWhitespaceSingle)
+		}
+		return out
+
+	case DialectMySQL:
+		if opts.Analyze {
+			out = append(out, NodeText(`analyze`), nodeWhitespaceSingle)
+		}
+		if opts.Format != `` {
+			out = append(out, NodeText(`format=`+strings.ToLower(opts.Format)), nodeWhitespaceSingle)
+		}
+		return out
+
+	case DialectSQLite:
+		return append(out, NodeText(`query`), nodeWhitespaceSingle, NodeText(`plan`), nodeWhitespaceSingle)
+
+	case DialectOracle:
+		return append(out, NodeText(`plan`), nodeWhitespaceSingle, NodeText(`for`), nodeWhitespaceSingle)
+
+	default:
+		return nil
+	}
+}
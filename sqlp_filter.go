@@ -0,0 +1,339 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Allowlist for validating user-supplied filter fragments before splicing them
+into queries, for example via `AndWhere`. See `FilterPolicy.Validate`.
+*/
+type FilterPolicy struct {
+	/*
+		Allowed columns. Unquoted columns in the filter are matched ignoring
+		case, and quoted columns are matched exactly. Qualified columns such as
+		"u.name" must be listed with the qualifier.
+	*/
+	Columns []string
+
+	/*
+		Allowed operators. Supported operators are "=", "<>", "!=", "<", ">",
+		"<=", ">=", "like", "ilike", "in", and "is", where "is" allows "is null"
+		and "is not null". Operators must be listed in lowercase.
+	*/
+	Operators []string
+}
+
+/*
+Parses the filter via `Parse` and validates it via `FilterPolicy.Validate`.
+*/
+func (self FilterPolicy) Parse(src string) (Nodes, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	err = self.Validate(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+/*
+Validates a user-supplied filter fragment, such as a WHERE condition, against
+the allowlist, and returns an error describing the first violation. Only the
+following grammar is accepted:
+
+	filter     = term {("and" | "or") term}
+	term       = {"not"} ("(" filter ")" | predicate)
+	predicate  = column operator value
+	           | column ["not"] ("like" | "ilike") value
+	           | column ["not"] "in" "(" value {"," value} ")"
+	           | column "is" ["not"] "null"
+	value      = parameter | 'string' | number | "true" | "false"
+
+Anything else is rejected, including function calls, subqueries, casts,
+comments, operators and columns not in the allowlist, and comparisons between
+columns. Keywords are case-insensitive. An empty filter is rejected. Rejecting
+comments ensures that the filter can't swallow the rest of the query. Strings
+and quoted columns containing backslashes are rejected, since backslash escapes
+are supported in some dialects but not in others, which would allow a string
+to end in a different place than validated.
+*/
+func (self FilterPolicy) Validate(nodes Nodes) (err error) {
+	defer rec(&err)
+
+	parser := filterParser{policy: &self, tokens: lexFilter(nodes)}
+	if len(parser.tokens) == 0 {
+		return fmt.Errorf(`[sqlp] invalid filter: empty filter`)
+	}
+	parser.filter()
+	parser.end()
+	return nil
+}
+
+type filterTokenKind byte
+
+const (
+	filterWord filterTokenKind = iota
+	filterOperator
+	filterSign
+	filterComma
+	filterValue
+	filterParen
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	node Node
+}
+
+/*
+Converts the nodes into filter tokens, splitting text nodes into words,
+operators, signs, and commas. Panics on disallowed nodes and characters.
+Adjacent single-quoted nodes, which represent a string with doubled quotes,
+form one value.
+*/
+func lexFilter(nodes Nodes) []filterToken {
+	var out []filterToken
+
+	for i, node := range nodes {
+		switch node.(type) {
+		case NodeQuoteSingle, NodeQuoteDouble:
+			if strings.Contains(node.String(), `\`) {
+				panic(fmt.Errorf(`[sqlp] invalid filter: ambiguous backslash in %q`, node))
+			}
+		}
+
+		switch node := node.(type) {
+		case NodeWhitespace:
+		case NodeText:
+			out = lexFilterText(out, string(node))
+		case NodeQuoteSingle:
+			if i > 0 {
+				if _, ok := nodes[i-1].(NodeQuoteSingle); ok {
+					continue
+				}
+			}
+			out = append(out, filterToken{filterValue, node.String(), node})
+		case NodeOrdinalParam, NodeNumericParam, NodeNamedParam:
+			out = append(out, filterToken{filterValue, node.String(), node})
+		case NodeQuoteDouble:
+			out = append(out, filterToken{filterWord, node.String(), node})
+		case ParenNodes:
+			out = append(out, filterToken{filterParen, node.String(), node})
+		default:
+			panic(fmt.Errorf(`[sqlp] invalid filter: unexpected %q`, node))
+		}
+	}
+	return out
+}
+
+func lexFilterText(out []filterToken, src string) []filterToken {
+	for len(src) > 0 {
+		char := src[0]
+		var size int
+		var kind filterTokenKind
+
+		switch {
+		case charsetIdentLike.has(char) || char == '.':
+			for size < len(src) && (charsetIdentLike.has(src[size]) || src[size] == '.') {
+				size++
+			}
+			kind = filterWord
+		case strings.IndexByte(`<>=!`, char) >= 0:
+			for size < len(src) && strings.IndexByte(`<>=!`, src[size]) >= 0 {
+				size++
+			}
+			kind = filterOperator
+		case char == '-' || char == '+':
+			size, kind = 1, filterSign
+		case char == ',':
+			size, kind = 1, filterComma
+		default:
+			panic(fmt.Errorf(`[sqlp] invalid filter: unexpected %q`, src))
+		}
+
+		out = append(out, filterToken{kind: kind, text: src[:size]})
+		src = src[size:]
+	}
+	return out
+}
+
+type filterParser struct {
+	policy *FilterPolicy
+	tokens []filterToken
+	cursor int
+}
+
+func (self *filterParser) filter() {
+	self.term()
+	for self.maybeWord(`and`, `or`) {
+		self.term()
+	}
+}
+
+func (self *filterParser) term() {
+	for self.maybeWord(`not`) {
+	}
+
+	tok := self.next()
+	if tok.kind == filterParen {
+		inner := filterParser{policy: self.policy, tokens: lexFilter(Nodes(tok.node.(ParenNodes)))}
+		inner.filter()
+		inner.end()
+		return
+	}
+	self.predicate(tok)
+}
+
+func (self *filterParser) predicate(col filterToken) {
+	self.column(col)
+
+	tok := self.next()
+	if tok.kind == filterOperator {
+		self.operator(tok.text)
+		self.value()
+		return
+	}
+
+	if isFilterWord(tok, `is`) {
+		self.operator(`is`)
+		self.maybeWord(`not`)
+		self.reqWord(`null`)
+		return
+	}
+
+	if isFilterWord(tok, `not`) {
+		tok = self.next()
+	}
+
+	switch {
+	case isFilterWord(tok, `like`, `ilike`):
+		self.operator(strings.ToLower(tok.text))
+		self.value()
+	case isFilterWord(tok, `in`):
+		self.operator(`in`)
+		self.list()
+	default:
+		panic(unexpectedFilterToken(tok))
+	}
+}
+
+func (self *filterParser) column(tok filterToken) {
+	if tok.kind != filterWord || isDecimal(tok.text) || isFilterWord(tok, filterKeywords...) {
+		panic(unexpectedFilterToken(tok))
+	}
+
+	for _, val := range self.policy.Columns {
+		quoted, ok := tok.node.(NodeQuoteDouble)
+		if ok && string(quoted) == val || !ok && strings.EqualFold(tok.text, val) {
+			return
+		}
+	}
+	panic(fmt.Errorf(`[sqlp] invalid filter: column %q is not allowed`, tok.text))
+}
+
+func (self *filterParser) operator(op string) {
+	if !hasString(filterOperators, op) {
+		panic(fmt.Errorf(`[sqlp] invalid filter: unsupported operator %q`, op))
+	}
+	if !hasString(self.policy.Operators, op) {
+		panic(fmt.Errorf(`[sqlp] invalid filter: operator %q is not allowed`, op))
+	}
+}
+
+func (self *filterParser) value() {
+	tok := self.next()
+	if tok.kind == filterSign {
+		tok = self.next()
+		if tok.kind != filterWord || !isDecimal(tok.text) {
+			panic(unexpectedFilterToken(tok))
+		}
+		return
+	}
+
+	if tok.kind == filterValue || isFilterWord(tok, `true`, `false`) ||
+		tok.kind == filterWord && tok.node == nil && isDecimal(tok.text) {
+		return
+	}
+	panic(unexpectedFilterToken(tok))
+}
+
+func (self *filterParser) list() {
+	tok := self.next()
+	if tok.kind != filterParen {
+		panic(unexpectedFilterToken(tok))
+	}
+
+	inner := filterParser{policy: self.policy, tokens: lexFilter(Nodes(tok.node.(ParenNodes)))}
+	inner.value()
+	for inner.more() {
+		if inner.next().kind != filterComma {
+			panic(unexpectedFilterToken(inner.tokens[inner.cursor-1]))
+		}
+		inner.value()
+	}
+}
+
+func (self *filterParser) maybeWord(words ...string) bool {
+	if self.more() && isFilterWord(self.tokens[self.cursor], words...) {
+		self.cursor++
+		return true
+	}
+	return false
+}
+
+func (self *filterParser) reqWord(word string) {
+	tok := self.next()
+	if !isFilterWord(tok, word) {
+		panic(unexpectedFilterToken(tok))
+	}
+}
+
+func (self *filterParser) next() filterToken {
+	if !self.more() {
+		panic(fmt.Errorf(`[sqlp] invalid filter: unexpected end of filter`))
+	}
+	tok := self.tokens[self.cursor]
+	self.cursor++
+	return tok
+}
+
+func (self *filterParser) end() {
+	if self.more() {
+		panic(unexpectedFilterToken(self.tokens[self.cursor]))
+	}
+}
+
+func (self *filterParser) more() bool { return self.cursor < len(self.tokens) }
+
+// True if the token is an unquoted word equal to one of the given keywords,
+// ignoring case.
+func isFilterWord(tok filterToken, words ...string) bool {
+	if tok.kind != filterWord || tok.node != nil {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(tok.text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func unexpectedFilterToken(tok filterToken) error {
+	return fmt.Errorf(`[sqlp] invalid filter: unexpected %q`, tok.text)
+}
+
+var (
+	filterOperators = []string{
+		`=`, `<>`, `!=`, `<`, `>`, `<=`, `>=`, `like`, `ilike`, `in`, `is`,
+	}
+
+	filterKeywords = []string{
+		`and`, `or`, `not`, `is`, `in`, `like`, `ilike`, `null`, `true`, `false`,
+	}
+)
@@ -0,0 +1,431 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Structured view of the list in an IN or NOT IN expression, such as
+"(10, 20, 30)" in "id in (10, 20, 30)". Found by `InLists` and provided to the
+callback of `RewriteInLists`. Lists which contain a subquery, such as
+"id in (select ...)", are not considered IN lists.
+
+The elements and the separators between them are stored separately, which
+allows to modify the list without dealing with commas and whitespace. For
+example, to remove an element, remove it together with the preceding
+separator. `InList.Paren` rebuilds the list, and produces the original text
+if the view is unmodified.
+*/
+type InList struct {
+	// True for "not in".
+	Not bool
+
+	// Elements of the list, without surrounding whitespace and comments.
+	Elems []Nodes
+
+	/*
+		Separators between consecutive elements. Each includes a comma, and any
+		whitespace and comments around it. Normally has one fewer entry than
+		`InList.Elems`. Missing separators are rendered as ", ".
+	*/
+	Seps []Nodes
+
+	// Whitespace and comments after the opening paren.
+	Lead Nodes
+
+	// Whitespace and comments before the closing paren.
+	Trail Nodes
+}
+
+// Rebuilds the list, including the parens, from its parts.
+func (self InList) Paren() ParenNodes {
+	out := make(ParenNodes, 0, len(self.Lead)+len(self.Trail)+len(self.Elems)*2)
+	out = append(out, self.Lead...)
+
+	for i, elem := range self.Elems {
+		if i > 0 {
+			if i-1 < len(self.Seps) {
+				out = append(out, self.Seps[i-1]...)
+			} else {
+				out = append(out, NodeText(`,`), nodeWhitespaceSingle)
+			}
+		}
+		out = append(out, elem...)
+	}
+
+	return append(out, self.Trail...)
+}
+
+/*
+Returns a copy of the list without duplicate elements, keeping the first
+occurrence of each. Elements are compared by their text, ignoring surrounding
+whitespace, so "1" and "01" are considered different. The separator before
+each removed element is removed along with it.
+*/
+func (self InList) Dedup() InList {
+	out := self
+	out.Elems = make([]Nodes, 0, len(self.Elems))
+	out.Seps = make([]Nodes, 0, len(self.Seps))
+	seen := make(map[string]bool, len(self.Elems))
+
+	for i, elem := range self.Elems {
+		key := elem.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if len(out.Elems) > 0 && i-1 < len(self.Seps) {
+			out.Seps = append(out.Seps, self.Seps[i-1])
+		}
+		out.Elems = append(out.Elems, elem)
+	}
+	return out
+}
+
+/*
+Finds every IN and NOT IN list at any nesting level, in order of occurrence,
+and returns their structured views. See `InList`.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users where id in (10, 20) and role not in ('admin')`)
+	...
+	lists := sqlp.InLists(nodes)
+	// lists[0].Elems: []Nodes{{NodeText("10")}, {NodeText("20")}}
+	// lists[1].Not:   true
+	// lists[1].Elems: []Nodes{{NodeQuoteSingle("admin")}}
+*/
+func InLists(nodes Nodes) []InList {
+	var out []InList
+	appendInLists(&out, nodes)
+	return out
+}
+
+func appendInLists(out *[]InList, nodes Nodes) {
+	for i, node := range nodes {
+		if _, list, ok := inListAt(nodes, i); ok {
+			*out = append(*out, list)
+		}
+
+		switch node := node.(type) {
+		case Nodes:
+			appendInLists(out, node)
+		case ParenNodes:
+			appendInLists(out, Nodes(node))
+		case BracketNodes:
+			appendInLists(out, Nodes(node))
+		case BraceNodes:
+			appendInLists(out, Nodes(node))
+		case DelimNodes:
+			appendInLists(out, node.Inner)
+		}
+	}
+}
+
+/*
+Replaces every IN and NOT IN expression at any nesting level with the nodes
+returned by the callback. The replaced range begins with the keyword "in" or
+"not", and ends with the closing paren; the left operand is kept. If the
+callback returns nil, the expression is kept as-is. Lists nested inside other
+lists are rewritten first. Doesn't modify the input.
+
+Example of removing duplicates:
+
+	out := sqlp.RewriteInLists(nodes, func(list sqlp.InList) sqlp.Nodes {
+		if list.Not {
+			return sqlp.Nodes{sqlp.NodeText(`not in`), list.Dedup().Paren()}
+		}
+		return sqlp.Nodes{sqlp.NodeText(`in`), list.Dedup().Paren()}
+	})
+*/
+func RewriteInLists(nodes Nodes, fun func(InList) Nodes) Nodes {
+	if nodes == nil || fun == nil {
+		return nodes
+	}
+
+	out := make(Nodes, 0, len(nodes))
+	for _, node := range nodes {
+		out = append(out, rewriteInListsInner(node, fun))
+
+		start, list, ok := inListAt(out, len(out)-1)
+		if !ok {
+			continue
+		}
+
+		repl := fun(list)
+		if repl != nil {
+			out = append(out[:start], repl...)
+		}
+	}
+	return out
+}
+
+func rewriteInListsInner(node Node, fun func(InList) Nodes) Node {
+	switch node := node.(type) {
+	case Nodes:
+		return RewriteInLists(node, fun)
+	case ParenNodes:
+		return ParenNodes(RewriteInLists(Nodes(node), fun))
+	case BracketNodes:
+		return BracketNodes(RewriteInLists(Nodes(node), fun))
+	case BraceNodes:
+		return BraceNodes(RewriteInLists(Nodes(node), fun))
+	case DelimNodes:
+		node.Inner = RewriteInLists(node.Inner, fun)
+		return node
+	default:
+		return CopyNode(node)
+	}
+}
+
+/*
+If the node at the given index is the list of an IN or NOT IN expression,
+returns the index of the keyword "in" or "not" and the view of the list.
+*/
+func inListAt(nodes Nodes, index int) (int, InList, bool) {
+	paren, ok := nodes[index].(ParenNodes)
+	if !ok {
+		return 0, InList{}, false
+	}
+
+	start := skipTriviaBack(nodes, index-1)
+	if start < 0 || !isKeyword(nodes[start], `in`) {
+		return 0, InList{}, false
+	}
+
+	list, ok := parseInList(Nodes(paren))
+	if !ok {
+		return 0, InList{}, false
+	}
+
+	if prev := skipTriviaBack(nodes, start-1); prev >= 0 && isKeyword(nodes[prev], `not`) {
+		start = prev
+		list.Not = true
+	}
+	return start, list, true
+}
+
+func parseInList(nodes Nodes) (InList, bool) {
+	first := skipTrivia(nodes, 0)
+	if first < len(nodes) && isKeyword(nodes[first], `select`, `with`, `values`, `table`) {
+		return InList{}, false
+	}
+
+	var out InList
+	var groups []Nodes
+	var group Nodes
+
+	for _, node := range splitCommas(nodes) {
+		if node == NodeText(`,`) {
+			groups = append(groups, group)
+			group = Nodes{node}
+			continue
+		}
+		group = append(group, node)
+	}
+	groups = append(groups, group)
+
+	for i, group := range groups {
+		var sep Nodes
+		if i > 0 {
+			sep, group = group[:1], group[1:]
+		}
+
+		start := skipTrivia(group, 0)
+		end := skipTriviaBack(group, len(group)-1) + 1
+		if end < start {
+			end = start
+		}
+
+		if i == 0 {
+			if start == len(group) && len(groups) == 1 {
+				out.Lead = copyNodes(group)
+				return out, true
+			}
+			out.Lead = copyNodes(group[:start])
+		} else {
+			out.Seps[i-1] = append(out.Seps[i-1], sep...)
+			out.Seps[i-1] = append(out.Seps[i-1], group[:start]...)
+		}
+
+		out.Elems = append(out.Elems, copyNodes(group[start:end]))
+
+		if i == len(groups)-1 {
+			out.Trail = copyNodes(group[end:])
+		} else {
+			out.Seps = append(out.Seps, copyNodes(group[end:]))
+		}
+	}
+	return out, true
+}
+
+/*
+Splits text nodes around commas, making each comma a separate `NodeText`.
+Returns the input as-is if there's nothing to split.
+*/
+func splitCommas(nodes Nodes) Nodes {
+	var out Nodes
+	for i, node := range nodes {
+		text, ok := node.(NodeText)
+		if !ok || text == NodeText(`,`) || !strings.Contains(string(text), `,`) {
+			if out != nil {
+				out = append(out, node)
+			}
+			continue
+		}
+
+		if out == nil {
+			out = make(Nodes, 0, len(nodes)+2)
+			out = append(out, nodes[:i]...)
+		}
+		for _, part := range strings.SplitAfter(string(text), `,`) {
+			if part == `` {
+				continue
+			}
+			if part != `,` && strings.HasSuffix(part, `,`) {
+				out = append(out, NodeText(part[:len(part)-1]), NodeText(`,`))
+			} else {
+				out = append(out, NodeText(part))
+			}
+		}
+	}
+
+	if out == nil {
+		return nodes
+	}
+	return out
+}
+
+// Index of the last non-trivia node at or before `start`, or -1.
+func skipTriviaBack(nodes Nodes, start int) int {
+	for start >= 0 && isTrivia(nodes[start]) {
+		start--
+	}
+	return start
+}
+
+func copyNodes(nodes Nodes) Nodes {
+	if len(nodes) == 0 {
+		return nil
+	}
+	return append(make(Nodes, 0, len(nodes)), nodes...)
+}
+
+/*
+Rewrites IN lists of consecutive Postgres ordinal parameters into comparisons
+with arrays, reducing the number of placeholders to one per list. Queries
+with large lists then have a stable text regardless of the list length, which
+helps Postgres cache their plans, and avoids the limit of 65535 parameters per
+query. Doesn't modify the input.
+
+	col in ($1, $2, $3)       ->  col = any($1)
+	col not in ($1, $2, $3)   ->  col <> all($1)
+
+A list is converted only if every element is an ordinal parameter, and the
+parameters are consecutive and ascending, such as "($3, $4, $5)". Other lists
+are left as-is. The remaining parameters are renumbered to close the gaps, so
+parameters after a converted list of N elements decrease by N-1.
+
+The caller must repackage the arguments accordingly: for each converted list,
+replace the arguments of its parameters with a single array argument holding
+the same values, in the same order. The arguments of the remaining parameters
+are unchanged. For example, with "lib/pq":
+
+	// where id in ($1, $2, $3) and name = $4
+	args := []interface{}{10, 20, 30, `one`}
+
+	// where id = any($1) and name = $2
+	args = []interface{}{pq.Array([]int{10, 20, 30}), `one`}
+
+Returns an error if a parameter of a convertible list is also used elsewhere
+in the query, since it can't be both an array and a scalar.
+*/
+func ConvertInToAny(nodes Nodes) (_ Nodes, err error) {
+	defer rec(&err)
+
+	counts := map[NodeOrdinalParam]int{}
+	DeepWalkNode(nodes, func(node Node) {
+		if val, ok := node.(NodeOrdinalParam); ok {
+			counts[val]++
+		}
+	})
+
+	var lists []inAnyList
+	out := RewriteInLists(nodes, func(list InList) Nodes {
+		first, ok := ordinalParamRange(list.Elems)
+		if !ok {
+			return nil
+		}
+
+		for i := range list.Elems {
+			param := first + NodeOrdinalParam(i)
+			if counts[param] > 1 {
+				panic(fmt.Errorf(`[sqlp] can't convert IN list to array: parameter %q is also used outside the list`, param))
+			}
+		}
+		lists = append(lists, inAnyList{first, len(list.Elems)})
+
+		if list.Not {
+			return Nodes{NodeText(`<>`), nodeWhitespaceSingle, NodeText(`all`), ParenNodes{first}}
+		}
+		return Nodes{NodeText(`=`), nodeWhitespaceSingle, NodeText(`any`), ParenNodes{first}}
+	})
+
+	if len(lists) == 0 {
+		return out, nil
+	}
+
+	for i := range out {
+		DeepWalkNodePtr(&out[i], func(ptr *Node) {
+			if val, ok := (*ptr).(NodeOrdinalParam); ok {
+				*ptr = renumberInAny(lists, val)
+			}
+		})
+	}
+	return out, nil
+}
+
+// Ordinal parameters of an IN list converted by `ConvertInToAny`.
+type inAnyList struct {
+	first NodeOrdinalParam
+	len   int
+}
+
+/*
+Renumbers a parameter which remains after `ConvertInToAny` by subtracting the
+parameters removed from preceding lists.
+*/
+func renumberInAny(lists []inAnyList, val NodeOrdinalParam) NodeOrdinalParam {
+	out := val
+	for _, list := range lists {
+		if list.first < val {
+			out -= NodeOrdinalParam(list.len - 1)
+		}
+	}
+	return out
+}
+
+/*
+If every element is a single ordinal parameter, and the parameters are
+consecutive and ascending, returns the first parameter.
+*/
+func ordinalParamRange(elems []Nodes) (NodeOrdinalParam, bool) {
+	var first NodeOrdinalParam
+	for i, elem := range elems {
+		if len(elem) != 1 {
+			return 0, false
+		}
+		val, ok := elem[0].(NodeOrdinalParam)
+		if !ok {
+			return 0, false
+		}
+		if i == 0 {
+			first = val
+		} else if val != first+NodeOrdinalParam(i) {
+			return 0, false
+		}
+	}
+	return first, len(elems) > 0
+}
@@ -0,0 +1,168 @@
+package sqlp
+
+import (
+	"encoding/binary"
+	"reflect"
+	"sync"
+)
+
+/*
+Deduplicates ASTs by sharing structurally identical subtrees between them
+(hash-consing). Intended for tools which cache many parsed queries, where
+common fragments such as WHERE conditions and column lists would otherwise be
+stored many times over.
+
+Interned ASTs share memory, and must be treated as immutable. To modify an
+interned AST, copy the parts being modified first: `Nodes.Clone` for top-level
+edits, or `CopyNode` for deep edits. Mutating an interned AST in place affects
+every other AST sharing the same subtrees.
+
+Nodes are identical when they have the same type and the same content.
+Collections such as `Nodes` and `ParenNodes` are compared by their inner nodes.
+Custom nodes whose types are not comparable, such as slices or structs with
+interface fields, are kept as-is and never shared.
+
+The zero value is ready to use. Safe for concurrent use. The interner retains
+every distinct node it has seen; to release memory, drop the interner.
+*/
+type Interner struct {
+	lock   sync.Mutex
+	count  uint64
+	leaves map[Node]internEntry
+	colls  map[internKey]internEntry
+}
+
+/*
+Returns a version of the given node, structurally identical to the original,
+which shares identical subtrees with every other node previously interned by
+the same interner. The returned node may share memory with the input.
+*/
+func (self *Interner) Intern(node Node) Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.intern(node).node
+}
+
+// Shortcut for calling `Interner.Intern` and converting the result to `Nodes`.
+func (self *Interner) InternNodes(nodes Nodes) Nodes {
+	out, _ := self.Intern(nodes).(Nodes)
+	return out
+}
+
+// Returns the count of distinct nodes retained by the interner.
+func (self *Interner) Len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.leaves) + len(self.colls)
+}
+
+// Interned node with a unique id. The id 0 is reserved for nil.
+type internEntry struct {
+	node Node
+	id   uint64
+}
+
+// Key of a collection, which consists of the ids of its interned inner nodes.
+type internKey struct {
+	typ   reflect.Type
+	delim Delim
+	ids   string
+}
+
+func (self *Interner) intern(node Node) internEntry {
+	switch node := node.(type) {
+	case nil:
+		return internEntry{}
+	case Nodes:
+		return self.coll(node, internKey{typ: reflect.TypeOf(node)}, func(val Nodes) Node { return val })
+	case ParenNodes:
+		return self.coll(Nodes(node), internKey{typ: reflect.TypeOf(node)}, func(val Nodes) Node { return ParenNodes(val) })
+	case BracketNodes:
+		return self.coll(Nodes(node), internKey{typ: reflect.TypeOf(node)}, func(val Nodes) Node { return BracketNodes(val) })
+	case BraceNodes:
+		return self.coll(Nodes(node), internKey{typ: reflect.TypeOf(node)}, func(val Nodes) Node { return BraceNodes(val) })
+	case DelimNodes:
+		return self.coll(node.Inner, internKey{typ: reflect.TypeOf(node), delim: node.Delim}, func(val Nodes) Node {
+			return DelimNodes{Delim: node.Delim, Inner: val}
+		})
+	}
+
+	if !isHashable(reflect.TypeOf(node)) {
+		return internEntry{node, self.nextId()}
+	}
+
+	entry, ok := self.leaves[node]
+	if ok {
+		return entry
+	}
+
+	entry = internEntry{node, self.nextId()}
+	if self.leaves == nil {
+		self.leaves = map[Node]internEntry{}
+	}
+	self.leaves[node] = entry
+	return entry
+}
+
+func (self *Interner) coll(nodes Nodes, key internKey, fun func(Nodes) Node) internEntry {
+	// Nil collections are not equivalent to empty ones for `reflect.DeepEqual`,
+	// and are kept as-is.
+	if nodes == nil {
+		return internEntry{fun(nil), self.nextId()}
+	}
+
+	inner := make(Nodes, len(nodes))
+	ids := make([]byte, 0, len(nodes)*2)
+	var buf [binary.MaxVarintLen64]byte
+
+	for i, val := range nodes {
+		entry := self.intern(val)
+		inner[i] = entry.node
+		ids = append(ids, buf[:binary.PutUvarint(buf[:], entry.id)]...)
+	}
+	key.ids = bytesToMutableString(ids)
+
+	entry, ok := self.colls[key]
+	if ok {
+		return entry
+	}
+
+	entry = internEntry{fun(inner), self.nextId()}
+	if self.colls == nil {
+		self.colls = map[internKey]internEntry{}
+	}
+	self.colls[key] = entry
+	return entry
+}
+
+func (self *Interner) nextId() uint64 {
+	self.count++
+	return self.count
+}
+
+/*
+True if values of the given type can be used as map keys without panicking.
+Unlike `reflect.Type.Comparable`, this excludes interfaces, whose dynamic
+values may be unhashable.
+*/
+func isHashable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.String, reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return isHashable(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if !isHashable(typ.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
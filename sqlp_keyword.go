@@ -0,0 +1,146 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Case-insensitive set of keywords. When assigned to `Tokenizer.Keywords`, the
+tokenizer produces tokens of `TypeKeyword` for words in the set, which the
+parser converts into `NodeKeyword`, instead of leaving them in `TypeText`. Use
+`DialectKeywords` for the built-in sets, `NewKeywords` for custom sets, and
+`Keywords.With` to extend a set. Sets are immutable, and may be shared between
+tokenizers and goroutines. The nil set is empty.
+
+Example:
+
+	parser := sqlp.Parser{Tokenizer: sqlp.Tokenizer{
+		Source:   `select * from users`,
+		Keywords: sqlp.DialectKeywords(sqlp.DialectPostgres),
+	}}
+	nodes, err := parser.Parse()
+	// Nodes{NodeKeyword(`select`), NodeWhitespace(` `), NodeText(`*`), ...}
+*/
+type Keywords struct{ set map[string]struct{} }
+
+/*
+Makes a set of the given keywords. Each keyword must be an unquoted identifier,
+such as "select" or "straight_join", and is matched ignoring case. Panics for
+other words, which can never match.
+*/
+func NewKeywords(words ...string) *Keywords {
+	return (*Keywords)(nil).With(words...)
+}
+
+// Returns a new set which contains the keywords of this set and the given
+// keywords. Doesn't modify this set. See `NewKeywords` for the requirements.
+func (self *Keywords) With(words ...string) *Keywords {
+	out := &Keywords{set: make(map[string]struct{}, self.Len()+len(words))}
+	if self != nil {
+		for word := range self.set {
+			out.set[word] = struct{}{}
+		}
+	}
+
+	for _, word := range words {
+		if word == `` || prefixIdent(word) != word {
+			panic(fmt.Errorf(`[sqlp] invalid keyword %q: expected an unquoted identifier`, word))
+		}
+		out.set[strings.ToLower(word)] = struct{}{}
+	}
+	return out
+}
+
+// True if the word is in the set, ignoring case.
+func (self *Keywords) Has(word string) bool {
+	if self == nil || len(self.set) == 0 {
+		return false
+	}
+
+	// Avoids allocating for typical keywords.
+	var buf [32]byte
+	if len(word) > len(buf) {
+		_, ok := self.set[strings.ToLower(word)]
+		return ok
+	}
+
+	for i := 0; i < len(word); i++ {
+		char := word[i]
+		if char >= 'A' && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+		buf[i] = char
+	}
+	_, ok := self.set[string(buf[:len(word)])]
+	return ok
+}
+
+// Number of keywords in the set.
+func (self *Keywords) Len() int {
+	if self == nil {
+		return 0
+	}
+	return len(self.set)
+}
+
+/*
+Returns the built-in set of reserved words for the given dialect: common SQL
+keywords such as "select" and "from", and keywords specific to the dialect,
+such as "returning" in Postgres or "top" in MSSQL. For `DialectAny` and
+unknown dialects, returns only the common keywords. The sets are not
+exhaustive, and are biased towards words which begin or separate clauses;
+words which are often used as column names, such as "key" or "level", are
+omitted. To add words, use `Keywords.With`. The result is shared, and
+must not be modified.
+*/
+func DialectKeywords(val Dialect) *Keywords {
+	out := dialectKeywords[val]
+	if out == nil {
+		return dialectKeywords[DialectAny]
+	}
+	return out
+}
+
+var dialectKeywords = func() map[Dialect]*Keywords {
+	common := NewKeywords(strings.Fields(`
+		all and any as asc between by case cast check collate constraint create
+		cross current_date current_time current_timestamp default delete desc
+		distinct drop else end escape except exists false for foreign from full
+		group having in inner insert intersect into is join left like natural
+		not null on or order outer primary references right select set some
+		table then true union unique update using values when where window with
+	`)...)
+
+	extra := func(str string) *Keywords { return common.With(strings.Fields(str)...) }
+
+	return map[Dialect]*Keywords{
+		DialectAny: common,
+		DialectPostgres: extra(`
+			analyze array asymmetric both concurrently conflict do fetch filter
+			ilike lateral leading limit materialized nothing offset over partition
+			recursive returning similar symmetric trailing verbose
+		`),
+		DialectMySQL: extra(`
+			div duplicate ignore limit mod offset over partition recursive regexp
+			replace rlike straight_join xor
+		`),
+		DialectSQLite: extra(`
+			abort autoincrement conflict fail glob ignore indexed limit offset over
+			partition pragma recursive regexp replace returning vacuum without
+		`),
+		DialectMSSQL: extra(`
+			apply begin commit declare exec execute fetch merge offset output over
+			partition pivot rollback top tran transaction unpivot
+		`),
+		DialectANSI: extra(`
+			fetch lateral offset over partition recursive similar
+		`),
+		DialectOracle: extra(`
+			connect fetch merge minus nocycle offset over partition prior start
+		`),
+		DialectBigQuery: extra(`
+			array limit offset over partition qualify recursive struct unnest
+		`),
+	}
+}()
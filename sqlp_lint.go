@@ -0,0 +1,153 @@
+package sqlp
+
+import "strings"
+
+/*
+Lint rule. Receives the AST parsed from the source, and the source itself, and
+returns any diagnostics found. Regions in the diagnostics must refer to the
+source, but their order doesn't matter. Nodes don't store their positions; use
+`WalkNodeRegions` to find them. Rules may panic with an error, which is
+returned by `Linter.Lint`.
+*/
+type Rule interface {
+	Check(nodes Nodes, src string) []Diagnostic
+}
+
+// Shortcut for implementing `Rule` with a function.
+type RuleFunc func(Nodes, string) []Diagnostic
+
+// Implement `Rule` by calling the function.
+func (self RuleFunc) Check(nodes Nodes, src string) []Diagnostic {
+	if self == nil {
+		return nil
+	}
+	return self(nodes, src)
+}
+
+/*
+Runs lint rules over SQL source. Rules are applied in order, and their
+diagnostics are combined. The optional `Severity` overrides the severity of
+diagnostics by code. Diagnostics suppressed by "sqlp:ignore" comments are
+dropped; see `Diagnostics.Suppress`.
+
+Example:
+
+	linter := sqlp.Linter{
+		Rules:    []sqlp.Rule{ruleOne, ruleTwo},
+		Severity: sqlp.SeverityConfig{`E_SELECT_STAR`: sqlp.SeverityWarning},
+	}
+
+	diags, err := linter.Lint(src)
+*/
+type Linter struct {
+	Rules    []Rule
+	Severity SeverityConfig
+}
+
+/*
+Parses the source, applies all rules, and returns the combined diagnostics,
+sorted by position. Returns an error if the source can't be parsed, or if any
+rule panics.
+*/
+func (self Linter) Lint(src string) (out Diagnostics, err error) {
+	defer rec(&err)
+
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range self.Rules {
+		if rule != nil {
+			out = append(out, rule.Check(nodes, src)...)
+		}
+	}
+
+	if self.Severity != nil {
+		out = self.Severity.Apply(out)
+	}
+	out, err = out.Suppress(src)
+	if err != nil {
+		return nil, err
+	}
+	out.Sort()
+	return out, nil
+}
+
+/*
+Walks the AST in depth-first order, invoking the function for every node,
+including collections, together with its region in the serialized AST. When
+the AST was parsed from a source, these are the regions in that source, because
+parsing is lossless. Collections are visited before their inner nodes.
+*/
+func WalkNodeRegions(node Node, fun func(Node, Region)) {
+	if fun == nil {
+		return
+	}
+	walkNodeRegions(node, 0, fun)
+}
+
+func walkNodeRegions(node Node, offset int, fun func(Node, Region)) int {
+	if node == nil {
+		return offset
+	}
+
+	size := nodeLen(node)
+	fun(node, Region{offset, offset + size})
+
+	impl, _ := node.(Walker)
+	if impl == nil {
+		return offset + size
+	}
+
+	cursor := offset + nodeInnerOffset(node)
+	impl.WalkNode(func(val Node) {
+		cursor = walkNodeRegions(val, cursor, fun)
+	})
+	return offset + size
+}
+
+func nodeLen(node Node) int {
+	switch node := node.(type) {
+	case NodeText:
+		return len(node)
+	case NodeWhitespace:
+		return len(node)
+	default:
+		return len(node.AppendTo(nil))
+	}
+}
+
+/*
+Returns the length of the serialized prefix of the collection, which precedes
+its inner nodes, such as the opening paren of `ParenNodes`. For unknown
+collections, finds the serialized inner nodes in the serialized collection.
+*/
+func nodeInnerOffset(node Node) int {
+	switch node := node.(type) {
+	case Nodes:
+		return 0
+	case ParenNodes, BracketNodes, BraceNodes:
+		return 1
+	case DelimNodes:
+		return len(node.Open)
+	}
+
+	impl, _ := node.(Walker)
+	if impl == nil {
+		return 0
+	}
+
+	var buf []byte
+	impl.WalkNode(func(val Node) {
+		if val != nil {
+			buf = val.AppendTo(buf)
+		}
+	})
+
+	index := strings.Index(node.String(), string(buf))
+	if index < 0 {
+		return 0
+	}
+	return index
+}
@@ -0,0 +1,341 @@
+package sqlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Marks a value to be rendered as a JSON literal by `Literal` and `Interpolate`,
+regardless of its type. Values implementing `json.Marshaler`, such as
+`json.RawMessage`, are rendered as JSON without this wrapper.
+*/
+type JSON struct{ Val interface{} }
+
+/*
+Implemented by custom types which render themselves as SQL literals in
+`Literal` and `Interpolate`, taking priority over all other rules. The
+returned node is inserted into the SQL as-is, and must be properly escaped.
+*/
+type SQLLiteraler interface {
+	SQLLiteral(Dialect) (Node, error)
+}
+
+/*
+Renders a Go value as an SQL literal in the given dialect, suitable for
+`Interpolate`. Values are rendered according to the following rules, in
+order:
+
+	nil             : null
+	SQLLiteraler    : result of the method
+	JSON            : JSON literal, see below
+	driver.Valuer   : result of the method, see `DriverValue`
+	time.Time       : timestamp literal, see below
+	[]byte          : binary literal, see below
+	json.Number     : number, such as 123.45, unquoted
+	*big.Int        : number, unquoted
+	*big.Float      : number, unquoted
+	json.Marshaler  : JSON literal, see below
+	string          : 'text', escaped for the dialect
+	bool            : true, false; 1, 0 in MSSQL and Oracle
+	ints, floats    : 123, 1.5
+	slices, arrays  : Postgres array literal, see `ArrayLiteral`
+
+Pointers are dereferenced; nil pointers and nil slices render as null. Types
+derived from strings, such as "type Decimal string", are rendered as strings;
+exact decimals should use `json.Number`, "math/big", or `SQLLiteraler`.
+
+Timestamps preserve the time zone offset and nanoseconds where supported:
+
+	DialectAny, DialectPostgres : '2006-01-02 15:04:05.999999999+07:00'::timestamptz
+	DialectMySQL                : '2006-01-02 15:04:05.999999', converted to UTC
+	DialectSQLite               : '2006-01-02 15:04:05.999999999+07:00'
+	DialectMSSQL                : '2006-01-02T15:04:05.9999999+07:00'
+	DialectANSI                 : timestamp with time zone '2006-01-02 15:04:05.999999999+07:00'
+	DialectOracle               : timestamp '2006-01-02 15:04:05.999999999 +07:00'
+	DialectBigQuery             : timestamp '2006-01-02 15:04:05.999999+07:00'
+
+Binary data is rendered in hex:
+
+	DialectAny, DialectPostgres : '\x0102'::bytea
+	DialectMSSQL                : 0x0102
+	DialectOracle               : hextoraw('0102')
+	DialectBigQuery             : from_hex('0102')
+	other                       : x'0102'
+
+JSON is rendered as a string literal with a cast appropriate for the dialect:
+
+	DialectAny, DialectPostgres : '{"one":1}'::jsonb
+	DialectMySQL                : cast('{"one":1}' as json)
+	other                       : '{"one":1}'
+
+In MySQL, backslashes in strings are escaped by doubling them, which assumes
+that the "NO_BACKSLASH_ESCAPES" mode is disabled, which is the default. In
+BigQuery, quotes, backslashes, and newlines in strings are escaped with
+backslashes.
+Returns an error for unsupported values, and for arrays outside of Postgres.
+*/
+func Literal(val interface{}, dialect Dialect) (_ Node, err error) {
+	defer rec(&err)
+	return literal(val, dialect), nil
+}
+
+/*
+Replaces ordinal parameters such as `$1`, and numeric parameters such as `:1`,
+with SQL literals of the corresponding arguments rendered via `Literal`, and
+returns the resulting SQL. Intended for logging and debugging, for example to
+produce runnable SQL from a failed query. Queries executed against a database
+should pass arguments separately. Negative numbers are wrapped in parens, which
+prevents a preceding minus from forming a line comment, as in "10--5".
+
+Returns an error if a parameter has no corresponding argument, or if the AST
+contains named parameters.
+*/
+func Interpolate(node Node, dialect Dialect, args []interface{}) (_ string, err error) {
+	defer rec(&err)
+	if node == nil {
+		return ``, nil
+	}
+
+	node = CopyNode(node)
+	var questions questionParams
+
+	DeepWalkNodePtr(&node, func(ptr *Node) {
+		switch val := (*ptr).(type) {
+		case NodeOrdinalParam:
+			*ptr = interpolationLiteral(interpolationArg(val, val.Index(), args), dialect)
+		case NodeNumericParam:
+			*ptr = interpolationLiteral(interpolationArg(val, val.Index(), args), dialect)
+		case NodeQuestionParam:
+			*ptr = interpolationLiteral(interpolationArg(val, questions.index(val)-1, args), dialect)
+		case NodeNamedParam, NodeAtParam, NodeDollarParam:
+			panic(fmt.Errorf(`[sqlp] can't interpolate named parameter %q`, val))
+		}
+	})
+	return node.String(), nil
+}
+
+// Wraps negative numbers in parens, see `Interpolate`.
+func interpolationLiteral(val interface{}, dialect Dialect) Node {
+	node := literal(val, dialect)
+	if text, ok := node.(NodeText); ok && strings.HasPrefix(string(text), `-`) {
+		return ParenNodes{node}
+	}
+	return node
+}
+
+func interpolationArg(node Node, index int, args []interface{}) interface{} {
+	if index < 0 || index >= len(args) {
+		panic(fmt.Errorf(`[sqlp] missing argument for parameter %q: got %v arguments`, node, len(args)))
+	}
+	return args[index]
+}
+
+func literal(val interface{}, dialect Dialect) Node {
+	if val == nil {
+		return NodeText(`null`)
+	}
+
+	switch val := val.(type) {
+	case SQLLiteraler:
+		if !isNilValue(reflect.ValueOf(val)) {
+			node, err := val.SQLLiteral(dialect)
+			if err != nil {
+				panic(err)
+			}
+			return node
+		}
+	case JSON:
+		return jsonLiteral(val.Val, dialect)
+	}
+
+	val = driverValue(val)
+	rval := derefValue(reflect.ValueOf(val))
+	if isNilValue(rval) {
+		return NodeText(`null`)
+	}
+
+	switch val := rval.Interface().(type) {
+	case time.Time:
+		return timeLiteral(val, dialect)
+	case []byte:
+		return bytesLiteral(val, dialect)
+	case json.Number:
+		return numberLiteral(string(val))
+	case big.Int:
+		return numberLiteral(val.String())
+	case big.Float:
+		return numberLiteral(val.Text('g', -1))
+	}
+
+	if impl, _ := val.(json.Marshaler); impl != nil {
+		return jsonLiteral(val, dialect)
+	}
+
+	if isArrayValue(rval) {
+		if dialect != DialectAny && dialect != DialectPostgres {
+			panic(fmt.Errorf(`[sqlp] array literals are not supported in dialect %q`, dialect))
+		}
+		return NodeQuoteSingle(escapeQuoteSingle(arrayText(rval)))
+	}
+
+	switch rval.Kind() {
+	case reflect.String:
+		return quoteString(dialect, rval.String())
+	case reflect.Bool:
+		return boolLiteral(rval.Bool(), dialect)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return scalarNode(rval)
+	default:
+		panic(fmt.Errorf(`[sqlp] can't render value of type %T as SQL literal`, val))
+	}
+}
+
+func boolLiteral(val bool, dialect Dialect) Node {
+	switch dialect {
+	case DialectMSSQL, DialectOracle:
+		if val {
+			return NodeText(`1`)
+		}
+		return NodeText(`0`)
+	default:
+		return NodeText(strconv.FormatBool(val))
+	}
+}
+
+func timeLiteral(val time.Time, dialect Dialect) Node {
+	switch dialect {
+	case DialectAny, DialectPostgres:
+		return Nodes{
+			NodeQuoteSingle(val.Format(`2006-01-02 15:04:05.999999999-07:00`)),
+			NodeDoubleColon{},
+			NodeText(`timestamptz`),
+		}
+	case DialectMySQL:
+		return NodeQuoteSingle(val.UTC().Format(`2006-01-02 15:04:05.999999`))
+	case DialectSQLite:
+		return NodeQuoteSingle(val.Format(`2006-01-02 15:04:05.999999999-07:00`))
+	case DialectMSSQL:
+		return NodeQuoteSingle(val.Format(`2006-01-02T15:04:05.9999999-07:00`))
+	case DialectANSI:
+		return Nodes{
+			NodeText(`timestamp`), nodeWhitespaceSingle,
+			NodeText(`with`), nodeWhitespaceSingle,
+			NodeText(`time`), nodeWhitespaceSingle,
+			NodeText(`zone`), nodeWhitespaceSingle,
+			NodeQuoteSingle(val.Format(`2006-01-02 15:04:05.999999999-07:00`)),
+		}
+	case DialectOracle:
+		return Nodes{
+			NodeText(`timestamp`), nodeWhitespaceSingle,
+			NodeQuoteSingle(val.Format(`2006-01-02 15:04:05.999999999 -07:00`)),
+		}
+	case DialectBigQuery:
+		return Nodes{
+			NodeText(`timestamp`), nodeWhitespaceSingle,
+			NodeQuoteSingle(val.Format(`2006-01-02 15:04:05.999999-07:00`)),
+		}
+	default:
+		panic(fmt.Errorf(`[sqlp] timestamp literals are not supported in dialect %q`, dialect))
+	}
+}
+
+func bytesLiteral(val []byte, dialect Dialect) Node {
+	str := hex.EncodeToString(val)
+
+	switch dialect {
+	case DialectAny, DialectPostgres:
+		return Nodes{NodeQuoteSingle(`\x` + str), NodeDoubleColon{}, NodeText(`bytea`)}
+	case DialectMSSQL:
+		return NodeText(`0x` + str)
+	case DialectOracle:
+		return Nodes{NodeText(`hextoraw`), ParenNodes{NodeQuoteSingle(str)}}
+	case DialectBigQuery:
+		return Nodes{NodeText(`from_hex`), ParenNodes{NodeQuoteSingle(str)}}
+	default:
+		return Nodes{NodeText(`x`), NodeQuoteSingle(str)}
+	}
+}
+
+// Validates a decimal number, which is rendered without quotes.
+func numberLiteral(str string) Node {
+	if !isDecimal(str) {
+		panic(fmt.Errorf(`[sqlp] invalid number %q`, str))
+	}
+	return NodeText(str)
+}
+
+/*
+True if the string is a decimal number, with an optional sign, fraction, and
+exponent, such as "-12.5e3". Doesn't accept special values such as "NaN".
+*/
+func isDecimal(str string) bool {
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		str = str[1:]
+	}
+
+	digits := prefixDigits(str)
+	str = str[len(digits):]
+
+	if len(str) > 0 && str[0] == '.' {
+		frac := prefixDigits(str[1:])
+		if digits == `` && frac == `` {
+			return false
+		}
+		digits += frac
+		str = str[1+len(frac):]
+	}
+	if digits == `` {
+		return false
+	}
+
+	if len(str) > 0 && (str[0] == 'e' || str[0] == 'E') {
+		str = str[1:]
+		if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+			str = str[1:]
+		}
+		exp := prefixDigits(str)
+		if exp == `` {
+			return false
+		}
+		str = str[len(exp):]
+	}
+	return str == ``
+}
+
+func jsonLiteral(val interface{}, dialect Dialect) Node {
+	body, err := json.Marshal(val)
+	if err != nil {
+		panic(fmt.Errorf(`[sqlp] failed to encode JSON literal: %w`, err))
+	}
+
+	str := quoteString(dialect, string(body))
+	switch dialect {
+	case DialectAny, DialectPostgres:
+		return Nodes{str, NodeDoubleColon{}, NodeText(`jsonb`)}
+	case DialectMySQL:
+		return Nodes{
+			NodeText(`cast`),
+			ParenNodes{str, nodeWhitespaceSingle, NodeText(`as`), nodeWhitespaceSingle, NodeText(`json`)},
+		}
+	default:
+		return str
+	}
+}
+
+func isNilValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return val.IsNil()
+	default:
+		return !val.IsValid()
+	}
+}
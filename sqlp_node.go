@@ -45,6 +45,7 @@ content.
 
 import (
 	"strconv"
+	"strings"
 )
 
 // Arbitrary non-whitespace text that wasn't recognized by the parser. When
@@ -69,6 +70,34 @@ func (self NodeWhitespace) Node() Node {
 	return self
 }
 
+/*
+Keyword such as "select", preserving the case of the source. Generated only
+when using `Tokenizer.Keywords`; otherwise keywords are part of `NodeText`.
+*/
+type NodeKeyword string
+
+func (self NodeKeyword) AppendTo(buf []byte) []byte { return append(buf, self...) }
+func (self NodeKeyword) String() string             { return appenderStr(&self) }
+
+/*
+Unquoted identifier such as "users", preserving the case of the source.
+Generated only when using `Tokenizer.Idents`; otherwise identifiers are part
+of `NodeText`. Qualified names such as "users.id" consist of multiple nodes.
+*/
+type NodeIdent string
+
+func (self NodeIdent) AppendTo(buf []byte) []byte { return append(buf, self...) }
+func (self NodeIdent) String() string             { return appenderStr(&self) }
+
+/*
+Semicolon which separates or terminates statements. Generated only when using
+`Tokenizer.Semicolons`; otherwise semicolons are part of `NodeText`.
+*/
+type NodeSemicolon struct{}
+
+func (self NodeSemicolon) AppendTo(buf []byte) []byte { return append(buf, semicolon) }
+func (self NodeSemicolon) String() string             { return string(rune(semicolon)) }
+
 // Text inside single quotes: ''. Escape sequences are not supported yet.
 type NodeQuoteSingle string
 
@@ -105,6 +134,133 @@ func (self NodeQuoteGrave) AppendTo(buf []byte) []byte {
 
 func (self NodeQuoteGrave) String() string { return appenderStr(&self) }
 
+// Implement `DialectNode`. Grave quotes are valid only in MySQL, SQLite, and
+// BigQuery.
+func (self NodeQuoteGrave) ValidIn(val Dialect) bool {
+	return isDialect(val, DialectMySQL, DialectSQLite, DialectBigQuery)
+}
+
+// Postgres dollar-quoted string: $$text$$ or $tag$text$tag$. The tag may be
+// empty.
+type NodeQuoteDollar struct {
+	Tag  string
+	Text string
+}
+
+func (self NodeQuoteDollar) AppendTo(buf []byte) []byte {
+	buf = self.appendDelim(buf)
+	buf = append(buf, self.Text...)
+	buf = self.appendDelim(buf)
+	return buf
+}
+
+func (self NodeQuoteDollar) appendDelim(buf []byte) []byte {
+	buf = append(buf, dollarQuote)
+	buf = append(buf, self.Tag...)
+	buf = append(buf, dollarQuote)
+	return buf
+}
+
+func (self NodeQuoteDollar) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Dollar quotes are valid only in Postgres.
+func (self NodeQuoteDollar) ValidIn(val Dialect) bool { return isDialect(val, DialectPostgres) }
+
+/*
+BigQuery string in triple single quotes. Generated only when using
+`DialectBigQuery`. May contain newlines and unescaped single quotes. Backslash
+escapes are preserved as-is.
+*/
+type NodeQuoteSingleTriple string
+
+func (self NodeQuoteSingleTriple) AppendTo(buf []byte) []byte {
+	buf = append(buf, quoteSingleTriple...)
+	buf = append(buf, self...)
+	buf = append(buf, quoteSingleTriple...)
+	return buf
+}
+
+func (self NodeQuoteSingleTriple) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Triple quotes are valid only in BigQuery.
+func (self NodeQuoteSingleTriple) ValidIn(val Dialect) bool { return isDialect(val, DialectBigQuery) }
+
+// BigQuery triple-quoted string with double quotes. Otherwise the same as
+// `NodeQuoteSingleTriple`.
+type NodeQuoteDoubleTriple string
+
+func (self NodeQuoteDoubleTriple) AppendTo(buf []byte) []byte {
+	buf = append(buf, quoteDoubleTriple...)
+	buf = append(buf, self...)
+	buf = append(buf, quoteDoubleTriple...)
+	return buf
+}
+
+func (self NodeQuoteDoubleTriple) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Triple quotes are valid only in BigQuery.
+func (self NodeQuoteDoubleTriple) ValidIn(val Dialect) bool { return isDialect(val, DialectBigQuery) }
+
+/*
+National character string: N'text', used for Unicode strings in MSSQL.
+Generated only when using `DialectMSSQL`. Unlike `NodeQuoteSingle`, represents
+the entire literal, including quotes escaped by doubling, which are preserved
+as-is. "Lower" indicates the lowercase prefix "n", which is equivalent.
+*/
+type NodeQuoteNational struct {
+	Text  string
+	Lower bool
+}
+
+func (self NodeQuoteNational) AppendTo(buf []byte) []byte {
+	if self.Lower {
+		buf = append(buf, nationalLower)
+	} else {
+		buf = append(buf, nationalPrefix)
+	}
+	buf = append(buf, quoteSingle)
+	buf = append(buf, self.Text...)
+	buf = append(buf, quoteSingle)
+	return buf
+}
+
+func (self NodeQuoteNational) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. National strings are not valid in SQLite and
+// BigQuery.
+func (self NodeQuoteNational) ValidIn(val Dialect) bool {
+	return isDialect(val, DialectPostgres, DialectMySQL, DialectMSSQL, DialectANSI, DialectOracle)
+}
+
+/*
+Postgres escape string: E'text', where backslashes begin C-style escape
+sequences such as "\n" and "\'". Generated only when using `DialectAny` or
+`DialectPostgres`. Like `NodeQuoteNational`, represents the entire literal,
+and preserves escape sequences and doubled quotes as-is. "Lower" indicates the
+lowercase prefix "e", which is equivalent.
+*/
+type NodeQuoteEscape struct {
+	Text  string
+	Lower bool
+}
+
+func (self NodeQuoteEscape) AppendTo(buf []byte) []byte {
+	if self.Lower {
+		buf = append(buf, escapeLower)
+	} else {
+		buf = append(buf, escapePrefix)
+	}
+	buf = append(buf, quoteSingle)
+	buf = append(buf, self.Text...)
+	buf = append(buf, quoteSingle)
+	return buf
+}
+
+func (self NodeQuoteEscape) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`.
+func (self NodeQuoteEscape) ValidIn(val Dialect) bool { return isDialect(val, DialectPostgres) }
+
 // Content of a line comment: --, including the newline.
 type NodeCommentLine string
 
@@ -128,12 +284,32 @@ func (self NodeCommentBlock) AppendTo(buf []byte) []byte {
 
 func (self NodeCommentBlock) String() string { return appenderStr(&self) }
 
+// Content of a line comment beginning with a hash: #, including the newline.
+// Generated only when using `DialectBigQuery`.
+type NodeCommentHash string
+
+func (self NodeCommentHash) AppendTo(buf []byte) []byte {
+	buf = append(buf, commentHashPrefix)
+	buf = append(buf, self...)
+	return buf
+}
+
+func (self NodeCommentHash) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Hash comments are valid only in MySQL and BigQuery.
+func (self NodeCommentHash) ValidIn(val Dialect) bool {
+	return isDialect(val, DialectMySQL, DialectBigQuery)
+}
+
 // Postgres cast operator: ::. Allows to disambiguate casts from named params.
 type NodeDoubleColon struct{}
 
 func (self NodeDoubleColon) AppendTo(buf []byte) []byte { return append(buf, castPrefix...) }
 func (self NodeDoubleColon) String() string             { return castPrefix }
 
+// Implement `DialectNode`. The cast operator is valid only in Postgres.
+func (self NodeDoubleColon) ValidIn(val Dialect) bool { return isDialect(val, DialectPostgres) }
+
 // Postgres-style ordinal parameter placeholder: $1, $2, $3, ...
 type NodeOrdinalParam int
 
@@ -148,6 +324,27 @@ func (self NodeOrdinalParam) String() string { return appenderStr(&self) }
 // Convenience method that returns the corresponding Go index (starts at zero).
 func (self NodeOrdinalParam) Index() int { return int(self) - 1 }
 
+/*
+Oracle-style numeric parameter placeholder: :1, :2, :3, ... Generated only when
+using `DialectOracle`. Like `NodeOrdinalParam`, the number is the 1-based index
+of the argument. See `RenderParams` for conversion between styles.
+*/
+type NodeNumericParam int
+
+func (self NodeNumericParam) AppendTo(buf []byte) []byte {
+	buf = append(buf, namedPrefix)
+	buf = strconv.AppendInt(buf, int64(self), 10)
+	return buf
+}
+
+func (self NodeNumericParam) String() string { return appenderStr(&self) }
+
+// Convenience method that returns the corresponding Go index (starts at zero).
+func (self NodeNumericParam) Index() int { return int(self) - 1 }
+
+// Implement `DialectNode`. Numeric params are valid only in Oracle.
+func (self NodeNumericParam) ValidIn(val Dialect) bool { return isDialect(val, DialectOracle) }
+
 // Named parameter preceded by colon: :identifier
 type NodeNamedParam string
 
@@ -159,6 +356,186 @@ func (self NodeNamedParam) AppendTo(buf []byte) []byte {
 
 func (self NodeNamedParam) String() string { return appenderStr(&self) }
 
+/*
+Named parameter preceded by "at" sign: @identifier. Generated only when using
+`DialectBigQuery`, `DialectSQLite`, `DialectMSSQL`, or `DialectMySQL`. System
+variables such as "@@rowcount" are not params; see `NodeSystemVariable`. In
+MySQL, "@name" is a user variable set by the session rather than by the
+driver, but it's treated as a named param, which allows the same rewrites as
+for ":name", for example via `RenderParams`.
+*/
+type NodeAtParam string
+
+func (self NodeAtParam) AppendTo(buf []byte) []byte {
+	buf = append(buf, atPrefix)
+	buf = append(buf, self...)
+	return buf
+}
+
+func (self NodeAtParam) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. "At" params are valid only in MSSQL, MySQL, SQLite,
+// and BigQuery.
+func (self NodeAtParam) ValidIn(val Dialect) bool {
+	return isDialect(val, DialectMSSQL, DialectMySQL, DialectSQLite, DialectBigQuery)
+}
+
+/*
+Named parameter preceded by dollar sign: $identifier. Generated only when using
+`DialectSQLite`. Unlike in `NodeOrdinalParam`, the name begins with a letter or
+"_". See `RenderParams` for conversion between styles.
+*/
+type NodeDollarParam string
+
+func (self NodeDollarParam) AppendTo(buf []byte) []byte {
+	buf = append(buf, ordinalPrefix)
+	buf = append(buf, self...)
+	return buf
+}
+
+func (self NodeDollarParam) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Dollar params are valid only in SQLite.
+func (self NodeDollarParam) ValidIn(val Dialect) bool { return isDialect(val, DialectSQLite) }
+
+/*
+System variable or function preceded by two "at" signs: @@identifier, such as
+"@@rowcount". Generated only when using `DialectMSSQL` or `DialectMySQL`. Not
+a parameter: doesn't correspond to any argument.
+*/
+type NodeSystemVariable string
+
+func (self NodeSystemVariable) AppendTo(buf []byte) []byte {
+	buf = append(buf, systemVarPrefix...)
+	buf = append(buf, self...)
+	return buf
+}
+
+func (self NodeSystemVariable) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. System variables are valid only in MSSQL, MySQL, and
+// BigQuery.
+func (self NodeSystemVariable) ValidIn(val Dialect) bool {
+	return isDialect(val, DialectMSSQL, DialectMySQL, DialectBigQuery)
+}
+
+/*
+Positional parameter placeholder: ?, or numbered: ?1, ?2, ?3, ... Generated
+only when using `DialectSQLite`. The zero value represents "?", which refers
+to the argument after the largest one referenced so far, which in queries
+without numbered placeholders is simply the next argument. Other values are
+1-based indexes of arguments, like in `NodeOrdinalParam`. See `RenderParams`
+for conversion between styles.
+*/
+type NodeQuestionParam int
+
+func (self NodeQuestionParam) AppendTo(buf []byte) []byte {
+	buf = append(buf, questionPrefix)
+	if self != 0 {
+		buf = strconv.AppendInt(buf, int64(self), 10)
+	}
+	return buf
+}
+
+func (self NodeQuestionParam) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Plain "?" is valid in MySQL and SQLite, while
+// numbered params are valid only in SQLite.
+func (self NodeQuestionParam) ValidIn(val Dialect) bool {
+	if self == 0 {
+		return isDialect(val, DialectMySQL, DialectSQLite)
+	}
+	return isDialect(val, DialectSQLite)
+}
+
+/*
+Client-side directive in an SQL script, such as a psql meta-command "\copy ..."
+or a MySQL "DELIMITER" command. Generated only when using `Tokenizer.Script`.
+Contains the entire directive, excluding the trailing newline and any preceding
+whitespace.
+*/
+type NodeDirective string
+
+func (self NodeDirective) AppendTo(buf []byte) []byte { return append(buf, self...) }
+func (self NodeDirective) String() string             { return string(self) }
+
+/*
+T-SQL batch separator "GO", optionally followed by a repeat count, such as
+"GO 5". Generated only when using `Tokenizer.Script` with `DialectMSSQL`.
+Contains the entire line, excluding the trailing newline and any preceding
+whitespace. Not an SQL statement: client tools such as "sqlcmd" send the
+preceding batch to the server when they encounter it.
+*/
+type NodeBatchSeparator string
+
+func (self NodeBatchSeparator) AppendTo(buf []byte) []byte { return append(buf, self...) }
+func (self NodeBatchSeparator) String() string             { return string(self) }
+
+/*
+Returns the repeat count, such as 5 for "GO 5", which tells the client to
+execute the preceding batch that many times. Returns 1 if the count is
+omitted.
+*/
+func (self NodeBatchSeparator) Count() int {
+	digits := prefixDigits(strings.TrimSpace(string(self)[len(batchSeparator):]))
+	if digits == `` {
+		return 1
+	}
+	val, err := strconv.Atoi(digits)
+	if err != nil {
+		return 1
+	}
+	return val
+}
+
+// Content of a template action or expression: {{ }}. Generated only when using
+// `TemplateGo` or `TemplateJinja`. Includes any whitespace and trim markers
+// inside the delimiters.
+type NodeTemplateAction string
+
+func (self NodeTemplateAction) AppendTo(buf []byte) []byte {
+	buf = append(buf, templateOpen...)
+	buf = append(buf, self...)
+	buf = append(buf, templateClose...)
+	return buf
+}
+
+func (self NodeTemplateAction) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Unexpanded template syntax is not valid SQL.
+func (self NodeTemplateAction) ValidIn(val Dialect) bool { return val == DialectAny }
+
+// Content of a Jinja statement: {% %}. Generated only when using
+// `TemplateJinja`.
+type NodeTemplateStatement string
+
+func (self NodeTemplateStatement) AppendTo(buf []byte) []byte {
+	buf = append(buf, templateStmtOpen...)
+	buf = append(buf, self...)
+	buf = append(buf, templateStmtClose...)
+	return buf
+}
+
+func (self NodeTemplateStatement) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Unexpanded template syntax is not valid SQL.
+func (self NodeTemplateStatement) ValidIn(val Dialect) bool { return val == DialectAny }
+
+// Content of a Jinja comment: {# #}. Generated only when using `TemplateJinja`.
+type NodeTemplateComment string
+
+func (self NodeTemplateComment) AppendTo(buf []byte) []byte {
+	buf = append(buf, templateCommOpen...)
+	buf = append(buf, self...)
+	buf = append(buf, templateCommClose...)
+	return buf
+}
+
+func (self NodeTemplateComment) String() string { return appenderStr(&self) }
+
+// Implement `DialectNode`. Unexpanded template syntax is not valid SQL.
+func (self NodeTemplateComment) ValidIn(val Dialect) bool { return val == DialectAny }
+
 /*
 Arbitrary sequence of AST nodes. When serializing, doesn't print any start or
 end delimiters.
@@ -224,6 +601,22 @@ func (self Nodes) CopyNodes() Nodes {
 // Implements `Copier` by calling `Nodes.CopyNodes`.
 func (self Nodes) CopyNode() Node { return self.CopyNodes() }
 
+/*
+Makes a shallow copy: copies the top-level slice, but shares all inner
+collections, such as `ParenNodes`, with the original. Much cheaper than
+`Nodes.CopyNodes` for large ASTs, and intended for cases where only top-level
+edits will be made, such as replacing, inserting, or removing top-level nodes,
+for example with `AndWhere`. Mutating an inner collection of the clone, for
+example via `DeepWalkNodePtr`, also mutates the original. To modify an inner
+collection, replace it with a copy from `CopyNode` first.
+*/
+func (self Nodes) Clone() Nodes {
+	if self == nil {
+		return nil
+	}
+	return append(make(Nodes, 0, len(self)), self...)
+}
+
 func (self Nodes) Procure(fun func(Node) Node) Node {
 	if fun == nil {
 		return nil
@@ -318,3 +711,38 @@ func (self BraceNodes) WalkNode(fun func(Node)) { self.Nodes().WalkNode(fun) }
 
 // Implement `PtrWalker` by calling `Nodes.WalkNodePtr`.
 func (self BraceNodes) WalkNodePtr(fun func(*Node)) { self.Nodes().WalkNodePtr(fun) }
+
+/*
+Nodes enclosed in user-defined delimiters, generated by `Parser` for delimiters
+specified in `Tokenizer.Delims`. The `Delim` field is used for serialization.
+*/
+type DelimNodes struct {
+	Delim
+	Inner Nodes
+}
+
+// Implement `Node`.
+func (self DelimNodes) AppendTo(buf []byte) []byte {
+	buf = append(buf, self.Open...)
+	buf = self.Inner.AppendTo(buf)
+	buf = append(buf, self.Close...)
+	return buf
+}
+
+// Implement `Node`. Also implements `fmt.Stringer` for debug purposes.
+func (self DelimNodes) String() string { return appenderStr(&self) }
+
+// Implement `Coll`. Returns the inner nodes.
+func (self DelimNodes) Nodes() Nodes { return self.Inner }
+
+// Implement `Copier` by calling `Nodes.Copy`.
+func (self DelimNodes) CopyNode() Node {
+	self.Inner = self.Inner.CopyNodes()
+	return self
+}
+
+// Implement `Walker` by calling `Nodes.WalkNode`.
+func (self DelimNodes) WalkNode(fun func(Node)) { self.Inner.WalkNode(fun) }
+
+// Implement `PtrWalker` by calling `Nodes.WalkNodePtr`.
+func (self DelimNodes) WalkNodePtr(fun func(*Node)) { self.Inner.WalkNodePtr(fun) }
@@ -0,0 +1,156 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+const optionPrefix = `option:`
+
+/*
+Parses SQL like `Parse`, and extracts per-query options via `ExtractOptions`.
+Returns the AST without the option directives.
+
+Example:
+
+	nodes, opts, err := sqlp.ParseOptions(`{option: timeout=5s replica} select 1`)
+	// opts = map[string]string{"timeout": "5s", "replica": "true"}
+*/
+func ParseOptions(src string) (Nodes, map[string]string, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ExtractOptions(nodes)
+}
+
+/*
+Extracts per-query options from top-level option directives, and returns the
+AST without them, along with the options. An option directive is a brace node
+whose content begins with "option:", followed by key-value pairs separated by
+whitespace or commas:
+
+	{option: timeout=5s, label='monthly report' replica}
+
+Values may be single-quoted, with quotes escaped by doubling them. A key
+without a value has the value "true". Keys are case-sensitive. Directives may
+be anywhere at the top level, and there may be several. Whitespace around
+directives is preserved. Returns nil options if there are no directives, and an
+error if a key is repeated or a directive is malformed. Other brace nodes are
+left as-is. Doesn't modify the input.
+
+This provides an in-band mechanism for query-level configuration, such as
+timeouts or routing hints, which is handled by the application and never
+reaches the database.
+*/
+func ExtractOptions(nodes Nodes) (_ Nodes, _ map[string]string, err error) {
+	defer rec(&err)
+
+	var out Nodes
+	var opts map[string]string
+
+	for i, node := range nodes {
+		body, ok := optionBody(node)
+		if !ok {
+			if out != nil {
+				out = append(out, node)
+			}
+			continue
+		}
+
+		if out == nil {
+			out = make(Nodes, 0, len(nodes)-1)
+			out = append(out, nodes[:i]...)
+		}
+		if opts == nil {
+			opts = map[string]string{}
+		}
+		parseOptions(body, opts)
+	}
+
+	if out == nil {
+		return append(Nodes(nil), nodes...), nil, nil
+	}
+	return out, opts, nil
+}
+
+// Returns the content of an option directive after the prefix, if the node is
+// one.
+func optionBody(node Node) (string, bool) {
+	brace, ok := node.(BraceNodes)
+	if !ok {
+		return ``, false
+	}
+
+	body := strings.TrimLeftFunc(Nodes(brace).String(), func(char rune) bool {
+		return char < 0x80 && charsetWhitespace.has(byte(char))
+	})
+	if len(body) < len(optionPrefix) || !strings.EqualFold(body[:len(optionPrefix)], optionPrefix) {
+		return ``, false
+	}
+	return body[len(optionPrefix):], true
+}
+
+func parseOptions(src string, out map[string]string) {
+	for cursor := 0; ; {
+		for cursor < len(src) && isOptionSeparator(src[cursor]) {
+			cursor++
+		}
+		if cursor >= len(src) {
+			return
+		}
+
+		start := cursor
+		for cursor < len(src) && src[cursor] != '=' && !isOptionSeparator(src[cursor]) {
+			cursor++
+		}
+		key := src[start:cursor]
+		if key == `` {
+			panic(fmt.Errorf(`[sqlp] invalid option directive %q: missing key`, src))
+		}
+
+		val := `true`
+		if cursor < len(src) && src[cursor] == '=' {
+			cursor++
+			val, cursor = parseOptionValue(src, cursor)
+		}
+
+		if _, ok := out[key]; ok {
+			panic(fmt.Errorf(`[sqlp] redundant option %q`, key))
+		}
+		out[key] = val
+	}
+}
+
+func parseOptionValue(src string, cursor int) (string, int) {
+	if cursor >= len(src) || src[cursor] != quoteSingle {
+		start := cursor
+		for cursor < len(src) && !isOptionSeparator(src[cursor]) {
+			cursor++
+		}
+		return src[start:cursor], cursor
+	}
+
+	var buf []byte
+	cursor++
+	for cursor < len(src) {
+		char := src[cursor]
+		cursor++
+
+		if char != quoteSingle {
+			buf = append(buf, char)
+			continue
+		}
+		if cursor < len(src) && src[cursor] == quoteSingle {
+			buf = append(buf, char)
+			cursor++
+			continue
+		}
+		return string(buf), cursor
+	}
+	panic(fmt.Errorf(`[sqlp] invalid option directive %q: unterminated quoted value`, src))
+}
+
+func isOptionSeparator(char byte) bool {
+	return char == ',' || charsetWhitespace.has(char)
+}
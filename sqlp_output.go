@@ -0,0 +1,342 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describes one item of the select list of a SELECT statement. See
+// `OutputColumns`.
+type OutputColumn struct {
+	/*
+		Name of the result column: the alias, or the name implied by the
+		expression, such as "id" for "users.id". Unquoted names are lowercased,
+		and quoted names are preserved, like in Postgres. Empty when the name
+		is chosen by the database, for example "?column?" for "1 + 2" in
+		Postgres, and for stars.
+	*/
+	Name string
+	// Expression without the alias. May share memory with the input.
+	Expr Nodes
+	// True for "*" and "table.*", whose columns are unknown without a schema.
+	Star bool
+}
+
+/*
+Returns the items of the select list of the SELECT statement in the given AST,
+describing the columns of the result, in order. Intended for checking that the
+fields of a Go type cover the result before scanning into it; see
+`CheckOutputColumns`. For compound queries such as UNION, the result columns
+are named by the first SELECT, which is the one analyzed. Returns nil if the
+statement is not a SELECT, and an error if the select list has an unsupported
+syntax, or if there are multiple statements.
+
+Names are inferred from the expression when there's no alias, like in Postgres:
+
+	users.id            : "id"
+	"Name"              : "Name"
+	count(*)            : "count"
+	id::text            : "id"
+	(a + b)::text       : "text"
+	1 + 2               : ""
+
+Example:
+
+	nodes, err := sqlp.Parse(`select u.id, u.name as user_name, count(*) from users u group by 1, 2`)
+	...
+	cols, err := sqlp.OutputColumns(nodes)
+	// names: "id", "user_name", "count"
+*/
+func OutputColumns(nodes Nodes) (_ []OutputColumn, err error) {
+	defer rec(&err)
+
+	if len(splitStatements(topLevel(nodes))) > 1 {
+		return nil, fmt.Errorf(`[sqlp] unsupported multiple statements`)
+	}
+	if ClassifyStmt(nodes) != StmtKindSelect {
+		return nil, nil
+	}
+
+	body, _ := splitTrailing(nodes)
+	if paren, ok := nodeAt(body, skipTrivia(body, 0)).(ParenNodes); ok {
+		return OutputColumns(Nodes(paren))
+	}
+
+	start, end := selectListRange(body)
+	if start < 0 {
+		return nil, nil
+	}
+
+	var out []OutputColumn
+	items := splitCommas(body[start:end])
+
+	for len(items) > 0 {
+		index := 0
+		for index < len(items) && items[index] != NodeText(`,`) {
+			index++
+		}
+		out = append(out, outputColumn(items[:index]))
+
+		if index >= len(items) {
+			break
+		}
+		items = items[index+1:]
+		if skipTrivia(items, 0) >= len(items) {
+			panic(fmt.Errorf(`[sqlp] invalid select list: unexpected trailing comma`))
+		}
+	}
+	return out, nil
+}
+
+/*
+Returns an error if any named column of the result of the given SELECT
+statement, as reported by `OutputColumns`, doesn't correspond to a field of the
+given struct, as reported by `Columns`. Intended for tests and startup checks,
+to detect queries which can't be scanned into the given type. Stars and
+unnamed columns can't be checked, and are ignored. Names are compared
+case-sensitively.
+*/
+func CheckOutputColumns(nodes Nodes, val interface{}) (err error) {
+	defer rec(&err)
+
+	cols, err := OutputColumns(nodes)
+	if err != nil {
+		return err
+	}
+	fields := structColumns(columnsType(val))
+
+	var missing []string
+	for _, col := range cols {
+		if col.Name != `` && !hasString(fields, col.Name) && !hasString(missing, col.Name) {
+			missing = append(missing, col.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			`[sqlp] result columns %q have no corresponding fields in %v`,
+			missing, columnsType(val),
+		)
+	}
+	return nil
+}
+
+// Range of the top-level select list, excluding the keywords, or -1.
+func selectListRange(nodes Nodes) (int, int) {
+	index := indexKeyword(nodes, 0, `select`)
+	if index < 0 {
+		return -1, -1
+	}
+
+	start := skipTrivia(nodes, index+1)
+	if isKeyword(nodeAt(nodes, start), `all`) {
+		start++
+	} else if isKeyword(nodeAt(nodes, start), `distinct`) {
+		start = skipTrivia(nodes, start+1)
+		if isKeyword(nodeAt(nodes, start), `on`) {
+			start = skipTrivia(nodes, start+1)
+			if _, ok := nodeAt(nodes, start).(ParenNodes); !ok {
+				panic(fmt.Errorf(`[sqlp] invalid select list: expected parens after DISTINCT ON`))
+			}
+			start++
+		}
+	}
+
+	end := indexKeyword(nodes, start, selectListSuccessorKeywords...)
+	if end < 0 {
+		end = len(nodes)
+	}
+	return start, end
+}
+
+func outputColumn(nodes Nodes) OutputColumn {
+	nodes = trimTrivia(nodes)
+	if len(nodes) == 0 {
+		panic(fmt.Errorf(`[sqlp] invalid select list: empty item`))
+	}
+
+	if isStar(nodes[0], NodeText(`select`)) && len(nodes) == 1 || isQualifiedStar(nodes) {
+		return OutputColumn{Expr: nodes, Star: true}
+	}
+
+	if isKeyword(nodes[len(nodes)-1], `as`) {
+		panic(fmt.Errorf(`[sqlp] invalid select list: expected alias after AS`))
+	}
+
+	name, last, ok := trailingIdent(nodes)
+	prev := skipTriviaBack(nodes, last-1)
+
+	if ok && prev >= 0 && prev < last-1 {
+		if isKeyword(nodes[prev], `as`) {
+			return OutputColumn{Name: name, Expr: trimTrivia(nodes[:prev])}
+		}
+		if isImplicitAlias(nodes[prev]) {
+			return OutputColumn{Name: name, Expr: trimTrivia(nodes[:prev+1])}
+		}
+	}
+
+	return OutputColumn{Name: exprName(nodes), Expr: nodes}
+}
+
+// True for a star with a qualifier in separate nodes, such as `"u".*`, or "u.*"
+// when identifiers are tokenized separately.
+func isQualifiedStar(nodes Nodes) bool {
+	text, ok := nodes[len(nodes)-1].(NodeText)
+	return ok && len(nodes) > 1 && strings.HasPrefix(string(text), `.`) && isStar(text, nil)
+}
+
+/*
+Name of the identifier at the end of the nodes, and the index where it begins.
+Adjacent double-quoted nodes form one identifier with escaped quotes, as in
+"a""b".
+*/
+func trailingIdent(nodes Nodes) (string, int, bool) {
+	index := len(nodes) - 1
+	if _, ok := nodes[index].(NodeQuoteDouble); !ok {
+		name, ok := identName(nodes[index])
+		return name, index, ok
+	}
+
+	var parts []string
+	for ; index >= 0; index-- {
+		part, ok := nodes[index].(NodeQuoteDouble)
+		if !ok {
+			break
+		}
+		parts = append([]string{string(part)}, parts...)
+	}
+	return strings.Join(parts, `"`), index + 1, true
+}
+
+/*
+Name implied by an expression without an alias: the last part of a column
+reference or function name, or for casts, the name implied by the operand, or
+failing that, the type.
+*/
+func exprName(nodes Nodes) string {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if _, ok := nodes[i].(NodeDoubleColon); ok {
+			if name := exprName(trimTrivia(nodes[:i])); name != `` {
+				return name
+			}
+			return exprName(typeName(trimTrivia(nodes[i+1:])))
+		}
+	}
+
+	// Function call such as "count(*)" or "pg_catalog.now()".
+	if len(nodes) > 1 {
+		if _, ok := nodes[len(nodes)-1].(ParenNodes); ok {
+			nodes = nodes[:len(nodes)-1]
+		}
+	}
+
+	// Column reference such as "users.id" or `"users"."Name"`.
+	var name string
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case NodeKeyword, NodeIdent:
+			var ok bool
+			if name, ok = identName(node); !ok {
+				return ``
+			}
+		case NodeText:
+			for _, part := range strings.Split(string(node), `.`) {
+				if part != `` {
+					var ok bool
+					if name, ok = identName(NodeText(part)); !ok {
+						return ``
+					}
+				}
+			}
+		case NodeQuoteDouble, NodeQuoteGrave:
+			name, _ = identName(node)
+		default:
+			return ``
+		}
+	}
+	return name
+}
+
+// Type name of a cast, without modifiers such as "(10)" or "[]".
+func typeName(nodes Nodes) Nodes {
+	for i, node := range nodes {
+		if text, ok := node.(NodeText); ok && strings.HasSuffix(string(text), `[]`) {
+			return append(nodes[:i:i], NodeText(strings.TrimSuffix(string(text), `[]`)))
+		}
+		if isTrivia(node) {
+			return nodes[:i]
+		}
+		switch node.(type) {
+		case ParenNodes, BracketNodes:
+			return nodes[:i]
+		}
+	}
+	return nodes
+}
+
+/*
+Name of a single identifier node: lowercased for unquoted identifiers, and
+preserved for quoted ones. Returns false for other nodes, and for reserved
+words which can't be used as aliases without quotes.
+*/
+func identName(node Node) (string, bool) {
+	switch node := node.(type) {
+	case NodeText, NodeKeyword, NodeIdent:
+		str, _ := wordText(node)
+		if str == `` || prefixIdentWith(str, charsetIdentLike) != str || isKeyword(node, selectListReservedWords...) {
+			return ``, false
+		}
+		return strings.ToLower(str), true
+	case NodeQuoteDouble:
+		return strings.ReplaceAll(string(node), `""`, `"`), true
+	case NodeQuoteGrave:
+		return strings.ReplaceAll(string(node), "``", "`"), true
+	default:
+		return ``, false
+	}
+}
+
+/*
+True if an identifier following the given node, separated by trivia, is an
+alias without AS, rather than a part of the expression, as in "a + b" or
+"a is not distinct from b".
+*/
+func isImplicitAlias(prev Node) bool {
+	if isKeyword(prev, selectListOperatorWords...) {
+		return false
+	}
+	if _, ok := prev.(NodeDoubleColon); ok {
+		return false
+	}
+	text, ok := prev.(NodeText)
+	return !(ok && text != `` && charsetCacheKeyOperator.has(text[len(text)-1]))
+}
+
+func trimTrivia(nodes Nodes) Nodes {
+	start := skipTrivia(nodes, 0)
+	end := skipTriviaBack(nodes, len(nodes)-1) + 1
+	if start >= end {
+		return nil
+	}
+	return nodes[start:end]
+}
+
+var (
+	selectListSuccessorKeywords = append(
+		[]string{`from`, `into`, `where`},
+		whereSuccessorKeywords...,
+	)
+
+	// Words which end an expression rather than name an alias.
+	selectListReservedWords = []string{
+		`end`, `null`, `true`, `false`, `unknown`, `and`, `or`, `not`, `is`,
+		`in`, `like`, `ilike`, `between`, `then`, `else`, `when`, `case`,
+	}
+
+	// Words after which an identifier is an operand rather than an alias.
+	selectListOperatorWords = []string{
+		`and`, `or`, `not`, `is`, `in`, `like`, `ilike`, `similar`, `between`,
+		`escape`, `collate`, `zone`, `then`, `else`, `when`, `case`, `distinct`,
+		`from`, `interval`, `array`, `exists`, `any`, `all`, `some`,
+	}
+)
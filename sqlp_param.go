@@ -0,0 +1,365 @@
+package sqlp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Placeholder syntax used by a query. See `DetectParamStyle`.
+type ParamStyle byte
+
+const (
+	// No placeholders.
+	ParamStyleNone ParamStyle = iota
+
+	// Postgres-style ordinal placeholders: $1, $2, $3, ...
+	ParamStyleOrdinal
+
+	// Named placeholders preceded by colon: :identifier
+	ParamStyleNamed
+
+	// Positional placeholders used by MySQL, SQLite, ODBC: ?
+	ParamStyleQuestion
+
+	// Named placeholders preceded by "at" sign, used by MSSQL: @identifier
+	ParamStyleAt
+
+	// Oracle-style numeric placeholders: :1, :2, :3, ...
+	ParamStyleNumeric
+
+	// Named placeholders preceded by dollar sign, used by SQLite: $identifier
+	ParamStyleDollar
+
+	// Numbered placeholders used by SQLite: ?1, ?2, ?3, ...
+	ParamStyleQuestionNumbered
+)
+
+// Implement `fmt.Stringer` for debug purposes.
+func (self ParamStyle) String() string {
+	switch self {
+	case ParamStyleNone:
+		return `none`
+	case ParamStyleOrdinal:
+		return `ordinal`
+	case ParamStyleNamed:
+		return `named`
+	case ParamStyleQuestion:
+		return `question`
+	case ParamStyleAt:
+		return `at`
+	case ParamStyleNumeric:
+		return `numeric`
+	case ParamStyleDollar:
+		return `dollar`
+	case ParamStyleQuestionNumbered:
+		return `question_numbered`
+	default:
+		return fmt.Sprintf(`ParamStyle(%d)`, byte(self))
+	}
+}
+
+/*
+Scans the tokens of the given query and reports which placeholder syntax it
+uses: `$N`, `:name`, `?`, or `@name`. Placeholders inside quotes and comments
+are ignored. Returns `ParamStyleNone` if the query has no placeholders, and an
+error if it mixes different styles, or if tokenization fails.
+
+Detection of `?` and `@name` is best-effort: they're not distinguished from
+operators such as the Postgres JSON operator `?`. Postgres operators `?|` and
+`?&` and MySQL/MSSQL system variables such as `@@version` are ignored.
+
+The source is tokenized with `DialectAny`, which means dialect-specific
+placeholders such as Oracle-style `:1` are not detected. To check them, parse
+the query with the appropriate `Tokenizer.Dialect`, and use
+`CheckParamConsistency`.
+*/
+func DetectParamStyle(src string) (_ ParamStyle, err error) {
+	defer rec(&err)
+
+	var styles paramStyles
+	tokenizer := Tokenizer{Source: src}
+
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			break
+		}
+
+		switch tok.Type {
+		case TypeOrdinalParam:
+			styles.add(ParamStyleOrdinal)
+		case TypeNamedParam:
+			styles.add(ParamStyleNamed)
+		case TypeText:
+			styles.addText(tok.Slice(src))
+		}
+	}
+
+	return styles.style, nil
+}
+
+/*
+Lint for parsed queries. Returns an error if the AST mixes different placeholder
+styles, for example ordinal `$1` and named `:name`, or named `:name` and
+`@name`. Such a mixture almost always indicates a bug or a missed rewrite. Uses
+the same rules as `DetectParamStyle`.
+*/
+func CheckParamConsistency(nodes Nodes) (err error) {
+	defer rec(&err)
+
+	var styles paramStyles
+	DeepWalkNode(nodes, func(node Node) {
+		switch node := node.(type) {
+		case NodeOrdinalParam:
+			styles.add(ParamStyleOrdinal)
+		case NodeNamedParam:
+			styles.add(ParamStyleNamed)
+		case NodeAtParam:
+			styles.add(ParamStyleAt)
+		case NodeDollarParam:
+			styles.add(ParamStyleDollar)
+		case NodeNumericParam:
+			styles.add(ParamStyleNumeric)
+		case NodeQuestionParam:
+			if node == 0 {
+				styles.add(ParamStyleQuestion)
+			} else {
+				styles.add(ParamStyleQuestionNumbered)
+			}
+		case NodeText:
+			styles.addText(string(node))
+		}
+	})
+	return nil
+}
+
+/*
+Serializes the AST like `Node.String`, but renders parameter placeholders in
+the given style, regardless of how they're represented in the AST. This allows
+to use the same AST with databases that use different placeholder syntax. The
+supported conversions are:
+
+	ParamStyleOrdinal          : $1 -> $1, :1 -> $1, ?1 -> $1
+	ParamStyleNumeric          : $1 -> :1, :1 -> :1, ?1 -> :1
+	ParamStyleQuestionNumbered : $1 -> ?1, :1 -> ?1, ?1 -> ?1
+	ParamStyleQuestion         : $1 -> ?, :1 -> ?, ?1 -> ?
+	ParamStyleNamed            : :name -> :name, @name -> :name, $name -> :name
+	ParamStyleAt               : :name -> @name, @name -> @name, $name -> @name, $1 -> @p1
+	ParamStyleDollar           : :name -> $name, @name -> $name, $name -> $name
+
+Plain "?" is treated as the numbered param after the largest one referenced so
+far, following SQLite, and is converted like other numbered params. In queries
+without numbered params, this is simply the next one: "?, ?" is the same as
+"?1, ?2".
+
+Conversion from numbered params to `?` requires the params to occur in
+sequence: $1, $2, $3 and so on, without gaps or repetitions, because `?` is
+positional. Any other conversion, such as named to ordinal, causes an error,
+because it requires changing the arguments, and not just the query. Style
+`ParamStyleNone` rejects all placeholders. Doesn't modify the input.
+*/
+func RenderParams(node Node, style ParamStyle) (_ string, err error) {
+	defer rec(&err)
+
+	node = CopyNode(node)
+	count := 0
+	var questions questionParams
+
+	DeepWalkNodePtr(&node, func(ptr *Node) {
+		switch val := (*ptr).(type) {
+		case NodeOrdinalParam:
+			count++
+			*ptr = renderOrdinalParam(val, style, count)
+		case NodeNumericParam:
+			count++
+			*ptr = renderOrdinalParam(NodeOrdinalParam(val), style, count)
+		case NodeQuestionParam:
+			count++
+			*ptr = renderOrdinalParam(NodeOrdinalParam(questions.index(val)), style, count)
+		case NodeNamedParam:
+			*ptr = renderNamedParam(val, string(val), style)
+		case NodeAtParam:
+			*ptr = renderNamedParam(val, string(val), style)
+		case NodeDollarParam:
+			*ptr = renderNamedParam(val, string(val), style)
+		}
+	})
+
+	if node == nil {
+		return ``, nil
+	}
+	return node.String(), nil
+}
+
+func renderOrdinalParam(val NodeOrdinalParam, style ParamStyle, count int) Node {
+	switch style {
+	case ParamStyleOrdinal:
+		return val
+	case ParamStyleNumeric:
+		return NodeNumericParam(val)
+	case ParamStyleQuestionNumbered:
+		return NodeQuestionParam(val)
+	case ParamStyleAt:
+		return NodeText(`@p` + strconv.Itoa(int(val)))
+	case ParamStyleQuestion:
+		if int(val) != count {
+			panic(fmt.Errorf(`[sqlp] can't render ordinal param %v as %q: expected %v`, val, `?`, NodeOrdinalParam(count)))
+		}
+		return NodeText(`?`)
+	default:
+		panic(errParamStyle(val, style))
+	}
+}
+
+func renderNamedParam(val Node, name string, style ParamStyle) Node {
+	switch style {
+	case ParamStyleNamed:
+		return NodeNamedParam(name)
+	case ParamStyleAt:
+		return NodeAtParam(name)
+	case ParamStyleDollar:
+		return NodeDollarParam(name)
+	default:
+		panic(errParamStyle(val, style))
+	}
+}
+
+/*
+Resolves `NodeQuestionParam` to 1-based indexes of arguments, following SQLite:
+plain "?" refers to the argument after the largest one referenced so far.
+*/
+type questionParams struct{ last int }
+
+func (self *questionParams) index(val NodeQuestionParam) int {
+	index := int(val)
+	if index == 0 {
+		index = self.last + 1
+	}
+	if index > self.last {
+		self.last = index
+	}
+	return index
+}
+
+func errParamStyle(val Node, style ParamStyle) error {
+	return fmt.Errorf(`[sqlp] can't render param %v in style %q`, val, style)
+}
+
+/*
+Accumulates placeholder styles found in a query. Panics on the first style
+that differs from a previously found one.
+*/
+type paramStyles struct{ style ParamStyle }
+
+func (self *paramStyles) add(style ParamStyle) {
+	if style == ParamStyleNone || style == self.style {
+		return
+	}
+	if self.style == ParamStyleNone {
+		self.style = style
+		return
+	}
+	panic(fmt.Errorf(`[sqlp] query mixes parameter styles %q and %q`, self.style, style))
+}
+
+func (self *paramStyles) addText(str string) {
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '?':
+			if i+1 < len(str) && (str[i+1] == '|' || str[i+1] == '&') {
+				i++
+				continue
+			}
+			self.add(ParamStyleQuestion)
+
+		case '@':
+			if i+1 < len(str) && str[i+1] == '@' {
+				i += len(prefixIdent(str[i+2:])) + 1
+				continue
+			}
+			if (i == 0 || !charsetIdent.has(str[i-1])) && len(prefixIdent(str[i+1:])) > 0 {
+				self.add(ParamStyleAt)
+			}
+		}
+	}
+}
+
+// Maximum number of placeholders in one query, enforced by the database.
+// Intended for `CheckPlaceholderLimit`.
+const (
+	MaxPlaceholdersPostgres = 65535
+	MaxPlaceholdersMSSQL    = 2100
+)
+
+/*
+Returns the number of arguments which the query requires, which is the number
+that counts toward database limits on placeholders. Numbered placeholders such
+as "$1", ":1", and "?1" require as many arguments as the largest number, and
+plain "?" is numbered like in `RenderParams`. Named placeholders such as
+":name", "@name", and "$name" require one argument per distinct name, which is
+how many ordinal placeholders `BindNamed` produces.
+*/
+func CountPlaceholders(nodes Nodes) int {
+	var questions questionParams
+	var last int
+	names := map[Node]struct{}{}
+
+	DeepWalkNode(nodes, func(node Node) {
+		switch node := node.(type) {
+		case NodeOrdinalParam:
+			last = maxInt(last, int(node))
+		case NodeNumericParam:
+			last = maxInt(last, int(node))
+		case NodeQuestionParam:
+			last = maxInt(last, questions.index(node))
+		case NodeNamedParam, NodeAtParam, NodeDollarParam:
+			names[node] = struct{}{}
+		}
+	})
+	return last + len(names)
+}
+
+/*
+Returns an error if the query requires more arguments than the given maximum,
+as counted by `CountPlaceholders`. Use `MaxPlaceholdersPostgres` or
+`MaxPlaceholdersMSSQL` for the limits of these databases. Intended for code
+which builds large batches, such as multi-row inserts, to fail early with a
+clear error, rather than with a driver error which may be cryptic. The error
+is `PlaceholderLimitError`, which suggests splitting the batch. Also returns
+an error if the maximum is not positive.
+*/
+func CheckPlaceholderLimit(nodes Nodes, max int) error {
+	if max <= 0 {
+		return fmt.Errorf(`[sqlp] invalid placeholder limit %v: expected positive number`, max)
+	}
+	if count := CountPlaceholders(nodes); count > max {
+		return PlaceholderLimitError{Count: count, Max: max}
+	}
+	return nil
+}
+
+// Error returned by `CheckPlaceholderLimit`.
+type PlaceholderLimitError struct {
+	Count int
+	Max   int
+}
+
+// Implement `error`.
+func (self PlaceholderLimitError) Error() string {
+	return fmt.Sprintf(
+		`[sqlp] query requires %v arguments, exceeding the limit of %v placeholders; consider splitting the batch into at least %v parts`,
+		self.Count, self.Max, self.Batches(),
+	)
+}
+
+/*
+Minimum number of batches which would fit under the limit, assuming that the
+number of placeholders is proportional to the batch size, as in multi-row
+inserts.
+*/
+func (self PlaceholderLimitError) Batches() int {
+	if self.Max <= 0 {
+		return 0
+	}
+	return (self.Count + self.Max - 1) / self.Max
+}
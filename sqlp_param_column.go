@@ -0,0 +1,511 @@
+package sqlp
+
+import (
+	"strings"
+)
+
+/*
+Column which a placeholder is compared against or assigned to, inferred by
+`ParamColumns`. Names are normalized like `TableRef.Name`: unquoted names are
+lowercased, and quoted names are preserved without quotes.
+*/
+type ParamColumn struct {
+	// Placeholder: `NodeNamedParam`, `NodeOrdinalParam`, or `NodeNumericParam`.
+	Param Node
+
+	// Table name, possibly schema-qualified, or empty if unknown.
+	Table string
+
+	// Column name.
+	Column string
+}
+
+// Implement `fmt.Stringer`. Returns the column qualified by the table if any,
+// such as "users.email".
+func (self ParamColumn) String() string {
+	if self.Table == `` {
+		return self.Column
+	}
+	return self.Table + `.` + self.Column
+}
+
+/*
+Best-effort analysis which infers the column corresponding to each placeholder
+by looking at the surrounding tokens, for code generators and type inference.
+Returns one entry per distinct placeholder, in order of occurrence, omitting
+placeholders whose column can't be inferred. Recognizes the following
+patterns, where casts such as "::uuid" after placeholders are allowed:
+
+	col = :param            (and other comparison operators, either side)
+	col [not] like :param   (and ilike)
+	col [not] in (:one, :two)
+	col = any(:param)       (and all, some)
+	col between :one and :two
+	set col = :param
+	insert into tab (one, two) values (:one, :two)
+
+Qualifiers such as "u" in "u.email" are resolved to table names via the
+aliases found by `Tables`, and via the targets of INSERT, UPDATE, and DELETE.
+Unqualified columns are attributed to the target of INSERT, UPDATE, or DELETE,
+or to the only table in the FROM clause of the innermost enclosing SELECT, and
+otherwise have no table. When a placeholder occurs
+more than once, the first inferred column wins. Never fails: syntax which
+`Tables` doesn't support only disables alias resolution.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users u where u.email = :email and id in (:ids)`)
+	...
+	sqlp.ParamColumns(nodes)
+	// []ParamColumn{
+	// 	{Param: NodeNamedParam("email"), Table: "users", Column: "email"},
+	// 	{Param: NodeNamedParam("ids"), Table: "users", Column: "id"},
+	// }
+*/
+func ParamColumns(nodes Nodes) []ParamColumn {
+	infer := paramInferrer{aliases: map[string]string{}, found: map[string]bool{}}
+	infer.resolveTables(nodes)
+	infer.level(nodes)
+	return infer.out
+}
+
+type paramTokKind byte
+
+const (
+	paramTokOther paramTokKind = iota
+	paramTokWord
+	paramTokOp
+	paramTokComma
+	paramTokCast
+	paramTokParam
+	paramTokParen
+)
+
+/*
+Significant token of one nesting level. Adjacent names separated by dots, such
+as "public"."users", form one word with several parts.
+*/
+type paramTok struct {
+	kind   paramTokKind
+	parts  []string
+	quoted bool
+	node   Node
+}
+
+// Lowercase keyword, or empty if the token is not a single unquoted word.
+func (self paramTok) keyword() string {
+	if self.kind != paramTokWord || self.quoted || len(self.parts) != 1 {
+		return ``
+	}
+	return self.parts[0]
+}
+
+// True if the token is a word which may be a column name.
+func (self paramTok) isColumn() bool {
+	return self.kind == paramTokWord && !anonKeywords[self.keyword()]
+}
+
+/*
+Converts one nesting level into significant tokens, skipping whitespace and
+comments, and merging dot-separated names.
+*/
+func paramToks(nodes Nodes) []paramTok {
+	var out []paramTok
+	dot := false
+
+	// Appends a name part, merging it with the preceding word after a dot.
+	part := func(val string, quoted bool) {
+		if dot && len(out) > 0 && out[len(out)-1].kind == paramTokWord {
+			prev := &out[len(out)-1]
+			prev.parts = append(prev.parts, val)
+			prev.quoted = prev.quoted || quoted
+		} else {
+			out = append(out, paramTok{kind: paramTokWord, parts: []string{val}, quoted: quoted})
+		}
+		dot = false
+	}
+
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case NodeWhitespace, NodeCommentLine, NodeCommentBlock, NodeCommentHash:
+			dot = false
+
+		case NodeQuoteDouble:
+			part(string(node), true)
+
+		case NodeQuoteGrave:
+			part(string(node), true)
+
+		case NodeIdent, NodeKeyword:
+			text, _ := wordText(node)
+			part(strings.ToLower(text), false)
+
+		case NodeText:
+			src := string(node)
+			for len(src) > 0 {
+				char := src[0]
+				size := 1
+
+				switch {
+				case charsetIdentStart.has(char) || charsetNonASCII.has(char):
+					for size < len(src) && charsetIdentLike.has(src[size]) {
+						size++
+					}
+					part(strings.ToLower(src[:size]), false)
+					src = src[size:]
+					continue
+
+				case char == '.' && len(out) > 0 && out[len(out)-1].kind == paramTokWord:
+					dot = true
+					src = src[size:]
+					continue
+
+				case strings.IndexByte(`<>=!~`, char) >= 0:
+					for size < len(src) && strings.IndexByte(`<>=!~`, src[size]) >= 0 {
+						size++
+					}
+					out = append(out, paramTok{kind: paramTokOp, parts: []string{src[:size]}})
+
+				case char == ',':
+					out = append(out, paramTok{kind: paramTokComma})
+
+				case charsetWhitespace.has(char):
+
+				default:
+					for size < len(src) && charsetIdent.has(src[size]) {
+						size++
+					}
+					out = append(out, paramTok{kind: paramTokOther})
+				}
+
+				dot = false
+				src = src[size:]
+			}
+
+		case NodeDoubleColon:
+			out = append(out, paramTok{kind: paramTokCast})
+			dot = false
+
+		case NodeNamedParam, NodeAtParam, NodeDollarParam, NodeOrdinalParam, NodeNumericParam, NodeQuestionParam:
+			out = append(out, paramTok{kind: paramTokParam, node: node})
+			dot = false
+
+		case ParenNodes:
+			out = append(out, paramTok{kind: paramTokParen, node: node})
+			dot = false
+
+		default:
+			out = append(out, paramTok{kind: paramTokOther, node: node})
+			dot = false
+		}
+	}
+	return out
+}
+
+type paramInferrer struct {
+	aliases map[string]string
+	found   map[string]bool
+	out     []ParamColumn
+
+	// Table of unqualified columns at the current level, or empty.
+	table string
+}
+
+// Collects the table names and aliases of the entire query, for resolving
+// qualifiers.
+func (self *paramInferrer) resolveTables(nodes Nodes) {
+	add := func(name, alias string) {
+		if alias != `` {
+			self.aliases[alias] = name
+		}
+		self.aliases[name] = name
+		self.aliases[name[strings.LastIndexByte(name, '.')+1:]] = name
+	}
+
+	tables, _ := Tables(nodes)
+	for _, val := range tables {
+		add(val.Name, strings.ToLower(val.Alias))
+	}
+
+	var walk func(Nodes)
+	walk = func(nodes Nodes) {
+		toks := paramToks(nodes)
+		for i, tok := range toks {
+			if name, alias, ok := paramTarget(toks, i); ok {
+				add(name, alias)
+			}
+			if tok.kind == paramTokParen {
+				walk(Nodes(tok.node.(ParenNodes)))
+			}
+		}
+	}
+	walk(nodes)
+}
+
+/*
+If the token at the given index begins INSERT, UPDATE, or DELETE, returns the
+name and the alias of the target table.
+*/
+func paramTarget(toks []paramTok, index int) (string, string, bool) {
+	next := index + 1
+
+	switch toks[index].keyword() {
+	case `insert`, `delete`:
+		key := paramTokAt(toks, next).keyword()
+		if key != `into` && key != `from` {
+			return ``, ``, false
+		}
+		next++
+
+	case `update`:
+		// Excludes locking clauses such as "for update" and "for no key update".
+		prev := paramTokAt(toks, index-1).keyword()
+		if prev == `for` || prev == `key` {
+			return ``, ``, false
+		}
+
+	default:
+		return ``, ``, false
+	}
+
+	target := paramTokAt(toks, next)
+	if !target.isColumn() {
+		return ``, ``, false
+	}
+	name := strings.Join(target.parts, `.`)
+
+	next++
+	if paramTokAt(toks, next).keyword() == `as` {
+		next++
+	}
+	alias := paramTokAt(toks, next)
+	if alias.isColumn() && len(alias.parts) == 1 {
+		return name, alias.parts[0], true
+	}
+	return name, ``, true
+}
+
+/*
+Returns the table of unqualified columns at the given level: the target of
+INSERT, UPDATE, or DELETE, or the only table of the FROM clause. The boolean
+is false if the level has neither, in which case the table of the enclosing
+level applies.
+*/
+func paramLevelTable(nodes Nodes, toks []paramTok) (string, bool) {
+	for i := range toks {
+		if name, _, ok := paramTarget(toks, i); ok {
+			return name, true
+		}
+	}
+
+	var items []fromItem
+	var err error
+	func() {
+		defer rec(&err)
+		items = fromItems(normalizeFrom(nodes))
+	}()
+
+	if err != nil || len(items) == 0 {
+		return ``, err != nil
+	}
+	if len(items) == 1 {
+		return items[0].Name, true
+	}
+	return ``, true
+}
+
+func (self *paramInferrer) level(nodes Nodes) {
+	toks := paramToks(nodes)
+
+	outer := self.table
+	if table, ok := paramLevelTable(nodes, toks); ok {
+		self.table = table
+	}
+
+	for i, tok := range toks {
+		switch tok.kind {
+		case paramTokParam:
+			self.param(toks, i)
+
+		case paramTokParen:
+			self.paren(toks, i)
+			self.level(Nodes(tok.node.(ParenNodes)))
+		}
+	}
+
+	self.table = outer
+}
+
+// Infers the column of the placeholder at the given index from its neighbors.
+func (self *paramInferrer) param(toks []paramTok, index int) {
+	param := toks[index].node
+
+	// Left side: "col = :param", "col like :param", "col between :param".
+	prev := index - 1
+	switch {
+	case paramTokAt(toks, prev).kind == paramTokOp:
+		self.column(param, paramTokAt(toks, prev-1))
+		return
+
+	case paramTokAt(toks, prev).keyword() == `and`:
+		// Second operand of BETWEEN.
+		start := paramSkipCast(toks, prev-1, -1)
+		if paramTokAt(toks, start).kind == paramTokParam {
+			prev = start - 1
+			if paramTokAt(toks, prev).keyword() == `between` {
+				self.column(param, paramTokAt(toks, paramSkipNot(toks, prev-1)))
+				return
+			}
+		}
+
+	case hasString([]string{`like`, `ilike`, `between`}, paramTokAt(toks, prev).keyword()):
+		self.column(param, paramTokAt(toks, paramSkipNot(toks, prev-1)))
+		return
+	}
+
+	// Right side: ":param = col".
+	next := paramSkipCast(toks, index+1, 1)
+	if paramTokAt(toks, next).kind == paramTokOp {
+		self.column(param, paramTokAt(toks, next+1))
+	}
+}
+
+/*
+Infers the columns of the placeholders directly inside the parens at the given
+index, for "col in (...)", "col = any(...)", and INSERT values.
+*/
+func (self *paramInferrer) paren(toks []paramTok, index int) {
+	inner := paramToks(Nodes(toks[index].node.(ParenNodes)))
+	prev := paramTokAt(toks, index-1)
+
+	switch prev.keyword() {
+	case `in`:
+		if len(inner) > 0 && inner[0].kind == paramTokParam {
+			col := paramTokAt(toks, paramSkipNot(toks, index-2))
+			for _, tok := range inner {
+				if tok.kind == paramTokParam {
+					self.column(tok.node, col)
+				}
+			}
+		}
+		return
+
+	case `any`, `all`, `some`:
+		if len(inner) > 0 && inner[0].kind == paramTokParam && paramTokAt(toks, index-2).kind == paramTokOp {
+			self.column(inner[0].node, paramTokAt(toks, index-3))
+		}
+		return
+	}
+
+	cols := paramInsertColumns(toks, index)
+	if cols == nil {
+		return
+	}
+
+	col := 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i].kind {
+		case paramTokComma:
+			col++
+		case paramTokParam:
+			start := i
+			i = paramSkipCast(inner, i+1, 1) - 1
+			isSole := paramTokAt(inner, start-1).kind == paramTokComma || start == 0
+			isSole = isSole && (i+1 >= len(inner) || inner[i+1].kind == paramTokComma)
+			if isSole && col < len(cols) {
+				self.column(inner[start].node, cols[col])
+			}
+		}
+	}
+}
+
+/*
+If the parens at the given index are a tuple of INSERT values, returns the
+column list of the INSERT. Supports multiple tuples separated by commas.
+*/
+func paramInsertColumns(toks []paramTok, index int) []paramTok {
+	prev := index - 1
+	for paramTokAt(toks, prev).kind == paramTokComma && paramTokAt(toks, prev-1).kind == paramTokParen {
+		prev -= 2
+	}
+	if paramTokAt(toks, prev).keyword() != `values` {
+		return nil
+	}
+
+	list := paramTokAt(toks, prev-1)
+	if list.kind != paramTokParen {
+		return nil
+	}
+
+	// Table name, optionally followed by an alias, optionally preceded by AS.
+	into := prev - 2
+	for into > prev-6 && paramTokAt(toks, into).kind == paramTokWord && paramTokAt(toks, into).keyword() != `into` {
+		into--
+	}
+	if paramTokAt(toks, into).keyword() != `into` || into == prev-2 {
+		return nil
+	}
+
+	var out []paramTok
+	for _, tok := range paramToks(Nodes(list.node.(ParenNodes))) {
+		if tok.kind != paramTokComma {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+func (self *paramInferrer) column(param Node, tok paramTok) {
+	if !tok.isColumn() {
+		return
+	}
+
+	key := param.String()
+	if self.found[key] {
+		return
+	}
+	self.found[key] = true
+
+	out := ParamColumn{Param: param, Column: tok.parts[len(tok.parts)-1]}
+	if len(tok.parts) > 1 {
+		qual := strings.Join(tok.parts[:len(tok.parts)-1], `.`)
+		out.Table = self.aliases[qual]
+		if out.Table == `` {
+			out.Table = qual
+		}
+	} else {
+		out.Table = self.table
+	}
+	self.out = append(self.out, out)
+}
+
+func paramTokAt(toks []paramTok, index int) paramTok {
+	if index >= 0 && index < len(toks) {
+		return toks[index]
+	}
+	return paramTok{kind: paramTokOther}
+}
+
+/*
+Skips a cast such as "::uuid" starting at the given index, in
+the given direction, and returns the index after it.
+*/
+func paramSkipCast(toks []paramTok, index, step int) int {
+	for {
+		if step > 0 && paramTokAt(toks, index).kind == paramTokCast && paramTokAt(toks, index+1).kind == paramTokWord {
+			index += 2
+		} else if step < 0 && paramTokAt(toks, index).kind == paramTokWord && paramTokAt(toks, index-1).kind == paramTokCast {
+			index -= 2
+		} else {
+			return index
+		}
+	}
+}
+
+// Skips a preceding NOT, as in "not like", moving backwards.
+func paramSkipNot(toks []paramTok, index int) int {
+	if paramTokAt(toks, index).keyword() == `not` {
+		return index - 1
+	}
+	return index
+}
@@ -0,0 +1,90 @@
+package sqlp
+
+import (
+	"strings"
+)
+
+// Named parameter with its documentation. See `ParamDocs`.
+type ParamDoc struct {
+	// Name without the prefix, such as "user_id" for ":user_id".
+	Name string
+	// Text of the documenting comment after the name, or empty.
+	Doc string
+}
+
+/*
+Returns the named parameters of the query, in order of first occurrence, each
+with the documentation from the nearest comment preceding its first occurrence
+which mentions it. Intended for generating documentation for libraries of
+queries stored in ".sql" files. A comment documents a parameter when a line of
+the comment begins with a reference to it, followed by the description:
+
+	-- :user_id the account owner
+	-- :limit   max rows to return
+	select * from posts where user_id = :user_id limit :limit
+
+Block comments may document several parameters, one per line. Leading
+separators such as "-" or ":" are trimmed from the description. Supports
+named params such as ":name", and also "@name" and "$name" in dialects where
+they're params; comments may use either prefix. Parameters without such
+comments have empty docs.
+
+Example:
+
+	nodes, err := sqlp.Parse(src)
+	...
+	docs := sqlp.ParamDocs(nodes)
+	// []ParamDoc{{Name: "user_id", Doc: "the account owner"}, {Name: "limit", Doc: "max rows to return"}}
+*/
+func ParamDocs(nodes Nodes) []ParamDoc {
+	var out []ParamDoc
+	docs := map[string]string{}
+	found := map[string]bool{}
+
+	add := func(name string) {
+		if !found[name] {
+			found[name] = true
+			out = append(out, ParamDoc{Name: name, Doc: docs[name]})
+		}
+	}
+
+	DeepWalkNode(nodes, func(node Node) {
+		switch node := node.(type) {
+		case NodeCommentLine:
+			paramDocComment(docs, string(node))
+		case NodeCommentBlock:
+			paramDocComment(docs, string(node))
+		case NodeCommentHash:
+			paramDocComment(docs, string(node))
+		case NodeNamedParam:
+			add(string(node))
+		case NodeAtParam:
+			add(string(node))
+		case NodeDollarParam:
+			add(string(node))
+		}
+	})
+	return out
+}
+
+// Collects docs from the lines of a comment which begin with param references.
+func paramDocComment(docs map[string]string, str string) {
+	for _, line := range strings.Split(str, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), `*`)
+		line = strings.TrimSpace(line)
+		if line == `` || !strings.ContainsRune(`:@$`, rune(line[0])) {
+			continue
+		}
+
+		name := prefixIdentWith(line[1:], charsetIdentLike)
+		if name == `` {
+			continue
+		}
+
+		rest := line[1+len(name):]
+		if rest != `` && !charsetWhitespace.has(rest[0]) && !strings.ContainsRune(`-:`, rune(rest[0])) {
+			continue
+		}
+		docs[name] = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(rest), `-:`))
+	}
+}
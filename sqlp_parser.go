@@ -4,8 +4,8 @@ import "fmt"
 
 /*
 Parses SQL text and returns the resulting AST. For the AST structure, see `Node`
-and the various node types. Also see `Tokenizer` and `Tokenizer.Next` for
-incremental parsing.
+and the various node types. Also see `Tokenizer` and `Tokenizer.Token` for
+incremental tokenization.
 
 Example:
 
@@ -24,8 +24,36 @@ func Parse(src string) (Nodes, error) {
 	return parser.Parse()
 }
 
-// See `Parse`.
-type Parser struct{ Tokenizer }
+/*
+See `Parse`. The embedded `Tokenizer` may be configured with custom
+recognizers. The optional `Factory` allows to produce application-specific
+nodes directly in the resulting AST.
+*/
+type Parser struct {
+	Tokenizer
+	Factory NodeFactory
+}
+
+/*
+Converts tokens into nodes during parsing; see `Parser`. Takes the full source
+text and a token. May return nil to fall back on the default conversion via
+`Token.Node`. Invoked for every token other than the delimiters of `()`, `[]`,
+`{}`. Required for tokens of custom types, produced by a custom `Recognizer`,
+because `Token.Node` doesn't support them.
+
+Example:
+
+	parser := Parser{
+		Tokenizer: Tokenizer{Source: src},
+		Factory: func(src string, tok Token) Node {
+			if tok.Type == TypeNamedParam && tok.Slice(src) == `:tenant` {
+				return TenantFilter{}
+			}
+			return nil
+		},
+	}
+*/
+type NodeFactory func(string, Token) Node
 
 // See `Parse`.
 func (self *Parser) Parse() (nodes Nodes, err error) {
@@ -44,23 +72,80 @@ func (self *Parser) parse(nodes *Nodes) {
 	}
 }
 
+/*
+Incremental version of `Parser.Parse`. Returns the next top-level node, or nil
+at EOF. Opening delimiters are parsed together with their content. Uses the
+same configuration as `Parser.Parse`, including the options of the embedded
+`Tokenizer` such as `Tokenizer.Dialect`, and the `Factory`, which means that
+parsing a source node by node produces the same nodes as parsing it at once.
+May be mixed with `Tokenizer.Token`. Panics on invalid syntax.
+*/
+func (self *Parser) Next() Node {
+	tok := self.Token()
+	if tok.IsInvalid() {
+		return nil
+	}
+
+	var nodes Nodes
+	self.parseToken(&nodes, tok)
+	return nodes[0]
+}
+
 func (self *Parser) parseToken(nodes *Nodes, tok Token) {
+	statNode()
+
 	switch tok.Type {
 	case TypeParenOpen:
-		*nodes = append(*nodes, self.parseParens())
+		appendNode(nodes, self.parseParens())
 
 	case TypeBracketOpen:
-		*nodes = append(*nodes, self.parseBrackets())
+		appendNode(nodes, self.parseBrackets())
 
 	case TypeBraceOpen:
-		*nodes = append(*nodes, self.parseBraces())
+		appendNode(nodes, self.parseBraces())
+
+	case TypeDelimOpen:
+		appendNode(nodes, self.parseDelim(tok))
 
-	case TypeParenClose, TypeBracketClose, TypeBraceClose:
+	case TypeParenClose, TypeBracketClose, TypeBraceClose, TypeDelimClose:
 		panic(fmt.Errorf(`[sqlp] unexpected closing %q`, tok.Slice(self.Source)))
 
 	default:
-		*nodes = append(*nodes, tok.Node(self.Source))
+		appendNode(nodes, self.node(tok))
+	}
+}
+
+// Appends a parsed node, counting the growth of the slice in `Stats.Allocs`.
+func appendNode(nodes *Nodes, node Node) {
+	if len(*nodes) == cap(*nodes) {
+		statAlloc()
 	}
+	*nodes = append(*nodes, node)
+}
+
+func (self *Parser) node(tok Token) Node {
+	src := self.Source
+
+	// A token which is unterminated at EOF, allowed by `UnclosedClose`. With the
+	// missing delimiter, it converts to the same node as in valid source.
+	if tok == self.closedTok && self.closedStr != `` {
+		src += self.closedStr
+		tok.Region[1] += len(self.closedStr)
+	}
+
+	if self.Factory != nil {
+		node := self.Factory(src, tok)
+		if node != nil {
+			return node
+		}
+	}
+	if self.Plugin != nil && self.Plugin.Factory != nil {
+		node := self.Plugin.Factory(src, tok)
+		if node != nil {
+			return node
+		}
+	}
+	return tok.Node(src)
 }
 
 func (self *Parser) parseParens() (out ParenNodes) {
@@ -78,6 +163,27 @@ func (self *Parser) parseBraces() (out BraceNodes) {
 	return
 }
 
+func (self *Parser) parseDelim(open Token) (out DelimNodes) {
+	delim, ok := self.delimByOpen(open.Slice(self.Source))
+	if !ok {
+		panic(fmt.Errorf(`[sqlp] unknown opening delimiter %q`, open.Slice(self.Source)))
+	}
+	out.Delim = delim
+
+	for {
+		tok := self.Token()
+		if tok.IsInvalid() {
+			break
+		}
+		if tok.Type == TypeDelimClose && tok.Slice(self.Source) == delim.Close {
+			return
+		}
+		self.parseToken(&out.Inner, tok)
+	}
+
+	panic(fmt.Errorf(`[sqlp] missing closing delimiter %q`, delim.Close))
+}
+
 func (self *Parser) parseUntil(nodes *Nodes, typ Type, str string) {
 	for {
 		tok := self.Token()
@@ -0,0 +1,424 @@
+package sqlp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+Structural pattern for searching the AST, for use in lint rules and bulk
+rewrites, as a replacement for regexes on SQL text. A pattern is written as SQL
+with wildcards. Use `ParsePattern` to make one.
+
+Both the pattern and the searched AST are compared as sequences of atoms,
+ignoring whitespace and comments. Text is split into words, such as "count"
+or "id", operators, such as "=" or "<=", and other punctuation characters,
+such as "," or ".", which means "a=b" and "a = b" are equivalent. As in
+Postgres, a run of operator characters is a single operator. Words are compared
+ignoring case. Other nodes, such as quotes and params, are atoms compared by
+type and content. Parens, brackets, braces, and `DelimNodes` are atoms whose
+content must match entirely. Wildcards:
+
+	_       : matches any one atom, including a parenthesized group
+	:name   : same as "_", and binds the atom to "name" in `Match.Binds`
+	...     : matches any number of atoms, as few as possible
+
+When the same ":name" occurs multiple times, all occurrences must match equal
+atoms. The name "_" in ":_" doesn't bind anything. A function call such as
+"f(x)" consists of two atoms, the name and the parens, and is matched by
+"_(...)" rather than by "_".
+
+Example:
+
+	pattern, err := sqlp.ParsePattern(`count(:arg)`)
+	if err != nil {
+		panic(err)
+	}
+	nodes, err := sqlp.Parse(`select count(id), count(*) from users`)
+	if err != nil {
+		panic(err)
+	}
+	for _, match := range pattern.Match(nodes) {
+		fmt.Println(match.Region, match.Binds[`arg`])
+	}
+	// [7 16] id
+	// [18 26] *
+*/
+type Pattern struct {
+	src   string
+	atoms []atom
+}
+
+/*
+Result of `Pattern.Match`. The region is the location of the matched nodes in
+the serialized AST, which is the same as in the source it was parsed from.
+`Binds` contains the atoms matched by named wildcards such as ":name", and is
+nil when there are none. Words are bound as `NodeText`.
+*/
+type Match struct {
+	Region Region
+	Binds  map[string]Node
+}
+
+// Parses a pattern. See `Pattern` for the syntax. Returns an error if the
+// source can't be parsed, or has no atoms.
+func ParsePattern(src string) (_ *Pattern, err error) {
+	defer rec(&err)
+
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	atoms := patternAtoms(toAtoms(nodes, 0))
+	if len(atoms) == 0 {
+		return nil, fmt.Errorf(`[sqlp] invalid pattern %q: expected at least one node`, src)
+	}
+	return &Pattern{src, atoms}, nil
+}
+
+// Implement `fmt.Stringer`, returning the source of the pattern.
+func (self *Pattern) String() string {
+	if self == nil {
+		return ``
+	}
+	return self.src
+}
+
+/*
+Finds all matches of the pattern at every nesting level of the AST, including
+inside matched nodes, sorted by position. Within one level, matches don't
+overlap, and are found from left to right.
+*/
+func (self *Pattern) Match(node Node) []Match {
+	if self == nil || node == nil {
+		return nil
+	}
+
+	var out []Match
+	self.matchLevels(node, 0, &out)
+	sort.SliceStable(out, func(one, two int) bool {
+		return out[one].Region[0] < out[two].Region[0]
+	})
+	return out
+}
+
+/*
+Returns a lint rule which reports every match of the pattern with the given
+code, message, and severity. See `Linter`.
+*/
+func (self *Pattern) Rule(code, msg string, severity Severity) Rule {
+	return RuleFunc(func(nodes Nodes, _ string) (out []Diagnostic) {
+		for _, match := range self.Match(nodes) {
+			out = append(out, Diagnostic{code, match.Region, msg, severity})
+		}
+		return
+	})
+}
+
+/*
+Parses the source, and replaces every match of the pattern with the text
+returned by the function, leaving the rest of the source unchanged. When
+matches are nested, only the outermost one is replaced. Useful for bulk
+rewrites, for example of a deprecated function call.
+*/
+func (self *Pattern) Replace(src string, fun func(Match) string) (string, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return ``, err
+	}
+
+	var buf strings.Builder
+	cursor := 0
+
+	for _, match := range self.Match(nodes) {
+		if match.Region[0] < cursor {
+			continue
+		}
+		buf.WriteString(src[cursor:match.Region[0]])
+		buf.WriteString(fun(match))
+		cursor = match.Region[1]
+	}
+
+	buf.WriteString(src[cursor:])
+	return buf.String(), nil
+}
+
+func (self *Pattern) matchLevels(node Node, offset int, out *[]Match) {
+	impl, _ := node.(Walker)
+	if impl == nil {
+		return
+	}
+
+	level := toAtoms(node, offset)
+
+	for start := 0; start < len(level); {
+		matcher := matcher{}
+		size, ok := matcher.match(self.atoms, level[start:], false)
+		if !ok || size == 0 {
+			start++
+			continue
+		}
+
+		region := Region{level[start].region[0], level[start+size-1].region[1]}
+		*out = append(*out, Match{region, matcher.bindsMap()})
+		start += size
+	}
+
+	cursor := offset + nodeInnerOffset(node)
+	impl.WalkNode(func(val Node) {
+		if val == nil {
+			return
+		}
+		self.matchLevels(val, cursor, out)
+		cursor += nodeLen(val)
+	})
+}
+
+type atomKind byte
+
+const (
+	atomNode atomKind = iota
+	atomWord
+	atomPunct
+	atomAny
+	atomBind
+	atomRest
+)
+
+/*
+Unit of comparison for `Pattern`: a word, operator, or punctuation character of
+a text node, a non-text leaf node, or a collection with its inner atoms.
+*/
+type atom struct {
+	kind   atomKind
+	text   string
+	node   Node
+	region Region
+	inner  []atom
+}
+
+/*
+Converts the inner nodes of the collection into atoms, skipping trivia. The
+offset is the position of the collection in the serialized AST.
+*/
+func toAtoms(node Node, offset int) (out []atom) {
+	impl, _ := node.(Walker)
+	if impl == nil {
+		return nil
+	}
+
+	cursor := offset + nodeInnerOffset(node)
+	impl.WalkNode(func(val Node) {
+		if val == nil {
+			return
+		}
+		size := nodeLen(val)
+		out = appendAtoms(out, val, cursor)
+		cursor += size
+	})
+	return
+}
+
+func appendAtoms(out []atom, node Node, offset int) []atom {
+	if isTrivia(node) {
+		return out
+	}
+
+	switch node := node.(type) {
+	case NodeText:
+		return appendTextAtoms(out, string(node), offset)
+	case NodeKeyword, NodeIdent:
+		text, _ := wordText(node)
+		return append(out, atom{kind: atomWord, text: text, node: node, region: Region{offset, offset + len(text)}})
+	case NodeSemicolon:
+		return append(out, atom{kind: atomPunct, text: node.String(), node: node, region: Region{offset, offset + 1}})
+	}
+
+	region := Region{offset, offset + nodeLen(node)}
+	if _, ok := node.(Walker); ok {
+		return append(out, atom{kind: atomNode, node: node, region: region, inner: toAtoms(node, offset)})
+	}
+	return append(out, atom{kind: atomNode, node: node, region: region})
+}
+
+// Splits text into words, operators, and punctuation. "..." is kept whole for
+// `Pattern`.
+func appendTextAtoms(out []atom, text string, offset int) []atom {
+	for pos := 0; pos < len(text); {
+		word := prefixWith(text[pos:], charsetIdentLike)
+		if word != `` {
+			out = append(out, atom{kind: atomWord, text: word, region: Region{offset + pos, offset + pos + len(word)}})
+			pos += len(word)
+			continue
+		}
+
+		size := maxInt(1, len(prefixWith(text[pos:], charsetOperator)))
+		if strings.HasPrefix(text[pos:], patternRest) {
+			size = len(patternRest)
+		}
+		out = append(out, atom{kind: atomPunct, text: text[pos : pos+size], region: Region{offset + pos, offset + pos + size}})
+		pos += size
+	}
+	return out
+}
+
+const patternRest = `...`
+
+// Characters of Postgres operators, which are also used by other dialects.
+var charsetOperator = new(charset).addStr(`+-*/<>=~!@#%^&|`)
+
+// Converts the atoms of a parsed pattern, replacing wildcards.
+func patternAtoms(atoms []atom) []atom {
+	for i := range atoms {
+		val := &atoms[i]
+
+		switch {
+		case val.kind == atomWord && val.text == `_` && val.node == nil:
+			val.kind = atomAny
+		case val.kind == atomPunct && val.text == patternRest:
+			val.kind = atomRest
+		case val.kind == atomNode:
+			if name, ok := val.node.(NodeNamedParam); ok {
+				if name == `_` {
+					val.kind = atomAny
+				} else {
+					val.kind = atomBind
+					val.text = string(name)
+				}
+			}
+			val.inner = patternAtoms(val.inner)
+		}
+	}
+	return atoms
+}
+
+// Matching state for `Pattern`. Bindings are kept in a slice, which allows to
+// undo them when backtracking.
+type matcher struct{ binds []atomBinding }
+
+type atomBinding struct {
+	name string
+	atom atom
+}
+
+/*
+Matches the pattern against a prefix of the atoms, returning the number of
+matched atoms. When `whole` is true, the pattern must match all atoms, which is
+used for the content of collections.
+*/
+func (self *matcher) match(pat []atom, src []atom, whole bool) (int, bool) {
+	if len(pat) == 0 {
+		return 0, !whole || len(src) == 0
+	}
+
+	head := pat[0]
+	if head.kind == atomRest {
+		for skip := 0; skip <= len(src); skip++ {
+			mark := len(self.binds)
+			size, ok := self.match(pat[1:], src[skip:], whole)
+			if ok {
+				return skip + size, true
+			}
+			self.binds = self.binds[:mark]
+		}
+		return 0, false
+	}
+
+	if len(src) == 0 {
+		return 0, false
+	}
+
+	mark := len(self.binds)
+	if !self.matchAtom(head, src[0]) {
+		self.binds = self.binds[:mark]
+		return 0, false
+	}
+
+	size, ok := self.match(pat[1:], src[1:], whole)
+	if !ok {
+		self.binds = self.binds[:mark]
+		return 0, false
+	}
+	return size + 1, true
+}
+
+func (self *matcher) matchAtom(pat, src atom) bool {
+	switch pat.kind {
+	case atomAny:
+		return true
+
+	case atomBind:
+		for _, val := range self.binds {
+			if val.name == pat.text {
+				return atomEqual(val.atom, src)
+			}
+		}
+		self.binds = append(self.binds, atomBinding{pat.text, src})
+		return true
+
+	case atomWord:
+		return src.kind == atomWord && strings.EqualFold(pat.text, src.text)
+
+	case atomPunct:
+		return src.kind == atomPunct && pat.text == src.text
+
+	default:
+		if src.kind != atomNode || !sameNodeKind(pat.node, src.node) {
+			return false
+		}
+		if pat.inner == nil && src.inner == nil {
+			return pat.node.String() == src.node.String()
+		}
+		_, ok := self.match(pat.inner, src.inner, true)
+		return ok
+	}
+}
+
+func (self *matcher) bindsMap() map[string]Node {
+	if len(self.binds) == 0 {
+		return nil
+	}
+
+	out := make(map[string]Node, len(self.binds))
+	for _, val := range self.binds {
+		out[val.name] = val.atom.boundNode()
+	}
+	return out
+}
+
+func (self atom) boundNode() Node {
+	if self.node != nil {
+		return self.node
+	}
+	return NodeText(self.text)
+}
+
+// Used for repeated named wildcards in `Pattern`.
+func atomEqual(one, two atom) bool {
+	if one.kind != two.kind {
+		return false
+	}
+	switch one.kind {
+	case atomWord:
+		return strings.EqualFold(one.text, two.text)
+	case atomPunct:
+		return one.text == two.text
+	default:
+		return sameNodeKind(one.node, two.node) && one.node.String() == two.node.String()
+	}
+}
+
+// True if the nodes have the same type, and for `DelimNodes`, the same
+// delimiters.
+func sameNodeKind(one, two Node) bool {
+	if reflect.TypeOf(one) != reflect.TypeOf(two) {
+		return false
+	}
+	if one, ok := one.(DelimNodes); ok {
+		return one.Delim == two.(DelimNodes).Delim
+	}
+	return true
+}
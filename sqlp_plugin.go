@@ -0,0 +1,208 @@
+package sqlp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Dialect plugin: a bundle of extensions which adds support for the syntax of a
+particular database, such as ClickHouse or BigQuery, without changes to this
+package. Plugins can be maintained out of tree, and registered globally via
+`RegisterPlugin`, which allows tools built on sqlp to select them by name.
+
+To use a plugin, assign it to `Tokenizer.Plugin`, or call `Plugin.Parse`.
+Plugins extend the tokenizer and the parser in the same way as
+`Tokenizer.Recognizers` and `Parser.Factory`, and apply after them, so
+application-specific extensions take priority over dialect-specific ones.
+
+This type is a struct rather than an interface so that new optional fields can
+be added without breaking existing plugins. All fields other than the name are
+optional. Plugins must not be modified after registration.
+*/
+type Plugin struct {
+	/*
+		Unique name of the plugin, such as "clickhouse". By convention, it's
+		lowercase. Required by `RegisterPlugin`.
+	*/
+	Name string
+
+	/*
+		Custom token recognizers, tried after `Tokenizer.Recognizers` and before
+		the built-in syntax. See `Recognizer`.
+	*/
+	Recognizers []Recognizer
+
+	/*
+		Converts tokens into nodes, tried after `Parser.Factory` and before the
+		default conversion via `Token.Node`. Required for custom token types
+		produced by the recognizers. See `NodeFactory`.
+	*/
+	Factory NodeFactory
+
+	/*
+		Renders nodes in the syntax of the dialect; used by `Plugin.Render`.
+		Allows to render built-in nodes differently, for example named
+		parameters as "@name" rather than ":name".
+	*/
+	Renderer NodeRenderer
+
+	/*
+		Reserved words of the dialect, in addition to common SQL keywords. Used
+		by `Plugin.IsKeyword`, and by the tokenizer when `Tokenizer.Keywords`
+		is set. Matched ignoring case.
+	*/
+	Keywords []string
+}
+
+/*
+Renders a node in the syntax of a particular dialect; see `Plugin.Renderer`.
+If the renderer handles the node, it must append its representation to the
+buffer and return true. Otherwise it must return the buffer as-is and false,
+in which case the node is rendered via `Node.AppendTo`, or, for collections
+such as `ParenNodes`, by rendering each child via the renderer.
+*/
+type NodeRenderer func([]byte, Node) ([]byte, bool)
+
+/*
+Parses the source with the plugin, like `Parse`. Shortcut for `Parser` with
+`Tokenizer.Plugin`.
+*/
+func (self *Plugin) Parse(src string) (Nodes, error) {
+	parser := Parser{Tokenizer: Tokenizer{Source: src, Plugin: self}}
+	return parser.Parse()
+}
+
+/*
+Renders the node in the syntax of the dialect via `Plugin.Renderer`, walking
+collections deeply. Without a renderer, the result is the same as
+`Node.String`.
+*/
+func (self *Plugin) Render(node Node) string {
+	return string(self.AppendTo(nil, node))
+}
+
+// Same as `Plugin.Render`, but appends to the given buffer.
+func (self *Plugin) AppendTo(buf []byte, node Node) []byte {
+	if node == nil {
+		return buf
+	}
+	if self == nil || self.Renderer == nil {
+		return node.AppendTo(buf)
+	}
+
+	if out, ok := self.Renderer(buf, node); ok {
+		return out
+	}
+
+	switch node := node.(type) {
+	case Nodes:
+		return self.appendNodes(buf, node)
+	case ParenNodes:
+		return append(self.appendNodes(append(buf, parenOpen), Nodes(node)), parenClose)
+	case BracketNodes:
+		return append(self.appendNodes(append(buf, bracketOpen), Nodes(node)), bracketClose)
+	case BraceNodes:
+		return append(self.appendNodes(append(buf, braceOpen), Nodes(node)), braceClose)
+	case DelimNodes:
+		buf = append(buf, node.Open...)
+		buf = self.appendNodes(buf, node.Inner)
+		return append(buf, node.Close...)
+	default:
+		return node.AppendTo(buf)
+	}
+}
+
+func (self *Plugin) appendNodes(buf []byte, nodes Nodes) []byte {
+	for _, node := range nodes {
+		buf = self.AppendTo(buf, node)
+	}
+	return buf
+}
+
+// True if the word is one of `Plugin.Keywords`, ignoring case.
+func (self *Plugin) IsKeyword(word string) bool {
+	if self == nil {
+		return false
+	}
+	for _, val := range self.Keywords {
+		if strings.EqualFold(val, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Plugin) recognized(tok *Tokenizer) Type {
+	for _, fun := range self.Recognizers {
+		if typ := tok.recognizedBy(fun); typ != TypeInvalid {
+			return typ
+		}
+	}
+	return TypeInvalid
+}
+
+var plugins = struct {
+	sync.RWMutex
+	byName map[string]*Plugin
+}{byName: map[string]*Plugin{}}
+
+/*
+Registers the plugin globally, making it available via `LookupPlugin`.
+Intended to be called from the "init" function of the package providing the
+plugin, like "database/sql.Register". Panics if the name is empty or already
+registered.
+*/
+func RegisterPlugin(val *Plugin) {
+	if val == nil || val.Name == `` {
+		panic(fmt.Errorf(`[sqlp] can't register plugin without name`))
+	}
+
+	plugins.Lock()
+	defer plugins.Unlock()
+
+	if plugins.byName[val.Name] != nil {
+		panic(fmt.Errorf(`[sqlp] redundant registration of plugin %q`, val.Name))
+	}
+	plugins.byName[val.Name] = val
+}
+
+// Returns the plugin registered under the given name via `RegisterPlugin`, or
+// nil.
+func LookupPlugin(name string) *Plugin {
+	plugins.RLock()
+	defer plugins.RUnlock()
+	return plugins.byName[name]
+}
+
+// Returns the names of all plugins registered via `RegisterPlugin`, sorted.
+func PluginNames() []string {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	out := make([]string, 0, len(plugins.byName))
+	for name := range plugins.byName {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+True if the byte may begin an unquoted identifier: an ASCII letter or "_".
+Intended for custom recognizers, which should treat identifiers in the same
+way as the built-in syntax.
+*/
+func IsIdentStart(char byte) bool { return charsetIdentStart.has(char) }
+
+/*
+True if the byte may continue an unquoted identifier: an ASCII letter, digit,
+or "_". Intended for custom recognizers; see `IsIdentStart`.
+*/
+func IsIdent(char byte) bool { return charsetIdent.has(char) }
+
+// True if the byte is whitespace recognized by the tokenizer. Intended for
+// custom recognizers.
+func IsWhitespace(char byte) bool { return charsetWhitespace.has(char) }
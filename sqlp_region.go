@@ -1,5 +1,7 @@
 package sqlp
 
+import "fmt"
+
 // Represents a region in source text. Part of `Token`. The regions generated by
 // this package are either all-zero, or have non-negative indexes corresponding
 // to valid positions in source text.
@@ -34,3 +36,42 @@ func (self Region) Slice(val string) string {
 
 	return val
 }
+
+/*
+Line and column in source text, both 1-based, for reporting locations of tokens
+and errors to users. The column is measured in bytes, like in "go/token", and
+a tab counts as one byte. Like in the tokenizer, lines end with LF, CRLF, or a
+lone CR. See `PositionAt` and `Tokenizer.Position`.
+*/
+type Position struct {
+	Line int
+	Col  int
+}
+
+// Formats the position as "line:col", which is understood by most editors.
+func (self Position) String() string { return fmt.Sprintf(`%v:%v`, self.Line, self.Col) }
+
+/*
+Returns the line and column of the given byte offset in the source text.
+Offsets outside the source are clamped to its bounds. Scans the source on every
+call; when computing many positions in the same source, use
+`Tokenizer.Position`, which builds a line index once.
+*/
+func PositionAt(src string, offset int) Position {
+	return positionAt(lineStarts(src), clampOffset(src, offset))
+}
+
+func positionAt(lines []int, offset int) Position {
+	line := lineAt(lines, offset)
+	return Position{Line: line + 1, Col: offset - lines[line] + 1}
+}
+
+func clampOffset(src string, offset int) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > len(src) {
+		return len(src)
+	}
+	return offset
+}
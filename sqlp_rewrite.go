@@ -0,0 +1,714 @@
+package sqlp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Adds the given condition to the top-level WHERE clause of the given statement,
+combining it with any existing condition via AND. If the statement has no
+top-level WHERE clause, inserts one before the first of GROUP BY, HAVING,
+WINDOW, ORDER BY, LIMIT, OFFSET, FETCH, FOR, RETURNING, or at the end of the
+statement, before any trailing whitespace, comments, and semicolons. The
+existing condition and the new condition are both wrapped in parens, which
+makes the result independent of operator precedence.
+
+Keywords are detected only at the top level of the AST, which means
+subqueries in parens are ignored. Compound statements such as UNION are not
+supported and cause an error. Doesn't modify the input.
+
+Example:
+
+	nodes, err := Parse(`select * from users where a or b order by id`)
+	panic(err)
+
+	nodes, err = AndWhere(nodes, Nodes{NodeText(`tenant_id`), NodeWhitespace(` `), NodeText(`=`), NodeWhitespace(` `), NodeOrdinalParam(1)})
+	panic(err)
+
+	// select * from users where (a or b) and (tenant_id = $1) order by id
+	fmt.Println(nodes)
+*/
+func AndWhere(nodes Nodes, cond Nodes) (Nodes, error) {
+	if len(cond) == 0 {
+		return nodes, nil
+	}
+
+	body, tail := splitTrailing(nodes)
+
+	index := indexKeyword(body, 0, compoundKeywords...)
+	if index >= 0 {
+		return nil, fmt.Errorf(`[sqlp] unsupported compound statement with %q`, body[index])
+	}
+
+	out := make(Nodes, 0, len(nodes)+8)
+	whereIndex := indexKeyword(body, 0, `where`)
+
+	if whereIndex < 0 {
+		index := indexKeyword(body, 0, whereSuccessorKeywords...)
+		if index < 0 {
+			out = append(out, body...)
+			out = append(out, nodeWhitespaceSingle, NodeText(`where`), nodeWhitespaceSingle, ParenNodes(cond))
+		} else {
+			out = append(out, body[:index]...)
+			out = append(out, NodeText(`where`), nodeWhitespaceSingle, ParenNodes(cond), nodeWhitespaceSingle)
+			out = append(out, body[index:]...)
+		}
+		return append(out, tail...), nil
+	}
+
+	endIndex := indexKeyword(body, whereIndex+1, whereSuccessorKeywords...)
+	if endIndex < 0 {
+		endIndex = len(body)
+	}
+
+	prev, lead, trail := trimWhitespace(body[whereIndex+1 : endIndex])
+	if len(prev) == 0 {
+		return nil, fmt.Errorf(`[sqlp] unexpected empty WHERE clause`)
+	}
+	if len(lead) == 0 {
+		lead = Nodes{nodeWhitespaceSingle}
+	}
+	if len(trail) == 0 && endIndex < len(body) {
+		trail = Nodes{nodeWhitespaceSingle}
+	}
+
+	out = append(out, body[:whereIndex+1]...)
+	out = append(out, lead...)
+	out = append(out, ParenNodes(append(Nodes(nil), prev...)), nodeWhitespaceSingle, NodeText(`and`), nodeWhitespaceSingle, ParenNodes(cond))
+	out = append(out, trail...)
+	out = append(out, body[endIndex:]...)
+	return append(out, tail...), nil
+}
+
+/*
+Wraps the given query into a query that counts its rows:
+
+	select count(*) from (<query>) as _
+
+If the query has a top-level ORDER BY clause which is the last clause, removes
+it, since it doesn't affect the count. If the ORDER BY is followed by other
+clauses such as LIMIT, the query is left as-is. Drops trailing whitespace,
+comments, and semicolons. Doesn't modify the input.
+*/
+func WrapCount(nodes Nodes) Nodes {
+	return Nodes{
+		NodeText(`select`), nodeWhitespaceSingle,
+		NodeText(`count`), ParenNodes{NodeText(`*`)}, nodeWhitespaceSingle,
+		NodeText(`from`), nodeWhitespaceSingle,
+		ParenNodes(wrappable(nodes)), nodeWhitespaceSingle,
+		NodeText(`as`), nodeWhitespaceSingle,
+		NodeText(`_`),
+	}
+}
+
+/*
+Wraps the given query into a query that checks if it has any rows:
+
+	select exists(<query>)
+
+Removes a trailing ORDER BY and drops trailing trivia, like `WrapCount`.
+Doesn't modify the input.
+*/
+func WrapExists(nodes Nodes) Nodes {
+	return Nodes{
+		NodeText(`select`), nodeWhitespaceSingle,
+		NodeText(`exists`), ParenNodes(wrappable(nodes)),
+	}
+}
+
+/*
+Adds or removes the trailing semicolon of the given statement. When `want` is
+true, ensures that the statement ends with a semicolon, inserting it directly
+after the last non-trivia node, so that it precedes any trailing whitespace and
+comments, including line comments which would otherwise swallow it. When `want`
+is false, removes all trailing semicolons, preserving trailing whitespace and
+comments. Nodes consisting only of trivia are returned as-is. Doesn't modify the
+input.
+*/
+func EnsureTrailingSemicolon(nodes Nodes, want bool) Nodes {
+	body, tail := splitTrailing(nodes)
+	if len(body) == 0 {
+		return append(Nodes(nil), nodes...)
+	}
+
+	out := make(Nodes, 0, len(nodes)+1)
+	out = append(out, body...)
+
+	if want {
+		for _, node := range tail {
+			if isSemicolons(node) {
+				return append(out, tail...)
+			}
+		}
+		out = append(out, NodeText(`;`))
+		return append(out, tail...)
+	}
+
+	for _, node := range tail {
+		if !isSemicolons(node) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+/*
+Hardens a user-supplied query, such as a query typed into a query console, by
+enforcing a maximum number of rows. Keeps only the first statement, dropping
+any following statements, trailing semicolons, and surrounding whitespace and
+comments. Returns an error if the statement is not a SELECT, or if the maximum
+is not positive.
+
+If the statement has a top-level LIMIT with a number larger than the maximum,
+or LIMIT ALL, the limit is replaced with the maximum. If it has no top-level
+LIMIT, one is added before OFFSET and FOR, or at the end. If the limit can't
+be verified, for example because it's a parameter or an expression, or
+because the statement uses FETCH, the statement is wrapped:
+
+	select * from (<query>) as _ limit <max>
+
+Doesn't modify the input. The result uses LIMIT, which is not supported in
+MSSQL and older versions of Oracle.
+*/
+func EnforceLimit(nodes Nodes, max int) (Nodes, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf(`[sqlp] invalid maximum limit %v: expected positive number`, max)
+	}
+
+	body, _ := splitTrailing(firstStatement(nodes))
+	for len(body) > 0 && isTrivia(body[0]) {
+		body = body[1:]
+	}
+
+	kind := ClassifyStmt(body)
+	if kind != StmtKindSelect {
+		return nil, fmt.Errorf(`[sqlp] expected select statement, got %v statement`, kind)
+	}
+
+	limit := NodeText(strconv.Itoa(max))
+	out := make(Nodes, 0, len(body)+4)
+
+	index := indexKeyword(body, 0, `limit`)
+	if index >= 0 {
+		valIndex := skipTrivia(body, index+1)
+		next := skipTrivia(body, valIndex+1)
+		if next < len(body) && !isKeyword(body[next], `offset`, `for`) {
+			return wrapLimit(body, limit), nil
+		}
+
+		val := nodeAt(body, valIndex)
+		out = append(out, body...)
+
+		switch {
+		case isKeyword(val, `all`):
+			out[valIndex] = limit
+		case isLimitNumber(val):
+			num, err := strconv.ParseUint(string(val.(NodeText)), 10, 64)
+			if err != nil || num > uint64(max) {
+				out[valIndex] = limit
+			}
+		default:
+			return wrapLimit(body, limit), nil
+		}
+		return out, nil
+	}
+
+	if indexKeyword(body, 0, `fetch`) >= 0 {
+		return wrapLimit(body, limit), nil
+	}
+
+	index = indexKeyword(body, 0, `offset`, `for`)
+	if index < 0 {
+		out = append(out, body...)
+		return append(out, nodeWhitespaceSingle, NodeText(`limit`), nodeWhitespaceSingle, limit), nil
+	}
+
+	out = append(out, body[:index]...)
+	out = append(out, NodeText(`limit`), nodeWhitespaceSingle, limit, nodeWhitespaceSingle)
+	return append(out, body[index:]...), nil
+}
+
+func wrapLimit(body Nodes, limit Node) Nodes {
+	return Nodes{
+		NodeText(`select`), nodeWhitespaceSingle,
+		NodeText(`*`), nodeWhitespaceSingle,
+		NodeText(`from`), nodeWhitespaceSingle,
+		ParenNodes(append(Nodes(nil), body...)), nodeWhitespaceSingle,
+		NodeText(`as`), nodeWhitespaceSingle,
+		NodeText(`_`), nodeWhitespaceSingle,
+		NodeText(`limit`), nodeWhitespaceSingle,
+		limit,
+	}
+}
+
+func isLimitNumber(node Node) bool {
+	text, ok := node.(NodeText)
+	return ok && text != `` && prefixDigits(string(text)) == string(text)
+}
+
+/*
+Returns the top-level nodes of the first statement, up to and excluding the
+first semicolon. Doesn't modify the input.
+*/
+func firstStatement(nodes Nodes) Nodes {
+	for i, node := range nodes {
+		if _, ok := node.(NodeSemicolon); ok {
+			return nodes[:i:i]
+		}
+
+		text, ok := node.(NodeText)
+		if !ok {
+			continue
+		}
+
+		index := strings.IndexByte(string(text), ';')
+		if index < 0 {
+			continue
+		}
+		if index == 0 {
+			return nodes[:i:i]
+		}
+		return append(nodes[:i:i], text[:index])
+	}
+	return nodes
+}
+
+func wrappable(nodes Nodes) Nodes {
+	body, _ := splitTrailing(nodes)
+	body, _, _ = trimWhitespace(body)
+
+	index := indexKeyword(body, 0, `order`)
+	if index >= 0 && indexKeyword(body, index+1, orderSuccessorKeywords...) < 0 {
+		body, _, _ = trimWhitespace(body[:index])
+		body, _ = splitTrailing(body)
+	}
+
+	return append(Nodes(nil), body...)
+}
+
+// Describes one element of an ORDER BY clause generated by `OrderBy`.
+type SortSpec struct {
+	Column    string
+	Desc      bool
+	NullsLast bool
+}
+
+/*
+Replaces the top-level ORDER BY clause of the given query, if any, with a
+clause generated from the given sort specs. If there are no specs, the
+existing clause is simply removed. The new clause is placed before any LIMIT,
+OFFSET, FETCH, or FOR clauses, or at the end of the statement, before any
+trailing whitespace, comments, and semicolons. Doesn't modify the input.
+
+Every column must be listed in `allowed`, otherwise this returns an error.
+Columns are quoted via `QuoteIdent`, making the result safe for user-supplied
+input. When `SortSpec.NullsLast` is false, the clause doesn't specify NULL
+ordering, leaving it to the database.
+
+Example:
+
+	nodes, err = OrderBy(nodes, []SortSpec{{Column: `name`, Desc: true}}, []string{`id`, `name`})
+	panic(err)
+
+	// select * from users order by "name" desc limit 10
+	fmt.Println(nodes)
+*/
+func OrderBy(nodes Nodes, specs []SortSpec, allowed []string) (Nodes, error) {
+	for _, spec := range specs {
+		if !hasString(allowed, spec.Column) {
+			return nil, fmt.Errorf(`[sqlp] column %q is not allowed in ORDER BY`, spec.Column)
+		}
+	}
+
+	body, tail := splitTrailing(nodes)
+	out := make(Nodes, 0, len(nodes)+len(specs)*6)
+
+	index := indexKeyword(body, 0, `order`)
+	if index >= 0 {
+		end := indexKeyword(body, index+1, orderSuccessorKeywords...)
+		if end < 0 {
+			end = len(body)
+		}
+		prefix, _, _ := trimWhitespace(body[:index])
+		_, _, suffix := trimWhitespace(body[index:end])
+		body = append(append(prefix[:len(prefix):len(prefix)], suffix...), body[end:]...)
+	}
+
+	clause := orderClause(specs)
+	index = indexKeyword(body, 0, orderSuccessorKeywords...)
+
+	if index < 0 {
+		out = append(out, body...)
+		if len(clause) > 0 {
+			out = append(out, nodeWhitespaceSingle)
+			out = append(out, clause...)
+		}
+	} else if len(clause) == 0 {
+		out = append(out, body...)
+	} else {
+		prefix, _, trail := trimWhitespace(body[:index])
+		if len(trail) == 0 {
+			trail = Nodes{nodeWhitespaceSingle}
+		}
+		out = append(out, prefix...)
+		out = append(out, nodeWhitespaceSingle)
+		out = append(out, clause...)
+		out = append(out, trail...)
+		out = append(out, body[index:]...)
+	}
+
+	return append(out, tail...), nil
+}
+
+func orderClause(specs []SortSpec) Nodes {
+	if len(specs) == 0 {
+		return nil
+	}
+
+	out := Nodes{NodeText(`order`), nodeWhitespaceSingle, NodeText(`by`), nodeWhitespaceSingle}
+	for i, spec := range specs {
+		if i > 0 {
+			out = append(out, NodeText(`,`), nodeWhitespaceSingle)
+		}
+		out = append(out, QuoteIdent(spec.Column))
+		if spec.Desc {
+			out = append(out, nodeWhitespaceSingle, NodeText(`desc`))
+		}
+		if spec.NullsLast {
+			out = append(out, nodeWhitespaceSingle, NodeText(`nulls`), nodeWhitespaceSingle, NodeText(`last`))
+		}
+	}
+	return out
+}
+
+/*
+Quotes an SQL identifier, escaping any double quotes inside it. Dot-separated
+identifiers such as "some_table.some_col" are treated as qualified names, and
+every part is quoted separately. Returns `NodeQuoteDouble` for unqualified
+names and `Nodes` for qualified names.
+*/
+func QuoteIdent(name string) Node {
+	if !strings.Contains(name, `.`) {
+		return quoteIdentPart(name)
+	}
+
+	var out Nodes
+	for i, part := range strings.Split(name, `.`) {
+		if i > 0 {
+			out = append(out, NodeText(`.`))
+		}
+		out = append(out, quoteIdentPart(part))
+	}
+	return out
+}
+
+func quoteIdentPart(name string) NodeQuoteDouble {
+	return NodeQuoteDouble(strings.ReplaceAll(name, `"`, `""`))
+}
+
+/*
+Escapes the LIKE wildcards `%` and `_`, and the escape character itself, by
+prefixing them with the escape character, so that the value matches literally
+when used as part of a LIKE pattern. The database must be told which escape
+character is used, via the ESCAPE clause; see `WithLikeEscape`. Postgres and
+MySQL default to backslash, while other databases have no default, and
+backslash is also special in MySQL string literals, so a character such as `!`
+is the most portable choice.
+
+Example:
+
+	arg := `%` + sqlp.EscapeLike(input, '!') + `%`
+*/
+func EscapeLike(value string, escapeChar byte) string {
+	var buf []byte
+
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		if char == '%' || char == '_' || char == escapeChar {
+			if buf == nil {
+				buf = make([]byte, 0, len(value)+4)
+				buf = append(buf, value[:i]...)
+			}
+			buf = append(buf, escapeChar)
+		}
+		if buf != nil {
+			buf = append(buf, char)
+		}
+	}
+
+	if buf == nil {
+		return value
+	}
+	return bytesToMutableString(buf)
+}
+
+/*
+Appends the clause "escape '<char>'" to every LIKE and ILIKE pattern which
+contains a parameter, at any nesting level, unless the pattern already has an
+ESCAPE clause. A pattern may be concatenated from several operands, such as
+"'%' || $1 || '%'", and the clause is appended after the last one. Patterns
+without parameters are left as-is, because they may already rely on the
+default escape character. Intended for use with arguments escaped via
+`EscapeLike`. Doesn't modify the input.
+
+Example:
+
+	select * from users where name like $1
+	->
+	select * from users where name like $1 escape '!'
+*/
+func WithLikeEscape(nodes Nodes, escapeChar byte) Nodes {
+	char := string(escapeChar)
+	if escapeChar == quoteSingle {
+		char += char
+	}
+
+	clause := Nodes{
+		nodeWhitespaceSingle, NodeText(`escape`),
+		nodeWhitespaceSingle, NodeQuoteSingle(char),
+	}
+	return withLikeEscape(nodes, clause)
+}
+
+func withLikeEscape(nodes Nodes, clause Nodes) Nodes {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		out = append(out, withLikeEscapeInner(nodes[i], clause))
+		if !isKeyword(nodes[i], `like`, `ilike`) {
+			continue
+		}
+
+		end := likePatternEnd(nodes, i+1)
+		if end < 0 {
+			continue
+		}
+
+		pattern := nodes[i+1 : end]
+		for _, node := range pattern {
+			out = append(out, withLikeEscapeInner(node, clause))
+		}
+		i = end - 1
+
+		next := skipTrivia(nodes, end)
+		if next < len(nodes) && isKeyword(nodes[next], `escape`) || !hasParam(pattern) {
+			continue
+		}
+		out = append(out, clause...)
+	}
+	return out
+}
+
+func withLikeEscapeInner(node Node, clause Nodes) Node {
+	switch node := node.(type) {
+	case Nodes:
+		return withLikeEscape(node, clause)
+	case ParenNodes:
+		return ParenNodes(withLikeEscape(Nodes(node), clause))
+	case BracketNodes:
+		return BracketNodes(withLikeEscape(Nodes(node), clause))
+	case BraceNodes:
+		return BraceNodes(withLikeEscape(Nodes(node), clause))
+	case DelimNodes:
+		node.Inner = withLikeEscape(node.Inner, clause)
+		return node
+	default:
+		return CopyNode(node)
+	}
+}
+
+/*
+Returns the index after the last operand of the LIKE pattern which begins at or
+after the given index, following "||" concatenations, or -1 if there's no
+pattern.
+*/
+func likePatternEnd(nodes Nodes, start int) int {
+	index := skipTrivia(nodes, start)
+	if index >= len(nodes) {
+		return -1
+	}
+
+	for {
+		end := operandEnd(nodes, index)
+		next := skipTrivia(nodes, end)
+		if next >= len(nodes) || !isKeyword(nodes[next], `||`) {
+			return end
+		}
+
+		operand := skipTrivia(nodes, next+1)
+		if operand >= len(nodes) {
+			return next + 1
+		}
+		index = operand
+	}
+}
+
+/*
+Returns the index after the operand which begins at the given index, including
+adjacent parens and brackets, as in function calls and subscripts, and
+adjacent casts such as "::text".
+*/
+func operandEnd(nodes Nodes, index int) int {
+	index++
+	for index < len(nodes) {
+		switch nodes[index].(type) {
+		case ParenNodes, BracketNodes:
+			index++
+		case NodeDoubleColon:
+			index++
+			if index < len(nodes) && !isTrivia(nodes[index]) {
+				index++
+			}
+		default:
+			return index
+		}
+	}
+	return index
+}
+
+// Index of the first non-trivia node at or after `start`, or `len(nodes)`.
+func skipTrivia(nodes Nodes, start int) int {
+	for start < len(nodes) && isTrivia(nodes[start]) {
+		start++
+	}
+	return start
+}
+
+// True if the nodes contain a parameter at any nesting level.
+func hasParam(nodes Nodes) (found bool) {
+	DeepWalkNode(nodes, func(node Node) {
+		switch node.(type) {
+		case NodeOrdinalParam, NodeNumericParam, NodeQuestionParam, NodeNamedParam, NodeAtParam, NodeDollarParam:
+			found = true
+		}
+	})
+	return
+}
+
+var (
+	orderSuccessorKeywords = []string{`limit`, `offset`, `fetch`, `for`}
+
+	compoundKeywords = []string{`union`, `intersect`, `except`}
+
+	whereSuccessorKeywords = []string{
+		`group`, `having`, `window`, `order`, `limit`, `offset`, `fetch`, `for`,
+		`returning`, `union`, `intersect`, `except`,
+	}
+)
+
+// True if the node is a text node equal to one of the given keywords,
+// ignoring case.
+func isKeyword(node Node, keywords ...string) bool {
+	text, ok := wordText(node)
+	if !ok {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text of a node which may be a word: `NodeText`, `NodeKeyword`, or
+// `NodeIdent`.
+func wordText(node Node) (string, bool) {
+	switch node := node.(type) {
+	case NodeText:
+		return string(node), true
+	case NodeKeyword:
+		return string(node), true
+	case NodeIdent:
+		return string(node), true
+	default:
+		return ``, false
+	}
+}
+
+// Index of the first top-level node at or after `start` which is one of the
+// given keywords, or -1.
+func indexKeyword(nodes Nodes, start int, keywords ...string) int {
+	for i := start; i < len(nodes); i++ {
+		if isKeyword(nodes[i], keywords...) {
+			return i
+		}
+	}
+	return -1
+}
+
+// True for nodes that don't affect the meaning of the surrounding SQL.
+func isTrivia(node Node) bool {
+	switch node.(type) {
+	case nil, NodeWhitespace, NodeCommentLine, NodeCommentBlock, NodeCommentHash:
+		return true
+	default:
+		return false
+	}
+}
+
+// True for `NodeSemicolon` and text nodes consisting entirely of semicolons.
+func isSemicolons(node Node) bool {
+	if _, ok := node.(NodeSemicolon); ok {
+		return true
+	}
+	text, ok := node.(NodeText)
+	return ok && len(text) > 0 && strings.Trim(string(text), `;`) == ``
+}
+
+/*
+Splits the nodes into the statement body and the trailing trivia and
+semicolons. A text node ending with semicolons is split into the body part and
+the semicolon part. Doesn't modify the input.
+*/
+func splitTrailing(nodes Nodes) (Nodes, Nodes) {
+	index := len(nodes)
+	for index > 0 && (isTrivia(nodes[index-1]) || isSemicolons(nodes[index-1])) {
+		index--
+	}
+
+	body, tail := nodes[:index:index], nodes[index:]
+	if index == 0 {
+		return body, tail
+	}
+
+	text, ok := body[index-1].(NodeText)
+	if !ok {
+		return body, tail
+	}
+
+	trimmed := strings.TrimRight(string(text), `;`)
+	if len(trimmed) == len(text) {
+		return body, tail
+	}
+
+	body = append(body[:index-1:index-1], NodeText(trimmed))
+	tail = append(Nodes{NodeText(text[len(trimmed):])}, tail...)
+	return body, tail
+}
+
+// Splits the nodes into the middle part, the leading whitespace, and the
+// trailing whitespace.
+func trimWhitespace(nodes Nodes) (mid, lead, trail Nodes) {
+	start := 0
+	for start < len(nodes) && isWhitespace(nodes[start]) {
+		start++
+	}
+
+	end := len(nodes)
+	for end > start && isWhitespace(nodes[end-1]) {
+		end--
+	}
+
+	return nodes[start:end], nodes[:start], nodes[end:]
+}
+
+func isWhitespace(node Node) bool {
+	_, ok := node.(NodeWhitespace)
+	return ok
+}
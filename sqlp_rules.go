@@ -0,0 +1,294 @@
+package sqlp
+
+import "strings"
+
+// Diagnostic codes used by the starter rules. See `StarterRules`.
+const (
+	CodeSelectStar  = `E_SELECT_STAR`
+	CodeNoWhere     = `E_NO_WHERE`
+	CodeCrossJoin   = `E_CROSS_JOIN`
+	CodeNaturalJoin = `E_NATURAL_JOIN`
+	CodeCastUnknown = `E_CAST_UNKNOWN`
+)
+
+/*
+Returns a new slice of the starter rules, for use with `Linter`:
+
+	RuleSelectStar  : E_SELECT_STAR, warning
+	RuleNoWhere     : E_NO_WHERE, error
+	RuleCrossJoin   : E_CROSS_JOIN, warning
+	RuleNaturalJoin : E_NATURAL_JOIN, warning
+	RuleCastUnknown : E_CAST_UNKNOWN, warning
+
+The rules only rely on keywords and nesting, and don't fully understand SQL
+grammar. They inspect every nesting level separately, including subqueries in
+parens. Severities can be adjusted via `SeverityConfig`.
+*/
+func StarterRules() []Rule {
+	return []Rule{
+		RuleSelectStar,
+		RuleNoWhere,
+		RuleCrossJoin,
+		RuleNaturalJoin,
+		RuleCastUnknown,
+	}
+}
+
+/*
+Reports "SELECT *" and "SELECT tbl.*" in select lists. Selecting all columns
+makes queries fragile to schema changes. Stars in function calls such as
+"count(*)" are ignored.
+*/
+var RuleSelectStar Rule = RuleFunc(checkSelectStar)
+
+/*
+Reports DELETE and UPDATE statements without a WHERE clause, which affect all
+rows of the table. Also applies to DELETE and UPDATE after WITH.
+*/
+var RuleNoWhere Rule = RuleFunc(checkNoWhere)
+
+/*
+Reports joins which may produce a cartesian product: any JOIN without ON or
+USING, and CROSS JOIN in a statement without a WHERE clause.
+*/
+var RuleCrossJoin Rule = RuleFunc(checkCrossJoin)
+
+/*
+Reports NATURAL JOIN, which joins on all columns with matching names, and
+silently changes meaning when columns are added.
+*/
+var RuleNaturalJoin Rule = RuleFunc(checkNaturalJoin)
+
+/*
+Reports "::unknown" casts, which hide type errors by deferring type resolution,
+and are almost always a mistake or a workaround.
+*/
+var RuleCastUnknown Rule = RuleFunc(checkCastUnknown)
+
+func checkSelectStar(nodes Nodes, _ string) (out []Diagnostic) {
+	walkLevels(nodes, func(level []regionNode) {
+		selecting := false
+		var prev Node
+
+		for i, val := range level {
+			switch {
+			case isKeyword(val.node, `select`):
+				selecting = true
+			case isKeyword(val.node, `from`) || isSemicolonEnd(val.node):
+				selecting = false
+			case selecting && isStar(val.node, prev):
+				text := string(val.node.(NodeText))
+				region := Region{val.region[0], val.region[1] - len(text) + len(strings.TrimRight(text, `,;`))}
+
+				// Qualifier in a separate node, such as `"one".*`, or "one.*"
+				// with `Tokenizer.Idents`.
+				if strings.HasPrefix(text, `.`) && i > 0 && level[i-1].region[1] == region[0] {
+					region[0] = level[i-1].region[0]
+				}
+				out = append(out, Diagnostic{CodeSelectStar, region, `avoid "select *"; list the columns explicitly`, SeverityWarning})
+			}
+			prev = val.node
+		}
+	})
+	return
+}
+
+func checkNoWhere(nodes Nodes, _ string) (out []Diagnostic) {
+	walkStatements(nodes, func(stmt []regionNode) {
+		index := indexStatementVerb(stmt)
+		if index < 0 || !isKeyword(stmt[index].node, `delete`, `update`) {
+			return
+		}
+		if indexLevelKeyword(stmt, index+1, `where`) >= 0 {
+			return
+		}
+		verb, _ := wordText(stmt[index].node)
+		verb = strings.ToUpper(verb)
+		out = append(out, Diagnostic{CodeNoWhere, stmt[index].region, verb + ` without WHERE affects all rows`, SeverityError})
+	})
+	return
+}
+
+func checkCrossJoin(nodes Nodes, _ string) (out []Diagnostic) {
+	walkStatements(nodes, func(stmt []regionNode) {
+		hasWhere := indexLevelKeyword(stmt, 0, `where`) >= 0
+
+		for i, val := range stmt {
+			if !isKeyword(val.node, `join`) {
+				continue
+			}
+
+			var prev Node
+			if i > 0 {
+				prev = stmt[i-1].node
+			}
+
+			switch {
+			case isKeyword(prev, `natural`):
+			case isKeyword(prev, `cross`):
+				if !hasWhere {
+					out = append(out, Diagnostic{CodeCrossJoin, Region{stmt[i-1].region[0], val.region[1]}, `CROSS JOIN without WHERE produces a cartesian product`, SeverityWarning})
+				}
+			case !hasJoinCondition(stmt[i+1:]):
+				out = append(out, Diagnostic{CodeCrossJoin, val.region, `JOIN without ON or USING produces a cartesian product`, SeverityWarning})
+			}
+		}
+	})
+	return
+}
+
+func checkNaturalJoin(nodes Nodes, _ string) (out []Diagnostic) {
+	walkLevels(nodes, func(level []regionNode) {
+		for _, val := range level {
+			if isKeyword(val.node, `natural`) {
+				out = append(out, Diagnostic{CodeNaturalJoin, val.region, `avoid NATURAL JOIN; specify the join condition explicitly`, SeverityWarning})
+			}
+		}
+	})
+	return
+}
+
+func checkCastUnknown(nodes Nodes, _ string) (out []Diagnostic) {
+	walkLevels(nodes, func(level []regionNode) {
+		for i, val := range level {
+			if _, ok := val.node.(NodeDoubleColon); !ok || i+1 >= len(level) {
+				continue
+			}
+
+			next := level[i+1]
+			text, ok := wordText(next.node)
+			if ok && next.region[0] == val.region[1] && strings.EqualFold(prefixIdent(text), `unknown`) {
+				out = append(out, Diagnostic{CodeCastUnknown, Region{val.region[0], next.region[0] + len(`unknown`)}, `"::unknown" casts hide type errors`, SeverityWarning})
+			}
+		}
+	})
+	return
+}
+
+// Node with its region in the source. Used by lint rules.
+type regionNode struct {
+	node   Node
+	region Region
+}
+
+/*
+Invokes the function for every nesting level of the AST, starting with the
+innermost levels. Each level contains the inner nodes of one collection,
+excluding whitespace and comments.
+*/
+func walkLevels(node Node, fun func([]regionNode)) {
+	walkLevelsAt(node, 0, fun)
+}
+
+func walkLevelsAt(node Node, offset int, fun func([]regionNode)) {
+	impl, _ := node.(Walker)
+	if impl == nil {
+		return
+	}
+
+	var level []regionNode
+	cursor := offset + nodeInnerOffset(node)
+
+	impl.WalkNode(func(val Node) {
+		if val == nil {
+			return
+		}
+		size := nodeLen(val)
+		if !isTrivia(val) {
+			level = append(level, regionNode{val, Region{cursor, cursor + size}})
+		}
+		walkLevelsAt(val, cursor, fun)
+		cursor += size
+	})
+
+	fun(level)
+}
+
+/*
+Similar to `walkLevels`, but further splits each level into statements
+separated by semicolons. A text node containing a semicolon is included in the
+statement which it ends.
+*/
+func walkStatements(node Node, fun func([]regionNode)) {
+	walkLevels(node, func(level []regionNode) {
+		for _, stmt := range splitStatements(level) {
+			fun(stmt)
+		}
+	})
+}
+
+// Index of the main verb of the statement, skipping any WITH clause, or -1.
+func indexStatementVerb(stmt []regionNode) int {
+	if len(stmt) == 0 {
+		return -1
+	}
+	if !isKeyword(stmt[0].node, `with`) {
+		return 0
+	}
+	return indexLevelKeyword(stmt, 1, statementVerbs...)
+}
+
+func indexLevelKeyword(level []regionNode, start int, keywords ...string) int {
+	for i := start; i < len(level); i++ {
+		if isKeyword(level[i].node, keywords...) {
+			return i
+		}
+	}
+	return -1
+}
+
+// True if the nodes following a JOIN have ON or USING before the next clause.
+func hasJoinCondition(level []regionNode) bool {
+	for _, val := range level {
+		switch {
+		case isKeyword(val.node, `on`, `using`):
+			return true
+		case isKeyword(val.node, joinSuccessorKeywords...) || isSemicolonEnd(val.node):
+			return false
+		}
+	}
+	return false
+}
+
+/*
+True if the node is a star in a select list, rather than multiplication. A bare
+star must begin a select list item, following SELECT, DISTINCT, ALL, or a comma.
+*/
+func isStar(node, prev Node) bool {
+	text, ok := node.(NodeText)
+	if !ok {
+		return false
+	}
+
+	str := strings.TrimRight(string(text), `,;`)
+	if strings.HasSuffix(str, `.*`) {
+		return true
+	}
+	if str != `*` {
+		return false
+	}
+
+	if isKeyword(prev, `select`, `distinct`, `all`) {
+		return true
+	}
+	prevText, _ := prev.(NodeText)
+	return strings.HasSuffix(string(prevText), `,`)
+}
+
+func isSemicolonEnd(node Node) bool {
+	if _, ok := node.(NodeSemicolon); ok {
+		return true
+	}
+	text, ok := node.(NodeText)
+	return ok && strings.Contains(string(text), `;`)
+}
+
+var (
+	statementVerbs = []string{`select`, `insert`, `update`, `delete`, `merge`}
+
+	joinSuccessorKeywords = []string{
+		`join`, `inner`, `left`, `right`, `full`, `cross`, `natural`,
+		`where`, `group`, `having`, `window`, `order`, `limit`, `offset`,
+		`fetch`, `for`, `returning`, `union`, `intersect`, `except`,
+	}
+)
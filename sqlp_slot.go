@@ -0,0 +1,302 @@
+package sqlp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const slotKeyword = `slot`
+
+/*
+Fills named slots in a base query with the given contents, which allows to
+maintain families of similar queries that differ only in some parts, such as
+the select list or the filter block. A slot is a brace node beginning with the
+keyword "slot", followed by the name, and optionally by a colon and the default
+content:
+
+	select {slot columns: *} from users where true {slot filter}
+
+Each slot is replaced with the content provided under its name, or with its
+default content, which may itself contain slots. A slot may occur more than
+once, and slots may be nested in parens and other collections. Returns an
+error if a slot has neither content nor a default, or if the contents include
+names which don't match any slot, which usually indicates a typo; the error
+lists all such names, sorted. To leave an optional slot empty, give it an
+empty default, as in "{slot filter:}", or provide empty content. Doesn't modify
+the input.
+
+Example:
+
+	base, err := sqlp.Parse(`select {slot columns: *} from users {slot filter:}`)
+	...
+	nodes, err := sqlp.FillSlots(base, map[string]sqlp.Nodes{
+		`columns`: {sqlp.NodeText(`id`)},
+	})
+	// select id from users
+*/
+func FillSlots(base Nodes, contents map[string]Nodes) (_ Nodes, err error) {
+	defer rec(&err)
+
+	filler := slotFiller{contents: contents, used: map[string]bool{}}
+	out := filler.nodes(base)
+
+	var unknown []string
+	for name := range contents {
+		if !filler.used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf(`[sqlp] unknown slots %q`, unknown)
+	}
+	return out, nil
+}
+
+/*
+Like `FillSlots`, but also renames table aliases declared in the contents which
+collide with aliases declared in the base, or in other contents, as reported
+by `Aliases`. Intended for splicing subqueries written independently of the
+base, such as "exists (select 1 from users u where ...)" into a query which
+also uses "u". Contents are processed in order of slot names, and each
+colliding alias is renamed via `RenameAlias` by appending the first free
+numeric suffix, as in "u_2". Returns the renames, in the same order, so that
+callers can report or log them. References in the contents to aliases of the
+base, as in correlated subqueries, are not affected, as long as the contents
+don't declare the same aliases.
+
+Example:
+
+	base, err := sqlp.Parse(`select * from users u where {slot filter}`)
+	...
+	filter, err := sqlp.Parse(`exists (select 1 from users u where u.id = $1)`)
+	...
+	nodes, renames, err := sqlp.FillSlotsRenaming(base, map[string]sqlp.Nodes{`filter`: filter})
+	// select * from users u where exists (select 1 from users u_2 where u_2.id = $1)
+	// []AliasRename{{Slot: "filter", From: "u", To: "u_2"}}
+*/
+func FillSlotsRenaming(base Nodes, contents map[string]Nodes) (_ Nodes, _ []AliasRename, err error) {
+	defer rec(&err)
+
+	empty := slotFiller{contents: map[string]Nodes{}, used: map[string]bool{}}
+	slots, err := Slots(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range slots {
+		empty.contents[name] = Nodes{}
+	}
+
+	taken, err := Aliases(empty.nodes(base))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var renames []AliasRename
+	renamed := make(map[string]Nodes, len(contents))
+
+	for _, name := range names {
+		content := contents[name]
+		aliases, err := Aliases(content)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, alias := range aliases {
+			if !hasString(taken, alias) {
+				continue
+			}
+
+			to := freeAlias(alias, taken, aliases)
+			content, err = RenameAlias(content, alias, to)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			aliases[i] = to
+			renames = append(renames, AliasRename{Slot: name, From: alias, To: to})
+		}
+
+		taken = append(taken, aliases...)
+		renamed[name] = content
+	}
+
+	out, err := FillSlots(base, renamed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, renames, nil
+}
+
+/*
+Returns the names of all slots in the given AST, in order of first occurrence,
+including slots nested in default contents. See `FillSlots`.
+*/
+func Slots(nodes Nodes) (_ []string, err error) {
+	defer rec(&err)
+
+	var out []string
+	var walk func(Node)
+	walk = func(node Node) {
+		name, def, ok := parseSlot(node)
+		if ok {
+			if !hasString(out, name) {
+				out = append(out, name)
+			}
+			def.WalkNode(walk)
+			return
+		}
+		if impl, _ := node.(Walker); impl != nil {
+			impl.WalkNode(walk)
+		}
+	}
+
+	nodes.WalkNode(walk)
+	return out, nil
+}
+
+type slotFiller struct {
+	contents map[string]Nodes
+	used     map[string]bool
+}
+
+func (self *slotFiller) nodes(nodes Nodes) Nodes {
+	if nodes == nil {
+		return nil
+	}
+
+	out := make(Nodes, 0, len(nodes))
+	for _, node := range nodes {
+		name, def, ok := parseSlot(node)
+		if !ok {
+			out = append(out, self.node(node))
+			continue
+		}
+
+		content, ok := self.contents[name]
+		if ok {
+			self.used[name] = true
+			out = append(out, content.CopyNodes()...)
+			continue
+		}
+		if def == nil {
+			panic(fmt.Errorf(`[sqlp] missing content for slot %q without default`, name))
+		}
+		out = append(out, self.nodes(def)...)
+	}
+	return out
+}
+
+func (self *slotFiller) node(node Node) Node {
+	switch node := node.(type) {
+	case Nodes:
+		return self.nodes(node)
+	case ParenNodes:
+		return ParenNodes(self.nodes(Nodes(node)))
+	case BracketNodes:
+		return BracketNodes(self.nodes(Nodes(node)))
+	case BraceNodes:
+		return BraceNodes(self.nodes(Nodes(node)))
+	case DelimNodes:
+		node.Inner = self.nodes(node.Inner)
+		return node
+	default:
+		return CopyNode(node)
+	}
+}
+
+/*
+If the node is a slot, returns its name and default content. The default is
+nil if absent, and non-nil but possibly empty if present. Panics if the node
+begins with the slot keyword but is malformed.
+*/
+func parseSlot(node Node) (string, Nodes, bool) {
+	name, rest, ok := parseBraceDirective(node, slotKeyword)
+	if !ok {
+		return ``, nil, false
+	}
+
+	if skipTrivia(rest, 0) >= len(rest) {
+		return name, nil, true
+	}
+
+	def, ok := afterColon(rest)
+	if !ok {
+		panic(fmt.Errorf(`[sqlp] invalid slot %q: expected ":" or "}" after slot name`, node))
+	}
+	return name, def, true
+}
+
+/*
+If the node is a brace node beginning with the given keyword, returns the name
+which follows the keyword, and the remaining nodes. A colon attached to the
+name, as in "{slot name:text}", is split into the remaining nodes. Panics if
+the name is missing or invalid.
+*/
+func parseBraceDirective(node Node, keyword string) (string, Nodes, bool) {
+	brace, ok := node.(BraceNodes)
+	if !ok {
+		return ``, nil, false
+	}
+
+	nodes := Nodes(brace)
+	index := skipTrivia(nodes, 0)
+	if index >= len(nodes) || !isKeyword(nodes[index], keyword) {
+		return ``, nil, false
+	}
+
+	index = skipTrivia(nodes, index+1)
+	text, _ := nodeAt(nodes, index).(NodeText)
+	name := string(text)
+	var rest Nodes
+
+	colon := strings.IndexByte(name, ':')
+	if colon >= 0 {
+		rest = append(rest, NodeText(name[colon:]))
+		name = name[:colon]
+	}
+	if name == `` || prefixIdent(name) != name {
+		panic(fmt.Errorf(`[sqlp] invalid %v %q: expected %v name`, keyword, node, keyword))
+	}
+	rest = append(rest, nodes[index+1:]...)
+
+	// In "{slot name:text}", the tokenizer sees a named parameter ":text".
+	if param, ok := nodeAt(rest, 0).(NodeNamedParam); ok {
+		rest[0] = NodeText(`:` + string(param))
+	}
+	return name, rest, true
+}
+
+/*
+If the first non-trivia node begins with a colon, returns the nodes after the
+colon, without surrounding whitespace. The result is non-nil.
+*/
+func afterColon(nodes Nodes) (Nodes, bool) {
+	index := skipTrivia(nodes, 0)
+	text, _ := nodeAt(nodes, index).(NodeText)
+	if !strings.HasPrefix(string(text), `:`) {
+		return nil, false
+	}
+
+	out := Nodes{}
+	if len(text) > 1 {
+		out = append(out, text[1:])
+	}
+	out = append(out, nodes[index+1:]...)
+	out, _, _ = trimWhitespace(out)
+	return append(Nodes{}, out...), true
+}
+
+func nodeAt(nodes Nodes, index int) Node {
+	if index >= 0 && index < len(nodes) {
+		return nodes[index]
+	}
+	return nil
+}
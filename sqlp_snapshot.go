@@ -0,0 +1,279 @@
+package sqlp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+Writes a canonical, line-oriented representation of the AST, suitable for
+golden files and snapshot tests of rewrites, where diffs between versions
+should be easy to read. The output can be decoded via `ReadSnapshot`. Each
+node occupies one line, indented with tabs by nesting depth. Atomic nodes are
+written as the name of the corresponding token type, as in `Type.String`,
+followed by the Go-quoted text of the node. Collections are written as
+"nodes", "paren", "bracket", "brace", or "delim" followed by the quoted tag,
+opening and closing delimiters, with their inner nodes on the following
+lines. Nil nodes are skipped. Returns an error for node types other than those
+defined in this package, and any error from the writer.
+
+Example output for `select (:id)`:
+
+	text "select"
+	whitespace " "
+	paren
+		named_param ":id"
+*/
+func WriteSnapshot(out io.Writer, nodes Nodes) (err error) {
+	defer rec(&err)
+	_, err = out.Write(appendSnapshot(nil, nodes, 0))
+	return
+}
+
+/*
+Decodes the output of `WriteSnapshot`. The result is equal to the original
+AST, except that empty collections are nil. Empty lines are ignored, and
+line endings may be "\r\n". Returns an error for malformed input, including
+unknown node names and text which doesn't match the node type.
+*/
+func ReadSnapshot(src io.Reader) (_ Nodes, err error) {
+	defer rec(&err)
+
+	body, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := snapshotReader{lines: strings.Split(string(body), "\n")}
+	return reader.nodes(0), nil
+}
+
+func appendSnapshot(buf []byte, nodes Nodes, depth int) []byte {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+
+		for i := 0; i < depth; i++ {
+			buf = append(buf, '\t')
+		}
+
+		switch node := node.(type) {
+		case Nodes:
+			buf = append(buf, `nodes`...)
+			buf = appendSnapshot(append(buf, '\n'), node, depth+1)
+		case ParenNodes:
+			buf = append(buf, `paren`...)
+			buf = appendSnapshot(append(buf, '\n'), Nodes(node), depth+1)
+		case BracketNodes:
+			buf = append(buf, `bracket`...)
+			buf = appendSnapshot(append(buf, '\n'), Nodes(node), depth+1)
+		case BraceNodes:
+			buf = append(buf, `brace`...)
+			buf = appendSnapshot(append(buf, '\n'), Nodes(node), depth+1)
+		case DelimNodes:
+			buf = append(buf, `delim `...)
+			buf = strconv.AppendQuote(buf, node.Tag)
+			buf = append(buf, ' ')
+			buf = strconv.AppendQuote(buf, node.Open)
+			buf = append(buf, ' ')
+			buf = strconv.AppendQuote(buf, node.Close)
+			buf = appendSnapshot(append(buf, '\n'), node.Inner, depth+1)
+		default:
+			buf = append(buf, snapshotType(node).String()...)
+			buf = append(buf, ' ')
+			buf = strconv.AppendQuote(buf, node.String())
+			buf = append(buf, '\n')
+		}
+	}
+	return buf
+}
+
+// Token type corresponding to an atomic node, for `WriteSnapshot`.
+func snapshotType(node Node) Type {
+	switch node.(type) {
+	case NodeText:
+		return TypeText
+	case NodeWhitespace:
+		return TypeWhitespace
+	case NodeKeyword:
+		return TypeKeyword
+	case NodeIdent:
+		return TypeIdent
+	case NodeSemicolon:
+		return TypeSemicolon
+	case NodeQuoteSingle:
+		return TypeQuoteSingle
+	case NodeQuoteDouble:
+		return TypeQuoteDouble
+	case NodeQuoteGrave:
+		return TypeQuoteGrave
+	case NodeQuoteDollar:
+		return TypeQuoteDollar
+	case NodeQuoteSingleTriple:
+		return TypeQuoteSingleTriple
+	case NodeQuoteDoubleTriple:
+		return TypeQuoteDoubleTriple
+	case NodeQuoteNational:
+		return TypeQuoteNational
+	case NodeQuoteEscape:
+		return TypeQuoteEscape
+	case NodeCommentLine:
+		return TypeCommentLine
+	case NodeCommentBlock:
+		return TypeCommentBlock
+	case NodeCommentHash:
+		return TypeCommentHash
+	case NodeDoubleColon:
+		return TypeDoubleColon
+	case NodeOrdinalParam:
+		return TypeOrdinalParam
+	case NodeNumericParam:
+		return TypeNumericParam
+	case NodeNamedParam:
+		return TypeNamedParam
+	case NodeAtParam:
+		return TypeAtParam
+	case NodeDollarParam:
+		return TypeDollarParam
+	case NodeQuestionParam:
+		return TypeQuestionParam
+	case NodeSystemVariable:
+		return TypeSystemVariable
+	case NodeDirective:
+		return TypeDirective
+	case NodeBatchSeparator:
+		return TypeBatchSeparator
+	case NodeTemplateAction:
+		return TypeTemplateAction
+	case NodeTemplateStatement:
+		return TypeTemplateStatement
+	case NodeTemplateComment:
+		return TypeTemplateComment
+	default:
+		panic(fmt.Errorf(`[sqlp] unsupported node type %T in snapshot`, node))
+	}
+}
+
+type snapshotReader struct {
+	lines []string
+	index int
+	line  int
+}
+
+// Reads consecutive nodes at the given depth, with their inner nodes.
+func (self *snapshotReader) nodes(depth int) Nodes {
+	var out Nodes
+
+	for self.skipEmpty() {
+		self.line = self.index + 1
+		line := strings.TrimSuffix(self.lines[self.index], "\r")
+		rest := strings.TrimLeft(line, "\t")
+		indent := len(line) - len(rest)
+
+		if indent < depth {
+			break
+		}
+		if indent > depth {
+			panic(self.errorf(`unexpected indentation`))
+		}
+
+		name, args := self.parse(rest)
+		self.index++
+
+		switch name {
+		case `nodes`:
+			self.args(args, 0)
+			out = append(out, self.nodes(depth+1))
+		case `paren`:
+			self.args(args, 0)
+			out = append(out, ParenNodes(self.nodes(depth+1)))
+		case `bracket`:
+			self.args(args, 0)
+			out = append(out, BracketNodes(self.nodes(depth+1)))
+		case `brace`:
+			self.args(args, 0)
+			out = append(out, BraceNodes(self.nodes(depth+1)))
+		case `delim`:
+			self.args(args, 3)
+			out = append(out, DelimNodes{Delim{args[0], args[1], args[2]}, self.nodes(depth + 1)})
+		default:
+			self.args(args, 1)
+			out = append(out, self.leaf(name, args[0]))
+		}
+	}
+	return out
+}
+
+func (self *snapshotReader) skipEmpty() bool {
+	for self.index < len(self.lines) && strings.TrimSuffix(self.lines[self.index], "\r") == `` {
+		self.index++
+	}
+	return self.index < len(self.lines)
+}
+
+// Splits a line into the node name and quoted arguments.
+func (self *snapshotReader) parse(line string) (string, []string) {
+	name := line
+	if index := strings.IndexByte(line, ' '); index >= 0 {
+		name, line = line[:index], line[index:]
+	} else {
+		line = ``
+	}
+
+	var args []string
+	for line != `` {
+		if line[0] != ' ' {
+			panic(self.errorf(`expected space before argument`))
+		}
+
+		quoted, err := strconv.QuotedPrefix(line[1:])
+		if err != nil {
+			panic(self.errorf(`invalid quoted argument %v`, line[1:]))
+		}
+
+		arg, err := strconv.Unquote(quoted)
+		if err != nil {
+			panic(self.errorf(`invalid quoted argument %v`, quoted))
+		}
+
+		args = append(args, arg)
+		line = line[1+len(quoted):]
+	}
+	return name, args
+}
+
+func (self *snapshotReader) args(args []string, count int) {
+	if len(args) != count {
+		panic(self.errorf(`expected %v arguments, got %v`, count, len(args)))
+	}
+}
+
+// Decodes an atomic node from the source text of a token of the named type.
+func (self *snapshotReader) leaf(name, src string) Node {
+	typ, err := ParseType(name)
+	if err != nil || !typ.IsKnown() {
+		panic(self.errorf(`unknown node %q`, name))
+	}
+
+	node, ok := snapshotNode(typ, src)
+	if !ok || node.String() != src {
+		panic(self.errorf(`%q is not a valid %v`, src, typ))
+	}
+	return node
+}
+
+func snapshotNode(typ Type, src string) (node Node, ok bool) {
+	defer func() {
+		if recover() != nil {
+			node, ok = nil, false
+		}
+	}()
+	return Token{Region{0, len(src)}, typ}.Node(src), true
+}
+
+func (self *snapshotReader) errorf(pattern string, args ...interface{}) error {
+	return fmt.Errorf(`[sqlp] invalid snapshot at line %v: %v`, self.line, fmt.Sprintf(pattern, args...))
+}
@@ -0,0 +1,127 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Excludes soft-deleted rows from SELECT statements. The columns map table names
+to their soft-delete columns, such as "deleted_at". For every reference to
+such a table in a FROM or JOIN clause, adds the condition "<table>.<column> is
+null", where the table is qualified by its alias if any, or by its name as
+written. Applies to subqueries and CTEs at any nesting level.
+
+Table names are matched against `TableRef.Name`, see `Tables`. An unqualified
+key such as "users" matches the table in any schema, while a qualified key
+such as "public.users" matches only qualified references.
+
+Conditions for tables in FROM, in comma-separated lists, and in inner and cross
+joins are added to the WHERE clause via `AndWhere`. Conditions for tables on
+the right side of a left join are added to its ON clause, which preserves the
+semantics of the outer join. Conditions are added in the order in which the
+tables occur in the query. Returns an error for soft-delete tables in
+queries with right or full joins, for left joins without ON, for compound
+statements such as UNION, and for multiple statements. Other statements are
+left as-is. Doesn't modify the input.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users u left join posts p on p.user_id = u.id`)
+	...
+	nodes, err = sqlp.FilterSoftDeleted(nodes, map[string]string{
+		`users`: `deleted_at`,
+		`posts`: `deleted_at`,
+	})
+	// select * from users u left join posts p on (p.user_id = u.id) and (p.deleted_at is null) where (u.deleted_at is null)
+*/
+func FilterSoftDeleted(nodes Nodes, columns map[string]string) (_ Nodes, err error) {
+	defer rec(&err)
+
+	if len(splitStatements(topLevel(nodes))) > 1 {
+		return nil, fmt.Errorf(`[sqlp] unsupported multiple statements`)
+	}
+	return filterSoftDeleted(nodes, columns), nil
+}
+
+func filterSoftDeleted(nodes Nodes, columns map[string]string) Nodes {
+	out := make(Nodes, len(nodes))
+	for i, node := range nodes {
+		if paren, ok := node.(ParenNodes); ok {
+			out[i] = ParenNodes(filterSoftDeleted(Nodes(paren), columns))
+		} else {
+			out[i] = node
+		}
+	}
+
+	out = normalizeFrom(out)
+	items := fromItems(out)
+	var where Nodes
+
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		column := softDeleteColumn(item.Name, columns)
+		if column == `` {
+			continue
+		}
+
+		cond := Nodes{
+			NodeText(item.qual + `.` + column), nodeWhitespaceSingle,
+			NodeText(`is`), nodeWhitespaceSingle,
+			NodeText(`null`),
+		}
+
+		switch item.join {
+		case ``, `inner`, `cross`:
+			if len(where) > 0 {
+				cond = append(cond, nodeWhitespaceSingle, NodeText(`and`), nodeWhitespaceSingle)
+			}
+			where = append(cond, where...)
+		case `left`:
+			if item.onStart == item.onEnd {
+				panic(fmt.Errorf(`[sqlp] can't filter soft-deleted rows of table %q in left join without ON`, item.Name))
+			}
+			out = andOn(out, item, cond)
+		default:
+			panic(fmt.Errorf(`[sqlp] can't filter soft-deleted rows of table %q in query with %v join`, item.Name, item.join))
+		}
+	}
+
+	for _, item := range items {
+		if item.join == `right` || item.join == `full` {
+			if len(where) > 0 {
+				panic(fmt.Errorf(`[sqlp] can't filter soft-deleted rows in query with %v join`, item.join))
+			}
+		}
+	}
+
+	out, err := AndWhere(out, where)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Combines the ON condition of the given item with the given condition.
+func andOn(nodes Nodes, item fromItem, cond Nodes) Nodes {
+	prev, lead, _ := trimWhitespace(nodes[item.onStart:item.onEnd])
+	if len(lead) == 0 {
+		lead = Nodes{nodeWhitespaceSingle}
+	}
+
+	out := make(Nodes, 0, len(nodes)+8)
+	out = append(out, nodes[:item.onStart]...)
+	out = append(out, lead...)
+	out = append(out, ParenNodes(append(Nodes(nil), prev...)), nodeWhitespaceSingle, NodeText(`and`), nodeWhitespaceSingle, ParenNodes(cond))
+	return append(out, nodes[item.onEnd:]...)
+}
+
+func softDeleteColumn(name string, columns map[string]string) string {
+	if name == `` {
+		return ``
+	}
+	if column, ok := columns[name]; ok {
+		return column
+	}
+	return columns[name[strings.LastIndexByte(name, '.')+1:]]
+}
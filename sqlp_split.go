@@ -0,0 +1,343 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+Splits an SQL script into individual statements separated by semicolons.
+Semicolons inside quotes, comments, and procedural blocks are ignored. The
+returned statements don't include the separating semicolons, and have leading
+and trailing whitespace removed. Statements consisting only of whitespace and
+comments are omitted.
+
+The splitter understands the following procedural blocks:
+
+	BEGIN ... END        : blocks in stored procedures, functions, triggers
+	CASE ... END         : expressions and statements
+	DO $$ ... $$         : any dollar-quoted string
+
+BEGIN followed by a semicolon or by TRANSACTION, WORK, TRAN, ISOLATION, READ,
+DEFERRABLE, or DISTRIBUTED is treated as the start of a transaction rather
+than a block. END followed by IF, LOOP, WHILE, REPEAT, or FOR ends a construct
+which is not tracked, and is ignored.
+
+For additional options, see `Splitter`.
+*/
+func Split(src string) ([]string, error) {
+	splitter := Splitter{Tokenizer: Tokenizer{Source: src}}
+	return splitter.Split()
+}
+
+/*
+Statement splitter. See `Split`. The embedded `Tokenizer` may be configured
+with additional options. In particular, when `Tokenizer.Script` is true,
+directives such as psql meta-commands are returned as separate statements, and
+terminate any preceding statement. A MySQL "DELIMITER" directive changes the
+statement delimiter for the rest of the script, until changed again. While a
+custom delimiter is in effect, semicolons and procedural blocks are ignored.
+
+When `Tokenizer.Script` is true and `Tokenizer.Dialect` is `DialectMSSQL`,
+T-SQL batch separators such as "GO" terminate any preceding statement and
+procedural block, and are omitted from the output. This allows to split
+scripts exported by SSMS, where statements are often separated only by "GO".
+*/
+type Splitter struct {
+	Tokenizer
+	depth   int
+	pending splitPending
+	start   int
+	content bool
+	text    Region
+	queued  Region
+	delim   string
+	init    bool
+}
+
+// See `Split`.
+func (self *Splitter) Split() (out []string, err error) {
+	defer rec(&err)
+	self.initOnce()
+
+	for {
+		region, ok := self.next()
+		if !ok {
+			return
+		}
+		out = append(out, strings.TrimSpace(region.Slice(self.Source)))
+	}
+}
+
+/*
+Incremental version of `Splitter.Split`, used by `Statements`. Returns the
+region of the next statement in `Tokenizer.Source`, without leading and
+trailing whitespace, or false at the end of the source.
+*/
+func (self *Splitter) nextStatement() (_ Region, _ bool, err error) {
+	defer rec(&err)
+	self.initOnce()
+
+	region, ok := self.next()
+	if !ok {
+		return Region{}, false, nil
+	}
+	return trimSpaceRegion(self.Source, region), true, nil
+}
+
+/*
+Parses the given statement region, as returned by `Splitter.nextStatement`,
+with the same options as the splitter. The statement is parsed in place, by
+truncating the source at the end of the statement and starting at its
+beginning, which keeps diagnostics relative to the full source.
+*/
+func (self *Splitter) parseStatement(region Region) (Nodes, error) {
+	parser := Parser{Tokenizer: self.Tokenizer}
+
+	// Excludes the recognizer of custom statement delimiters; see `initOnce`.
+	parser.Recognizers = self.Recognizers[1:]
+	parser.Source = self.Source[:region[1]]
+	parser.SetPos(region[0])
+
+	nodes, err := parser.Parse()
+	if err != nil {
+		return nil, fmt.Errorf(`[sqlp] failed to parse statement at offset %v: %w`, region[0], err)
+	}
+	return nodes, nil
+}
+
+func (self *Splitter) initOnce() {
+	if !self.init {
+		self.init = true
+		self.Recognizers = append([]Recognizer{self.recognizeDelim}, self.Recognizers...)
+	}
+}
+
+type splitPending byte
+
+const (
+	splitPendingNone splitPending = iota
+	splitPendingBegin
+	splitPendingEnd
+)
+
+/*
+Returns the region of the next statement, excluding the semicolon. Uses tokens
+to skip quotes and comments, and scans text tokens for keywords and
+semicolons.
+*/
+func (self *Splitter) next() (Region, bool) {
+	for {
+		if self.queued.HasLen() {
+			region := self.queued
+			self.queued = Region{}
+			return region, true
+		}
+
+		if self.text.HasLen() {
+			region, ok := self.scanText()
+			if ok {
+				return region, true
+			}
+			continue
+		}
+
+		tok := self.Token()
+		if tok.IsInvalid() {
+			return self.flush(len(self.Source))
+		}
+
+		switch tok.Type {
+		case TypeWhitespace, TypeCommentLine, TypeCommentBlock, TypeCommentHash:
+		case TypeText, TypeKeyword, TypeIdent:
+			self.text = tok.Region
+		case typeStatementDelim:
+			self.depth = 0
+			self.pending = splitPendingNone
+			region, ok := self.flush(tok.Region[0])
+			self.start = tok.Region[1]
+			if ok {
+				return region, true
+			}
+
+		case TypeSemicolon:
+			if self.delim != `` {
+				self.content = true
+				continue
+			}
+			self.resolvePending()
+			if self.depth == 0 {
+				region, ok := self.flush(tok.Region[0])
+				self.start = tok.Region[1]
+				if ok {
+					return region, true
+				}
+			}
+
+		case TypeBatchSeparator:
+			self.depth = 0
+			self.pending = splitPendingNone
+			region, ok := self.flush(tok.Region[0])
+			self.start = tok.Region[1]
+			if ok {
+				return region, true
+			}
+
+		case TypeDirective:
+			self.directive(tok.Slice(self.Source))
+			self.resolvePending()
+			region, ok := self.flush(tok.Region[0])
+			self.start = tok.Region[1]
+			if ok {
+				self.queued = tok.Region
+				return region, true
+			}
+			return tok.Region, true
+		default:
+			self.resolvePending()
+			self.content = true
+		}
+	}
+}
+
+/*
+Scans the current text token until a statement-terminating semicolon or the
+end of the token. Keywords are detected at word boundaries.
+*/
+func (self *Splitter) scanText() (Region, bool) {
+	src := self.Source
+
+	for self.text.HasLen() {
+		pos := self.text[0]
+		char := src[pos]
+
+		if char == ';' && self.delim == `` {
+			self.text[0]++
+			self.resolvePending()
+			if self.depth == 0 {
+				region, ok := self.flush(pos)
+				self.start = pos + 1
+				if ok {
+					return region, true
+				}
+			}
+			continue
+		}
+
+		if charsetIdentStart.has(char) && (pos == 0 || !charsetIdent.has(src[pos-1])) {
+			word := prefixIdent(self.text.Slice(src))
+			self.text[0] += len(word)
+			self.word(word)
+			self.content = true
+			continue
+		}
+
+		self.text[0]++
+		self.content = true
+	}
+	return Region{}, false
+}
+
+// Handles the MySQL "DELIMITER" directive.
+func (self *Splitter) directive(str string) {
+	if !(len(str) > len(delimiterCommand) && strings.EqualFold(str[:len(delimiterCommand)], delimiterCommand)) {
+		return
+	}
+
+	fields := strings.Fields(str[len(delimiterCommand):])
+	if len(fields) == 0 || fields[0] == `;` {
+		self.delim = ``
+	} else {
+		self.delim = fields[0]
+	}
+}
+
+// Internal token type used by `Splitter` for custom statement delimiters.
+const typeStatementDelim = TypeCustom - 1
+
+func (self *Splitter) recognizeDelim(tok *Tokenizer) (Token, bool) {
+	if self.delim == `` || !strings.HasPrefix(tok.Rest(), self.delim) {
+		return Token{}, false
+	}
+	start := tok.Cursor()
+	return Token{Region{start, start + len(self.delim)}, typeStatementDelim}, true
+}
+
+func (self *Splitter) word(word string) {
+	switch self.pending {
+	case splitPendingBegin:
+		self.pending = splitPendingNone
+		if hasWordFold(beginTransactionWords, word) {
+			return
+		}
+		self.depth++
+
+	case splitPendingEnd:
+		self.pending = splitPendingNone
+		if hasWordFold(endUntrackedWords, word) {
+			return
+		}
+		self.decDepth()
+		if strings.EqualFold(word, `case`) {
+			return
+		}
+	}
+
+	switch {
+	case strings.EqualFold(word, `begin`):
+		self.pending = splitPendingBegin
+	case strings.EqualFold(word, `case`):
+		self.depth++
+	case strings.EqualFold(word, `end`):
+		self.pending = splitPendingEnd
+	}
+}
+
+func (self *Splitter) resolvePending() {
+	if self.pending == splitPendingEnd {
+		self.decDepth()
+	}
+	self.pending = splitPendingNone
+}
+
+func (self *Splitter) decDepth() {
+	if self.depth > 0 {
+		self.depth--
+	}
+}
+
+func (self *Splitter) flush(end int) (Region, bool) {
+	region := Region{self.start, end}
+	content := self.content
+	self.start = end
+	self.content = false
+	return region, content
+}
+
+var (
+	beginTransactionWords = []string{
+		`transaction`, `work`, `tran`, `isolation`, `read`, `deferrable`, `distributed`,
+	}
+
+	endUntrackedWords = []string{`if`, `loop`, `while`, `repeat`, `for`}
+)
+
+func hasWordFold(words []string, word string) bool {
+	for _, val := range words {
+		if strings.EqualFold(val, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shrinks the region to exclude leading and trailing whitespace, like
+// `strings.TrimSpace`.
+func trimSpaceRegion(src string, region Region) Region {
+	str := region.Slice(src)
+	trimmed := strings.TrimLeftFunc(str, unicode.IsSpace)
+	region[0] += len(str) - len(trimmed)
+	region[1] = region[0] + len(strings.TrimRightFunc(trimmed, unicode.IsSpace))
+	return region
+}
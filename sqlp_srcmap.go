@@ -0,0 +1,269 @@
+package sqlp
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+/*
+Pair of corresponding regions in the original and rewritten SQL. See
+`SourceMap`.
+*/
+type SourceSpan struct {
+	Src Region
+	Out Region
+}
+
+/*
+Maps positions in rewritten SQL back to positions in the original SQL, and vice
+versa. Consists of spans of text which are identical in both, sorted by
+position. Text inserted by a rewrite is not covered by any span. Use
+`NewSourceMap` to create.
+*/
+type SourceMap []SourceSpan
+
+/*
+Creates a source map between an AST and its rewritten version, such as the
+result of `AndWhere`, by matching their leaf nodes. Leaf nodes are matched
+when they have the same type and the same serialized text, and occur in the
+same relative order. Collections such as `ParenNodes` are not matched
+directly, but their inner nodes are. Regions in the resulting map refer to the
+serialized forms of both ASTs, which, for parsed ASTs, are the sources they
+were parsed from.
+
+The matching uses a longest common subsequence algorithm, after skipping the
+common prefix and suffix. For typical rewrites, which insert or replace a small
+part of the query, it's fast. For unrelated inputs, its cost is proportional to
+the product of their sizes.
+*/
+func NewSourceMap(src, out Node) SourceMap {
+	srcLeaves := regionLeaves(src)
+	outLeaves := regionLeaves(out)
+
+	var builder sourceMapBuilder
+	start := 0
+	for start < len(srcLeaves) && start < len(outLeaves) && sameLeaf(srcLeaves[start], outLeaves[start]) {
+		builder.add(srcLeaves[start], outLeaves[start])
+		start++
+	}
+
+	srcEnd, outEnd := len(srcLeaves), len(outLeaves)
+	for srcEnd > start && outEnd > start && sameLeaf(srcLeaves[srcEnd-1], outLeaves[outEnd-1]) {
+		srcEnd--
+		outEnd--
+	}
+
+	for _, pair := range lcsLeaves(srcLeaves[start:srcEnd], outLeaves[start:outEnd]) {
+		builder.add(srcLeaves[start+pair[0]], outLeaves[start+pair[1]])
+	}
+
+	for i := 0; srcEnd+i < len(srcLeaves); i++ {
+		builder.add(srcLeaves[srcEnd+i], outLeaves[outEnd+i])
+	}
+	return builder.out
+}
+
+/*
+Returns the position in the original SQL which corresponds to the given
+position in the rewritten SQL. Returns false if the position is in text
+inserted by the rewrite.
+*/
+func (self SourceMap) Source(pos int) (int, bool) {
+	index := sort.Search(len(self), func(index int) bool {
+		return self[index].Out[1] > pos
+	})
+	if index < len(self) && self[index].Out[0] <= pos {
+		span := self[index]
+		return span.Src[0] + pos - span.Out[0], true
+	}
+	return 0, false
+}
+
+/*
+Returns the position in the rewritten SQL which corresponds to the given
+position in the original SQL. Returns false if the position is in text
+removed by the rewrite.
+*/
+func (self SourceMap) Output(pos int) (int, bool) {
+	index := sort.Search(len(self), func(index int) bool {
+		return self[index].Src[1] > pos
+	})
+	if index < len(self) && self[index].Src[0] <= pos {
+		span := self[index]
+		return span.Out[0] + pos - span.Src[0], true
+	}
+	return 0, false
+}
+
+// Accumulates matched leaves, merging adjacent ones into larger spans.
+type sourceMapBuilder struct{ out SourceMap }
+
+func (self *sourceMapBuilder) add(src, out sourceLeaf) {
+	if !src.region.HasLen() {
+		return
+	}
+
+	if len(self.out) > 0 {
+		last := &self.out[len(self.out)-1]
+		if last.Src[1] == src.region[0] && last.Out[1] == out.region[0] {
+			last.Src[1] = src.region[1]
+			last.Out[1] = out.region[1]
+			return
+		}
+	}
+	self.out = append(self.out, SourceSpan{src.region, out.region})
+}
+
+// Leaf node with its region, type, and serialized text, precomputed for
+// comparisons.
+type sourceLeaf struct {
+	region Region
+	typ    reflect.Type
+	text   string
+}
+
+func regionLeaves(node Node) (out []sourceLeaf) {
+	WalkNodeRegions(node, func(node Node, region Region) {
+		if _, ok := node.(Walker); !ok {
+			out = append(out, sourceLeaf{region, reflect.TypeOf(node), node.String()})
+		}
+	})
+	return
+}
+
+func sameLeaf(one, two sourceLeaf) bool {
+	return one.typ == two.typ && one.text == two.text
+}
+
+// Returns index pairs of a longest common subsequence of the given leaves.
+func lcsLeaves(src, out []sourceLeaf) [][2]int {
+	if len(src) == 0 || len(out) == 0 {
+		return nil
+	}
+
+	// Suffix lengths: `table[i][j]` is the LCS length of `src[i:]` and `out[j:]`.
+	width := len(out) + 1
+	table := make([]int32, (len(src)+1)*width)
+
+	for i := len(src) - 1; i >= 0; i-- {
+		for j := len(out) - 1; j >= 0; j-- {
+			if sameLeaf(src[i], out[j]) {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else if table[(i+1)*width+j] >= table[i*width+j+1] {
+				table[i*width+j] = table[(i+1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(src) && j < len(out); {
+		switch {
+		case sameLeaf(src[i], out[j]):
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case table[(i+1)*width+j] >= table[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+/*
+Maps a byte offset in rewritten SQL, such as the position of a database error,
+to a byte offset in the original SQL. Unlike `SourceMap.Source`, this always
+returns a position: an offset in text inserted by the rewrite is mapped to the
+position in the original where the insertion occurred, which is the end of the
+preceding span, or the start of the following span when there's no preceding
+span. Returns 0 if the map is empty.
+*/
+func MapErrorPosition(srcMap SourceMap, dbPos int) int {
+	pos, ok := srcMap.Source(dbPos)
+	if ok {
+		return pos
+	}
+
+	index := sort.Search(len(srcMap), func(index int) bool {
+		return srcMap[index].Out[0] > dbPos
+	})
+	if index > 0 {
+		return srcMap[index-1].Src[1]
+	}
+	if index < len(srcMap) {
+		return srcMap[index].Src[0]
+	}
+	return 0
+}
+
+/*
+Finds the position of a Postgres error in the rewritten query, and maps it to
+a byte offset in the original SQL via `MapErrorPosition`. The query must be
+the rewritten SQL which was sent to the database.
+
+Postgres reports positions as 1-based character indexes. This function finds a
+field named "Position" in the error or in any error it wraps, and supports
+integer fields, as in "pgconn.PgError", and string fields, as in "pq.Error",
+without depending on any driver. Returns false if there's no such field, or if
+the position is zero or out of range.
+*/
+func PostgresErrorPosition(err error, srcMap SourceMap, query string) (int, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		pos, ok := errPosition(err)
+		if !ok {
+			continue
+		}
+
+		offset, ok := charOffset(query, pos-1)
+		if !ok {
+			return 0, false
+		}
+		return MapErrorPosition(srcMap, offset), true
+	}
+	return 0, false
+}
+
+func errPosition(err error) (int, bool) {
+	val := reflect.ValueOf(err)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return 0, false
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	field := val.FieldByName(`Position`)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(field.Int()), field.Int() > 0
+	case reflect.String:
+		pos, err := strconv.Atoi(field.String())
+		return pos, err == nil && pos > 0
+	default:
+		return 0, false
+	}
+}
+
+// Converts a 0-based character index to a byte offset in the given string.
+func charOffset(str string, index int) (int, bool) {
+	if index < 0 {
+		return 0, false
+	}
+
+	count := 0
+	for offset := range str {
+		if count == index {
+			return offset, true
+		}
+		count++
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+//go:build go1.23
+
+package sqlp
+
+import "iter"
+
+/*
+Iterates over the statements of an SQL script, parsing one statement at a time.
+Statements are found in the same way as by `Split`, and tokenized lazily,
+which allows to stop at the first failing statement of a huge dump without
+tokenizing or parsing the rest. On error, yields nil nodes and the error, and
+stops. Parse errors mention the offset of the statement in the source.
+Each iteration starts over from the beginning of the source.
+
+Example:
+
+	for nodes, err := range sqlp.Statements(src) {
+		if err != nil {
+			return err
+		}
+		fmt.Println(nodes.String())
+	}
+
+For additional options, see `Splitter.Statements`. Requires Go 1.23 or later.
+*/
+func Statements(src string) iter.Seq2[Nodes, error] {
+	return func(yield func(Nodes, error) bool) {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: src}}
+		splitter.Statements()(yield)
+	}
+}
+
+/*
+Same as `Statements`, but uses the options of the embedded `Tokenizer`, such as
+`Tokenizer.Dialect` and `Tokenizer.Script`, both for splitting and for parsing
+each statement. Iteration consumes the splitter: like `Splitter.Split`, it
+continues from where the previous iteration stopped.
+*/
+func (self *Splitter) Statements() iter.Seq2[Nodes, error] {
+	return func(yield func(Nodes, error) bool) {
+		for {
+			region, ok, err := self.nextStatement()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !ok {
+				return
+			}
+
+			nodes, err := self.parseStatement(region)
+			if !yield(nodes, err) || err != nil {
+				return
+			}
+		}
+	}
+}
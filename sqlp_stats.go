@@ -0,0 +1,78 @@
+package sqlp
+
+import "sync/atomic"
+
+/*
+Counters of work done by `Tokenizer` and `Parser` across the entire process,
+for attributing parsing cost in profiles and benchmarks of downstream code.
+Collected only when building with the "sqlp_stats" build tag:
+
+	go test -tags=sqlp_stats -bench=.
+
+Without the tag, `StatsEnabled` is false, collection is compiled out, and
+`ReadStats` always returns zero. With the tag, counters are updated
+atomically, which adds a small cost to every token. See `ReadStats` and
+`ResetStats`.
+*/
+type Stats struct {
+	// Count of tokens returned by `Tokenizer.Token`, including tokens
+	// consumed by `Parser`.
+	Tokens uint64
+
+	// Count of source bytes covered by those tokens.
+	Bytes uint64
+
+	// Count of nodes created by `Parser`, including collections such as
+	// `ParenNodes`.
+	Nodes uint64
+
+	// Count of heap allocations of node slices by `Parser`, which happen when
+	// a slice of nodes, such as the content of `ParenNodes`, grows. Doesn't
+	// include allocations made by `Token.Node` or `Parser.Factory` when
+	// creating individual nodes, which are roughly proportional to `Nodes`.
+	Allocs uint64
+}
+
+// Returns the current values of the global counters. See `Stats`.
+func ReadStats() Stats {
+	return Stats{
+		Tokens: atomic.LoadUint64(&globalStats.Tokens),
+		Bytes:  atomic.LoadUint64(&globalStats.Bytes),
+		Nodes:  atomic.LoadUint64(&globalStats.Nodes),
+		Allocs: atomic.LoadUint64(&globalStats.Allocs),
+	}
+}
+
+/*
+Resets the global counters to zero, returning their previous values. Useful for
+measuring a specific operation. See `Stats`.
+*/
+func ResetStats() Stats {
+	return Stats{
+		Tokens: atomic.SwapUint64(&globalStats.Tokens, 0),
+		Bytes:  atomic.SwapUint64(&globalStats.Bytes, 0),
+		Nodes:  atomic.SwapUint64(&globalStats.Nodes, 0),
+		Allocs: atomic.SwapUint64(&globalStats.Allocs, 0),
+	}
+}
+
+var globalStats Stats
+
+func statToken(tok Token) {
+	if StatsEnabled && !tok.IsInvalid() {
+		atomic.AddUint64(&globalStats.Tokens, 1)
+		atomic.AddUint64(&globalStats.Bytes, uint64(tok.Len()))
+	}
+}
+
+func statNode() {
+	if StatsEnabled {
+		atomic.AddUint64(&globalStats.Nodes, 1)
+	}
+}
+
+func statAlloc() {
+	if StatsEnabled {
+		atomic.AddUint64(&globalStats.Allocs, 1)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !sqlp_stats
+// +build !sqlp_stats
+
+package sqlp
+
+// True when building with the "sqlp_stats" tag. See `Stats`.
+const StatsEnabled = false
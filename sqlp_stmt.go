@@ -0,0 +1,149 @@
+package sqlp
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+)
+
+/*
+Returns a normalized representation of the given AST, suitable as a cache key
+for queries that differ only in formatting. Every run of whitespace is replaced
+with a single space, and leading and trailing whitespace is removed. Top-level
+comments in the sqlcommenter format are removed via `StripSQLCommenter`, which
+prevents per-request trace IDs from fragmenting caches. Other comments, quotes,
+and everything else are preserved as-is.
+*/
+func Fingerprint(node Node) string {
+	if node == nil {
+		return ``
+	}
+
+	if nodes, ok := node.(Nodes); ok {
+		node = StripSQLCommenter(nodes)
+	}
+	node = CopyNode(node)
+	DeepWalkNodePtr(&node, func(ptr *Node) {
+		if _, ok := (*ptr).(NodeWhitespace); ok {
+			*ptr = nodeWhitespaceSingle
+		}
+	})
+	return strings.TrimSpace(node.String())
+}
+
+/*
+Caches prepared statements for one connection pool, keyed by `Fingerprint`.
+Intended for applications that build queries by rewriting an AST, and execute
+them repeatedly: the rewritten query is rendered and prepared once, and
+subsequent calls with an equivalent AST reuse the same statement. Statements
+prepared via `*sql.DB` are safe for concurrent use, and so is this cache.
+
+The zero value is not usable; `DB` must be set. Call `StmtCache.Close` to
+release the statements when the cache is no longer needed.
+
+Example:
+
+	cache := sqlp.StmtCache{DB: db}
+	defer cache.Close()
+
+	stmt, err := cache.Prepare(ctx, nodes)
+	if err != nil {
+		return err
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+*/
+type StmtCache struct {
+	DB    *sql.DB
+	lock  sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+/*
+Returns a prepared statement for the given AST, preparing it on the first call
+for the given fingerprint. Comments in the sqlcommenter format are removed from
+the prepared SQL via `StripSQLCommenter`, because they're excluded from the
+fingerprint, and the statement is shared by queries with different comments.
+The statement is owned by the cache, and must not be closed by the caller.
+*/
+func (self *StmtCache) Prepare(ctx context.Context, node Node) (*sql.Stmt, error) {
+	key := Fingerprint(node)
+
+	stmt := self.get(key)
+	if stmt != nil {
+		return stmt, nil
+	}
+
+	if nodes, ok := node.(Nodes); ok {
+		node = StripSQLCommenter(nodes)
+	}
+
+	stmt, err := self.DB.PrepareContext(ctx, nodeString(node))
+	if err != nil {
+		return nil, err
+	}
+	return self.set(key, stmt), nil
+}
+
+// Returns the count of cached statements.
+func (self *StmtCache) Len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.stmts)
+}
+
+/*
+Closes all cached statements and clears the cache. Returns the first error
+encountered, if any. The cache remains usable afterwards.
+*/
+func (self *StmtCache) Close() (err error) {
+	self.lock.Lock()
+	stmts := self.stmts
+	self.stmts = nil
+	self.lock.Unlock()
+
+	for _, stmt := range stmts {
+		val := stmt.Close()
+		if err == nil {
+			err = val
+		}
+	}
+	return
+}
+
+func (self *StmtCache) get(key string) *sql.Stmt {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stmts[key]
+}
+
+/*
+Stores the statement unless another goroutine has already stored one for the
+same key, in which case the redundant statement is closed and the existing one
+is returned. This allows to prepare statements without holding the lock.
+*/
+func (self *StmtCache) set(key string, stmt *sql.Stmt) *sql.Stmt {
+	self.lock.Lock()
+	prev := self.stmts[key]
+	if prev == nil {
+		if self.stmts == nil {
+			self.stmts = map[string]*sql.Stmt{}
+		}
+		self.stmts[key] = stmt
+	}
+	self.lock.Unlock()
+
+	if prev != nil {
+		_ = stmt.Close()
+		return prev
+	}
+	return stmt
+}
+
+func nodeString(node Node) string {
+	if node == nil {
+		return ``
+	}
+	return node.String()
+}
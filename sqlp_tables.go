@@ -0,0 +1,329 @@
+package sqlp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describes a table referenced in a FROM or JOIN clause. See `Tables`.
+type TableRef struct {
+	// Name without quotes, such as "public.users". Unquoted parts are
+	// lowercased, and quoted parts are preserved, like in Postgres.
+	Name string
+	// Alias as written, or empty.
+	Alias string
+}
+
+/*
+Returns the tables referenced in FROM and JOIN clauses of SELECT statements in
+the given AST, including subqueries and CTEs, in order of occurrence, with
+outer queries before subqueries. Derived tables and table functions, such as
+"generate_series(1, 10)", are not included, but their contents are searched.
+References to CTEs are indistinguishable from references to tables, and are
+included. Returns an error if a FROM clause has an unsupported syntax.
+
+Example:
+
+	nodes, err := sqlp.Parse(`select * from users u join public.posts on true`)
+	...
+	tables, err := sqlp.Tables(nodes)
+	// []TableRef{{Name: "users", Alias: "u"}, {Name: "public.posts"}}
+*/
+func Tables(nodes Nodes) (_ []TableRef, err error) {
+	defer rec(&err)
+
+	var out []TableRef
+	var walk func(Nodes)
+	walk = func(nodes Nodes) {
+		for _, item := range fromItems(normalizeFrom(nodes)) {
+			if item.Name != `` {
+				out = append(out, item.TableRef)
+			}
+		}
+		for _, node := range nodes {
+			if paren, ok := node.(ParenNodes); ok {
+				walk(Nodes(paren))
+			}
+		}
+	}
+
+	walk(nodes)
+	return out, nil
+}
+
+// One item of a FROM clause, with indexes into the level nodes.
+type fromItem struct {
+	TableRef
+
+	// How to refer to the table in conditions: the alias, or the name as
+	// written.
+	qual string
+
+	// Join type: empty for the first item and items following commas,
+	// otherwise one of "inner", "left", "right", "full", "cross".
+	join string
+
+	// Range of the ON condition, or zeros if there's no ON.
+	onStart, onEnd int
+
+	// Index after the table name, and index of the alias, or zeros.
+	nameEnd, aliasIndex int
+}
+
+/*
+Name by which the item is referred to in qualified column references: the
+alias, or the table name without the schema. Unquoted names are lowercased.
+Empty for derived tables and table functions without an alias.
+*/
+func (self fromItem) qualName(nodes Nodes) string {
+	if self.aliasIndex > 0 {
+		name, _ := identName(nodes[self.aliasIndex])
+		return name
+	}
+	return self.Name[strings.LastIndexByte(self.Name, '.')+1:]
+}
+
+/*
+Returns the items of the top-level FROM clause, if the nodes are a SELECT
+statement. Expects nodes normalized via `normalizeFrom`. Panics on unsupported
+syntax.
+*/
+func fromItems(nodes Nodes) []fromItem {
+	if ClassifyStmt(nodes) != StmtKindSelect {
+		return nil
+	}
+
+	start, end := fromRange(nodes)
+	if start < 0 {
+		return nil
+	}
+
+	var out []fromItem
+	join := ``
+	index := start
+
+	for {
+		index = skipTrivia(nodes, index)
+		if index >= end {
+			return out
+		}
+		if isKeyword(nodes[index], `lateral`, `only`) {
+			index = skipTrivia(nodes, index+1)
+		}
+
+		item := fromItem{join: join}
+		index = fromPrimary(nodes, index, end, &item)
+		index = fromAlias(nodes, index, end, &item)
+
+		index = skipTrivia(nodes, index)
+		if index < end && isKeyword(nodes[index], `on`) {
+			item.onStart = index + 1
+			index = fromCondEnd(nodes, index+1, end)
+			item.onEnd = index
+		} else if index < end && isKeyword(nodes[index], `using`) {
+			index = skipTrivia(nodes, index+1)
+			if _, ok := nodeAt(nodes, index).(ParenNodes); !ok {
+				panic(fmt.Errorf(`[sqlp] invalid FROM clause: expected parens after USING`))
+			}
+			index++
+		}
+		out = append(out, item)
+
+		index = skipTrivia(nodes, index)
+		if index >= end {
+			return out
+		}
+		if nodes[index] == NodeText(`,`) {
+			join = ``
+			index++
+			continue
+		}
+		join, index = fromJoin(nodes, index, end)
+	}
+}
+
+/*
+Range of the top-level FROM clause, excluding the keyword and the trailing
+trivia and semicolons, or -1.
+*/
+func fromRange(nodes Nodes) (int, int) {
+	index := indexKeyword(nodes, 0, `from`)
+	if index < 0 {
+		return -1, -1
+	}
+
+	body, _ := splitTrailing(nodes)
+	end := indexKeyword(body, index+1, append([]string{`where`}, whereSuccessorKeywords...)...)
+	if end < 0 {
+		end = len(body)
+	}
+	return index + 1, end
+}
+
+/*
+Parses a table name, derived table, or table function, and returns the index
+after it. Table names consist of adjacent text, identifier, and double-quoted
+nodes.
+*/
+func fromPrimary(nodes Nodes, index, end int, item *fromItem) int {
+	if _, ok := nodes[index].(ParenNodes); ok {
+		return index + 1
+	}
+
+	var name, qual strings.Builder
+	for ; index < end; index++ {
+		switch node := nodes[index].(type) {
+		case NodeText, NodeIdent:
+			text, _ := wordText(node)
+			if text == `,` {
+				break
+			}
+			name.WriteString(strings.ToLower(text))
+			qual.WriteString(text)
+			continue
+		case NodeQuoteDouble:
+			name.WriteString(string(node))
+			qual.WriteString(node.String())
+			continue
+		}
+		break
+	}
+
+	if qual.Len() == 0 {
+		panic(fmt.Errorf(`[sqlp] invalid FROM clause: unexpected %q`, nodes[index]))
+	}
+
+	// Table function such as "generate_series(1, 10)".
+	if _, ok := nodeAt(nodes, index).(ParenNodes); ok && index < end {
+		return index + 1
+	}
+
+	item.Name = name.String()
+	item.qual = qual.String()
+	item.nameEnd = index
+	return index
+}
+
+/*
+Parses an optional alias with an optional column list, and returns the index
+after it.
+*/
+func fromAlias(nodes Nodes, index, end int, item *fromItem) int {
+	next := skipTrivia(nodes, index)
+	if next >= end {
+		return index
+	}
+
+	explicit := isKeyword(nodes[next], `as`)
+	if explicit {
+		next = skipTrivia(nodes, next+1)
+	}
+
+	var alias string
+	switch node := nodeAt(nodes, next).(type) {
+	case NodeText, NodeIdent:
+		text, _ := wordText(node)
+		if next < end && text != `,` && !isKeyword(node, fromKeywords...) {
+			alias = text
+		}
+	case NodeQuoteDouble:
+		alias = node.String()
+	}
+
+	if alias == `` {
+		if explicit {
+			panic(fmt.Errorf(`[sqlp] invalid FROM clause: expected alias after AS`))
+		}
+		return index
+	}
+
+	item.Alias = alias
+	item.aliasIndex = next
+	if item.Name != `` {
+		item.qual = alias
+	}
+
+	index = next + 1
+	next = skipTrivia(nodes, index)
+	if _, ok := nodeAt(nodes, next).(ParenNodes); ok && next < end {
+		return next + 1
+	}
+	return index
+}
+
+// Index of the end of an ON condition, which ends at the next join or comma.
+func fromCondEnd(nodes Nodes, index, end int) int {
+	for ; index < end; index++ {
+		if nodes[index] == NodeText(`,`) || isJoinKeyword(nodes, index) {
+			break
+		}
+	}
+	for index > 0 && isTrivia(nodes[index-1]) {
+		index--
+	}
+	return index
+}
+
+/*
+Parses a join operator such as "natural left outer join", and returns the join
+type and the index after the operator.
+*/
+func fromJoin(nodes Nodes, index, end int) (string, int) {
+	join := `inner`
+	for ; index < end; index = skipTrivia(nodes, index+1) {
+		node := nodes[index]
+		switch {
+		case isKeyword(node, `join`):
+			return join, index + 1
+		case isKeyword(node, `inner`, `left`, `right`, `full`, `cross`):
+			join = strings.ToLower(node.String())
+		case isKeyword(node, `natural`, `outer`):
+		default:
+			panic(fmt.Errorf(`[sqlp] invalid FROM clause: unexpected %q`, node))
+		}
+	}
+	panic(fmt.Errorf(`[sqlp] invalid FROM clause: expected JOIN`))
+}
+
+// True if the node at the index begins a join operator, rather than being a
+// function call such as "left(name, 1)".
+func isJoinKeyword(nodes Nodes, index int) bool {
+	if !isKeyword(nodes[index], `join`, `inner`, `left`, `right`, `full`, `cross`, `natural`) {
+		return false
+	}
+	_, ok := nodeAt(nodes, index+1).(ParenNodes)
+	return !ok
+}
+
+/*
+Splits commas in the text nodes of the top-level FROM clause, and trailing
+semicolons, into separate nodes, which simplifies parsing without affecting the
+resulting SQL. Returns the input as-is if there's nothing to split.
+*/
+func normalizeFrom(nodes Nodes) Nodes {
+	start, end := fromRange(nodes)
+	if start < 0 {
+		return nodes
+	}
+
+	body, tail := splitTrailing(nodes)
+	if text, ok := nodeAt(body, len(body)-1).(NodeText); ok && text != nodes[len(body)-1] {
+		nodes = append(body, tail...)
+	}
+
+	mid := splitCommas(nodes[start:end])
+	if len(mid) == end-start {
+		return nodes
+	}
+
+	out := make(Nodes, 0, len(nodes)+len(mid)-(end-start))
+	out = append(out, nodes[:start]...)
+	out = append(out, mid...)
+	return append(out, nodes[end:]...)
+}
+
+var fromKeywords = []string{
+	`on`, `using`, `join`, `inner`, `left`, `right`, `full`, `cross`, `natural`,
+	`outer`, `lateral`, `where`, `group`, `having`, `window`, `order`, `limit`,
+	`offset`, `fetch`, `for`, `returning`, `union`, `intersect`, `except`,
+}
@@ -1,6 +1,9 @@
 package sqlp
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 // Region of source text generated by `Tokenizer`.
 type Token struct {
@@ -8,6 +11,92 @@ type Token struct {
 	Type
 }
 
+/*
+Tokenizes the entire source text, returning all tokens in order. Also see
+`Tokenizer` for incremental tokenization.
+
+Tokenization is lossless. This is a permanent guarantee: every byte of the
+source is covered by exactly one token, tokens are non-empty, and their regions
+are contiguous and non-overlapping, starting at 0 and ending at the length of
+the source. This allows to map any token back to its exact position in the
+source, for example in editors. The same applies to `Tokenizer` with any
+options. See `CoverageCheck`.
+*/
+func Tokenize(src string) (out []Token, err error) {
+	defer rec(&err)
+	tokenizer := Tokenizer{Source: src}
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			return
+		}
+		out = append(out, tok)
+	}
+}
+
+/*
+Verifies the guarantee described in `Tokenize`: the tokens must be non-empty,
+contiguous, and non-overlapping, and must cover the entire source. Returns a
+descriptive error for the first violation. Intended for tests of custom
+recognizers and of tools which produce or transform tokens.
+*/
+func CoverageCheck(src string, tokens []Token) error {
+	cursor := 0
+
+	for i, tok := range tokens {
+		if tok.IsInvalid() {
+			return fmt.Errorf(`[sqlp] token %v at %v has invalid type`, i, tok.Region)
+		}
+		if tok.Region[0] != cursor {
+			return fmt.Errorf(`[sqlp] token %v at %v doesn't start at expected offset %v`, i, tok.Region, cursor)
+		}
+		if !tok.HasLen() {
+			return fmt.Errorf(`[sqlp] token %v at %v is empty`, i, tok.Region)
+		}
+		if tok.Region[1] > len(src) {
+			return fmt.Errorf(`[sqlp] token %v at %v exceeds source length %v`, i, tok.Region, len(src))
+		}
+		cursor = tok.Region[1]
+	}
+
+	if cursor != len(src) {
+		return fmt.Errorf(`[sqlp] tokens end at offset %v, expected to cover source length %v`, cursor, len(src))
+	}
+	return nil
+}
+
+/*
+Formats a token stream as stable, line-oriented text, suitable for golden files
+and regression tests of tokenizer behavior. Each token occupies one line in the
+compact form `[offset,type] "text"`, where "offset" is the starting byte
+position of the token, "type" is the result of `Type.String`, and "text" is the
+Go-quoted source slice.
+
+Example output for `select $1`:
+
+	[0,text] "select"
+	[6,whitespace] " "
+	[7,ordinal_param] "$1"
+*/
+func TokensString(tokens []Token, src string) string {
+	var buf []byte
+	for _, tok := range tokens {
+		buf = tok.appendString(buf, src)
+		buf = append(buf, '\n')
+	}
+	return bytesToMutableString(buf)
+}
+
+func (self Token) appendString(buf []byte, src string) []byte {
+	buf = append(buf, '[')
+	buf = strconv.AppendInt(buf, int64(self.Region[0]), 10)
+	buf = append(buf, ',')
+	buf = append(buf, self.Type.String()...)
+	buf = append(buf, `] `...)
+	buf = strconv.AppendQuote(buf, self.Slice(src))
+	return buf
+}
+
 /*
 Takes full source text and attempts to parse an atomic node corresponding to the
 region and type of the current token. The output is always non-nil, but if the
@@ -20,6 +109,12 @@ func (self Token) Node(src string) Node {
 		return self.NodeText(src)
 	case TypeWhitespace:
 		return self.NodeWhitespace(src).Node()
+	case TypeKeyword:
+		return self.NodeKeyword(src)
+	case TypeIdent:
+		return self.NodeIdent(src)
+	case TypeSemicolon:
+		return self.NodeSemicolon(src)
 	case TypeQuoteSingle:
 		return self.NodeQuoteSingle(src)
 	case TypeQuoteDouble:
@@ -34,8 +129,40 @@ func (self Token) Node(src string) Node {
 		return self.NodeDoubleColon(src)
 	case TypeOrdinalParam:
 		return self.NodeOrdinalParam(src)
+	case TypeNumericParam:
+		return self.NodeNumericParam(src)
 	case TypeNamedParam:
 		return self.NodeNamedParam(src)
+	case TypeQuoteDollar:
+		return self.NodeQuoteDollar(src)
+	case TypeQuoteSingleTriple:
+		return self.NodeQuoteSingleTriple(src)
+	case TypeQuoteDoubleTriple:
+		return self.NodeQuoteDoubleTriple(src)
+	case TypeCommentHash:
+		return self.NodeCommentHash(src)
+	case TypeAtParam:
+		return self.NodeAtParam(src)
+	case TypeQuestionParam:
+		return self.NodeQuestionParam(src)
+	case TypeDollarParam:
+		return self.NodeDollarParam(src)
+	case TypeQuoteNational:
+		return self.NodeQuoteNational(src)
+	case TypeQuoteEscape:
+		return self.NodeQuoteEscape(src)
+	case TypeSystemVariable:
+		return self.NodeSystemVariable(src)
+	case TypeDirective:
+		return self.NodeDirective(src)
+	case TypeBatchSeparator:
+		return self.NodeBatchSeparator(src)
+	case TypeTemplateAction:
+		return self.NodeTemplateAction(src)
+	case TypeTemplateStatement:
+		return self.NodeTemplateStatement(src)
+	case TypeTemplateComment:
+		return self.NodeTemplateComment(src)
 	default:
 		panic(fmt.Errorf(`[sqlp] can't convert token %#v to node`, self))
 	}
@@ -51,6 +178,22 @@ func (self Token) NodeWhitespace(src string) NodeWhitespace {
 	return NodeWhitespace(self.Slice(src))
 }
 
+// Used by `Token.Node`.
+func (self Token) NodeKeyword(src string) NodeKeyword {
+	return NodeKeyword(self.Slice(src))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeIdent(src string) NodeIdent {
+	return NodeIdent(self.Slice(src))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeSemicolon(src string) NodeSemicolon {
+	reqStrEq(self.Slice(src), string(rune(semicolon)))
+	return NodeSemicolon{}
+}
+
 // Used by `Token.Node`.
 func (self Token) NodeQuoteSingle(src string) NodeQuoteSingle {
 	return NodeQuoteSingle(tryTrimPrefixSuffixByte(self.Slice(src), quoteSingle, quoteSingle))
@@ -87,7 +230,123 @@ func (self Token) NodeOrdinalParam(src string) NodeOrdinalParam {
 	return NodeOrdinalParam(tryParseInt(tryTrimPrefixByte(self.Slice(src), ordinalPrefix)))
 }
 
+// Used by `Token.Node`.
+func (self Token) NodeNumericParam(src string) NodeNumericParam {
+	return NodeNumericParam(tryParseInt(tryTrimPrefixByte(self.Slice(src), namedPrefix)))
+}
+
 // Used by `Token.Node`.
 func (self Token) NodeNamedParam(src string) NodeNamedParam {
 	return NodeNamedParam(tryTrimPrefixByte(self.Slice(src), namedPrefix))
 }
+
+// Used by `Token.Node`.
+func (self Token) NodeQuoteDollar(src string) NodeQuoteDollar {
+	str := self.Slice(src)
+	delim := dollarDelim(str)
+	if delim == `` {
+		panic(fmt.Errorf(`[sqlp] expected %q to begin with a dollar quote`, str))
+	}
+	return NodeQuoteDollar{
+		Tag:  delim[1 : len(delim)-1],
+		Text: tryTrimPrefixSuffix(str, delim, delim),
+	}
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeQuoteSingleTriple(src string) NodeQuoteSingleTriple {
+	return NodeQuoteSingleTriple(tryTrimPrefixSuffix(self.Slice(src), quoteSingleTriple, quoteSingleTriple))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeQuoteDoubleTriple(src string) NodeQuoteDoubleTriple {
+	return NodeQuoteDoubleTriple(tryTrimPrefixSuffix(self.Slice(src), quoteDoubleTriple, quoteDoubleTriple))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeCommentHash(src string) NodeCommentHash {
+	return NodeCommentHash(tryTrimPrefixByte(self.Slice(src), commentHashPrefix))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeAtParam(src string) NodeAtParam {
+	return NodeAtParam(tryTrimPrefixByte(self.Slice(src), atPrefix))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeQuestionParam(src string) NodeQuestionParam {
+	str := tryTrimPrefixByte(self.Slice(src), questionPrefix)
+	if str == `` {
+		return 0
+	}
+	return NodeQuestionParam(tryParseInt(str))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeDollarParam(src string) NodeDollarParam {
+	return NodeDollarParam(tryTrimPrefixByte(self.Slice(src), ordinalPrefix))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeQuoteNational(src string) NodeQuoteNational {
+	str := self.Slice(src)
+	lower := str != `` && str[0] == nationalLower
+	if !lower {
+		str = tryTrimPrefixByte(str, nationalPrefix)
+	} else {
+		str = str[byteLen:]
+	}
+	return NodeQuoteNational{
+		Text:  tryTrimPrefixSuffixByte(str, quoteSingle, quoteSingle),
+		Lower: lower,
+	}
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeQuoteEscape(src string) NodeQuoteEscape {
+	str := self.Slice(src)
+	lower := str != `` && str[0] == escapeLower
+	if !lower {
+		str = tryTrimPrefixByte(str, escapePrefix)
+	} else {
+		str = str[byteLen:]
+	}
+	return NodeQuoteEscape{
+		Text:  tryTrimPrefixSuffixByte(str, quoteSingle, quoteSingle),
+		Lower: lower,
+	}
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeSystemVariable(src string) NodeSystemVariable {
+	return NodeSystemVariable(tryTrimPrefix(self.Slice(src), systemVarPrefix))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeDirective(src string) NodeDirective {
+	return NodeDirective(self.Slice(src))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeBatchSeparator(src string) NodeBatchSeparator {
+	str := self.Slice(src)
+	if batchSeparatorLen(str) != len(str) {
+		panic(fmt.Errorf(`[sqlp] expected %q to be a batch separator`, str))
+	}
+	return NodeBatchSeparator(str)
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeTemplateAction(src string) NodeTemplateAction {
+	return NodeTemplateAction(tryTrimPrefixSuffix(self.Slice(src), templateOpen, templateClose))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeTemplateStatement(src string) NodeTemplateStatement {
+	return NodeTemplateStatement(tryTrimPrefixSuffix(self.Slice(src), templateStmtOpen, templateStmtClose))
+}
+
+// Used by `Token.Node`.
+func (self Token) NodeTemplateComment(src string) NodeTemplateComment {
+	return NodeTemplateComment(tryTrimPrefixSuffix(self.Slice(src), templateCommOpen, templateCommClose))
+}
@@ -3,6 +3,8 @@ package sqlp
 import (
 	"fmt"
 	"strings"
+
+	"github.com/mitranim/sqlp/scan"
 )
 
 /*
@@ -11,7 +13,7 @@ Incremental parser. Example usage:
 	tokenizer := Tokenizer{Source: `select * from some_table where some_col = $1`}
 
 	for {
-		tok := tokenizer.Next()
+		tok := tokenizer.Token()
 		if tok.IsInvalid() {
 			break
 		}
@@ -20,11 +22,275 @@ Incremental parser. Example usage:
 
 Tokenization is allocation-free, but parsing is always slow, and should be
 amortized by caching whenever possible.
+
+Custom syntax can be supported by providing `Recognizers`, which are tried
+before the built-in ones, or by a dialect `Plugin`, whose recognizers are tried
+after `Recognizers`. Additional grouping delimiters can be provided via
+`Delims`; see `Delim`. Templated SQL can be supported via `Template`.
+
+`Dialect` enables dialect-specific syntax which conflicts with the default
+superset grammar. Currently this includes:
+
+	DialectOracle   : numeric params such as :1, producing `TypeNumericParam`
+	DialectOracle   : "$" in identifiers of named params, such as :some$name
+	DialectMySQL    : "$" in identifiers of named params
+	DialectPostgres : "$" in identifiers of named params
+	DialectBigQuery : triple-quoted strings such as '''text''' and """text"""
+	DialectBigQuery : backslash escapes in quotes, such as 'it\'s'
+	DialectBigQuery : hash comments such as "# text", producing `TypeCommentHash`
+	DialectBigQuery : params such as @name, producing `TypeAtParam`
+	DialectSQLite   : params such as ? and ?1, producing `TypeQuestionParam`
+	DialectSQLite   : params such as @name, producing `TypeAtParam`
+	DialectSQLite   : params such as $name, producing `TypeDollarParam`
+	DialectMSSQL    : national strings such as N'text', producing `TypeQuoteNational`
+	DialectPostgres : escape strings such as E'it\'s', producing `TypeQuoteEscape`
+	DialectMSSQL    : system variables such as @@rowcount, producing `TypeSystemVariable`
+	DialectMSSQL    : params such as @name, producing `TypeAtParam`
+	DialectMySQL    : system variables such as @@sql_mode, producing `TypeSystemVariable`
+	DialectMySQL    : user variables such as @name, producing `TypeAtParam`
+	DialectMySQL    : backslash escapes in strings, such as 'it\'s'
+	DialectMySQL    : hash comments such as "# text", producing `TypeCommentHash`
+
+`DialectAny`, the default, recognizes a superset of the syntax of all dialects,
+except where it conflicts. Other dialects also disable syntax which is not
+valid in them, as reported by `DialectNode.ValidIn`:
+
+	grave quotes such as `text`    : only MySQL, SQLite, BigQuery
+	dollar quotes such as $$text$$ : only Postgres
+	cast operator "::"             : only Postgres
+
+For example, with `DialectMSSQL`, a grave quote is plain text, and with
+`DialectMySQL`, "a::b" is plain text rather than a cast. Ordinal params such as
+"$1" and named params such as ":name" are recognized in every dialect, since
+they're used by this package for binding and rewriting; see `BindNamed`.
+
+In any dialect, "$" directly preceded by an identifier character, as in
+"some$1", is considered part of the identifier, and doesn't begin an ordinal
+param or a dollar-quoted string.
+
+By default, the source is treated as UTF-8, and the tokenizer advances by whole
+characters. Invalid UTF-8 bytes are treated as opaque single-byte characters.
+When `Bytewise` is true, the tokenizer never decodes characters, and advances
+by single bytes, which is intended for legacy encodings such as Latin-1 and
+Windows-1252, for example in old database dumps. All built-in syntax is ASCII,
+so built-in tokens are the same in both modes. However, in bytewise mode,
+custom recognizers and delimiters are tried at every byte offset, including in
+the middle of multi-byte UTF-8 characters. Before producing the first token,
+the tokenizer checks if the source is pure ASCII, which is by far the most
+common case, and if so, advances by single bytes without decoding, since the
+result is the same.
+
+When `Script` is true, the tokenizer recognizes client-side directives found in
+SQL scripts, producing tokens of `TypeDirective`. Directives must begin a
+line, optionally after spaces or tabs, and end at the end of the line.
+Currently this includes:
+
+	\copy ...        : psql meta-commands, beginning with a backslash
+	DELIMITER //     : MySQL client command for changing the statement delimiter
+
+The DELIMITER command is recognized only with `DialectAny` and `DialectMySQL`.
+In other dialects, a line beginning with "delimiter" is ordinary SQL.
+
+With `DialectMSSQL`, a line consisting of the T-SQL batch separator "GO",
+optionally followed by a repeat count such as "GO 5", produces a token of
+`TypeBatchSeparator`.
+
+When `MaxText` is positive, runs of text longer than that many bytes are split
+into multiple consecutive tokens of `TypeText`, which keeps the size of each
+token bounded for streaming consumers, for example when the source contains
+huge lists of literals or data blobs. Splitting happens only at character
+boundaries, so a token may exceed the limit by less than one character. Chunks
+are not aligned with words; consumers which interpret text tokens, such as
+`Splitter`, may not work correctly with this option. By default, there's no
+limit.
+
+When `Keywords` is set, words from the set, as well as from `Plugin.Keywords`,
+produce tokens of `TypeKeyword` rather than being part of `TypeText`, which
+saves analysis tools from splitting text into words. Matching ignores case.
+Only whole words are recognized: a keyword must not be adjacent to identifier
+characters, and must not be preceded or followed by a dot, which excludes
+qualified names such as "users.from". Use `DialectKeywords` for the built-in
+sets. By default, keywords are not recognized, and the output is unaffected.
+
+When `Idents` is true, unquoted identifiers such as "users" and "id" in
+"users.id" produce tokens of `TypeIdent` rather than being part of `TypeText`,
+which allows tools such as rename refactorings and column extraction to work
+with individual identifiers. Identifiers follow the same rules as keywords, and
+may contain "$" in dialects which allow it, such as Postgres. When `Keywords`
+is also set, keywords take priority, except in qualified names such as
+"t.from"; otherwise every unquoted word, including "select", is an
+identifier. Quoted identifiers remain `TypeQuoteDouble` or `TypeQuoteGrave`.
+
+Functions of this package which look for keywords, such as `ClassifyStmt` and
+`AndWhere`, accept `NodeKeyword` and `NodeIdent` as well as `NodeText`.
+
+When `Semicolons` is true, every semicolon outside of quotes and comments
+produces a token of `TypeSemicolon` rather than being part of `TypeText`,
+which allows consumers to find the ends of statements without scanning text.
+The tokenizer doesn't track procedural blocks, so semicolons inside BEGIN ...
+END don't necessarily end statements; see `Splitter`. Functions of this
+package which look for semicolons accept both `NodeSemicolon` and `NodeText`.
+
+`Unclosed` controls what happens when a quote, comment, or template action is
+unterminated at EOF, such as "select 'text". By default, the tokenizer panics,
+and functions such as `Parse` return an error, which suits strict consumers
+such as query binders. With `UnclosedText`, the rest of the source becomes
+`TypeText`, which suits log scrapers and other tools working with truncated
+or malformed text. With `UnclosedClose`, the token has its usual type and
+extends to EOF, `Parser` produces a node as if the missing delimiter was
+present, and `Tokenizer.Diagnostics` reports it, which suits editor tooling.
+In every mode, tokenization remains lossless. Unbalanced grouping delimiters
+such as "(" are not tokenizer errors, and are reported by `Parser` regardless.
 */
 type Tokenizer struct {
-	Source string
-	cursor int
-	next   Token
+	Source      string
+	Recognizers []Recognizer
+	Plugin      *Plugin
+	Delims      []Delim
+	Template    Template
+	Script      bool
+	Dialect     Dialect
+	Bytewise    bool
+	MaxText     int
+	Keywords    *Keywords
+	Idents      bool
+	Semicolons  bool
+	Unclosed    Unclosed
+	cursor      int
+	next        Token
+	closing     string
+	closedTok   Token
+	closedStr   string
+	scanned     bool
+	ascii       bool
+	lines       []int
+	linesSrc    string
+}
+
+/*
+User-defined pair of grouping delimiters, such as `{{` and `}}`. When provided
+to `Tokenizer`, the delimiters are recognized before the built-in syntax,
+producing `TypeDelimOpen` and `TypeDelimClose` tokens. `Parser` turns content
+between them into `DelimNodes`. The tag is arbitrary and may be used to
+distinguish different delimiter pairs. The opening and closing delimiters must
+be non-empty and must differ from each other.
+*/
+type Delim struct {
+	Tag   string
+	Open  string
+	Close string
+}
+
+func (self Delim) isValid() bool {
+	return self.Open != `` && self.Close != `` && self.Open != self.Close
+}
+
+/*
+Custom token recognizer used by `Tokenizer`. Invoked at every position where a
+token may begin, before any built-in recognizers. If the source text at
+`Tokenizer.Cursor` matches the custom syntax, the recognizer must return a
+non-empty token that begins at the cursor, and true. Otherwise it must return
+false. The tokenizer advances past the returned token. Custom token types
+should be `TypeCustom` or higher. To convert custom tokens into nodes, use
+`NodeFactory`.
+
+Example:
+
+	func recognizeSigil(tok *Tokenizer) (Token, bool) {
+		rest := tok.Rest()
+		if !strings.HasPrefix(rest, `%%`) {
+			return Token{}, false
+		}
+		end := strings.Index(rest[2:], `%%`)
+		if end < 0 {
+			return Token{}, false
+		}
+		start := tok.Cursor()
+		return Token{Region{start, start + end + 4}, TypeCustom}, true
+	}
+*/
+type Recognizer func(*Tokenizer) (Token, bool)
+
+// Current position in the source text, as a byte offset. Mostly for use by
+// `Recognizer` functions.
+func (self *Tokenizer) Cursor() int { return self.cursor }
+
+// Remainder of the source text, starting at `Tokenizer.Cursor`. Mostly for use
+// by `Recognizer` functions.
+func (self *Tokenizer) Rest() string { return self.rest() }
+
+/*
+Returns the line and column of the given byte offset in `Tokenizer.Source`, for
+example the start of a token's region, or of a `Diagnostic`. Offsets outside
+the source are clamped to its bounds. The first call builds an index of line
+starts, which makes subsequent calls fast. The index is rebuilt if the source
+is replaced.
+*/
+func (self *Tokenizer) Position(offset int) Position {
+	if self.lines == nil || self.linesSrc != self.Source {
+		self.lines = lineStarts(self.Source)
+		self.linesSrc = self.Source
+	}
+	return positionAt(self.lines, clampOffset(self.Source, offset))
+}
+
+/*
+Returns problems found in the source so far, which the tokenizer recovered
+from. Currently, this only includes a construct which is unterminated at EOF,
+reported with `CodeUnclosed` when `Tokenizer.Unclosed` is `UnclosedClose`. In
+other modes, the result is always empty. Diagnostics are found as a side
+effect of tokenization, and are complete only after reaching EOF.
+*/
+func (self *Tokenizer) Diagnostics() Diagnostics {
+	if self.closedTok.IsInvalid() {
+		return nil
+	}
+	return Diagnostics{{
+		Code:    CodeUnclosed,
+		Region:  self.closedTok.Region,
+		Message: fmt.Sprintf(`expected closing %q, got unexpected EOF`, self.closedStr),
+	}}
+}
+
+/*
+Returns the next token without consuming it: the following call to
+`Tokenizer.Token` returns the same token. Returns `Token{}` at EOF. Useful for
+lookahead in parsers built on top of the tokenizer. The token is scanned again
+when consumed, so peeking costs about as much as consuming. Custom recognizers
+are invoked as usual, and must not depend on being invoked only once per
+position.
+*/
+func (self *Tokenizer) Peek() Token {
+	cursor, next := self.cursor, self.next
+	tok := self.token()
+	self.cursor, self.next = cursor, next
+	return tok
+}
+
+/*
+Moves the tokenizer back to the start of the given token, previously returned
+by `Tokenizer.Token` or `Tokenizer.Peek`, so that the following call to
+`Tokenizer.Token` returns it again. Allows backtracking after speculative
+parsing. Does nothing for `Token{}`, which is returned at EOF. Same as
+`Tokenizer.SetPos` with the start of the token's region.
+*/
+func (self *Tokenizer) Rewind(tok Token) {
+	if !tok.IsInvalid() {
+		self.SetPos(tok.Region[0])
+	}
+}
+
+/*
+Moves the tokenizer to the given byte offset in `Tokenizer.Source`, discarding
+any pending token, so that tokenization resumes from that offset. Offsets
+outside the source are clamped to its bounds. The offset should be the start
+of a token, for example one previously returned by `Tokenizer.Cursor`. Resuming
+in the middle of a token, such as inside a quoted string, produces different
+tokens than scanning from the start.
+*/
+func (self *Tokenizer) SetPos(offset int) {
+	self.cursor = clampOffset(self.Source, offset)
+	self.next = Token{}
 }
 
 /*
@@ -32,19 +298,74 @@ Returns the next token. Upon reaching EOF, returns `Token{}`. Use
 `Token.IsInvalid` to detect end of iteration.
 */
 func (self *Tokenizer) Token() Token {
+	tok := self.token()
+	statToken(tok)
+	return tok
+}
+
+/*
+Returns the next top-level node, or nil at EOF. Same as `Parser.Next` without
+a `NodeFactory`. Provided for compatibility with code written against older
+versions of this package, where the tokenizer produced nodes.
+
+Deprecated: use `Tokenizer.Token` to iterate over tokens, which is much faster,
+and `Token.Node` to convert them to nodes, or `Parser.Next` to parse nodes
+incrementally. This method will be removed in the next major version.
+*/
+func (self *Tokenizer) Next() Node {
+	parser := Parser{Tokenizer: *self}
+	node := parser.Next()
+	*self = parser.Tokenizer
+	return node
+}
+
+func (self *Tokenizer) token() Token {
 	next := self.next
 	if !next.IsInvalid() {
 		self.next = Token{}
 		return next
 	}
 
+	if !self.scanned {
+		self.scanned = true
+		self.ascii = isASCII(self.Source)
+	}
+
 	start := self.cursor
 
 	for self.more() {
+		if self.MaxText > 0 && self.cursor-start >= self.MaxText {
+			break
+		}
+
 		mid := self.cursor
+		if typ := self.maybeRecognized(); typ != TypeInvalid {
+			return self.choose(start, mid, self.cursor, typ)
+		}
+		if typ := self.maybeDelim(); typ != TypeInvalid {
+			return self.choose(start, mid, self.cursor, typ)
+		}
+		if typ := self.maybeTemplate(); typ != TypeInvalid {
+			return self.choose(start, mid, self.cursor, typ)
+		}
+		if self.maybeDirective(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeDirective)
+		}
+		if self.maybeBatchSeparator(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeBatchSeparator)
+		}
 		if self.maybeWhitespace(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeWhitespace)
 		}
+		if typ := self.maybeQuoteTriple(); typ != TypeInvalid {
+			return self.choose(start, mid, self.cursor, typ)
+		}
+		if self.maybeQuoteNational(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeQuoteNational)
+		}
+		if self.maybeQuoteEscape(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeQuoteEscape)
+		}
 		if self.maybeQuoteSingle(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeQuoteSingle)
 		}
@@ -57,18 +378,42 @@ func (self *Tokenizer) Token() Token {
 		if self.maybeCommentLine(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeCommentLine)
 		}
+		if self.maybeCommentHash(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeCommentHash)
+		}
 		if self.maybeCommentBlock(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeCommentBlock)
 		}
 		if self.maybeDoubleColon(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeDoubleColon)
 		}
+		if self.maybeQuoteDollar(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeQuoteDollar)
+		}
 		if self.maybeOrdinalParam(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeOrdinalParam)
 		}
+		if self.maybeNumericParam(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeNumericParam)
+		}
 		if self.maybeNamedParam(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeNamedParam)
 		}
+		if self.maybeSystemVariable(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeSystemVariable)
+		}
+		if self.maybeAtParam(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeAtParam)
+		}
+		if self.maybeDollarParam(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeDollarParam)
+		}
+		if self.maybeQuestionParam(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeQuestionParam)
+		}
+		if self.maybeSemicolon(); self.cursor > mid {
+			return self.choose(start, mid, self.cursor, TypeSemicolon)
+		}
 		if self.maybeParenOpen(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeParenOpen)
 		}
@@ -87,6 +432,9 @@ func (self *Tokenizer) Token() Token {
 		if self.maybeBraceClose(); self.cursor > mid {
 			return self.choose(start, mid, self.cursor, TypeBraceClose)
 		}
+		if typ := self.maybeWord(); typ != TypeInvalid {
+			return self.choose(start, mid, self.cursor, typ)
+		}
 		self.skipChar()
 	}
 
@@ -97,6 +445,10 @@ func (self *Tokenizer) Token() Token {
 }
 
 func (self *Tokenizer) choose(start, mid, end int, typ Type) Token {
+	if self.closing != `` {
+		return self.chooseUnclosed(start, mid, end, typ)
+	}
+
 	prev := Token{Region{start, mid}, TypeText}
 	next := Token{Region{mid, end}, typ}
 
@@ -112,6 +464,23 @@ func (self *Tokenizer) choose(start, mid, end int, typ Type) Token {
 	return next
 }
 
+/*
+Used for a token which is unterminated at EOF, when `Tokenizer.Unclosed` allows
+it. See `Tokenizer.unclosed`.
+*/
+func (self *Tokenizer) chooseUnclosed(start, mid, end int, typ Type) Token {
+	closing := self.closing
+	self.closing = ``
+
+	if self.Unclosed == UnclosedText {
+		return Token{Region{start, end}, TypeText}
+	}
+
+	self.closedTok = Token{Region{mid, end}, typ}
+	self.closedStr = closing
+	return self.choose(start, mid, end, typ)
+}
+
 func (self *Tokenizer) setNext(next Token) {
 	if !self.next.IsInvalid() {
 		panic(fmt.Errorf(
@@ -122,29 +491,330 @@ func (self *Tokenizer) setNext(next Token) {
 	self.next = next
 }
 
+func (self *Tokenizer) maybeRecognized() Type {
+	if len(self.Recognizers) == 0 && self.Plugin == nil {
+		return TypeInvalid
+	}
+	return self.recognized()
+}
+
+/*
+Passes a copy of the tokenizer to custom recognizers. This prevents the
+tokenizer itself from escaping to the heap, which keeps tokenization
+allocation-free when no recognizers are used.
+*/
+func (self *Tokenizer) recognized() Type {
+	for _, fun := range self.Recognizers {
+		if typ := self.recognizedBy(fun); typ != TypeInvalid {
+			return typ
+		}
+	}
+	if self.Plugin != nil {
+		return self.Plugin.recognized(self)
+	}
+	return TypeInvalid
+}
+
+func (self *Tokenizer) recognizedBy(fun Recognizer) Type {
+	if fun == nil {
+		return TypeInvalid
+	}
+
+	tokenizer := *self
+	tok, ok := fun(&tokenizer)
+	if !ok {
+		return TypeInvalid
+	}
+
+	if tok.IsInvalid() || tok.Region[0] != self.cursor || !tok.HasLen() || tok.Region[1] > len(self.Source) {
+		panic(fmt.Errorf(`[sqlp] recognizer returned invalid token %#v at position %v`, tok, self.cursor))
+	}
+
+	self.cursor = tok.Region[1]
+	return tok.Type
+}
+
+func (self *Tokenizer) maybeDelim() Type {
+	for _, delim := range self.Delims {
+		if delim.isValid() && self.skippedString(delim.Open) {
+			return TypeDelimOpen
+		}
+	}
+	for _, delim := range self.Delims {
+		if delim.isValid() && self.skippedString(delim.Close) {
+			return TypeDelimClose
+		}
+	}
+	return TypeInvalid
+}
+
+func (self *Tokenizer) delimByOpen(str string) (Delim, bool) {
+	for _, delim := range self.Delims {
+		if delim.isValid() && delim.Open == str {
+			return delim, true
+		}
+	}
+	return Delim{}, false
+}
+
+func (self *Tokenizer) maybeTemplate() Type {
+	switch self.Template {
+	case TemplateGo:
+		if self.skippedTemplate(templateOpen, templateClose) {
+			return TypeTemplateAction
+		}
+
+	case TemplateJinja:
+		if self.skippedTemplate(templateOpen, templateClose) {
+			return TypeTemplateAction
+		}
+		if self.skippedTemplate(templateStmtOpen, templateStmtClose) {
+			return TypeTemplateStatement
+		}
+		if self.skippedString(templateCommOpen) {
+			self.skipUntilString(templateCommClose)
+			return TypeTemplateComment
+		}
+	}
+	return TypeInvalid
+}
+
+/*
+Skips a template action or statement, such as `{{ ... }}`. String literals and,
+for Go templates, comments inside the action may contain the closing delimiter,
+and are skipped as a whole.
+*/
+func (self *Tokenizer) skippedTemplate(prefix, suffix string) bool {
+	if !self.skippedString(prefix) {
+		return false
+	}
+
+	for self.more() {
+		if self.skippedString(suffix) {
+			return true
+		}
+
+		switch {
+		case self.isNextByte('"') || self.isNextByte('\''):
+			self.maybeStringBetweenBytesEscaped(self.headByte(), self.headByte())
+		case self.Template == TemplateGo && self.isNextByte('`'):
+			self.maybeStringBetweenBytes('`', '`')
+		case self.Template == TemplateGo && self.isNextString(commentBlockPrefix):
+			self.maybeStringBetween(commentBlockPrefix, commentBlockSuffix)
+		default:
+			self.skipChar()
+		}
+	}
+
+	self.unclosed(suffix)
+	return true
+}
+
+func (self *Tokenizer) skipUntilString(suffix string) {
+	for self.more() {
+		if self.skippedString(suffix) {
+			return
+		}
+		self.skipChar()
+	}
+
+	self.unclosed(suffix)
+}
+
+func (self *Tokenizer) maybeDirective() {
+	if !self.Script || !(self.isNextByte(directivePrefix) || self.isNextDelimiterCommand()) || !self.isLineStart() {
+		return
+	}
+	self.skipUntilNewline()
+}
+
+func (self *Tokenizer) maybeBatchSeparator() {
+	if !self.Script || self.Dialect != DialectMSSQL || !(self.isNextByte('G') || self.isNextByte('g')) {
+		return
+	}
+	if size := batchSeparatorLen(self.rest()); size > 0 && self.isLineStart() {
+		self.skipBytes(size)
+	}
+}
+
+func (self *Tokenizer) isNextDelimiterCommand() bool {
+	if !isDialect(self.Dialect, DialectMySQL) {
+		return false
+	}
+	rest := self.rest()
+	return len(rest) > len(delimiterCommand) &&
+		strings.EqualFold(rest[:len(delimiterCommand)], delimiterCommand) &&
+		charsetSpace.has(rest[len(delimiterCommand)])
+}
+
+// True if the cursor is preceded only by spaces or tabs on the current line.
+func (self *Tokenizer) isLineStart() bool {
+	for i := self.cursor - 1; i >= 0; i-- {
+		char := self.Source[i]
+		if charsetNewline.has(char) {
+			return true
+		}
+		if !charsetSpace.has(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// Skips until the next newline, without skipping the newline.
+func (self *Tokenizer) skipUntilNewline() {
+	for self.more() && !charsetNewline.has(self.headByte()) {
+		self.skipChar()
+	}
+}
+
+/*
+Skips whitespace. At the start of the source, also skips a UTF-8 byte order
+mark and zero-width characters, which are often inserted by editors, and would
+otherwise be glued to the first text token.
+*/
 func (self *Tokenizer) maybeWhitespace() {
+	if self.cursor == 0 {
+		self.skipLeadingJunk()
+		return
+	}
 	for self.isNextWhitespace() {
 		self.skipByte()
 	}
 }
 
+func (self *Tokenizer) skipLeadingJunk() {
+	for self.more() {
+		if self.isNextWhitespace() {
+			self.skipByte()
+			continue
+		}
+		if !self.skippedZeroWidth() {
+			return
+		}
+	}
+}
+
+func (self *Tokenizer) skippedZeroWidth() bool {
+	for _, val := range zeroWidthChars {
+		if self.skippedString(val) {
+			return true
+		}
+	}
+	return false
+}
+
 func (self *Tokenizer) maybeQuoteSingle() {
-	self.maybeStringBetweenBytes(quoteSingle, quoteSingle)
+	self.maybeQuote(quoteSingle)
 }
 
 func (self *Tokenizer) maybeQuoteDouble() {
-	self.maybeStringBetweenBytes(quoteDouble, quoteDouble)
+	self.maybeQuote(quoteDouble)
 }
 
 func (self *Tokenizer) maybeQuoteGrave() {
-	self.maybeStringBetweenBytes(quoteGrave, quoteGrave)
+	if NodeQuoteGrave(``).ValidIn(self.Dialect) {
+		self.maybeQuote(quoteGrave)
+	}
 }
 
-func (self *Tokenizer) maybeCommentLine() {
-	if !self.skippedString(commentLinePrefix) {
+/*
+In BigQuery, both strings and quoted identifiers support backslash escapes. In
+MySQL, only strings do, unless disabled by the SQL mode "NO_BACKSLASH_ESCAPES",
+which is not supported here.
+*/
+func (self *Tokenizer) maybeQuote(quote byte) {
+	if self.Dialect == DialectBigQuery || self.Dialect == DialectMySQL && quote != quoteGrave {
+		self.maybeStringBetweenBytesEscaped(quote, quote)
+	} else {
+		self.maybeStringBetweenBytes(quote, quote)
+	}
+}
+
+/*
+Skips a national string such as N'text'. Unlike other quotes, quotes escaped by
+doubling are included in the same token, because the prefix applies to the
+entire literal.
+*/
+func (self *Tokenizer) maybeQuoteNational() {
+	if self.Dialect != DialectMSSQL ||
+		!(self.isNextByte(nationalPrefix) || self.isNextByte(nationalLower)) ||
+		self.left() < 2 || self.Source[self.cursor+1] != quoteSingle ||
+		!self.isIdentBoundary() {
 		return
 	}
 
+	self.skipBytes(2)
+	for self.more() {
+		if self.skippedByte(quoteSingle) {
+			if !self.skippedByte(quoteSingle) {
+				return
+			}
+			continue
+		}
+		self.skipChar()
+	}
+
+	self.unclosedByte(quoteSingle)
+}
+
+func (self *Tokenizer) maybeQuoteEscape() {
+	if !(self.Dialect == DialectAny || self.Dialect == DialectPostgres) ||
+		!(self.isNextByte(escapePrefix) || self.isNextByte(escapeLower)) ||
+		self.left() < 2 || self.Source[self.cursor+1] != quoteSingle ||
+		!self.isIdentBoundary() {
+		return
+	}
+
+	start := self.cursor
+	self.skipBytes(2)
+	for self.more() {
+		if self.skippedByte('\\') {
+			if self.more() {
+				self.skipChar()
+			}
+			continue
+		}
+		if self.skippedByte(quoteSingle) {
+			if !self.skippedByte(quoteSingle) {
+				return
+			}
+			continue
+		}
+		self.skipChar()
+	}
+
+	self.unclosedEscaped(start)
+	self.unclosedByte(quoteSingle)
+}
+
+func (self *Tokenizer) maybeQuoteTriple() Type {
+	if self.Dialect != DialectBigQuery {
+		return TypeInvalid
+	}
+	if self.skippedStringEscaped(quoteSingleTriple) {
+		return TypeQuoteSingleTriple
+	}
+	if self.skippedStringEscaped(quoteDoubleTriple) {
+		return TypeQuoteDoubleTriple
+	}
+	return TypeInvalid
+}
+
+func (self *Tokenizer) maybeCommentLine() {
+	if self.skippedString(commentLinePrefix) {
+		self.skipLine()
+	}
+}
+
+func (self *Tokenizer) maybeCommentHash() {
+	if (self.Dialect == DialectBigQuery || self.Dialect == DialectMySQL) && self.skippedByte(commentHashPrefix) {
+		self.skipLine()
+	}
+}
+
+// Skips until the next newline, including the newline.
+func (self *Tokenizer) skipLine() {
 	for self.more() {
 		if self.skippedNewline() {
 			break
@@ -158,11 +828,34 @@ func (self *Tokenizer) maybeCommentBlock() {
 }
 
 func (self *Tokenizer) maybeDoubleColon() {
-	self.maybeSkipString(castPrefix)
+	if (NodeDoubleColon{}).ValidIn(self.Dialect) {
+		self.maybeSkipString(castPrefix)
+	}
+}
+
+func (self *Tokenizer) maybeQuoteDollar() {
+	if !(NodeQuoteDollar{}).ValidIn(self.Dialect) || !self.isNextByte(dollarQuote) || !self.isIdentBoundary() {
+		return
+	}
+
+	delim := dollarDelim(self.rest())
+	if delim == `` {
+		return
+	}
+
+	self.skipBytes(len(delim))
+	for self.more() {
+		if self.skippedString(delim) {
+			return
+		}
+		self.skipChar()
+	}
+
+	self.unclosed(delim)
 }
 
 func (self *Tokenizer) maybeOrdinalParam() {
-	if !self.isNextByte(ordinalPrefix) {
+	if !self.isNextByte(ordinalPrefix) || !self.isIdentBoundary() {
 		return
 	}
 
@@ -175,12 +868,30 @@ func (self *Tokenizer) maybeOrdinalParam() {
 	self.skipBytes(ordinalPrefixLen + size)
 }
 
+func (self *Tokenizer) maybeNumericParam() {
+	if self.Dialect != DialectOracle || !self.isNextByte(namedPrefix) {
+		return
+	}
+
+	digits := prefixDigits(self.restAfter(namedPrefixLen))
+	size := len(digits)
+	if size == 0 {
+		return
+	}
+
+	self.skipBytes(namedPrefixLen + size)
+}
+
+/*
+Skips a named param such as ":name". In dialects without the cast operator
+"::", the second colon of "::name" doesn't begin a param.
+*/
 func (self *Tokenizer) maybeNamedParam() {
-	if !self.isNextByte(namedPrefix) {
+	if !self.isNextByte(namedPrefix) || (self.cursor > 0 && self.Source[self.cursor-1] == namedPrefix) {
 		return
 	}
 
-	ident := prefixIdent(self.restAfter(namedPrefixLen))
+	ident := prefixIdentWith(self.restAfter(namedPrefixLen), self.identChars())
 	size := len(ident)
 	if size == 0 {
 		return
@@ -189,6 +900,125 @@ func (self *Tokenizer) maybeNamedParam() {
 	self.skipBytes(namedPrefixLen + size)
 }
 
+/*
+Skips an "at" param such as "@name". The second "at" sign of a system variable
+such as "@@version" doesn't begin a param.
+*/
+func (self *Tokenizer) maybeAtParam() {
+	if !self.isAtParamDialect() || !self.isNextByte(atPrefix) || !self.isIdentBoundary() ||
+		(self.cursor > 0 && self.Source[self.cursor-1] == atPrefix) {
+		return
+	}
+
+	ident := prefixIdent(self.restAfter(atPrefixLen))
+	size := len(ident)
+	if size == 0 {
+		return
+	}
+
+	self.skipBytes(atPrefixLen + size)
+}
+
+func (self *Tokenizer) isAtParamDialect() bool {
+	switch self.Dialect {
+	case DialectBigQuery, DialectSQLite, DialectMSSQL, DialectMySQL:
+		return true
+	default:
+		return false
+	}
+}
+
+// Skips a system variable such as "@@rowcount".
+func (self *Tokenizer) maybeSystemVariable() {
+	if !(self.Dialect == DialectMSSQL || self.Dialect == DialectMySQL) || !self.isNextString(systemVarPrefix) || !self.isIdentBoundary() {
+		return
+	}
+
+	ident := prefixIdent(self.restAfter(len(systemVarPrefix)))
+	size := len(ident)
+	if size == 0 {
+		return
+	}
+
+	self.skipBytes(len(systemVarPrefix) + size)
+}
+
+// Skips a named param such as "$name". Params such as "$1" are ordinal.
+func (self *Tokenizer) maybeDollarParam() {
+	if self.Dialect != DialectSQLite || !self.isNextByte(ordinalPrefix) || !self.isIdentBoundary() {
+		return
+	}
+
+	ident := prefixIdent(self.restAfter(ordinalPrefixLen))
+	size := len(ident)
+	if size == 0 {
+		return
+	}
+
+	self.skipBytes(ordinalPrefixLen + size)
+}
+
+// Skips a positional param "?" or a numbered param such as "?1".
+func (self *Tokenizer) maybeQuestionParam() {
+	if self.Dialect != DialectSQLite || !self.isNextByte(questionPrefix) {
+		return
+	}
+	self.skipBytes(questionPrefixLen + len(prefixDigits(self.restAfter(questionPrefixLen))))
+}
+
+/*
+True if the cursor is not preceded by a character which may occur inside an
+identifier, including "$" and non-ASCII characters. Used to avoid detecting
+params and dollar quotes in the middle of identifiers such as "some$1".
+*/
+func (self *Tokenizer) isIdentBoundary() bool {
+	return self.cursor == 0 || !charsetIdentLike.has(self.Source[self.cursor-1])
+}
+
+// Charset for identifier characters after the first, depending on the dialect.
+func (self *Tokenizer) identChars() *charset {
+	switch self.Dialect {
+	case DialectPostgres, DialectMySQL, DialectOracle:
+		return charsetIdentDollar
+	default:
+		return charsetIdent
+	}
+}
+
+/*
+Skips a whole word which is a keyword from `Tokenizer.Keywords` or
+`Plugin.Keywords`, or when `Tokenizer.Idents` is true, any unquoted
+identifier. Keywords take priority, except in qualified names.
+*/
+func (self *Tokenizer) maybeWord() Type {
+	if (self.Keywords == nil && !self.Idents) || !self.isIdentBoundary() || !charsetIdentStart.has(self.headByte()) {
+		return TypeInvalid
+	}
+
+	word := prefixIdentWith(self.rest(), self.identChars())
+	next := self.restAfter(len(word))
+	if next != `` && charsetIdentLike.has(next[0]) {
+		return TypeInvalid
+	}
+
+	qualified := (self.cursor > 0 && self.Source[self.cursor-1] == '.') || (next != `` && next[0] == '.')
+	if self.Keywords != nil && !qualified && (self.Keywords.Has(word) || self.Plugin.IsKeyword(word)) {
+		self.skipBytes(len(word))
+		return TypeKeyword
+	}
+	if self.Idents {
+		self.skipBytes(len(word))
+		return TypeIdent
+	}
+	return TypeInvalid
+}
+
+func (self *Tokenizer) maybeSemicolon() {
+	if self.Semicolons {
+		self.maybeSkipByte(semicolon)
+	}
+}
+
 func (self *Tokenizer) maybeParenOpen() {
 	self.maybeSkipByte(parenOpen)
 }
@@ -214,35 +1044,88 @@ func (self *Tokenizer) maybeBraceClose() {
 }
 
 func (self *Tokenizer) maybeStringBetween(prefix string, suffix string) {
-	if !self.skippedString(prefix) {
-		return
+	size, ok := scan.BetweenStrings(self.rest(), prefix, suffix)
+	if self.skippedUnclosed(size, ok) {
+		self.unclosed(suffix)
 	}
+}
 
-	for self.more() {
-		if self.isNextString(suffix) {
-			self.skipBytes(len(suffix))
-			return
-		}
-		self.skipChar()
+// Faster than `maybeStringBetween`, enough to make a difference in benchmarks.
+func (self *Tokenizer) maybeStringBetweenBytes(prefix byte, suffix byte) {
+	size, ok := scan.Between(self.rest(), prefix, suffix)
+	if self.skippedUnclosed(size, ok) {
+		self.unclosedByte(suffix)
 	}
+}
 
-	panic(fmt.Errorf(`[sqlp] expected closing %q, got unexpected EOF`, suffix))
+// Similar to `maybeStringBetweenBytes`, but skips any character preceded by a
+// backslash.
+func (self *Tokenizer) maybeStringBetweenBytesEscaped(prefix byte, suffix byte) {
+	start := self.cursor
+	size, ok := scan.BetweenEscaped(self.rest(), prefix, suffix)
+	if self.skippedUnclosed(size, ok) {
+		self.unclosedEscaped(start)
+		self.unclosedByte(suffix)
+	}
 }
 
-// Faster than `maybeStringBetween`, enough to make a difference in benchmarks.
-func (self *Tokenizer) maybeStringBetweenBytes(prefix byte, suffix byte) {
-	if !self.skippedByte(prefix) {
-		return
+/*
+Similar to `maybeStringBetween` with the same prefix and suffix, but skips any
+character preceded by a backslash. Returns true if the source begins with the
+delimiter.
+*/
+func (self *Tokenizer) skippedStringEscaped(delim string) bool {
+	start := self.cursor
+	size, ok := scan.BetweenStringsEscaped(self.rest(), delim, delim)
+	if self.skippedUnclosed(size, ok) {
+		self.unclosedEscaped(start)
+		self.unclosed(delim)
 	}
+	return size > 0
+}
 
-	for self.more() {
-		if self.skippedByte(suffix) {
-			return
-		}
-		self.skipChar()
+/*
+Skips a span scanned by one of the "Between" functions of package "scan", and
+returns true if it's unterminated. An unterminated span is skipped to EOF,
+which allows to report the position of the error.
+*/
+func (self *Tokenizer) skippedUnclosed(size int, ok bool) bool {
+	self.skipBytes(size)
+	return size > 0 && !ok
+}
+
+/*
+Handles a construct which is unterminated at EOF, according to
+`Tokenizer.Unclosed`. Either panics, or remembers the missing closing
+delimiter, which is used by `Tokenizer.chooseUnclosed`. For nested constructs,
+such as a string inside a template action, delimiters are accumulated from the
+innermost outwards.
+*/
+func (self *Tokenizer) unclosed(suffix string) {
+	if self.Unclosed == UnclosedError {
+		panic(fmt.Errorf(`[sqlp] expected closing %q, got unexpected EOF`, suffix))
 	}
+	self.closing += suffix
+}
 
-	panic(fmt.Errorf(`[sqlp] expected closing %q, got unexpected EOF`, rune(suffix)))
+// Same as `Tokenizer.unclosed`, but for a single-byte delimiter, which is quoted
+// as a character in the error message.
+func (self *Tokenizer) unclosedByte(suffix byte) {
+	if self.Unclosed == UnclosedError {
+		panic(fmt.Errorf(`[sqlp] expected closing %q, got unexpected EOF`, rune(suffix)))
+	}
+	self.closing += string(rune(suffix))
+}
+
+/*
+For an unterminated string with backslash escapes, which begins at the given
+offset: if the source ends with a dangling backslash, such as 'text\, the
+closing delimiter would be escaped, so another backslash must precede it.
+*/
+func (self *Tokenizer) unclosedEscaped(start int) {
+	if self.Unclosed != UnclosedError && hasDanglingEscape(self.Source[start:]) {
+		self.closing += `\`
+	}
 }
 
 func (self *Tokenizer) more() bool {
@@ -291,7 +1174,16 @@ func (self *Tokenizer) skipByte() { self.skipBytes(1) }
 
 func (self *Tokenizer) skipBytes(count int) { self.cursor += count }
 
+// Small enough to be inlined, which makes a difference in benchmarks.
 func (self *Tokenizer) skipChar() {
+	if self.ascii || self.Bytewise {
+		self.cursor++
+	} else {
+		self.skipCharUtf8()
+	}
+}
+
+func (self *Tokenizer) skipCharUtf8() {
 	_, size := headChar(self.rest())
 	self.skipBytes(size)
 }
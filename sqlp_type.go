@@ -1,27 +1,218 @@
 package sqlp
 
-// Type of a `Token` generated by `Tokenizer`.
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Type of a `Token` generated by `Tokenizer`.
+
+The numeric values of the types are explicit and permanent: each type keeps its
+value across versions of this package, new types get new values, and values
+of removed types are never reused. The names returned by `Type.String` are
+permanent too. This allows to serialize tokens, either as numbers or as names
+via `Type.MarshalText`, and decode them with a different version of the
+package. A value below `TypeCustom` which is unknown to the current version,
+such as a type added in a newer version, is preserved as-is; see
+`Type.IsKnown`.
+*/
 type Type byte
 
+// Do not reorder or renumber. Add new types at the end.
 const (
-	TypeInvalid Type = iota
-	TypeText
-	TypeWhitespace
-	TypeQuoteSingle
-	TypeQuoteDouble
-	TypeQuoteGrave
-	TypeCommentLine
-	TypeCommentBlock
-	TypeDoubleColon
-	TypeOrdinalParam
-	TypeNamedParam
-	TypeParenOpen
-	TypeParenClose
-	TypeBracketOpen
-	TypeBracketClose
-	TypeBraceOpen
-	TypeBraceClose
+	TypeInvalid           Type = 0
+	TypeText              Type = 1
+	TypeWhitespace        Type = 2
+	TypeQuoteSingle       Type = 3
+	TypeQuoteDouble       Type = 4
+	TypeQuoteGrave        Type = 5
+	TypeCommentLine       Type = 6
+	TypeCommentBlock      Type = 7
+	TypeDoubleColon       Type = 8
+	TypeOrdinalParam      Type = 9
+	TypeNamedParam        Type = 10
+	TypeParenOpen         Type = 11
+	TypeParenClose        Type = 12
+	TypeBracketOpen       Type = 13
+	TypeBracketClose      Type = 14
+	TypeBraceOpen         Type = 15
+	TypeBraceClose        Type = 16
+	TypeDelimOpen         Type = 17
+	TypeDelimClose        Type = 18
+	TypeTemplateAction    Type = 19
+	TypeTemplateStatement Type = 20
+	TypeTemplateComment   Type = 21
+	TypeQuoteDollar       Type = 22
+	TypeDirective         Type = 23
+	TypeNumericParam      Type = 24
+	TypeQuoteSingleTriple Type = 25
+	TypeQuoteDoubleTriple Type = 26
+	TypeCommentHash       Type = 27
+	TypeAtParam           Type = 28
+	TypeQuestionParam     Type = 29
+	TypeDollarParam       Type = 30
+	TypeQuoteNational     Type = 31
+	TypeSystemVariable    Type = 32
+	TypeBatchSeparator    Type = 33
+	TypeQuoteEscape       Type = 34
+	TypeKeyword           Type = 35
+	TypeIdent             Type = 36
+	TypeSemicolon         Type = 37
 )
 
+/*
+Starting value for user-defined token types, which may be produced by a custom
+`Recognizer`. Values below this are reserved by this package.
+*/
+const TypeCustom Type = 128
+
 // True if zero. Used to detect end of tokenization.
 func (self Type) IsInvalid() bool { return self == TypeInvalid }
+
+/*
+Implement `fmt.Stringer`. Returns a short lowercase name used by `TokensString`.
+For values that don't correspond to a known type, returns a numeric
+representation such as "type(123)".
+*/
+func (self Type) String() string {
+	if int(self) < len(typeNames) && typeNames[self] != `` {
+		return typeNames[self]
+	}
+	return `type(` + strconv.Itoa(int(self)) + `)`
+}
+
+/*
+True if the type is one of the types defined by this version of the package,
+excluding `TypeInvalid`. False for custom types, and for reserved values which
+may be defined by other versions.
+*/
+func (self Type) IsKnown() bool {
+	return self != TypeInvalid && int(self) < len(typeNames) && typeNames[self] != ``
+}
+
+/*
+Implement `encoding.TextMarshaler`, encoding the type as its name, as returned
+by `Type.String`. Unknown types are encoded in the numeric form such as
+"type(123)", which is decoded back to the same value.
+*/
+func (self Type) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// Implement `encoding.TextUnmarshaler`. Inverse of `Type.MarshalText`.
+func (self *Type) UnmarshalText(src []byte) error {
+	val, err := ParseType(string(src))
+	if err != nil {
+		return err
+	}
+	*self = val
+	return nil
+}
+
+/*
+Inverse of `Type.String`. Parses a type name such as "text", or the numeric
+form such as "type(123)", which is used for unknown and custom types.
+*/
+func ParseType(str string) (Type, error) {
+	for i, name := range typeNames {
+		if name != `` && name == str {
+			return Type(i), nil
+		}
+	}
+
+	if strings.HasPrefix(str, `type(`) && strings.HasSuffix(str, `)`) {
+		val, err := strconv.ParseUint(str[len(`type(`):len(str)-len(`)`)], 10, 8)
+		if err == nil {
+			return Type(val), nil
+		}
+	}
+	return TypeInvalid, fmt.Errorf(`[sqlp] unknown token type %q`, str)
+}
+
+var typeNames = [...]string{
+	TypeInvalid:           `invalid`,
+	TypeText:              `text`,
+	TypeWhitespace:        `whitespace`,
+	TypeQuoteSingle:       `quote_single`,
+	TypeQuoteDouble:       `quote_double`,
+	TypeQuoteGrave:        `quote_grave`,
+	TypeCommentLine:       `comment_line`,
+	TypeCommentBlock:      `comment_block`,
+	TypeDoubleColon:       `double_colon`,
+	TypeOrdinalParam:      `ordinal_param`,
+	TypeNamedParam:        `named_param`,
+	TypeParenOpen:         `paren_open`,
+	TypeParenClose:        `paren_close`,
+	TypeBracketOpen:       `bracket_open`,
+	TypeBracketClose:      `bracket_close`,
+	TypeBraceOpen:         `brace_open`,
+	TypeBraceClose:        `brace_close`,
+	TypeDelimOpen:         `delim_open`,
+	TypeDelimClose:        `delim_close`,
+	TypeTemplateAction:    `template_action`,
+	TypeTemplateStatement: `template_statement`,
+	TypeTemplateComment:   `template_comment`,
+	TypeQuoteDollar:       `quote_dollar`,
+	TypeDirective:         `directive`,
+	TypeNumericParam:      `numeric_param`,
+	TypeQuoteSingleTriple: `quote_single_triple`,
+	TypeQuoteDoubleTriple: `quote_double_triple`,
+	TypeCommentHash:       `comment_hash`,
+	TypeAtParam:           `at_param`,
+	TypeQuestionParam:     `question_param`,
+	TypeDollarParam:       `dollar_param`,
+	TypeQuoteNational:     `quote_national`,
+	TypeSystemVariable:    `system_variable`,
+	TypeBatchSeparator:    `batch_separator`,
+	TypeQuoteEscape:       `quote_escape`,
+	TypeKeyword:           `keyword`,
+	TypeIdent:             `ident`,
+	TypeSemicolon:         `semicolon`,
+}
+
+/*
+Template syntax recognized by `Tokenizer`, in addition to SQL. See
+`Tokenizer.Template`. The zero value disables template support.
+*/
+type Template byte
+
+const (
+	TemplateNone Template = iota
+
+	// Go "text/template" syntax. Actions `{{ ... }}` are treated as opaque
+	// atomic tokens of `TypeTemplateAction`, producing `NodeTemplateAction`.
+	TemplateGo
+
+	// Jinja syntax, as used by DBT. Expressions `{{ ... }}`, statements
+	// `{% ... %}`, and comments `{# ... #}` are treated as opaque atomic tokens,
+	// producing `NodeTemplateAction`, `NodeTemplateStatement`, and
+	// `NodeTemplateComment` respectively.
+	TemplateJinja
+)
+
+/*
+Behavior of `Tokenizer` for a quote, comment, or template action which is
+unterminated at EOF. See `Tokenizer.Unclosed`. The zero value is
+`UnclosedError`.
+*/
+type Unclosed byte
+
+const (
+	// Panic in `Tokenizer.Token`, which makes functions such as `Parse` return
+	// an error.
+	UnclosedError Unclosed = iota
+
+	// Treat the unterminated construct and the rest of the source as
+	// `TypeText`.
+	UnclosedText
+
+	// Produce a token of the usual type, extending to EOF, and report a
+	// `Diagnostic` with `CodeUnclosed` via `Tokenizer.Diagnostics`. `Parser`
+	// produces a node as if the missing delimiter was present.
+	UnclosedClose
+)
+
+// Diagnostic code used by `Tokenizer.Diagnostics`.
+const CodeUnclosed = `E_UNCLOSED`
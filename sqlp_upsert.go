@@ -0,0 +1,64 @@
+package sqlp
+
+import "fmt"
+
+/*
+Generates an upsert clause for Postgres and SQLite, to be appended to an INSERT
+statement:
+
+	on conflict ("id") do update set "name" = excluded."name", "email" = excluded."email"
+
+Column names must be unqualified. They're always quoted, like in `QuoteIdent`,
+making the result safe for user-supplied names; a name containing a dot is
+treated as a single identifier. The result begins with a space,
+so that it can be appended directly to the nodes of an INSERT statement without
+trailing semicolons. When there are no columns to update, generates "do
+nothing" instead. The conflict target may be empty only with "do nothing",
+because "do update" requires it; panics when it's empty and there are columns
+to update. Doesn't modify the inputs.
+
+Example:
+
+	nodes = append(nodes, sqlp.OnConflictUpdate(
+		[]string{`name`, `email`},
+		[]string{`id`},
+	)...)
+*/
+func OnConflictUpdate(cols []string, conflictTarget []string) Nodes {
+	if len(conflictTarget) == 0 && len(cols) > 0 {
+		panic(fmt.Errorf(`[sqlp] invalid upsert: "do update" requires a conflict target`))
+	}
+
+	out := Nodes{
+		nodeWhitespaceSingle, NodeText(`on`),
+		nodeWhitespaceSingle, NodeText(`conflict`),
+	}
+
+	if len(conflictTarget) > 0 {
+		target := make(ParenNodes, 0, len(conflictTarget)*3)
+		for i, col := range conflictTarget {
+			if i > 0 {
+				target = append(target, NodeText(`,`), nodeWhitespaceSingle)
+			}
+			target = append(target, quoteIdentPart(col))
+		}
+		out = append(out, nodeWhitespaceSingle, target)
+	}
+
+	out = append(out, nodeWhitespaceSingle, NodeText(`do`), nodeWhitespaceSingle)
+	if len(cols) == 0 {
+		return append(out, NodeText(`nothing`))
+	}
+
+	out = append(out, NodeText(`update`), nodeWhitespaceSingle, NodeText(`set`), nodeWhitespaceSingle)
+	for i, col := range cols {
+		if i > 0 {
+			out = append(out, NodeText(`,`), nodeWhitespaceSingle)
+		}
+		out = append(out,
+			quoteIdentPart(col), nodeWhitespaceSingle, NodeText(`=`), nodeWhitespaceSingle,
+			NodeText(`excluded.`), quoteIdentPart(col),
+		)
+	}
+	return out
+}
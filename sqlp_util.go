@@ -6,28 +6,53 @@ import (
 	"strings"
 	"unicode/utf8"
 	"unsafe"
+
+	"github.com/mitranim/sqlp/scan"
 )
 
 const (
 	ordinalPrefix      = '$'
+	dollarQuote        = '$'
+	directivePrefix    = '\\'
+	delimiterCommand   = `delimiter`
+	batchSeparator     = `go`
 	namedPrefix        = ':'
 	castPrefix         = `::`
 	commentLinePrefix  = `--`
+	commentHashPrefix  = '#'
 	commentBlockPrefix = `/*`
 	commentBlockSuffix = `*/`
 	quoteSingle        = '\''
 	quoteDouble        = '"'
 	quoteGrave         = '`'
+	quoteSingleTriple  = `'''`
+	quoteDoubleTriple  = `"""`
+	atPrefix           = '@'
+	questionPrefix     = '?'
+	nationalPrefix     = 'N'
+	nationalLower      = 'n'
+	escapePrefix       = 'E'
+	escapeLower        = 'e'
+	systemVarPrefix    = `@@`
 	parenOpen          = '('
 	parenClose         = ')'
 	bracketOpen        = '['
 	bracketClose       = ']'
 	braceOpen          = '{'
 	braceClose         = '}'
+	semicolon          = ';'
+	templateOpen       = `{{`
+	templateClose      = `}}`
+	templateStmtOpen   = `{%`
+	templateStmtClose  = `%}`
+	templateCommOpen   = `{#`
+	templateCommClose  = `#}`
 
-	byteLen          = 1
-	ordinalPrefixLen = byteLen
-	namedPrefixLen   = byteLen
+	byteLen           = 1
+	ordinalPrefixLen  = byteLen
+	namedPrefixLen    = byteLen
+	atPrefixLen       = byteLen
+	questionPrefixLen = byteLen
 )
 
 var (
@@ -49,30 +74,51 @@ func rec(ptr *error) {
 	panic(val)
 }
 
-func prefixDigits(str string) string {
-	for i := range str {
-		if !charsetDigitDec.has(str[i]) {
-			return str[:i]
-		}
+func prefixDigits(str string) string { return scan.PrefixDigits(str) }
+
+/*
+If the line at the start of the string is a T-SQL batch separator such as "GO"
+or "GO 5", returns its length, excluding the newline. Otherwise returns 0.
+*/
+func batchSeparatorLen(str string) int {
+	if !(len(str) >= len(batchSeparator) && strings.EqualFold(str[:len(batchSeparator)], batchSeparator)) {
+		return 0
 	}
-	return str
-}
 
-func prefixIdent(str string) string {
-	for i := range str {
-		if i == 0 {
-			if !charsetIdentStart.has(str[i]) {
-				return ""
-			}
-		} else {
-			if !charsetIdent.has(str[i]) {
-				return str[:i]
-			}
-		}
+	size := len(batchSeparator)
+	for size < len(str) && charsetSpace.has(str[size]) {
+		size++
+	}
+	if size > len(batchSeparator) {
+		size += len(prefixDigits(str[size:]))
+	}
+	for size < len(str) && charsetSpace.has(str[size]) {
+		size++
 	}
-	return str
+
+	if size < len(str) && !charsetNewline.has(str[size]) {
+		return 0
+	}
+	return size
+}
+
+func prefixIdent(str string) string { return scan.PrefixIdent(str) }
+
+// Similar to `prefixIdent`, but uses the given charset for characters other
+// than the first.
+func prefixIdentWith(str string, chars *charset) string {
+	return scan.PrefixIdentWith(str, (*scan.Charset)(chars))
 }
 
+// Returns the longest prefix consisting of characters from the given charset.
+func prefixWith(str string, chars *charset) string {
+	return scan.PrefixWith(str, (*scan.Charset)(chars))
+}
+
+// Returns the opening delimiter of a dollar-quoted string such as `$$` or
+// `$tag$` at the start of the input, or an empty string.
+func dollarDelim(str string) string { return scan.DollarDelim(str) }
+
 func tryParseInt(str string) int64 {
 	num, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
@@ -81,35 +127,49 @@ func tryParseInt(str string) int64 {
 	return num
 }
 
-type charset [256]bool
+// Byte order mark and zero-width characters skipped at the start of the source.
+var zeroWidthChars = [...]string{
+	"\ufeff", // Byte order mark, also zero-width no-break space.
+	"\u200b", // Zero-width space.
+	"\u200c", // Zero-width non-joiner.
+	"\u200d", // Zero-width joiner.
+	"\u2060", // Word joiner.
+}
+
+// Same as `scan.Charset`, with short unexported methods for internal use.
+type charset scan.Charset
 
 func (self *charset) has(val byte) bool { return self[val] }
 
 func (self *charset) addStr(vals string) *charset {
-	for _, val := range vals {
-		self[val] = true
-	}
+	(*scan.Charset)(self).AddStr(vals)
 	return self
 }
 
 func (self *charset) addSet(vals *charset) *charset {
-	for i, val := range vals {
-		if val {
-			self[i] = true
-		}
-	}
+	(*scan.Charset)(self).AddSet((*scan.Charset)(vals))
 	return self
 }
 
+// Private copies of the sets of "scan", which callers may modify by mistake.
 var (
-	charsetDigitDec   = new(charset).addStr(`0123456789`)
-	charsetIdentStart = new(charset).addStr(`ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_`)
-	charsetIdent      = new(charset).addSet(charsetIdentStart).addSet(charsetDigitDec)
-	charsetSpace      = new(charset).addStr(" \t\v")
-	charsetNewline    = new(charset).addStr("\r\n")
-	charsetWhitespace = new(charset).addSet(charsetSpace).addSet(charsetNewline)
+	charsetDigitDec    = copyCharset(scan.DigitDec)
+	charsetDigitOct    = copyCharset(scan.DigitOct)
+	charsetDigitHex    = copyCharset(scan.DigitHex)
+	charsetIdentStart  = copyCharset(scan.IdentStart)
+	charsetIdent       = copyCharset(scan.Ident)
+	charsetIdentDollar = copyCharset(scan.IdentDollar)
+	charsetIdentLike   = copyCharset(scan.IdentLike)
+	charsetNonASCII    = copyCharset(scan.NonASCII)
+	charsetSpace       = copyCharset(scan.Space)
+	charsetNewline     = copyCharset(scan.Newline)
+	charsetWhitespace  = copyCharset(scan.Whitespace)
 )
 
+func copyCharset(val *scan.Charset) *charset {
+	return (*charset)(new(scan.Charset).AddSet(val))
+}
+
 func appenderStr(val interface{ AppendTo([]byte) []byte }) string {
 	return bytesToMutableString(val.AppendTo(nil))
 }
@@ -135,6 +195,15 @@ func headChar(str string) (rune, int) {
 	return utf8.DecodeRuneInString(str)
 }
 
+func maxInt(one, two int) int {
+	if one > two {
+		return one
+	}
+	return two
+}
+
+func isASCII(str string) bool { return scan.IsASCII(str) }
+
 func tryTrimPrefixByte(val string, prefix byte) string {
 	if !(len(val) >= byteLen && val[0] == prefix) {
 		panic(fmt.Errorf(`[sqlp] expected %q to begin with %q`, val, rune(prefix)))
@@ -142,6 +211,15 @@ func tryTrimPrefixByte(val string, prefix byte) string {
 	return val[byteLen:]
 }
 
+// True if the string ends with an odd number of backslashes.
+func hasDanglingEscape(val string) bool {
+	count := 0
+	for i := len(val) - 1; i >= 0 && val[i] == '\\'; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
 func tryTrimPrefix(val, prefix string) string {
 	if !strings.HasPrefix(val, prefix) {
 		panic(fmt.Errorf(`[sqlp] expected %q to begin with %q`, val, prefix))
@@ -172,3 +250,12 @@ func reqStrEq(val, exp string) {
 	}
 	panic(fmt.Errorf(`[sqlp] expected token %q, found %q`, exp, val))
 }
+
+func hasString(vals []string, val string) bool {
+	for _, item := range vals {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+Command for generating Go code from named queries in ".sql" files, via the
+"sqlpgen" package. Intended for "go:generate":
+
+	//go:generate go run github.com/mitranim/sqlp/sqlpgen/cmd/sqlpgen -out queries_gen.go queries
+
+Arguments are ".sql" files or directories, which are searched recursively.
+Flags:
+
+	-pkg  : package of the generated file; defaults to $GOPACKAGE, set by "go generate"
+	-out  : path of the generated file; defaults to stdout
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mitranim/sqlp/sqlpgen"
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet(`sqlpgen`, flag.ContinueOnError)
+	pkg := flags.String(`pkg`, os.Getenv(`GOPACKAGE`), `package of the generated file`)
+	out := flags.String(`out`, ``, `path of the generated file; defaults to stdout`)
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *pkg == `` {
+		return fmt.Errorf(`[sqlpgen] missing package name: use -pkg or run via "go generate"`)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf(`[sqlpgen] missing input files or directories`)
+	}
+
+	var queries []sqlpgen.Query
+	for _, path := range flags.Args() {
+		vals, err := parsePath(path)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, vals...)
+	}
+
+	src, err := sqlpgen.Generate(*pkg, queries)
+	if err != nil {
+		return err
+	}
+
+	if *out == `` {
+		_, err = stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*out, src, 0o666)
+}
+
+func parsePath(path string) ([]sqlpgen.Query, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		queries, err := sqlpgen.ParseFS(os.DirFS(path))
+		if err != nil {
+			return nil, err
+		}
+		for i := range queries {
+			queries[i].File = filepath.ToSlash(filepath.Join(path, queries[i].File))
+		}
+		return queries, nil
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return sqlpgen.ParseFile(filepath.ToSlash(path), string(src))
+}
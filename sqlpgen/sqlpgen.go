@@ -0,0 +1,502 @@
+/*
+Code generator for named queries stored in ".sql" files. Emits a Go file with a
+constant per query, ready for "database/sql", and a params struct per query
+with a `Bind` method which returns the query and its arguments in order. The
+generated code doesn't depend on sqlp. Intended for "go:generate", via the
+"sqlpgen/cmd/sqlpgen" command:
+
+	//go:generate go run github.com/mitranim/sqlp/sqlpgen/cmd/sqlpgen -out queries_gen.go queries
+
+Each query begins with a line comment specifying its name, in the format used
+by other tools such as sqlc:
+
+	-- name: UserByEmail
+	select * from users where email = :email::text and active = :active
+
+Named parameters such as ":email" are converted to ordinal parameters such as
+"$1", and become fields of the params struct. Casts directly following
+parameters, such as "::text", serve as type hints for the fields; see
+`GoType`. For the query above, the generated code is equivalent to:
+
+	const UserByEmail = `select * from users where email = $1::text and active = $2`
+
+	type UserByEmailParams struct {
+		Email  string
+		Active interface{}
+	}
+
+	func (self UserByEmailParams) Bind() (string, []interface{}) {
+		return UserByEmail, []interface{}{self.Email, self.Active}
+	}
+
+Comments in the query which begin with a parameter, such as
+"-- :email the address to look up", describe it, and are copied into the
+comments of the fields; see `sqlp.ParamDocs`.
+*/
+package sqlpgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mitranim/sqlp"
+)
+
+// Named query found in a ".sql" file by `ParseFile`.
+type Query struct {
+	// Go name of the query, used for the constant. The params struct has the
+	// same name with the suffix "Params".
+	Name string
+
+	// Path of the file, for error messages and generated comments.
+	File string
+
+	// SQL with ordinal parameters, without the name comment and without
+	// surrounding whitespace and comments.
+	Src string
+
+	// Parameters in the order of their ordinals.
+	Params []Param
+}
+
+// Named parameter of a query. See `Query`.
+type Param struct {
+	// Name as written in SQL, without the colon.
+	Name string
+
+	// Go name of the struct field.
+	Field string
+
+	// Go type of the struct field, such as "string" or "time.Time".
+	Type string
+
+	// Description from a comment in the query, such as "-- :email the
+	// address", used in the comment of the field. See `sqlp.ParamDocs`.
+	Doc string
+}
+
+const namePrefix = `name:`
+
+/*
+Parses named queries from the content of one ".sql" file. Content before the
+first name comment may contain only whitespace and comments. Returns an error
+for invalid or duplicate names, for queries with ordinal parameters, and for
+files that can't be parsed by `sqlp.Parse`.
+*/
+func ParseFile(file, src string) ([]Query, error) {
+	nodes, err := sqlp.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf(`[sqlpgen] %v: %w`, file, err)
+	}
+
+	var out []Query
+	var named bool
+	var name string
+	var body sqlp.Nodes
+
+	flush := func() error {
+		if !named {
+			return nil
+		}
+		query, err := makeQuery(file, name, body)
+		if err != nil {
+			return err
+		}
+		out = append(out, query)
+		return nil
+	}
+
+	for _, node := range nodes {
+		if comment, ok := node.(sqlp.NodeCommentLine); ok {
+			text := strings.TrimSpace(string(comment))
+			if strings.HasPrefix(text, namePrefix) {
+				err := flush()
+				if err != nil {
+					return nil, err
+				}
+				named = true
+				name = strings.TrimSpace(strings.TrimPrefix(text, namePrefix))
+				body = nil
+				continue
+			}
+		}
+
+		if !named {
+			if !isTrivia(node) {
+				return nil, fmt.Errorf(`[sqlpgen] %v: unexpected %q before the first query name`, file, node)
+			}
+			continue
+		}
+		body = append(body, node)
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func makeQuery(file, name string, body sqlp.Nodes) (Query, error) {
+	docs := map[string]string{}
+	for _, val := range sqlp.ParamDocs(body) {
+		docs[val.Name] = val.Doc
+	}
+	body = trimNodes(body)
+
+	goName := goIdent(name)
+	if goName == `` {
+		return Query{}, fmt.Errorf(`[sqlpgen] %v: invalid query name %q`, file, name)
+	}
+	if len(body) == 0 {
+		return Query{}, fmt.Errorf(`[sqlpgen] %v: query %q is empty`, file, name)
+	}
+
+	hints := typeHints(body)
+
+	// Binding names to themselves returns the names in the order of ordinals.
+	args := map[string]interface{}{}
+	sqlp.DeepWalkNode(body, func(node sqlp.Node) {
+		if param, ok := node.(sqlp.NodeNamedParam); ok {
+			args[string(param)] = string(param)
+		}
+	})
+
+	bound, names, err := sqlp.BindNamed(body, args)
+	if err != nil {
+		return Query{}, fmt.Errorf(`[sqlpgen] %v: query %q: %w`, file, name, err)
+	}
+
+	query := Query{Name: goName, File: file, Src: bound.String()}
+	fields := map[string]string{}
+
+	for _, val := range names {
+		param := val.(string)
+		field := goIdent(param)
+		if field == `` {
+			return Query{}, fmt.Errorf(`[sqlpgen] %v: query %q: can't convert parameter %q to a Go name`, file, name, param)
+		}
+		if prev, ok := fields[field]; ok {
+			return Query{}, fmt.Errorf(`[sqlpgen] %v: query %q: parameters %q and %q have the same Go name %q`, file, name, prev, param, field)
+		}
+		fields[field] = param
+
+		query.Params = append(query.Params, Param{Name: param, Field: field, Type: hints[param], Doc: docs[param]})
+	}
+	return query, nil
+}
+
+/*
+Returns the Go types of the named parameters which are directly followed by
+casts with known types. The first cast of each parameter wins. Parameters
+without hints are absent.
+*/
+func typeHints(nodes sqlp.Nodes) map[string]string {
+	out := map[string]string{}
+
+	var walk func(sqlp.Nodes)
+	walk = func(nodes sqlp.Nodes) {
+		for i, node := range nodes {
+			if coll, ok := node.(sqlp.Coll); ok {
+				walk(coll.Nodes())
+				continue
+			}
+
+			param, ok := node.(sqlp.NodeNamedParam)
+			if !ok || out[string(param)] != `` || i+2 >= len(nodes) {
+				continue
+			}
+			if _, ok := nodes[i+1].(sqlp.NodeDoubleColon); !ok {
+				continue
+			}
+
+			text, ok := nodes[i+2].(sqlp.NodeText)
+			if !ok {
+				continue
+			}
+			word := typeWord(string(text))
+
+			// Array casts such as "::text[]" have empty brackets after the type.
+			array := false
+			if len(word) == len(text) && i+3 < len(nodes) {
+				if brackets, ok := nodes[i+3].(sqlp.BracketNodes); ok && len(brackets) == 0 {
+					array = true
+				}
+			}
+
+			typ := GoType(word)
+			if typ == `` {
+				continue
+			}
+			if array {
+				typ = `[]` + typ
+			}
+			out[string(param)] = typ
+		}
+	}
+
+	walk(nodes)
+	return out
+}
+
+// Leading SQL type name in the text, such as "text" in "text, 10".
+func typeWord(str string) string {
+	for i, char := range str {
+		if !(char == '_' || unicode.IsLetter(char) || i > 0 && unicode.IsDigit(char)) {
+			return str[:i]
+		}
+	}
+	return str
+}
+
+/*
+Returns the Go type corresponding to the given SQL type name, as used for
+fields of params structs, or empty if the type is unknown. Parameters without
+known types use "interface{}". Type names are case-insensitive:
+
+	bool boolean                          : bool
+	smallint int2                         : int16
+	int integer int4 serial               : int32
+	bigint int8 bigserial                 : int64
+	real float4                           : float32
+	double float8                         : float64
+	text varchar char character uuid      : string
+	numeric decimal                       : string
+	bytea json jsonb                      : []byte
+	date time timestamp timestamptz       : time.Time
+
+Array casts such as "::text[]" produce slices, such as "[]string".
+*/
+func GoType(sqlType string) string {
+	switch strings.ToLower(sqlType) {
+	case `bool`, `boolean`:
+		return `bool`
+	case `smallint`, `int2`:
+		return `int16`
+	case `int`, `integer`, `int4`, `serial`:
+		return `int32`
+	case `bigint`, `int8`, `bigserial`:
+		return `int64`
+	case `real`, `float4`:
+		return `float32`
+	case `double`, `float8`:
+		return `float64`
+	case `text`, `varchar`, `char`, `character`, `uuid`, `numeric`, `decimal`:
+		return `string`
+	case `bytea`, `json`, `jsonb`:
+		return `[]byte`
+	case `date`, `time`, `timestamp`, `timestamptz`:
+		return `time.Time`
+	default:
+		return ``
+	}
+}
+
+/*
+Parses all files with the ".sql" extension in the given file system,
+recursively, via `ParseFile`, and returns their queries in order of file
+paths. Returns an error for duplicate query names across files.
+*/
+func ParseFS(fsys fs.FS) ([]Query, error) {
+	var out []Query
+
+	err := fs.WalkDir(fsys, `.`, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.EqualFold(path.Ext(filePath), `.sql`) {
+			return nil
+		}
+
+		src, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return err
+		}
+
+		queries, err := ParseFile(filePath, string(src))
+		if err != nil {
+			return err
+		}
+		out = append(out, queries...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+/*
+Generates a Go file in the given package, with a constant, a params struct,
+and a `Bind` method for each query, in the given order. The output is
+formatted via "go/format". Returns an error for duplicate query names, and for
+query names which collide with the params structs of other queries.
+*/
+func Generate(pkg string, queries []Query) ([]byte, error) {
+	names := map[string]string{}
+	for _, query := range queries {
+		for _, name := range []string{query.Name, query.Name + `Params`} {
+			if prev, ok := names[name]; ok {
+				return nil, fmt.Errorf(`[sqlpgen] duplicate Go name %q in %v and %v`, name, prev, query.File)
+			}
+			names[name] = query.File
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by sqlpgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %v\n", pkg)
+
+	if usesTime(queries) {
+		buf.WriteString("\nimport \"time\"\n")
+	}
+
+	for _, query := range queries {
+		writeQuery(&buf, query)
+	}
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf(`[sqlpgen] failed to format generated code: %w`, err)
+	}
+	return out, nil
+}
+
+func writeQuery(buf *bytes.Buffer, query Query) {
+	params := query.Name + `Params`
+
+	fmt.Fprintf(buf, "\n// Query %q from %q.\n", query.Name, query.File)
+	fmt.Fprintf(buf, "const %v = %v\n", query.Name, goString(query.Src))
+
+	fmt.Fprintf(buf, "\n// Parameters of `%v`.\n", query.Name)
+	fmt.Fprintf(buf, "type %v struct {\n", params)
+	for _, param := range query.Params {
+		fmt.Fprintf(buf, "\t%v %v // :%v", param.Field, paramType(param), param.Name)
+		if param.Doc != `` {
+			buf.WriteString(` ` + param.Doc)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	fmt.Fprintf(buf, "\n// Returns `%v` and its arguments in order.\n", query.Name)
+	fmt.Fprintf(buf, "func (self %v) Bind() (string, []interface{}) {\n", params)
+	fmt.Fprintf(buf, "\treturn %v, []interface{}{", query.Name)
+	for i, param := range query.Params {
+		if i > 0 {
+			buf.WriteString(`, `)
+		}
+		buf.WriteString(`self.` + param.Field)
+	}
+	buf.WriteString("}\n}\n")
+}
+
+func paramType(param Param) string {
+	if param.Type == `` {
+		return `interface{}`
+	}
+	return param.Type
+}
+
+func usesTime(queries []Query) bool {
+	for _, query := range queries {
+		for _, param := range query.Params {
+			if strings.Contains(param.Type, `time.`) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Formats the string as a Go literal, preferring raw strings for readability.
+func goString(str string) string {
+	if strings.ContainsAny(str, "`\r") {
+		return strconv.Quote(str)
+	}
+	return "`" + str + "`"
+}
+
+/*
+Converts a name such as "user_by_email" or "userByEmail" to an exported Go
+identifier such as "UserByEmail", upper-casing common initialisms such as "ID".
+Returns empty if the result isn't a valid identifier.
+*/
+func goIdent(name string) string {
+	var buf strings.Builder
+
+	for _, word := range splitWords(name) {
+		upper := strings.ToUpper(word)
+		if initialisms[upper] {
+			buf.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		buf.WriteString(string(runes))
+	}
+
+	out := buf.String()
+	for i, char := range out {
+		if !(char == '_' || unicode.IsLetter(char) || i > 0 && unicode.IsDigit(char)) {
+			return ``
+		}
+	}
+	if out == `` || !unicode.IsUpper([]rune(out)[0]) {
+		return ``
+	}
+	return out
+}
+
+// Splits a name at underscores and at lowercase-uppercase boundaries.
+func splitWords(name string) []string {
+	var out []string
+	for _, part := range strings.FieldsFunc(name, func(char rune) bool { return char == '_' }) {
+		start := 0
+		runes := []rune(part)
+		for i := 1; i < len(runes); i++ {
+			if unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1]) {
+				out = append(out, string(runes[start:i]))
+				start = i
+			}
+		}
+		out = append(out, string(runes[start:]))
+	}
+	return out
+}
+
+var initialisms = func() map[string]bool {
+	out := map[string]bool{}
+	for _, val := range []string{
+		`API`, `DB`, `HTML`, `HTTP`, `ID`, `IP`, `JSON`, `SQL`, `URI`, `URL`, `UUID`,
+	} {
+		out[val] = true
+	}
+	return out
+}()
+
+func isTrivia(node sqlp.Node) bool {
+	switch node.(type) {
+	case sqlp.NodeWhitespace, sqlp.NodeCommentLine, sqlp.NodeCommentBlock:
+		return true
+	default:
+		return false
+	}
+}
+
+// Removes leading and trailing whitespace and comments.
+func trimNodes(nodes sqlp.Nodes) sqlp.Nodes {
+	for len(nodes) > 0 && isTrivia(nodes[0]) {
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 && isTrivia(nodes[len(nodes)-1]) {
+		nodes = nodes[:len(nodes)-1]
+	}
+	return nodes
+}
@@ -0,0 +1,172 @@
+package sqlpgen
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testSrc = `-- Queries for users.
+
+-- name: UserByEmail
+select * from users where email = :email::text and active = :active;
+
+-- name: update_user_tags
+-- Replaces the tags.
+-- :tags new tags, replacing the old ones
+update users
+set tags = :tags::text[], updated_at = :updated_at::timestamptz
+where id = :user_id::uuid and (:user_id::int8 is not null)
+returning id -- trailing comment
+`
+
+func TestParseFile(_ *testing.T) {
+	queries, err := ParseFile(`users.sql`, testSrc)
+	try(err)
+
+	eq([]Query{
+		{
+			Name: `UserByEmail`,
+			File: `users.sql`,
+			Src:  `select * from users where email = $1::text and active = $2;`,
+			Params: []Param{
+				{Name: `email`, Field: `Email`, Type: `string`},
+				{Name: `active`, Field: `Active`},
+			},
+		},
+		{
+			Name: `UpdateUserTags`,
+			File: `users.sql`,
+			Src: `update users
+set tags = $1::text[], updated_at = $2::timestamptz
+where id = $3::uuid and ($3::int8 is not null)
+returning id`,
+			Params: []Param{
+				{Name: `tags`, Field: `Tags`, Type: `[]string`, Doc: `new tags, replacing the old ones`},
+				{Name: `updated_at`, Field: `UpdatedAt`, Type: `time.Time`},
+				{Name: `user_id`, Field: `UserID`, Type: `string`},
+			},
+		},
+	}, queries)
+
+	queries, err = ParseFile(`empty.sql`, "-- comment\n\n")
+	try(err)
+	eq([]Query(nil), queries)
+
+	fail := func(msg, src string) {
+		_, err := ParseFile(`one.sql`, src)
+		eq(true, err != nil)
+		if !strings.Contains(err.Error(), msg) {
+			panic(fmt.Errorf(`expected error containing %q, got %q`, msg, err))
+		}
+	}
+
+	fail(`unexpected "select" before the first query name`, `select 1`)
+	fail(`invalid query name "1st"`, "-- name: 1st\nselect 1")
+	fail(`invalid query name ""`, "-- name:\nselect 1")
+	fail(`query "One" is empty`, "-- name: One\n-- name: Two\nselect 1")
+	fail(`query "One": [sqlp]`, "-- name: One\nselect $1, :two")
+	fail(`parameters "user_id" and "userId" have the same Go name "UserID"`, "-- name: One\nselect :user_id, :userId")
+	fail(`one.sql:`, "-- name: One\nselect (")
+}
+
+func TestParseFS(_ *testing.T) {
+	queries, err := ParseFS(fstest.MapFS{
+		`one.sql`:     {Data: []byte("-- name: One\nselect 1")},
+		`two/two.sql`: {Data: []byte("-- name: Two\nselect 2")},
+		`three.txt`:   {Data: []byte(`not sql`)},
+	})
+	try(err)
+
+	eq([]Query{
+		{Name: `One`, File: `one.sql`, Src: `select 1`},
+		{Name: `Two`, File: `two/two.sql`, Src: `select 2`},
+	}, queries)
+
+	_, err = ParseFS(fstest.MapFS{`one.sql`: {Data: []byte(`select 1`)}})
+	eq(true, err != nil)
+}
+
+func TestGenerate(_ *testing.T) {
+	queries, err := ParseFile(`users.sql`, testSrc)
+	try(err)
+
+	out, err := Generate(`queries`, queries)
+	try(err)
+
+	eq("// Code generated by sqlpgen. DO NOT EDIT.\n\npackage queries\n\nimport \"time\"\n\n"+
+		"// Query \"UserByEmail\" from \"users.sql\".\n"+
+		"const UserByEmail = `select * from users where email = $1::text and active = $2;`\n\n"+
+		"// Parameters of `UserByEmail`.\n"+
+		"type UserByEmailParams struct {\n"+
+		"\tEmail  string      // :email\n"+
+		"\tActive interface{} // :active\n"+
+		"}\n\n"+
+		"// Returns `UserByEmail` and its arguments in order.\n"+
+		"func (self UserByEmailParams) Bind() (string, []interface{}) {\n"+
+		"\treturn UserByEmail, []interface{}{self.Email, self.Active}\n"+
+		"}\n\n"+
+		"// Query \"UpdateUserTags\" from \"users.sql\".\n"+
+		"const UpdateUserTags = `update users\nset tags = $1::text[], updated_at = $2::timestamptz\nwhere id = $3::uuid and ($3::int8 is not null)\nreturning id`\n\n"+
+		"// Parameters of `UpdateUserTags`.\n"+
+		"type UpdateUserTagsParams struct {\n"+
+		"\tTags      []string  // :tags new tags, replacing the old ones\n"+
+		"\tUpdatedAt time.Time // :updated_at\n"+
+		"\tUserID    string    // :user_id\n"+
+		"}\n\n"+
+		"// Returns `UpdateUserTags` and its arguments in order.\n"+
+		"func (self UpdateUserTagsParams) Bind() (string, []interface{}) {\n"+
+		"\treturn UpdateUserTags, []interface{}{self.Tags, self.UpdatedAt, self.UserID}\n"+
+		"}\n",
+		string(out))
+
+	out, err = Generate(`queries`, []Query{{Name: `One`, File: `one.sql`, Src: "select '`'"}})
+	try(err)
+	eq(true, strings.Contains(string(out), "const One = \"select '`'\"\n"))
+	eq(false, strings.Contains(string(out), `import`))
+
+	_, err = Generate(`queries`, []Query{{Name: `One`, File: `one.sql`}, {Name: `One`, File: `two.sql`}})
+	eq(`[sqlpgen] duplicate Go name "One" in one.sql and two.sql`, err.Error())
+
+	_, err = Generate(`queries`, []Query{{Name: `One`, File: `one.sql`}, {Name: `OneParams`, File: `two.sql`}})
+	eq(`[sqlpgen] duplicate Go name "OneParams" in one.sql and two.sql`, err.Error())
+}
+
+func TestGoIdent(_ *testing.T) {
+	test := func(exp, src string) { eq(exp, goIdent(src)) }
+
+	test(``, ``)
+	test(``, `1st`)
+	test(``, `one-two`)
+	test(`One`, `one`)
+	test(`OneTwo`, `one_two`)
+	test(`OneTwo`, `oneTwo`)
+	test(`OneTwo`, `OneTwo`)
+	test(`UserID`, `user_id`)
+	test(`UserID`, `userId`)
+	test(`HTTPURL`, `http_url`)
+	test(`One2`, `one2`)
+}
+
+func try(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func eq(exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		panic(fmt.Errorf(`
+expected (detailed):
+	%#[1]v
+actual (detailed):
+	%#[2]v
+expected (simple):
+	%[1]s
+actual (simple):
+	%[2]s
+`, exp, act))
+	}
+}
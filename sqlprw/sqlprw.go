@@ -0,0 +1,393 @@
+/*
+Ready-made rewrites for "github.com/mitranim/sqlp", implemented as composable
+passes which can be chained via `Pipeline`. The core package provides the
+primitives; this package provides the common transforms built on them, such
+as converting named parameters to ordinal parameters, stripping comments,
+expanding lists in IN clauses, and enforcing a LIMIT.
+
+Example:
+
+	args := map[string]interface{}{`ids`: []int{10, 20}, `name`: `one`}
+	var vals []interface{}
+
+	nodes, err := sqlp.Parse(`select * from users where id in (:ids) and name = :name -- comment`)
+	...
+	nodes, err = sqlprw.Pipeline{
+		sqlprw.StripComments,
+		sqlprw.ExpandIn(args),
+		sqlprw.BindNamed(args, &vals),
+		sqlprw.EnforceLimit(100),
+	}.Run(nodes)
+	...
+	// select * from users where id in ($1, $2) and name = $3 limit 100
+	// vals = []interface{}{10, 20, "one"}
+*/
+package sqlprw
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitranim/sqlp"
+)
+
+/*
+Rewrite pass. Takes an AST and returns a new AST, or an error. Passes must not
+modify their input. Functions with this signature may be used as passes
+directly, such as `StripComments`. Passes which need configuration are created
+by functions such as `EnforceLimit`.
+*/
+type Pass func(sqlp.Nodes) (sqlp.Nodes, error)
+
+/*
+Sequence of passes applied in order by `Pipeline.Run`. The zero value is a
+valid empty pipeline which returns its input as-is. For debugging, use
+`Pipeline.RunTrace`, which records what each pass did.
+*/
+type Pipeline []Pass
+
+/*
+Applies the passes in order, feeding the output of each pass to the next, and
+returns the output of the last pass. Stops at the first error, which is
+wrapped with the index of the failed pass. Nil passes are skipped.
+*/
+func (self Pipeline) Run(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+	return self.run(nodes, nil)
+}
+
+/*
+Same as `Pipeline.Run`, but also returns a trace with an entry for each pass
+which ran, including the failed pass, if any. Each entry records how long the
+pass took, and the SQL before and after the pass. Intended for debugging
+pipelines, for example to find which pass produced unexpected output. Nil
+passes are skipped and don't have entries.
+*/
+func (self Pipeline) RunTrace(nodes sqlp.Nodes) (sqlp.Nodes, Trace, error) {
+	var trace Trace
+	out, err := self.run(nodes, &trace)
+	return out, trace, err
+}
+
+func (self Pipeline) run(nodes sqlp.Nodes, trace *Trace) (sqlp.Nodes, error) {
+	for i, pass := range self {
+		if pass == nil {
+			continue
+		}
+
+		var entry TraceEntry
+		if trace != nil {
+			entry = TraceEntry{Index: i, Name: passName(pass), Before: nodes.String()}
+		}
+
+		start := time.Now()
+		out, err := pass(nodes)
+
+		if trace != nil {
+			entry.Duration = time.Since(start)
+			entry.Err = err
+			if err == nil {
+				entry.After = out.String()
+			}
+			*trace = append(*trace, entry)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf(`[sqlprw] pass %v: %w`, i, err)
+		}
+		nodes = out
+	}
+	return nodes, nil
+}
+
+// Trace of a pipeline run, returned by `Pipeline.RunTrace`.
+type Trace []TraceEntry
+
+/*
+Implement `fmt.Stringer`. Returns a human-readable description of each pass,
+with the SQL before and after the pass. For passes which didn't change the
+SQL, the SQL is omitted.
+*/
+func (self Trace) String() string {
+	var buf strings.Builder
+	for _, val := range self {
+		buf.WriteString(val.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// Trace of one pass, created by `Pipeline.RunTrace`.
+type TraceEntry struct {
+	// Index of the pass in the pipeline.
+	Index int
+
+	/*
+		Name of the function implementing the pass, without the package path,
+		such as "sqlprw.StripComments". Passes created by functions, such as
+		`EnforceLimit`, are named after those functions.
+	*/
+	Name string
+
+	// How long the pass took.
+	Duration time.Duration
+
+	// SQL given to the pass.
+	Before string
+
+	// SQL returned by the pass. Empty if the pass failed.
+	After string
+
+	// Error returned by the pass, if any.
+	Err error
+}
+
+// True if the pass succeeded and changed the SQL.
+func (self TraceEntry) Changed() bool { return self.Err == nil && self.Before != self.After }
+
+// Implement `fmt.Stringer`. Used by `Trace.String`.
+func (self TraceEntry) String() string {
+	head := fmt.Sprintf(`pass %v %v (%v)`, self.Index, self.Name, self.Duration)
+
+	switch {
+	case self.Err != nil:
+		return fmt.Sprintf("%v: error: %v\n\tbefore: %v", head, self.Err, self.Before)
+	case self.Changed():
+		return fmt.Sprintf("%v: changed\n\tbefore: %v\n\tafter:  %v", head, self.Before, self.After)
+	default:
+		return head + `: unchanged`
+	}
+}
+
+/*
+Returns the name of the function implementing the pass, without the package
+path and without the suffixes of anonymous functions, which names closures
+after the functions that created them.
+*/
+func passName(pass Pass) string {
+	fun := runtime.FuncForPC(reflect.ValueOf(pass).Pointer())
+	if fun == nil {
+		return ``
+	}
+
+	name := fun.Name()
+	name = name[strings.LastIndexByte(name, '/')+1:]
+
+	for {
+		index := strings.LastIndexByte(name, '.')
+		if index < 0 || !isAnonFuncSuffix(name[index+1:]) {
+			return name
+		}
+		name = name[:index]
+	}
+}
+
+// True for suffixes such as "func1" and "1", used by Go for anonymous functions.
+func isAnonFuncSuffix(str string) bool {
+	str = strings.TrimPrefix(str, `func`)
+	if str == `` {
+		return false
+	}
+	for _, char := range str {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Parses the source via `sqlp.Parse`, applies the passes via `Pipeline.Run`, and
+returns the resulting SQL.
+*/
+func (self Pipeline) RunString(src string) (string, error) {
+	nodes, err := sqlp.Parse(src)
+	if err != nil {
+		return ``, err
+	}
+
+	nodes, err = self.Run(nodes)
+	if err != nil {
+		return ``, err
+	}
+	return nodes.String(), nil
+}
+
+/*
+Pass which removes all comments at any nesting level. Each comment is replaced
+with whitespace, which prevents adjacent text from merging: a newline for line
+comments ending with a newline, and a space otherwise.
+*/
+func StripComments(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+	out := nodes.CopyNodes()
+	for i := range out {
+		sqlp.DeepWalkNodePtr(&out[i], func(ptr *sqlp.Node) {
+			switch node := (*ptr).(type) {
+			case sqlp.NodeCommentLine:
+				*ptr = lineCommentWhitespace(string(node))
+			case sqlp.NodeCommentHash:
+				*ptr = lineCommentWhitespace(string(node))
+			case sqlp.NodeCommentBlock:
+				*ptr = sqlp.NodeWhitespace(` `)
+			}
+		})
+	}
+	return out, nil
+}
+
+func lineCommentWhitespace(val string) sqlp.Node {
+	if strings.HasSuffix(val, "\n") {
+		return sqlp.NodeWhitespace("\n")
+	}
+	return sqlp.NodeWhitespace(` `)
+}
+
+/*
+Returns a pass which converts named parameters to ordinal parameters via
+`sqlp.BindNamed`, using the given arguments, and stores the resulting
+arguments in the given output. Usually the last pass which deals with
+parameters.
+*/
+func BindNamed(args map[string]interface{}, out *[]interface{}) Pass {
+	return func(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+		nodes, vals, err := sqlp.BindNamed(nodes, args)
+		if err != nil {
+			return nil, err
+		}
+		*out = vals
+		return nodes, nil
+	}
+}
+
+/*
+Returns a pass which expands named parameters in IN clauses whose arguments
+are slices or arrays, such as "id in (:ids)", into one parameter per element,
+such as "id in (:ids__1, :ids__2)". The parameter must be the only content of
+the parens following IN or NOT IN. Adds the elements to the given arguments
+under the generated names, which makes them available to `BindNamed`, and
+leaves the original argument as-is. Byte slices are not considered lists.
+Returns an error for an empty list, since "in ()" is invalid SQL, and the
+alternatives differ in semantics between IN and NOT IN.
+*/
+func ExpandIn(args map[string]interface{}) Pass {
+	return func(nodes sqlp.Nodes) (_ sqlp.Nodes, err error) {
+		defer rec(&err)
+		return expandIn(nodes, args), nil
+	}
+}
+
+func expandIn(nodes sqlp.Nodes, args map[string]interface{}) sqlp.Nodes {
+	out := make(sqlp.Nodes, len(nodes))
+	var prev sqlp.Node
+
+	for i, node := range nodes {
+		switch node := node.(type) {
+		case sqlp.ParenNodes:
+			if isKeyword(prev, `in`) {
+				if list, ok := expandInList(node, args); ok {
+					out[i] = list
+					break
+				}
+			}
+			out[i] = sqlp.ParenNodes(expandIn(sqlp.Nodes(node), args))
+		case sqlp.BracketNodes:
+			out[i] = sqlp.BracketNodes(expandIn(sqlp.Nodes(node), args))
+		case sqlp.BraceNodes:
+			out[i] = sqlp.BraceNodes(expandIn(sqlp.Nodes(node), args))
+		case sqlp.Nodes:
+			out[i] = expandIn(node, args)
+		default:
+			out[i] = node
+		}
+
+		if !isTrivia(node) {
+			prev = node
+		}
+	}
+	return out
+}
+
+func expandInList(parens sqlp.ParenNodes, args map[string]interface{}) (sqlp.ParenNodes, bool) {
+	var param sqlp.NodeNamedParam
+	for _, node := range parens {
+		switch node := node.(type) {
+		case sqlp.NodeWhitespace:
+		case sqlp.NodeNamedParam:
+			if param != `` {
+				return nil, false
+			}
+			param = node
+		default:
+			return nil, false
+		}
+	}
+	if param == `` {
+		return nil, false
+	}
+
+	val := reflect.ValueOf(args[string(param)])
+	kind := val.Kind()
+	if !(kind == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 || kind == reflect.Array) {
+		return nil, false
+	}
+	if val.Len() == 0 {
+		panic(fmt.Errorf(`[sqlprw] can't expand empty list for parameter %q in IN clause`, param))
+	}
+
+	out := make(sqlp.ParenNodes, 0, val.Len()*3)
+	for i := 0; i < val.Len(); i++ {
+		if i > 0 {
+			out = append(out, sqlp.NodeText(`,`), sqlp.NodeWhitespace(` `))
+		}
+		name := string(param) + `__` + strconv.Itoa(i+1)
+		args[name] = val.Index(i).Interface()
+		out = append(out, sqlp.NodeNamedParam(name))
+	}
+	return out, true
+}
+
+// Returns a pass which applies `sqlp.EnforceLimit` with the given maximum.
+func EnforceLimit(max int) Pass {
+	return func(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+		return sqlp.EnforceLimit(nodes, max)
+	}
+}
+
+// Returns a pass which applies `sqlp.AndWhere` with the given condition.
+func AndWhere(cond sqlp.Nodes) Pass {
+	return func(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+		return sqlp.AndWhere(nodes, cond)
+	}
+}
+
+func isKeyword(node sqlp.Node, keyword string) bool {
+	text, ok := node.(sqlp.NodeText)
+	return ok && strings.EqualFold(string(text), keyword)
+}
+
+func isTrivia(node sqlp.Node) bool {
+	switch node.(type) {
+	case nil, sqlp.NodeWhitespace, sqlp.NodeCommentLine, sqlp.NodeCommentBlock, sqlp.NodeCommentHash:
+		return true
+	default:
+		return false
+	}
+}
+
+func rec(ptr *error) {
+	val := recover()
+	if val == nil {
+		return
+	}
+
+	recErr, ok := val.(error)
+	if ok {
+		*ptr = recErr
+		return
+	}
+
+	panic(val)
+}
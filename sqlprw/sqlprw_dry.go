@@ -0,0 +1,204 @@
+package sqlprw
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitranim/sqlp"
+)
+
+/*
+Runs the pipeline without applying its output, and reports the changes which
+each pass would make, for implementing "dry run" modes in tools built on sqlp.
+The given AST is not modified, as required of every pass. Stops at the first
+error, like `Pipeline.Run`, returning the changes reported so far.
+
+Changes are found by comparing the tokens of the SQL before and after each
+pass. Each change has the region it replaces in the SQL given to that pass,
+which for the first pass is the original SQL. Passes which don't change the
+SQL don't report anything.
+*/
+func (self Pipeline) DryRun(nodes sqlp.Nodes) (Report, error) {
+	_, trace, err := self.RunTrace(nodes)
+
+	var out Report
+	for _, entry := range trace {
+		if !entry.Changed() {
+			continue
+		}
+		for _, span := range diffSQL(entry.Before, entry.After) {
+			out = append(out, Change{
+				Index:  entry.Index,
+				Name:   entry.Name,
+				Region: span.old,
+				Old:    entry.Before[span.old[0]:span.old[1]],
+				New:    entry.After[span.new[0]:span.new[1]],
+			})
+		}
+	}
+	return out, err
+}
+
+// List of changes returned by `Pipeline.DryRun`.
+type Report []Change
+
+// Implement `fmt.Stringer`, formatting the report for humans, one line per
+// change.
+func (self Report) String() string {
+	var buf strings.Builder
+	for _, val := range self {
+		buf.WriteString(val.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+/*
+Change which a pass would make, reported by `Pipeline.DryRun`. The region
+refers to the SQL given to the pass. Either of the old and new text may be
+empty, for insertions and deletions respectively.
+*/
+type Change struct {
+	Index  int
+	Name   string
+	Region sqlp.Region
+	Old    string
+	New    string
+}
+
+// Describes the change, such as `replace "one" with "two"`.
+func (self Change) Description() string {
+	switch {
+	case self.Old == ``:
+		return fmt.Sprintf(`insert %q`, self.New)
+	case self.New == ``:
+		return fmt.Sprintf(`delete %q`, self.Old)
+	default:
+		return fmt.Sprintf(`replace %q with %q`, self.Old, self.New)
+	}
+}
+
+// Implement `fmt.Stringer`, formatting the change for humans.
+func (self Change) String() string {
+	return fmt.Sprintf(`pass %v %v: %v-%v: %v`, self.Index, self.Name, self.Region[0], self.Region[1], self.Description())
+}
+
+// Maximum size of the table used by `diffSQL`, beyond which it falls back on
+// reporting the entire differing region as one change.
+const diffSQLLimit = 1 << 20
+
+type diffSpan struct{ old, new sqlp.Region }
+
+/*
+Finds the differences between two SQL texts, at the granularity of tokens,
+using the longest common subsequence. Adjacent differing tokens are merged into
+one span. Falls back on one span covering everything between the common prefix
+and suffix when either text can't be tokenized, or when the texts are too
+large.
+*/
+func diffSQL(one, two string) []diffSpan {
+	srcOne, errOne := sqlp.Tokenize(one)
+	srcTwo, errTwo := sqlp.Tokenize(two)
+	if errOne != nil || errTwo != nil {
+		return diffText(one, two)
+	}
+
+	head := 0
+	for head < len(srcOne) && head < len(srcTwo) && tokenText(one, srcOne[head]) == tokenText(two, srcTwo[head]) {
+		head++
+	}
+
+	tail := 0
+	for tail < len(srcOne)-head && tail < len(srcTwo)-head &&
+		tokenText(one, srcOne[len(srcOne)-1-tail]) == tokenText(two, srcTwo[len(srcTwo)-1-tail]) {
+		tail++
+	}
+
+	midOne := srcOne[head : len(srcOne)-tail]
+	midTwo := srcTwo[head : len(srcTwo)-tail]
+	if (len(midOne)+1)*(len(midTwo)+1) > diffSQLLimit {
+		return diffText(one, two)
+	}
+
+	// Lengths of common subsequences of the suffixes of both token lists.
+	width := len(midTwo) + 1
+	table := make([]int, (len(midOne)+1)*width)
+	for i := len(midOne) - 1; i >= 0; i-- {
+		for j := len(midTwo) - 1; j >= 0; j-- {
+			if tokenText(one, midOne[i]) == tokenText(two, midTwo[j]) {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else if table[(i+1)*width+j] >= table[i*width+j+1] {
+				table[i*width+j] = table[(i+1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j+1]
+			}
+		}
+	}
+
+	var out []diffSpan
+	startOne, startTwo := -1, -1
+
+	flush := func(i, j int) {
+		if startOne < 0 {
+			return
+		}
+		out = append(out, diffSpan{
+			old: sqlp.Region{tokenPos(one, srcOne, head+startOne), tokenPos(one, srcOne, head+i)},
+			new: sqlp.Region{tokenPos(two, srcTwo, head+startTwo), tokenPos(two, srcTwo, head+j)},
+		})
+		startOne, startTwo = -1, -1
+	}
+
+	i, j := 0, 0
+	for i < len(midOne) || j < len(midTwo) {
+		if i < len(midOne) && j < len(midTwo) && tokenText(one, midOne[i]) == tokenText(two, midTwo[j]) {
+			flush(i, j)
+			i++
+			j++
+			continue
+		}
+
+		if startOne < 0 {
+			startOne, startTwo = i, j
+		}
+		if j >= len(midTwo) || i < len(midOne) && table[(i+1)*width+j] >= table[i*width+j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	flush(i, j)
+	return out
+}
+
+// Fallback for `diffSQL`, comparing bytes rather than tokens.
+func diffText(one, two string) []diffSpan {
+	head := 0
+	for head < len(one) && head < len(two) && one[head] == two[head] {
+		head++
+	}
+
+	tail := 0
+	for tail < len(one)-head && tail < len(two)-head && one[len(one)-1-tail] == two[len(two)-1-tail] {
+		tail++
+	}
+
+	if head == len(one) && head == len(two) {
+		return nil
+	}
+	return []diffSpan{{
+		old: sqlp.Region{head, len(one) - tail},
+		new: sqlp.Region{head, len(two) - tail},
+	}}
+}
+
+func tokenText(src string, tok sqlp.Token) string { return src[tok.Region[0]:tok.Region[1]] }
+
+// Returns the start of the token at the given index, or the end of the source
+// when the index is past the last token.
+func tokenPos(src string, tokens []sqlp.Token, index int) int {
+	if index < len(tokens) {
+		return tokens[index].Region[0]
+	}
+	return len(src)
+}
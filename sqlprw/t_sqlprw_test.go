@@ -0,0 +1,256 @@
+package sqlprw
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitranim/sqlp"
+)
+
+func TestPipeline(_ *testing.T) {
+	test := func(exp string, pipeline Pipeline, src string) {
+		out, err := pipeline.RunString(src)
+		try(err)
+		eq(exp, out)
+	}
+
+	test(`select 1`, nil, `select 1`)
+	test(`select 1`, Pipeline{nil}, `select 1`)
+	test(`select 1 limit 10`, Pipeline{EnforceLimit(10)}, `select 1`)
+	test(
+		`select * from users where (active) limit 10`,
+		Pipeline{StripComments, AndWhere(parse(`active`)), EnforceLimit(10)},
+		`select * from users -- comment`,
+	)
+
+	var calls []int
+	pass := func(val int) Pass {
+		return func(nodes sqlp.Nodes) (sqlp.Nodes, error) {
+			calls = append(calls, val)
+			return nodes, nil
+		}
+	}
+	test(`select 1`, Pipeline{pass(1), pass(2), pass(3)}, `select 1`)
+	eq([]int{1, 2, 3}, calls)
+
+	calls = nil
+	errFail := errors.New(`fail`)
+	fail := func(sqlp.Nodes) (sqlp.Nodes, error) { return nil, errFail }
+
+	_, err := Pipeline{pass(1), fail, pass(3)}.Run(parse(`select 1`))
+	eq([]int{1}, calls)
+	eq(true, errors.Is(err, errFail))
+	eq(`[sqlprw] pass 1: fail`, err.Error())
+
+	_, err = Pipeline{pass(1)}.RunString(`select (`)
+	eq(true, err != nil)
+}
+
+func TestPipelineRunTrace(_ *testing.T) {
+	errFail := errors.New(`fail`)
+	fail := func(sqlp.Nodes) (sqlp.Nodes, error) { return nil, errFail }
+
+	pipeline := Pipeline{StripComments, nil, EnforceLimit(10), BindNamed(nil, new([]interface{})), fail, StripComments}
+
+	out, trace, err := pipeline.RunTrace(parse(`select 1 /* comment */`))
+	eq(true, errors.Is(err, errFail))
+	eq(sqlp.Nodes(nil), out)
+
+	for i := range trace {
+		eq(true, trace[i].Duration >= 0)
+		trace[i].Duration = 0
+	}
+
+	eq(Trace{
+		{Index: 0, Name: `sqlprw.StripComments`, Before: `select 1 /* comment */`, After: `select 1  `},
+		{Index: 2, Name: `sqlprw.EnforceLimit`, Before: `select 1  `, After: `select 1 limit 10`},
+		{Index: 3, Name: `sqlprw.BindNamed`, Before: `select 1 limit 10`, After: `select 1 limit 10`},
+		{Index: 4, Name: `sqlprw.TestPipelineRunTrace`, Before: `select 1 limit 10`, Err: errFail},
+	}, trace)
+
+	eq(true, trace[0].Changed())
+	eq(false, trace[2].Changed())
+	eq(false, trace[3].Changed())
+
+	eq(`pass 0 sqlprw.StripComments (0s): changed
+	before: select 1 /* comment */
+	after:  select 1  
+pass 2 sqlprw.EnforceLimit (0s): changed
+	before: select 1  
+	after:  select 1 limit 10
+pass 3 sqlprw.BindNamed (0s): unchanged
+pass 4 sqlprw.TestPipelineRunTrace (0s): error: fail
+	before: select 1 limit 10
+`, trace.String())
+
+	out, trace, err = Pipeline{StripComments}.RunTrace(parse(`select 1`))
+	try(err)
+	eq(`select 1`, out.String())
+	eq(1, len(trace))
+}
+
+func TestPipelineDryRun(_ *testing.T) {
+	args := map[string]interface{}{`ids`: []int{10, 20}}
+	nodes := parse(`select * from users /* one */ where id in (:ids) -- two`)
+
+	report, err := Pipeline{
+		StripComments,
+		ExpandIn(args),
+		EnforceLimit(100),
+		EnforceLimit(100),
+	}.DryRun(nodes)
+	try(err)
+
+	eq(`select * from users /* one */ where id in (:ids) -- two`, nodes.String())
+	eq(Report{
+		{Index: 0, Name: `sqlprw.StripComments`, Region: sqlp.Region{19, 30}, Old: ` /* one */ `, New: `   `},
+		{Index: 0, Name: `sqlprw.StripComments`, Region: sqlp.Region{48, 55}, Old: ` -- two`, New: `  `},
+		{Index: 1, Name: `sqlprw.ExpandIn`, Region: sqlp.Region{35, 39}, Old: `:ids`, New: `:ids__1, :ids__2`},
+		{Index: 2, Name: `sqlprw.EnforceLimit`, Region: sqlp.Region{52, 54}, Old: `  `, New: ` limit 100`},
+	}, report)
+
+	eq(`pass 0 sqlprw.StripComments: 19-30: replace " /* one */ " with "   "
+pass 0 sqlprw.StripComments: 48-55: replace " -- two" with "  "
+pass 1 sqlprw.ExpandIn: 35-39: replace ":ids" with ":ids__1, :ids__2"
+pass 2 sqlprw.EnforceLimit: 52-54: replace "  " with " limit 100"
+`, report.String())
+
+	errFail := errors.New(`fail`)
+	fail := func(sqlp.Nodes) (sqlp.Nodes, error) { return nil, errFail }
+
+	report, err = Pipeline{EnforceLimit(10), fail}.DryRun(parse(`select 1`))
+	eq(true, errors.Is(err, errFail))
+	eq(Report{
+		{Index: 0, Name: `sqlprw.EnforceLimit`, Region: sqlp.Region{8, 8}, New: ` limit 10`},
+	}, report)
+	eq(`insert " limit 10"`, report[0].Description())
+}
+
+func TestStripComments(_ *testing.T) {
+	test := func(exp, src string) {
+		nodes := parse(src)
+		out, err := StripComments(nodes)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(``, ``)
+	test(`select 1`, `select 1`)
+	test("select \n1", "select --comment\n1")
+	test(`select 1  `, `select 1 --comment`)
+	test(`select   1`, `select /* comment */ 1`)
+	test(`select 1 2`, `select 1/* comment */2`)
+	test("select (1 \n)", "select (1 -- comment\n)")
+	test(`select [{ }]`, `select [{/**/}]`)
+	test(`select '-- one', "/* two */"`, `select '-- one', "/* two */"`)
+
+	nodes := sqlp.Nodes{sqlp.NodeText(`one`), sqlp.NodeCommentHash(" two\n"), sqlp.NodeCommentHash(`three`)}
+	out, err := StripComments(nodes)
+	try(err)
+	eq("one\n ", out.String())
+}
+
+func TestBindNamed(_ *testing.T) {
+	var vals []interface{}
+	args := map[string]interface{}{`one`: 10, `two`: 20}
+
+	out, err := BindNamed(args, &vals)(parse(`select :two, :one, :two`))
+	try(err)
+	eq(`select $1, $2, $1`, out.String())
+	eq([]interface{}{20, 10}, vals)
+
+	vals = []interface{}{`unchanged`}
+	_, err = BindNamed(args, &vals)(parse(`select :three`))
+	eq(true, err != nil)
+	eq([]interface{}{`unchanged`}, vals)
+}
+
+func TestExpandIn(_ *testing.T) {
+	test := func(exp string, src string, args map[string]interface{}) {
+		nodes := parse(src)
+		out, err := ExpandIn(args)(nodes)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(`select :ids`, `select :ids`, map[string]interface{}{`ids`: []int{1}})
+	test(`where id in (:id)`, `where id in (:id)`, map[string]interface{}{`id`: 1})
+	test(`where id in (:id)`, `where id in (:id)`, nil)
+	test(`where id in (:id)`, `where id in (:id)`, map[string]interface{}{`id`: []byte(`one`)})
+	test(`where id in (:id, 2)`, `where id in (:id, 2)`, map[string]interface{}{`id`: []int{1}})
+	test(`where (:ids)`, `where (:ids)`, map[string]interface{}{`ids`: []int{1}})
+
+	args := map[string]interface{}{`ids`: []int{10, 20, 30}, `name`: `one`}
+	test(
+		`where id in (:ids__1, :ids__2, :ids__3) and name = :name`,
+		`where id in (:ids) and name = :name`,
+		args,
+	)
+	eq(map[string]interface{}{
+		`ids`:    []int{10, 20, 30},
+		`ids__1`: 10,
+		`ids__2`: 20,
+		`ids__3`: 30,
+		`name`:   `one`,
+	}, args)
+
+	args = map[string]interface{}{`ids`: [2]string{`one`, `two`}}
+	test(
+		`where id NOT IN /**/ (:ids__1, :ids__2) and exists (select where id in (:ids__1, :ids__2))`,
+		`where id NOT IN /**/ ( :ids ) and exists (select where id in (:ids))`,
+		args,
+	)
+	eq(`two`, args[`ids__2`])
+
+	_, err := ExpandIn(map[string]interface{}{`ids`: []int{}})(parse(`where id in (:ids)`))
+	eq(true, err != nil)
+	eq(true, strings.Contains(err.Error(), `empty list`))
+}
+
+func TestExpandInBindNamed(_ *testing.T) {
+	var vals []interface{}
+	args := map[string]interface{}{`ids`: []int{10, 20}, `name`: `one`}
+
+	out, err := Pipeline{
+		StripComments,
+		ExpandIn(args),
+		BindNamed(args, &vals),
+		EnforceLimit(100),
+	}.RunString(`select * from users where id in (:ids) and name = :name -- comment`)
+	try(err)
+
+	eq(`select * from users where id in ($1, $2) and name = $3 limit 100`, out)
+	eq([]interface{}{10, 20, `one`}, vals)
+}
+
+func parse(src string) sqlp.Nodes {
+	nodes, err := sqlp.Parse(src)
+	try(err)
+	return nodes
+}
+
+func try(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func eq(exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		panic(fmt.Errorf(`
+expected (detailed):
+	%#[1]v
+actual (detailed):
+	%#[2]v
+expected (simple):
+	%[1]s
+actual (simple):
+	%[2]s
+`, exp, act))
+	}
+}
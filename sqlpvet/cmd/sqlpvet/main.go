@@ -0,0 +1,19 @@
+/*
+Command for checking SQL embedded in Go code, via the "sqlpvet" analyzer:
+
+	go run github.com/mitranim/sqlp/sqlpvet/cmd/sqlpvet ./...
+
+Can also be used as a tool for "go vet":
+
+	go vet -vettool=$(which sqlpvet) ./...
+
+See the "sqlpvet" package for the checks and flags.
+*/
+package main
+
+import (
+	"github.com/mitranim/sqlp/sqlpvet"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(sqlpvet.Analyzer) }
@@ -0,0 +1,15 @@
+module github.com/mitranim/sqlp/sqlpvet
+
+go 1.25.0
+
+require (
+	github.com/mitranim/sqlp v0.0.0
+	golang.org/x/tools v0.47.0
+)
+
+require (
+	golang.org/x/mod v0.37.0 // indirect
+	golang.org/x/sync v0.21.0 // indirect
+)
+
+replace github.com/mitranim/sqlp => ../
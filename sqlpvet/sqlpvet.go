@@ -0,0 +1,218 @@
+/*
+Static analyzer for SQL embedded in Go code, compatible with "go vet" and other
+drivers of "golang.org/x/tools/go/analysis". Finds calls to known query
+functions, such as "(*database/sql.DB).Query", whose query argument is a
+constant string, parses the query with sqlp, and reports problems at lint time
+rather than at runtime:
+
+  - syntax errors, such as unterminated quotes or unbalanced parens
+  - mixed placeholder styles, such as "$1" and ":name"; see `sqlp.CheckParamConsistency`
+  - mismatch between numbered placeholders and the number of arguments
+
+The query argument is the first parameter of type string. Non-constant queries
+are ignored, as are calls which pass arguments via "args...", where the count
+is unknown. The argument count is checked only for numbered placeholders such
+as "$1" and "?", and for queries without placeholders.
+
+Run via the "sqlpvet/cmd/sqlpvet" command, or directly via "go vet":
+
+	go vet -vettool=$(which sqlpvet) ./...
+
+Flags:
+
+	-funcs   : additional query functions, comma-separated, in the format of "types.Func.FullName"
+	-dialect : dialect for parsing, such as "postgres" or "mysql"; see `sqlp.Dialect`
+
+Example of a function name for "-funcs":
+
+	(*github.com/jmoiron/sqlx.DB).Get
+
+This package is a separate module, so that the main sqlp module doesn't depend
+on "golang.org/x/tools".
+*/
+package sqlpvet
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"strings"
+
+	"github.com/mitranim/sqlp"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// The analyzer. See the package description.
+var Analyzer = &analysis.Analyzer{
+	Name:     `sqlp`,
+	Doc:      `check SQL in constant strings passed to query functions`,
+	URL:      `https://pkg.go.dev/github.com/mitranim/sqlp/sqlpvet`,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+/*
+Query functions checked by default: methods of "database/sql" and of the
+common Postgres driver "pgx". Functions can be added via the "-funcs" flag.
+*/
+var DefaultFuncs = []string{
+	`(*database/sql.DB).Exec`,
+	`(*database/sql.DB).ExecContext`,
+	`(*database/sql.DB).Prepare`,
+	`(*database/sql.DB).PrepareContext`,
+	`(*database/sql.DB).Query`,
+	`(*database/sql.DB).QueryContext`,
+	`(*database/sql.DB).QueryRow`,
+	`(*database/sql.DB).QueryRowContext`,
+	`(*database/sql.Tx).Exec`,
+	`(*database/sql.Tx).ExecContext`,
+	`(*database/sql.Tx).Prepare`,
+	`(*database/sql.Tx).PrepareContext`,
+	`(*database/sql.Tx).Query`,
+	`(*database/sql.Tx).QueryContext`,
+	`(*database/sql.Tx).QueryRow`,
+	`(*database/sql.Tx).QueryRowContext`,
+	`(*database/sql.Conn).ExecContext`,
+	`(*database/sql.Conn).PrepareContext`,
+	`(*database/sql.Conn).QueryContext`,
+	`(*database/sql.Conn).QueryRowContext`,
+	`(*github.com/jackc/pgx/v5.Conn).Exec`,
+	`(*github.com/jackc/pgx/v5.Conn).Query`,
+	`(*github.com/jackc/pgx/v5.Conn).QueryRow`,
+	`(*github.com/jackc/pgx/v5/pgxpool.Pool).Exec`,
+	`(*github.com/jackc/pgx/v5/pgxpool.Pool).Query`,
+	`(*github.com/jackc/pgx/v5/pgxpool.Pool).QueryRow`,
+}
+
+var (
+	flagFuncs   string
+	flagDialect dialectFlag
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&flagFuncs, `funcs`, ``, `additional query functions, comma-separated, such as "(*github.com/jmoiron/sqlx.DB).Get"`)
+	Analyzer.Flags.Var(&flagDialect, `dialect`, `dialect for parsing queries, such as "postgres"`)
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	funcs := queryFuncs()
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	insp.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(node ast.Node) {
+		call := node.(*ast.CallExpr)
+		fun, _ := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+		if fun == nil || !funcs[fun.FullName()] {
+			return
+		}
+
+		index := queryParamIndex(fun)
+		if index < 0 || index >= len(call.Args) {
+			return
+		}
+
+		arg := call.Args[index]
+		val := pass.TypesInfo.Types[arg].Value
+		if val == nil || val.Kind() != constant.String {
+			return
+		}
+
+		args := -1
+		if !call.Ellipsis.IsValid() {
+			args = len(call.Args) - index - 1
+		}
+
+		if msg := checkQuery(constant.StringVal(val), sqlp.Dialect(flagDialect), args); msg != `` {
+			pass.Reportf(arg.Pos(), `%v`, msg)
+		}
+	})
+	return nil, nil
+}
+
+/*
+Checks the query and returns a description of the first problem, or an empty
+string. A negative argument count means it's unknown.
+*/
+func checkQuery(src string, dialect sqlp.Dialect, args int) string {
+	parser := sqlp.Parser{Tokenizer: sqlp.Tokenizer{Source: src, Dialect: dialect}}
+	nodes, err := parser.Parse()
+	if err != nil {
+		return fmt.Sprintf(`invalid SQL: %v`, trimErr(err))
+	}
+
+	err = sqlp.CheckParamConsistency(nodes)
+	if err != nil {
+		return fmt.Sprintf(`invalid SQL placeholders: %v`, trimErr(err))
+	}
+
+	if args < 0 {
+		return ``
+	}
+
+	count := sqlp.CountPlaceholders(nodes)
+	style, err := sqlp.DetectParamStyle(src)
+	if err != nil || !(count > 0 && isNumbered(style) || style == sqlp.ParamStyleNone) {
+		return ``
+	}
+
+	if count != args {
+		return fmt.Sprintf(`SQL query requires %v arguments, got %v`, count, args)
+	}
+	return ``
+}
+
+func isNumbered(style sqlp.ParamStyle) bool {
+	switch style {
+	case sqlp.ParamStyleOrdinal, sqlp.ParamStyleNumeric, sqlp.ParamStyleQuestion, sqlp.ParamStyleQuestionNumbered:
+		return true
+	default:
+		return false
+	}
+}
+
+// Index of the first parameter of type string, or -1.
+func queryParamIndex(fun *types.Func) int {
+	params := fun.Type().(*types.Signature).Params()
+	for i := 0; i < params.Len(); i++ {
+		if types.Identical(params.At(i).Type(), types.Typ[types.String]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func queryFuncs() map[string]bool {
+	out := map[string]bool{}
+	for _, val := range DefaultFuncs {
+		out[val] = true
+	}
+	for _, val := range strings.Split(flagFuncs, `,`) {
+		if val = strings.TrimSpace(val); val != `` {
+			out[val] = true
+		}
+	}
+	return out
+}
+
+// Errors from sqlp are prefixed with "[sqlp]", which is redundant in reports.
+func trimErr(err error) string {
+	return strings.TrimPrefix(err.Error(), `[sqlp] `)
+}
+
+// Implements `flag.Value` for `sqlp.Dialect`, using its string representation.
+type dialectFlag sqlp.Dialect
+
+func (self dialectFlag) String() string { return sqlp.Dialect(self).String() }
+
+func (self *dialectFlag) Set(src string) error {
+	for val := 0; val <= 0xff; val++ {
+		if sqlp.Dialect(val).String() == src {
+			*self = dialectFlag(val)
+			return nil
+		}
+	}
+	return fmt.Errorf(`[sqlpvet] unknown dialect %q`, src)
+}
@@ -0,0 +1,45 @@
+package sqlpvet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mitranim/sqlp"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, `a`)
+}
+
+func TestDialectFlag(_ *testing.T) {
+	var val dialectFlag
+	try(val.Set(`mysql`))
+	eq(sqlp.DialectMySQL, sqlp.Dialect(val))
+	eq(`mysql`, val.String())
+	eq(true, val.Set(`unknown`) != nil)
+}
+
+func TestCheckQuery(_ *testing.T) {
+	eq(``, checkQuery(`select ?, ?`, sqlp.DialectSQLite, 2))
+	eq(`SQL query requires 2 arguments, got 1`, checkQuery(`select ?, ?`, sqlp.DialectSQLite, 1))
+	eq(``, checkQuery(`select ?, ?`, sqlp.DialectAny, 1))
+	eq(``, checkQuery(`select @one`, sqlp.DialectMSSQL, 0))
+}
+
+func try(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func eq(exp, act interface{}) {
+	if exp != act {
+		panic(fmt.Errorf(`
+expected:
+	%#v
+actual:
+	%#v
+`, exp, act))
+	}
+}
@@ -0,0 +1,27 @@
+package a
+
+import (
+	"context"
+	"database/sql"
+)
+
+const usersByID = `select * from users where id = $1`
+
+func queries(ctx context.Context, db *sql.DB, tx *sql.Tx, args []interface{}) {
+	db.Query(`select * from users where id = $1`, 10)
+	db.Query(usersByID, 10)
+	db.Query(`select 1`)
+	db.QueryContext(ctx, `select * from users where id = $1 and name = $2`, args...)
+	db.Exec(`select ':one', $1 -- $2`, 10)
+
+	db.Query(`select * from users where name = 'one`)                        // want `invalid SQL: expected closing`
+	db.QueryRowContext(ctx, `select * from (select 1`)                       // want `invalid SQL: `
+	tx.Exec(`update users set name = :name where id = $1`, 10)               // want `invalid SQL placeholders: query mixes parameter styles`
+	db.Query(usersByID)                                                      // want `SQL query requires 1 arguments, got 0`
+	db.Query(`select 1`, 10)                                                 // want `SQL query requires 0 arguments, got 1`
+	tx.QueryContext(ctx, `select * from users where id in ($1, $3)`, 10, 20) // want `SQL query requires 3 arguments, got 2`
+
+	query := `select (`
+	db.Query(query)
+	db.Query(`select * from users where id = :id`, sql.Named(`id`, 10))
+}
@@ -0,0 +1,91 @@
+//go:build go1.23
+
+package sqlp
+
+import "testing"
+
+func TestStatements(_ *testing.T) {
+	collect := func(seq func(func(Nodes, error) bool)) (out []string, err error) {
+		for nodes, err := range seq {
+			if err != nil {
+				return out, err
+			}
+			out = append(out, nodes.String())
+		}
+		return
+	}
+
+	{
+		out, err := collect(Statements(`
+			select 1;
+			-- comment
+			;
+			create function f() returns int as $$ begin return 1; end $$ language plpgsql;
+			select 'two' ; select 3
+		`))
+		try(err)
+		eq([]string{
+			`select 1`,
+			`create function f() returns int as $$ begin return 1; end $$ language plpgsql`,
+			`select 'two'`,
+			`select 3`,
+		}, out)
+	}
+
+	{
+		var out Nodes
+		for nodes, err := range Statements(`select 1; select (; select 'three`) {
+			try(err)
+			out = nodes
+			break
+		}
+		eq(Nodes{NodeText(`select`), NodeWhitespace(` `), NodeText(`1`)}, out)
+	}
+
+	{
+		out, err := collect(Statements(`select 1; select (; select 3`))
+		eq([]string{`select 1`}, out)
+		eq(`[sqlp] failed to parse statement at offset 10: [sqlp] missing closing delimiter ")"`, err.Error())
+	}
+
+	{
+		out, err := collect(Statements(`select 1; select 'two`))
+		eq([]string{`select 1`}, out)
+		eq(`[sqlp] expected closing '\'', got unexpected EOF`, err.Error())
+	}
+
+	{
+		splitter := Splitter{Tokenizer: Tokenizer{
+			Source:  "select `one`;\nDELIMITER //\nselect 2; select 3//\nselect 'four' // ",
+			Dialect: DialectMySQL,
+			Script:  true,
+		}}
+		out, err := collect(splitter.Statements())
+		try(err)
+		eq([]string{"select `one`", `DELIMITER //`, `select 2; select 3`, `select 'four'`}, out)
+
+		out, err = collect(splitter.Statements())
+		try(err)
+		eq([]string(nil), out)
+	}
+
+	{
+		splitter := Splitter{Tokenizer: Tokenizer{
+			Source:  "select 1;\n# trailing\n",
+			Dialect: DialectMySQL,
+		}}
+		out, err := collect(splitter.Statements())
+		try(err)
+		eq([]string{`select 1`}, out)
+	}
+
+	{
+		splitter := Splitter{Tokenizer: Tokenizer{
+			Source:   `select 'one`,
+			Unclosed: UnclosedClose,
+		}}
+		out, err := collect(splitter.Statements())
+		try(err)
+		eq([]string{`select 'one'`}, out)
+	}
+}
@@ -1,9 +1,22 @@
 package sqlp
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mitranim/sqlp/scan"
+	"github.com/mitranim/sqlp/testsuite"
 )
 
 func TestParse(_ *testing.T) {
@@ -142,7 +155,6 @@ func TestWalkNode(_ *testing.T) {
 	eq(src, visited)
 }
 
-// TODO also test `DeepWalkNodePtr`.
 func TestDeepWalkNode(_ *testing.T) {
 	src := Nodes{
 		NodeText(`one`),
@@ -191,6 +203,4752 @@ func TestCopyNode(_ *testing.T) {
 	eq(srcBackup, copy)
 }
 
+func TestNodesClone(_ *testing.T) {
+	eq(Nodes(nil), Nodes(nil).Clone())
+
+	src := Nodes{
+		NodeText(`one`),
+		ParenNodes{NodeText(`two`)},
+	}
+
+	clone := src.Clone()
+	eq(src, clone)
+
+	clone[0] = NodeText(`three`)
+	clone = append(clone, NodeText(`four`))
+	eq(Nodes{NodeText(`one`), ParenNodes{NodeText(`two`)}}, src)
+
+	// Inner collections are shared.
+	clone[1].(ParenNodes)[0] = NodeText(`five`)
+	eq(Nodes{NodeText(`one`), ParenNodes{NodeText(`five`)}}, src)
+}
+
+func TestInterner(_ *testing.T) {
+	var interner Interner
+	eq(nil, interner.Intern(nil))
+
+	srcOne := `select * from one where (a = $1 and b) limit 1`
+	srcTwo := `select * from two where (a = $1 and b) limit 2`
+
+	nodesOne, err := Parse(srcOne)
+	try(err)
+	nodesTwo, err := Parse(srcTwo)
+	try(err)
+
+	one := interner.InternNodes(nodesOne)
+	two := interner.InternNodes(nodesTwo)
+
+	eq(nodesOne, one)
+	eq(nodesTwo, two)
+	eq(srcOne, one.String())
+	eq(srcTwo, two.String())
+
+	parenOne := one[len(one)-5].(ParenNodes)
+	parenTwo := two[len(two)-5].(ParenNodes)
+	eq(true, &parenOne[0] == &parenTwo[0])
+
+	count := interner.Len()
+	eq(Nodes(one), interner.InternNodes(nodesOne))
+	eq(count, interner.Len())
+
+	// Structurally different nodes are not shared, even when their text matches.
+	three := interner.InternNodes(Nodes{ParenNodes{NodeText(`a`), NodeWhitespace(` `), NodeText(`b`)}})
+	four := interner.InternNodes(Nodes{ParenNodes{NodeText(`a b`)}})
+	eq(`(a b)`, three.String())
+	eq(Nodes{ParenNodes{NodeText(`a b`)}}, four)
+
+	eq(
+		Nodes{ParenNodes(nil), ParenNodes{}},
+		interner.InternNodes(Nodes{ParenNodes(nil), ParenNodes{}}),
+	)
+}
+
+func TestStats(_ *testing.T) {
+	ResetStats()
+
+	_, err := Parse(`select (one)`)
+	try(err)
+
+	if StatsEnabled {
+		eq(Stats{Tokens: 5, Bytes: 12, Nodes: 4, Allocs: 4}, ResetStats())
+	} else {
+		eq(Stats{}, ResetStats())
+	}
+	eq(Stats{}, ReadStats())
+}
+
+func TestAlign(_ *testing.T) {
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out := Align(nodes)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(``, ``)
+	test(`select a::int where b  = c`, `select a::int where b  = c`)
+
+	test(`
+	where
+		true
+		and (:one :: text is null or col_one = :one)
+		and (:two     :: int is null or col_two = :two)
+		and (:three :: date   is null or col_three <> :three)
+	`, `
+	where
+		true
+		and (:one   :: text is null or col_one   = :one)
+		and (:two   :: int  is null or col_two   = :two)
+		and (:three :: date is null or col_three <> :three)
+	`)
+
+	// Blank lines separate blocks; lines with different operator kinds are
+	// aligned separately.
+	test(`
+	a = 1
+	bbb = 2
+
+	cc = 3
+	d :: int
+	eeeee :: int
+	`, `
+	a   = 1
+	bbb = 2
+
+	cc = 3
+	d     :: int
+	eeeee :: int
+	`)
+}
+
+func TestEnsureTrailingSemicolon(_ *testing.T) {
+	test := func(src string, want bool, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, EnsureTrailingSemicolon(nodes, want).String())
+		eq(src, nodes.String())
+	}
+
+	test(``, true, ``)
+	test(` -- comment`, true, ` -- comment`)
+	test(`select 1`, true, `select 1;`)
+	test(`select 1;`, true, `select 1;`)
+	test(`select 1 ;`, true, `select 1 ;`)
+	test(`select 1 -- comment`, true, `select 1; -- comment`)
+	test(`select 1 /* one */ ; -- two`, true, `select 1 /* one */ ; -- two`)
+	test("select 1\n-- comment\n", true, "select 1;\n-- comment\n")
+
+	test(``, false, ``)
+	test(`select 1`, false, `select 1`)
+	test(`select 1;`, false, `select 1`)
+	test(`select 1;; `, false, `select 1 `)
+	test(`select 1 ; -- comment`, false, `select 1  -- comment`)
+	test(`select (1);`, false, `select (1)`)
+	test(`select 1; select 2;`, false, `select 1; select 2`)
+}
+
+func TestEnforceLimit(_ *testing.T) {
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		out, err := EnforceLimit(nodes, 100)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	fail := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		_, err = EnforceLimit(nodes, 100)
+		eq(exp, err.Error())
+	}
+
+	test(`select * from one`, `select * from one limit 100`)
+	test(` -- comment
+select * from one; -- comment`, `select * from one limit 100`)
+	test(`select * from one;select 2; delete from two`, `select * from one limit 100`)
+	test(`select * from one limit 10`, `select * from one limit 10`)
+	test(`select * from one limit 1000;`, `select * from one limit 100`)
+	test(`select * from one limit 99999999999999999999999`, `select * from one limit 100`)
+	test(`select * from one LIMIT ALL offset 5`, `select * from one LIMIT 100 offset 5`)
+	test(`select * from one offset 5 limit 500`, `select * from one offset 5 limit 100`)
+	test(`select * from one order by id offset 5`, `select * from one order by id limit 100 offset 5`)
+	test(`select * from one for update`, `select * from one limit 100 for update`)
+	test(`select * from one where id in (select id from two limit 1000)`, `select * from one where id in (select id from two limit 1000) limit 100`)
+	test(`select 1 union select 2`, `select 1 union select 2 limit 100`)
+	test(`select * from one limit $1`, `select * from (select * from one limit $1) as _ limit 100`)
+	test(`select * from one limit 5, 10`, `select * from (select * from one limit 5, 10) as _ limit 100`)
+	test(`select * from one fetch first 1000 rows only`, `select * from (select * from one fetch first 1000 rows only) as _ limit 100`)
+
+	fail(``, `[sqlp] expected select statement, got unknown statement`)
+	fail(`delete from one; select 1`, `[sqlp] expected select statement, got delete statement`)
+
+	_, err := EnforceLimit(nil, 0)
+	eq(`[sqlp] invalid maximum limit 0: expected positive number`, err.Error())
+}
+
+func TestEscapeLike(_ *testing.T) {
+	eq(``, EscapeLike(``, '!'))
+	eq(`one`, EscapeLike(`one`, '!'))
+	eq(`!%one!_two!!three\`, EscapeLike(`%one_two!three\`, '!'))
+	eq(`50\%`, EscapeLike(`50%`, '\\'))
+}
+
+func TestWithLikeEscape(_ *testing.T) {
+	test := func(src string, char byte, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, WithLikeEscape(nodes, char).String())
+		eq(src, nodes.String())
+	}
+
+	test(``, '!', ``)
+	test(`select * from one where name like 'a%'`, '!', `select * from one where name like 'a%'`)
+	test(`select * from one where name like $1`, '!', `select * from one where name like $1 escape '!'`)
+	test(`select * from one where name LIKE :name`, '\\', `select * from one where name LIKE :name escape '\'`)
+	test(`select * from one where name like $1`, '\'', `select * from one where name like $1 escape ''''`)
+
+	test(
+		`select * from one where name not ilike '%' || $1 || '%' and id = $2`,
+		'!',
+		`select * from one where name not ilike '%' || $1 || '%' escape '!' and id = $2`,
+	)
+
+	test(
+		`select * from one where (name like lower($1)) and b like $2 escape '#'`,
+		'!',
+		`select * from one where (name like lower($1) escape '!') and b like $2 escape '#'`,
+	)
+
+	test(
+		`select * from one where name like $1::text`,
+		'!',
+		`select * from one where name like $1::text escape '!'`,
+	)
+}
+
+func TestInLists(_ *testing.T) {
+	parse := func(src string) Nodes {
+		nodes, err := Parse(src)
+		try(err)
+		return nodes
+	}
+
+	eq([]InList(nil), InLists(parse(`select * from one where id = any($1) and two in (select 1)`)))
+
+	lists := InLists(parse(`select * from one where id in ( 10,20 , /* c */ 30 ) and (name NOT IN ('a')) and x in ()`))
+	eq([]InList{
+		{
+			Elems: []Nodes{{NodeText(`10`)}, {NodeText(`20`)}, {NodeText(`30`)}},
+			Seps: []Nodes{
+				{NodeText(`,`)},
+				{NodeWhitespace(` `), NodeText(`,`), NodeWhitespace(` `), NodeCommentBlock(` c `), NodeWhitespace(` `)},
+			},
+			Lead:  Nodes{NodeWhitespace(` `)},
+			Trail: Nodes{NodeWhitespace(` `)},
+		},
+		{Not: true, Elems: []Nodes{{NodeQuoteSingle(`a`)}}},
+		{},
+	}, lists)
+
+	eq(`( 10,20 , /* c */ 30 )`, lists[0].Paren().String())
+	eq(`('a')`, lists[1].Paren().String())
+	eq(`()`, lists[2].Paren().String())
+
+	list := InList{Elems: []Nodes{{NodeText(`1`)}, {NodeText(`2`)}, {NodeText(`3`)}}}
+	eq(`(1, 2, 3)`, list.Paren().String())
+
+	test := func(src, exp string) {
+		nodes := parse(src)
+		out := RewriteInLists(nodes, func(list InList) Nodes {
+			return Nodes{NodeText(`in`), NodeWhitespace(` `), list.Dedup().Paren()}
+		})
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(`select 1`, `select 1`)
+	test(`where id in (1, 2, 1, 3,2)`, `where id in (1, 2, 3)`)
+	test(`where id in(1,1) and (a, b) in ((1, 2), (1, 2))`, `where id in (1) and (a, b) in ((1, 2))`)
+	test(`where id not in ($1, $1)`, `where id in ($1)`)
+	test(`where id in (select id from two where x in (1, 1))`, `where id in (select id from two where x in (1))`)
+
+	nodes := parse(`where id in (1, 2) and x not in (3)`)
+	out := RewriteInLists(nodes, func(list InList) Nodes {
+		if list.Not {
+			return nil
+		}
+		return Nodes{NodeText(`= any`), ParenNodes{NodeOrdinalParam(1)}}
+	})
+	eq(`where id = any($1) and x not in (3)`, out.String())
+}
+
+func TestConvertInToAny(_ *testing.T) {
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		out, err := ConvertInToAny(nodes)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(`select 1`, `select 1`)
+	test(`where id in ($1)`, `where id = any($1)`)
+	test(`where id in ($1, $2, $3) and name = $4`, `where id = any($1) and name = $2`)
+	test(`where id NOT IN ( $2,$3 ) and name = $1`, `where id <> all($2) and name = $1`)
+	test(`where id in ($1, $3) and x in (1, 2) and y in ($2, 'one')`, `where id in ($1, $3) and x in (1, 2) and y in ($2, 'one')`)
+
+	test(
+		`where a = $1 and b in ($2, $3, $4) and c in (select d from e where f in ($5, $6)) and g = $7`,
+		`where a = $1 and b = any($2) and c in (select d from e where f = any($3)) and g = $4`,
+	)
+
+	nodes, err := Parse(`where id in ($1, $2) or parent_id = $2`)
+	try(err)
+	_, err = ConvertInToAny(nodes)
+	eq(true, err != nil)
+}
+
+func TestArrayLiteral(_ *testing.T) {
+	test := func(val interface{}, exp string) {
+		node, err := ArrayLiteral(val)
+		try(err)
+		eq(exp, node.String())
+	}
+
+	str := `three`
+	test([]string{}, `'{}'`)
+	test([]string{`one`, `two three`, ``, `NULL`, `a"b\c`, `{x}`, `it's`}, `'{one,"two three","","NULL","a\"b\\c","{x}",it''s}'`)
+	test([]*string{&str, nil}, `'{three,NULL}'`)
+	test([]interface{}{1, true, 1.5, nil, uint8(2)}, `'{1,true,1.5,NULL,2}'`)
+	test([2][]int{{1, 2}, {3, 4}}, `'{{1,2},{3,4}}'`)
+	test([]float64{math.NaN(), math.Inf(-1)}, `'{NaN,-Infinity}'`)
+
+	_, err := ArrayLiteral(nil)
+	eq(`[sqlp] expected slice or array, got <nil>`, err.Error())
+
+	_, err = ArrayLiteral([]byte(`one`))
+	eq(`[sqlp] expected slice or array, got []uint8`, err.Error())
+
+	_, err = ArrayLiteral([]struct{}{{}})
+	eq(`[sqlp] unsupported array element type struct {}`, err.Error())
+}
+
+func TestArrayConstructor(_ *testing.T) {
+	test := func(val interface{}, exp string) {
+		node, err := ArrayConstructor(val)
+		try(err)
+		eq(exp, node.String())
+	}
+
+	test([]string{}, `array[]`)
+	test([]string{`one`, `it's`}, `array['one', 'it''s']`)
+	test([]interface{}{1, nil, false}, `array[1, null, false]`)
+	test([][]int{{1, 2}, {3, 4}}, `array[[1, 2], [3, 4]]`)
+	test([]float32{1.25, float32(math.Inf(1))}, `array[1.25, 'Infinity']`)
+}
+
+func TestParseArray(_ *testing.T) {
+	test := func(src string, exp []interface{}) {
+		val, err := ParseArray(src)
+		try(err)
+		eq(exp, val)
+	}
+
+	test(`{}`, []interface{}{})
+	test(` { one , "two three",NULL, "NULL", "", "a\"b\\c" } `, []interface{}{`one`, `two three`, nil, `NULL`, ``, `a"b\c`})
+	test(`{{1,2},{3,4}}`, []interface{}{[]interface{}{`1`, `2`}, []interface{}{`3`, `4`}})
+	test(`{one two}`, []interface{}{`one two`})
+
+	fail := func(src, msg string) {
+		_, err := ParseArray(src)
+		eq(msg, err.Error())
+	}
+
+	fail(``, `[sqlp] invalid array "" at offset 0: expected '{'`)
+	fail(`{one`, `[sqlp] invalid array "{one" at offset 4: expected ','`)
+	fail(`{"one}`, `[sqlp] invalid array "{\"one}" at offset 6: unterminated quoted element`)
+	fail(`{one,}`, `[sqlp] invalid array "{one,}" at offset 5: unexpected empty element`)
+	fail(`{one} two`, `[sqlp] invalid array "{one} two" at offset 6: unexpected trailing text`)
+
+	// Round trip.
+	src := []string{`one`, `two three`, `a"b\c`, `NULL`, ``}
+	node, err := ArrayLiteral(src)
+	try(err)
+
+	val, err := ParseArray(strings.ReplaceAll(string(node), `''`, `'`))
+	try(err)
+	eq([]interface{}{`one`, `two three`, `a"b\c`, `NULL`, ``}, val)
+}
+
+func TestLiteral(_ *testing.T) {
+	test := func(val interface{}, dialect Dialect, exp string) {
+		node, err := Literal(val, dialect)
+		try(err)
+		eq(exp, node.String())
+	}
+
+	str := `one`
+	var nilStr *string
+
+	test(nil, DialectAny, `null`)
+	test(nilStr, DialectAny, `null`)
+	test([]string(nil), DialectAny, `null`)
+	test(&str, DialectAny, `'one'`)
+	test(`it's \n`, DialectPostgres, `'it''s \n'`)
+	test(`it's \n`, DialectMySQL, `'it''s \\n'`)
+	test(123, DialectAny, `123`)
+	test(-1.5, DialectAny, `-1.5`)
+	test(true, DialectAny, `true`)
+	test([]string{`one`, `two three`}, DialectPostgres, `'{one,"two three"}'`)
+
+	test(json.RawMessage(`{"one":1}`), DialectPostgres, `'{"one":1}'::jsonb`)
+	test(json.RawMessage(nil), DialectPostgres, `null`)
+	test(JSON{map[string]string{`key`: `it's`}}, DialectAny, `'{"key":"it''s"}'::jsonb`)
+	test(JSON{[]int{1, 2}}, DialectMySQL, `cast('[1,2]' as json)`)
+	test(JSON{`a\b`}, DialectMySQL, `cast('"a\\\\b"' as json)`)
+	test(JSON{nil}, DialectSQLite, `'null'`)
+
+	_, err := Literal([]string{}, DialectMySQL)
+	eq(`[sqlp] array literals are not supported in dialect "mysql"`, err.Error())
+
+	_, err = Literal(struct{}{}, DialectAny)
+	eq(`[sqlp] can't render value of type struct {} as SQL literal`, err.Error())
+
+	_, err = Literal(JSON{func() {}}, DialectAny)
+	eq(true, strings.HasPrefix(err.Error(), `[sqlp] failed to encode JSON literal: `))
+}
+
+type literalSigil string
+
+func (self literalSigil) SQLLiteral(dialect Dialect) (Node, error) {
+	if self == `` {
+		return nil, fmt.Errorf(`empty sigil`)
+	}
+	return NodeText(`sigil_` + string(self) + `_` + dialect.String()), nil
+}
+
+func TestLiteral_types(_ *testing.T) {
+	test := func(val interface{}, dialect Dialect, exp string) {
+		node, err := Literal(val, dialect)
+		try(err)
+		eq(exp, node.String())
+	}
+
+	inst := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.FixedZone(``, 7*3600))
+	var nilTime *time.Time
+
+	test(inst, DialectAny, `'2020-01-02 03:04:05.123456789+07:00'::timestamptz`)
+	test(&inst, DialectPostgres, `'2020-01-02 03:04:05.123456789+07:00'::timestamptz`)
+	test(nilTime, DialectPostgres, `null`)
+	test(inst, DialectMySQL, `'2020-01-01 20:04:05.123456'`)
+	test(inst, DialectSQLite, `'2020-01-02 03:04:05.123456789+07:00'`)
+	test(inst, DialectMSSQL, `'2020-01-02T03:04:05.1234567+07:00'`)
+	test(inst, DialectANSI, `timestamp with time zone '2020-01-02 03:04:05.123456789+07:00'`)
+	test(inst, DialectOracle, `timestamp '2020-01-02 03:04:05.123456789 +07:00'`)
+	test(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), DialectPostgres, `'2020-01-02 00:00:00+00:00'::timestamptz`)
+
+	test([]byte{0x01, 0xab}, DialectPostgres, `'\x01ab'::bytea`)
+	test([]byte{}, DialectAny, `'\x'::bytea`)
+	test([]byte(nil), DialectAny, `null`)
+	test([]byte{0x01, 0xab}, DialectMySQL, `x'01ab'`)
+	test([]byte{0x01, 0xab}, DialectSQLite, `x'01ab'`)
+	test([]byte{0x01, 0xab}, DialectMSSQL, `0x01ab`)
+	test([]byte{0x01, 0xab}, DialectOracle, `hextoraw('01ab')`)
+
+	test(true, DialectPostgres, `true`)
+	test(false, DialectSQLite, `false`)
+	test(true, DialectMSSQL, `1`)
+	test(false, DialectOracle, `0`)
+
+	test(json.Number(`-12.50e3`), DialectAny, `-12.50e3`)
+	test(big.NewInt(-123), DialectAny, `-123`)
+	test(big.NewFloat(1.5), DialectAny, `1.5`)
+
+	test(literalSigil(`one`), DialectMySQL, `sigil_one_mysql`)
+	test(valuerId(3), DialectAny, `30`)
+	test(sql.NullString{}, DialectAny, `null`)
+	test(sql.NullTime{Time: inst, Valid: true}, DialectSQLite, `'2020-01-02 03:04:05.123456789+07:00'`)
+
+	_, err := Literal(literalSigil(``), DialectAny)
+	eq(`empty sigil`, err.Error())
+
+	_, err = Literal(json.Number(`1e`), DialectAny)
+	eq(`[sqlp] invalid number "1e"`, err.Error())
+
+	_, err = Literal(inst, Dialect(100))
+	eq(`[sqlp] timestamp literals are not supported in dialect "Dialect(100)"`, err.Error())
+
+	eq(true, isDecimal(`1`))
+	eq(true, isDecimal(`.5`))
+	eq(true, isDecimal(`5.`))
+	eq(true, isDecimal(`+1E-7`))
+	eq(false, isDecimal(``))
+	eq(false, isDecimal(`.`))
+	eq(false, isDecimal(`NaN`))
+	eq(false, isDecimal(`1 `))
+	eq(false, isDecimal(`1; drop table one`))
+}
+
+type valuerId int
+
+func (self valuerId) Value() (driver.Value, error) {
+	if self < 0 {
+		return nil, fmt.Errorf(`negative id`)
+	}
+	return int64(self) * 10, nil
+}
+
+type valuerPtr struct{}
+
+func (self *valuerPtr) Value() (driver.Value, error) { return `ptr`, nil }
+
+type valuerCycle struct{}
+
+func (self valuerCycle) Value() (driver.Value, error) { return self, nil }
+
+func TestDriverValue(_ *testing.T) {
+	test := func(val interface{}, exp interface{}) {
+		out, err := DriverValue(val)
+		try(err)
+		eq(exp, out)
+	}
+
+	var nilId *valuerId
+	var nilPtr *valuerPtr
+
+	test(nil, nil)
+	test(`one`, `one`)
+	test(valuerId(2), int64(20))
+	test(nilId, nil)
+	test(nilPtr, `ptr`)
+	test(sql.NullString{}, nil)
+	test(sql.NullString{String: `one`, Valid: true}, `one`)
+
+	_, err := DriverValue(valuerId(-1))
+	eq(`[sqlp] failed to convert sqlp.valuerId via driver.Valuer: negative id`, err.Error())
+
+	_, err = DriverValue(valuerCycle{})
+	eq(`[sqlp] too many nested driver.Valuer conversions for sqlp.valuerCycle, possible cycle`, err.Error())
+}
+
+func TestBindNamed(_ *testing.T) {
+	nodes, err := Parse(`select * from one where a = :one and (b = :two or c = :one) and d = ':three'`)
+	try(err)
+	src := nodes.String()
+
+	out, args, err := BindNamed(nodes, map[string]interface{}{
+		`one`:   valuerId(1),
+		`two`:   `two`,
+		`three`: 3,
+	})
+	try(err)
+
+	eq(`select * from one where a = $1 and (b = $2 or c = $1) and d = ':three'`, out.String())
+	eq([]interface{}{int64(10), `two`}, args)
+	eq(src, nodes.String())
+
+	out, args, err = BindNamed(Nodes{NodeText(`select 1`)}, nil)
+	try(err)
+	eq(`select 1`, out.String())
+	eq([]interface{}(nil), args)
+
+	// "At" params as in MSSQL and MySQL, sharing arguments with named params.
+	parser := Parser{Tokenizer: Tokenizer{Source: `select @@rowcount, @one, :two, @two, @one`, Dialect: DialectMSSQL}}
+	nodes, err = parser.Parse()
+	try(err)
+
+	out, args, err = BindNamed(nodes, map[string]interface{}{`one`: 1, `two`: 2})
+	try(err)
+	eq(`select @@rowcount, $1, $2, $2, $1`, out.String())
+	eq([]interface{}{1, 2}, args)
+
+	rendered, err := RenderParams(out, ParamStyleAt)
+	try(err)
+	eq(`select @@rowcount, @p1, @p2, @p2, @p1`, rendered)
+
+	fail := func(src string, args map[string]interface{}, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, _, err = BindNamed(nodes, args)
+		eq(msg, err.Error())
+	}
+
+	fail(`select :one`, nil, `[sqlp] missing argument for named parameter ":one"`)
+
+	parser = Parser{Tokenizer: Tokenizer{Source: `select @one`, Dialect: DialectMySQL}}
+	nodes, err = parser.Parse()
+	try(err)
+	_, _, err = BindNamed(nodes, nil)
+	eq(`[sqlp] missing argument for named parameter "@one"`, err.Error())
+	fail(`select :one, $1`, map[string]interface{}{`one`: 1}, `[sqlp] can't bind named parameters in a query with parameter "$1"`)
+	fail(`select :one`, map[string]interface{}{`one`: valuerId(-1)}, `[sqlp] failed to convert sqlp.valuerId via driver.Valuer: negative id`)
+}
+
+func TestBinder_nulls(_ *testing.T) {
+	nodes, err := Parse(`select :one, :two, :three, :four, :five, :six`)
+	try(err)
+
+	str := `str`
+	args := map[string]interface{}{
+		`one`:   nil,
+		`two`:   (*string)(nil),
+		`three`: &str,
+		`four`:  sql.NullInt64{},
+		`five`:  sql.NullInt64{Int64: 5, Valid: true},
+	}
+
+	_, _, err = BindNamed(nodes, args)
+	eq(`[sqlp] missing argument for named parameter ":six"`, err.Error())
+
+	out, vals, err := Binder{MissingAsNull: true}.BindNamed(nodes, args)
+	try(err)
+	eq(`select $1, $2, $3, $4, $5, $6`, out.String())
+	eq([]interface{}{nil, nil, &str, nil, int64(5), nil}, vals)
+}
+
+func TestInterpolate(_ *testing.T) {
+	test := func(src string, dialect Dialect, args []interface{}, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := Interpolate(nodes, dialect, args)
+		try(err)
+		eq(exp, out)
+		eq(src, nodes.String())
+	}
+
+	test(``, DialectAny, nil, ``)
+	test(
+		`select * from one where a = $1 and (b = $2 or c = $1) and d = $3`,
+		DialectPostgres,
+		[]interface{}{`it's`, 10, json.RawMessage(`[1]`)},
+		`select * from one where a = 'it''s' and (b = 10 or c = 'it''s') and d = '[1]'::jsonb`,
+	)
+	test(
+		`select 10-$1, x, $2, $3`,
+		DialectAny,
+		[]interface{}{-5, -1.5, json.Number(`-2`)},
+		`select 10-(-5), x, (-1.5), (-2)`,
+	)
+
+	fail := func(src string, args []interface{}, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = Interpolate(nodes, DialectAny, args)
+		eq(msg, err.Error())
+	}
+
+	fail(`select $2`, []interface{}{1}, `[sqlp] missing argument for parameter "$2": got 1 arguments`)
+	fail(`select :one`, nil, `[sqlp] can't interpolate named parameter ":one"`)
+}
+
+func TestExtractOptions(_ *testing.T) {
+	test := func(src, expSrc string, expOpts map[string]string) {
+		nodes, opts, err := ParseOptions(src)
+		try(err)
+		eq(expSrc, nodes.String())
+		eq(expOpts, opts)
+	}
+
+	test(``, ``, nil)
+	test(`select {one}`, `select {one}`, nil)
+	test(`{option:} select 1`, ` select 1`, map[string]string{})
+
+	test(
+		`{option: timeout=5s, label='it''s here' replica} select 1`,
+		` select 1`,
+		map[string]string{`timeout`: `5s`, `label`: `it's here`, `replica`: `true`},
+	)
+
+	test(
+		"select 1\n{ OPTION:key=val }\n{option: other=}",
+		"select 1\n\n",
+		map[string]string{`key`: `val`, `other`: ``},
+	)
+
+	// Nested braces are not directives.
+	test(`select ({option: one=two})`, `select ({option: one=two})`, nil)
+
+	fail := func(src, msg string) {
+		_, _, err := ParseOptions(src)
+		eq(msg, err.Error())
+	}
+
+	fail(`{option: one=1} {option: one=2}`, `[sqlp] redundant option "one"`)
+	fail(`{option: =1}`, `[sqlp] invalid option directive " =1": missing key`)
+	fail(`{option: one=1`, `[sqlp] missing closing delimiter "}"`)
+
+	_, _, err := ExtractOptions(Nodes{BraceNodes{NodeText(`option: one='1`)}})
+	eq(`[sqlp] invalid option directive " one='1": unterminated quoted value`, err.Error())
+}
+
+func TestFillSlots(_ *testing.T) {
+	base, err := Parse(`select {slot columns: *} from users where true {slot filter:} and ({slot extra: {slot inner:x}})`)
+	try(err)
+	src := base.String()
+
+	names, err := Slots(base)
+	try(err)
+	eq([]string{`columns`, `filter`, `extra`, `inner`}, names)
+
+	test := func(contents map[string]Nodes, exp string) {
+		out, err := FillSlots(base, contents)
+		try(err)
+		eq(exp, out.String())
+		eq(src, base.String())
+	}
+
+	test(nil, `select * from users where true  and (x)`)
+
+	test(
+		map[string]Nodes{
+			`columns`: {NodeText(`id,`), nodeWhitespaceSingle, NodeText(`name`)},
+			`filter`:  {NodeText(`and`), nodeWhitespaceSingle, NodeText(`active`)},
+			`inner`:   {NodeOrdinalParam(1)},
+		},
+		`select id, name from users where true and active and ($1)`,
+	)
+
+	test(map[string]Nodes{`extra`: {}}, `select * from users where true  and ()`)
+
+	fail := func(src string, contents map[string]Nodes, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = FillSlots(nodes, contents)
+		eq(msg, err.Error())
+	}
+
+	fail(`select {slot columns}`, nil, `[sqlp] missing content for slot "columns" without default`)
+	fail(`select {slot columns:*}`, map[string]Nodes{`colums`: nil, `a`: nil}, `[sqlp] unknown slots ["a" "colums"]`)
+	fail(`select {slot}`, nil, `[sqlp] invalid slot "{slot}": expected slot name`)
+	fail(`select {slot one two}`, nil, `[sqlp] invalid slot "{slot one two}": expected ":" or "}" after slot name`)
+
+	out, err := FillSlots(Nodes{BraceNodes{NodeText(`slot`), nodeWhitespaceSingle, NodeText(`one`), nodeWhitespaceSingle, NodeText(`:`), NodeText(`two`)}}, nil)
+	try(err)
+	eq(`two`, out.String())
+}
+
+func TestFillSlotsRenaming(_ *testing.T) {
+	parse := func(src string) Nodes {
+		nodes, err := Parse(src)
+		try(err)
+		return nodes
+	}
+
+	base := parse(`select * from users u join posts p on p.user_id = u.id where {slot filter} and {slot other: true}`)
+
+	out, renames, err := FillSlotsRenaming(base, map[string]Nodes{
+		`filter`: parse(`exists (select 1 from users u where u.id = p.user_id)`),
+		`other`:  parse(`u.id in (select u.id from users u join posts p on p.id = $1) and exists (select 1 from users u_2)`),
+	})
+	try(err)
+
+	eq(
+		`select * from users u join posts p on p.user_id = u.id where exists (select 1 from users u_2 where u_2.id = p.user_id) and u.id in (select u_3.id from users u_3 join posts p_2 on p_2.id = $1) and exists (select 1 from users u_2_2)`,
+		out.String(),
+	)
+	eq([]AliasRename{
+		{Slot: `filter`, From: `u`, To: `u_2`},
+		{Slot: `other`, From: `u`, To: `u_3`},
+		{Slot: `other`, From: `p`, To: `p_2`},
+		{Slot: `other`, From: `u_2`, To: `u_2_2`},
+	}, renames)
+
+	// Without collisions, same as `FillSlots`.
+	out, renames, err = FillSlotsRenaming(base, map[string]Nodes{`filter`: parse(`u.id = $1`)})
+	try(err)
+	eq(`select * from users u join posts p on p.user_id = u.id where u.id = $1 and true`, out.String())
+	eq([]AliasRename(nil), renames)
+
+	_, _, err = FillSlotsRenaming(base, map[string]Nodes{`filter`: nil, `unknown`: nil})
+	eq(`[sqlp] unknown slots ["unknown"]`, err.Error())
+}
+
+func TestExpandEach(_ *testing.T) {
+	test := func(src string, lists map[string][]Node, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := ExpandEach(nodes, lists)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(`select 1`, nil, `select 1`)
+
+	test(
+		`insert into one (id) values ($1) on conflict (id) do update set {each cols: {.} = excluded.{.}}`,
+		map[string][]Node{`cols`: Idents(`name`, `one.email`)},
+		`insert into one (id) values ($1) on conflict (id) do update set "name" = excluded."name", "one"."email" = excluded."one"."email"`,
+	)
+
+	test(
+		`select * from one where ({each conds ' and ': ({.})})`,
+		map[string][]Node{`conds`: {NodeText(`a`), Nodes{NodeText(`b`), NodeText(`=`), NodeOrdinalParam(1)}}},
+		`select * from one where ((a) and (b=$1))`,
+	)
+
+	test(
+		`select {each cols '; ':{.}} from one`,
+		map[string][]Node{`cols`: {NodeText(`a`), NodeText(`b`)}},
+		`select a; b from one`,
+	)
+
+	test(`select {each cols: {.}} from one`, map[string][]Node{`cols`: nil}, `select  from one`)
+
+	test(
+		`{each rows: ({each cols: {.}})}`,
+		map[string][]Node{`rows`: {NodeText(`x`), NodeText(`y`)}, `cols`: {NodeText(`a`), NodeText(`b`)}},
+		`(a, b), (a, b)`,
+	)
+
+	// "{.}" outside of fragments is left as-is.
+	test(`select {.}`, nil, `select {.}`)
+
+	fail := func(src string, lists map[string][]Node, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = ExpandEach(nodes, lists)
+		eq(msg, err.Error())
+	}
+
+	fail(`{each cols: {.}}`, nil, `[sqlp] missing list "cols"`)
+	fail(`{each cols: {.}}`, map[string][]Node{`cols`: nil, `rows`: nil}, `[sqlp] unknown lists ["rows"]`)
+	fail(`{each cols {.}}`, map[string][]Node{`cols`: nil}, `[sqlp] invalid each "{each cols {.}}": expected ":" before fragment`)
+	fail(`{each ', ': {.}}`, nil, `[sqlp] invalid each "{each ', ': {.}}": expected each name`)
+}
+
+func TestSplitInsert(_ *testing.T) {
+	type batch struct {
+		Src  string
+		Args []interface{}
+	}
+
+	test := func(src string, args []interface{}, maxPlaceholders, maxRows int, exp ...batch) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := SplitInsert(nodes, args, maxPlaceholders, maxRows)
+		try(err)
+		eq(src, nodes.String())
+
+		var act []batch
+		for _, val := range out {
+			act = append(act, batch{val.Nodes.String(), val.Args})
+		}
+		eq(exp, act)
+	}
+
+	test(
+		`insert into one (a, b) values ($1, $2), ($3, $4), ($5, $6) on conflict do nothing`,
+		[]interface{}{10, `one`, 20, `two`, 30, `three`}, 4, 0,
+		batch{`insert into one (a, b) values ($1, $2), ($3, $4) on conflict do nothing`, []interface{}{10, `one`, 20, `two`}},
+		batch{`insert into one (a, b) values ($1, $2) on conflict do nothing`, []interface{}{30, `three`}},
+	)
+
+	test(
+		"insert into one values($1),\n\t($2) ,($3);",
+		[]interface{}{10, 20, 30}, 0, 2,
+		batch{"insert into one values($1),\n\t($2);", []interface{}{10, 20}},
+		batch{`insert into one values($1);`, []interface{}{30}},
+	)
+
+	test(
+		`insert into one values ($1, 'one'), (default, 2)`,
+		[]interface{}{10}, 0, 0,
+		batch{`insert into one values ($1, 'one'), (default, 2)`, []interface{}{10}},
+	)
+
+	test(
+		`insert into one values ($2, lower($1), $2), ($3, $1, $4) on conflict (a) do update set b = $5`,
+		[]interface{}{`one`, `two`, `three`, `four`, `five`}, 4, 0,
+		batch{`insert into one values ($1, lower($2), $1) on conflict (a) do update set b = $3`, []interface{}{`two`, `one`, `five`}},
+		batch{`insert into one values ($1, $2, $3) on conflict (a) do update set b = $4`, []interface{}{`three`, `one`, `four`, `five`}},
+	)
+
+	fail := func(src string, args []interface{}, maxPlaceholders int) {
+		nodes, err := Parse(src)
+		try(err)
+		_, err = SplitInsert(nodes, args, maxPlaceholders, 0)
+		eq(true, err != nil)
+	}
+
+	fail(`select $1`, []interface{}{10}, 0)
+	fail(`insert into one select $1`, []interface{}{10}, 0)
+	fail(`insert into one values ($1); insert into two values ($2)`, []interface{}{10, 20}, 0)
+	fail(`insert into one values (:one)`, nil, 0)
+	fail(`insert into one values ($1, $2)`, []interface{}{10}, 0)
+	fail(`insert into one values ($1, $2)`, []interface{}{10, 20}, 1)
+}
+
+func TestColumns(_ *testing.T) {
+	type Base struct {
+		Id      int64 `db:"id"`
+		private int   `db:"private"`
+	}
+
+	type User struct {
+		Base
+		Name    string `db:"name,omitempty"`
+		Email   string `db:"email"`
+		Skipped string `db:"-"`
+		Untag   string
+		Quoted  string `db:"a\"b"`
+		Nested  Base   `db:"nested"`
+		_       int    `db:"blank"`
+	}
+
+	const exp = `"id", "name", "email", "a""b", "nested"`
+	eq(exp, Columns(User{}).String())
+	eq(exp, Columns(&User{}).String())
+	eq(exp, Columns((*User)(nil)).String())
+	eq(exp, Columns(reflect.TypeOf(User{})).String())
+
+	eq(`"users"."id", "users"."name"`, TableColumns(`users`, struct {
+		Id   int64  `db:"id"`
+		Name string `db:"name"`
+	}{}).String())
+
+	eq(`"public"."users"."id"`, TableColumns(`public.users`, Base{}).String())
+	eq(`"id"`, TableColumns(``, Base{}).String())
+	eq(``, Columns(struct{}{}).String())
+
+	// The result is a valid AST which can be spliced into a query.
+	nodes, err := Parse(`select * from users`)
+	try(err)
+	nodes[2] = Columns(Base{})
+	eq(`select "id" from users`, nodes.String())
+
+	panics := func(val interface{}) {
+		defer func() { eq(true, recover() != nil) }()
+		Columns(val)
+	}
+	panics(nil)
+	panics(10)
+	panics([]User(nil))
+}
+
+func TestOutputColumns(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		for _, nodes := range parseModes(src) {
+			cols, err := OutputColumns(nodes)
+			try(err)
+
+			var names []string
+			for _, col := range cols {
+				if col.Star {
+					names = append(names, `*`)
+				} else {
+					names = append(names, col.Name)
+				}
+			}
+			eq(exp, names)
+		}
+	}
+
+	test(`insert into one values (1)`)
+	test(`select`)
+	test(`select 1`, ``)
+	test(`select id from users`, `id`)
+	test(`select id,Name,"Email" from users;`, `id`, `name`, `Email`)
+	test(`select u.id, u."Name", "u".email from users u`, `id`, `Name`, `email`)
+	test(`select *, u.* from users u`, `*`, `*`)
+	test(`select 1 as one, 2 two, 3 "Three", 4 as "fo""ur"`, `one`, `two`, `Three`, `fo"ur`)
+	test(`select count(*), count(*) total, pg_catalog.now() from users`, `count`, `total`, `now`)
+	test(`select id::text, (a + b)::text, c::varchar(10), (c)::varchar(10), 1::int[], e::text as f`, `id`, `text`, `c`, `varchar`, `int`, `f`)
+	test(`select a + b, a - b c, a is null, b is not true, name collate "C"`, ``, `c`, ``, ``, ``)
+	test(`select case when a then 1 else 2 end, case when a then 1 end as b`, ``, `b`)
+	test(`select distinct on (a) a, b from t order by a`, `a`, `b`)
+	test(`select distinct a from t`, `a`)
+	test(`select all a from t`, `a`)
+	test(`select a, (select b from t) as c, exists (select 1) d from one where x limit 1`, `a`, `c`, `d`)
+	test(`with cte as (select x from y) select a from cte union select b from two`, `a`)
+	test(`(select a, b from one) union (select c, d from two)`, `a`, `b`)
+	test("select `a`, b -- comment\nfrom t", `a`, `b`)
+
+	fail := func(src, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+		_, err = OutputColumns(nodes)
+		eq(msg, err.Error())
+	}
+
+	fail(`select a,, b`, `[sqlp] invalid select list: empty item`)
+	fail(`select a, from t`, `[sqlp] invalid select list: unexpected trailing comma`)
+	fail(`select a as from t`, `[sqlp] invalid select list: expected alias after AS`)
+	fail(`select 1; select 2`, `[sqlp] unsupported multiple statements`)
+
+	nodes, err := Parse(`select a + b as c`)
+	try(err)
+	cols, err := OutputColumns(nodes)
+	try(err)
+	eq(`a + b`, cols[0].Expr.String())
+}
+
+func TestCheckOutputColumns(_ *testing.T) {
+	type User struct {
+		Id   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+
+	check := func(src string) error {
+		nodes, err := Parse(src)
+		try(err)
+		return CheckOutputColumns(nodes, (*User)(nil))
+	}
+
+	try(check(`select id, name from users`))
+	try(check(`select u.id, upper(u.name) as name, * from users u`))
+	try(check(`select 1 + 2`))
+	eq(
+		`[sqlp] result columns ["email" "count"] have no corresponding fields in sqlp.User`,
+		check(`select id, email, count(*), email from users`).Error(),
+	)
+}
+
+func TestOnConflictUpdate(_ *testing.T) {
+	eq(
+		` on conflict ("id") do update set "name" = excluded."name", "e""mail" = excluded."e""mail"`,
+		OnConflictUpdate([]string{`name`, `e"mail`}, []string{`id`}).String(),
+	)
+
+	eq(
+		` on conflict ("one", "two") do nothing`,
+		OnConflictUpdate(nil, []string{`one`, `two`}).String(),
+	)
+
+	eq(` on conflict do nothing`, OnConflictUpdate(nil, nil).String())
+
+	func() {
+		defer func() {
+			eq(`[sqlp] invalid upsert: "do update" requires a conflict target`, recover().(error).Error())
+		}()
+		OnConflictUpdate([]string{`name`}, nil)
+	}()
+
+	nodes, err := Parse(`insert into one (id, name) values ($1, $2)`)
+	try(err)
+
+	eq(
+		`insert into one (id, name) values ($1, $2) on conflict ("id") do update set "name" = excluded."name"`,
+		append(nodes, OnConflictUpdate([]string{`name`}, []string{`id`})...).String(),
+	)
+}
+
+func TestTables(_ *testing.T) {
+	test := func(src string, exp []TableRef) {
+		for _, nodes := range parseModes(src) {
+			tables, err := Tables(nodes)
+			try(err)
+			eq(exp, tables)
+		}
+	}
+
+	test(`select 1`, nil)
+	test(`insert into one values (1)`, nil)
+	test(`select * from one`, []TableRef{{Name: `one`}})
+	test(`select * from One as o where true`, []TableRef{{Name: `one`, Alias: `o`}})
+
+	test(
+		`select * from public."Two" t, "s"."t" as x, a,b join c on a.id = c.id left outer join d using (id)`,
+		[]TableRef{
+			{Name: `public.Two`, Alias: `t`},
+			{Name: `s.t`, Alias: `x`},
+			{Name: `a`},
+			{Name: `b`},
+			{Name: `c`},
+			{Name: `d`},
+		},
+	)
+
+	test(
+		`with one as (select * from two) select * from one, generate_series(1, 2) as g (val), (select * from three) as three where id in (select id from four)`,
+		[]TableRef{{Name: `one`}, {Name: `two`}, {Name: `three`}, {Name: `four`}},
+	)
+
+	nodes, err := Parse(`select * from one two three`)
+	try(err)
+	_, err = Tables(nodes)
+	eq(`[sqlp] invalid FROM clause: unexpected "three"`, err.Error())
+}
+
+func TestAliases(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		nodes, err := Parse(src)
+		try(err)
+		aliases, err := Aliases(nodes)
+		try(err)
+		eq(exp, aliases)
+	}
+
+	test(`select 1`)
+	test(`insert into one values (1)`)
+	test(`select * from users u join public.Posts on true`, `u`, `posts`)
+	test(`select * from users as "U", (select 1) as sub, (select 2) x2, generate_series(1, 2)`, `U`, `sub`, `x2`)
+	test(`select * from one where id in (select id from two t join one on true)`, `one`, `t`)
+}
+
+func TestRenameAlias(_ *testing.T) {
+	test := func(src, from, to, exp string) {
+		for _, nodes := range parseModes(src) {
+			out, err := RenameAlias(nodes, from, to)
+			try(err)
+			eq(exp, out.String())
+			eq(src, nodes.String())
+		}
+	}
+
+	test(
+		`select u.id, u."name", "u".email, x.u.id from users u where u.id=$1 and (u.a+u.b) > 0 order by u.id`,
+		`u`, `u2`,
+		`select u2.id, u2."name", u2.email, x.u.id from users u2 where u2.id=$1 and (u2.a+u2.b) > 0 order by u2.id`,
+	)
+
+	test(
+		`select U.id from users as U`,
+		`u`, `Users`,
+		`select "Users".id from users as "Users"`,
+	)
+
+	test(
+		`select users.id, count(posts.id) from users join posts on posts.user_id = users.id group by users.id`,
+		`users`, `u`,
+		`select u.id, count(posts.id) from users as u join posts on posts.user_id = u.id group by u.id`,
+	)
+
+	// Subqueries which declare the same alias shadow the outer one.
+	test(
+		`select t.id from one t where t.id in (select t.id from two t) and exists (select 1 from three where three.id = t.id)`,
+		`t`, `o`,
+		`select o.id from one o where o.id in (select t.id from two t) and exists (select 1 from three where three.id = o.id)`,
+	)
+
+	// Declarations in subqueries.
+	test(
+		`select * from (select t.id from one t) a, (select t.id from two t) b where a.id = b.id`,
+		`t`, `x`,
+		`select * from (select x.id from one x) a, (select x.id from two x) b where a.id = b.id`,
+	)
+
+	fail := func(src, from, to, msg string) {
+		nodes, err := Parse(src)
+		try(err)
+		_, err = RenameAlias(nodes, from, to)
+		eq(msg, err.Error())
+	}
+
+	fail(`select * from one t`, `u`, `x`, `[sqlp] alias "u" not found`)
+	fail(`select * from one t, two u`, `t`, `u`, `[sqlp] can't rename alias "t" to "u": name already used`)
+	fail(`select * from one t`, `t`, ``, `[sqlp] can't rename alias "t" to empty name`)
+}
+
+func TestMapOrderDeterministic(_ *testing.T) {
+	const count = 16
+
+	var names []string
+	args := map[string]interface{}{}
+	meta := map[string]string{}
+	slots := map[string]Nodes{}
+	lists := map[string][]Node{}
+	columns := map[string]string{}
+
+	var src, from []string
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf(`name_%02d`, count-i)
+		names = append(names, name)
+		args[name] = i
+		meta[name] = name
+		slots[name] = Nodes{NodeText(name)}
+		lists[name] = []Node{NodeText(name)}
+		columns[name] = `deleted_at`
+		src = append(src, `:`+name)
+		from = append(from, name)
+	}
+
+	nodes, err := Parse(`select ` + strings.Join(src, `, `) + ` from ` + strings.Join(from, `, `))
+	try(err)
+
+	run := func() string {
+		bound, vals, err := BindNamed(nodes, args)
+		try(err)
+
+		filtered, err := FilterSoftDeleted(nodes, columns)
+		try(err)
+
+		_, slotErr := FillSlots(Nodes{NodeText(`select`)}, slots)
+		_, eachErr := ExpandEach(Nodes{NodeText(`select`)}, lists)
+
+		withMeta, err := WithMeta(nodes, meta)
+		try(err)
+
+		return fmt.Sprint(
+			bound, vals, filtered, slotErr, eachErr, withMeta, WithComment(nodes, meta),
+		)
+	}
+
+	exp := run()
+	for i := 0; i < 32; i++ {
+		eq(exp, run())
+	}
+
+	_, vals, err := BindNamed(nodes, args)
+	try(err)
+	for i, val := range vals {
+		eq(i, val)
+	}
+
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	_, err = FillSlots(nil, slots)
+	eq(fmt.Sprintf(`[sqlp] unknown slots %q`, sorted), err.Error())
+}
+
+func TestAnonymize(_ *testing.T) {
+	test := func(exp, src string) {
+		for _, nodes := range parseModes(src) {
+			eq(exp, Anonymize(nodes).String())
+			eq(src, nodes.String())
+		}
+	}
+
+	test(``, ``)
+	test(`select 0`, `select 1`)
+	test(`select c1, c2 from t1`, `select id, name from users`)
+	test(`SELECT c1 FROM t1 WHERE c1 = 0`, `SELECT Id FROM Users WHERE id = 10`)
+	test(`select t1.c1 from t2 t1 where t1.c2 = '' and c3 > 0  `, `select u.email from users u where u.name = 'Alice' and age > 30 -- find alice`)
+	test(`select c1 from t1.t2 as t3`, `select "Id" from public."Users" as u`)
+	test(`select c1, c2 from t1`, `select "id", id from t1`)
+	test(`select count(*), lower(c1) from t1`, `select count(*), lower(name) from users`)
+	test(`select c1 from t1 where c2 = ''`, `select a from b where c = 'it''s'`)
+	test(`select c1::uuid, c2::text from t1`, `select a::uuid, b::text from c`)
+	test(`select * from t1 where c1 = :p1 or c2 = :p1 or c3 = :p2 or c4 = $1`, `select * from users where a = :one or b = :one or c = :two or d = $1`)
+	test("select \n   0 from t1", "select -- comment\n /* comment */ 1.5e3 from users")
+	test(`select $$$$, $tag$$tag$`, `select $$one$$, $tag$two$tag$`)
+
+	test(
+		`select c1 from t1 left join t2 as t3 on t3.c2 = t1.c2 inner join (select c3 from t4) as t5 using (c2) where t5.c3 in (0, 0)`,
+		`select name from users left join posts as p on p.user_id = users.user_id inner join (select tag from tags) as t using (user_id) where t.tag in (1, 2)`,
+	)
+
+	test(
+		`insert into t1 (c1, c2) values (:p1, '') on conflict (c1) do update set c2 = t2.c2 returning c1`,
+		`insert into users (id, name) values (:id, 'one') on conflict (id) do update set name = excluded.name returning id`,
+	)
+
+	test(
+		`update t1 set c1 = true where c2 = :p1`,
+		`update users set active = true where id = :id`,
+	)
+}
+
+func TestParamColumns(_ *testing.T) {
+	test := func(exp []string, src string) {
+		for _, nodes := range parseModes(src) {
+			var act []string
+			for _, val := range ParamColumns(nodes) {
+				act = append(act, val.Param.String()+` `+val.String())
+			}
+			eq(exp, act)
+		}
+	}
+
+	test(nil, ``)
+	test(nil, `select :one`)
+	test(nil, `select * from users where :one`)
+	test(nil, `select * from users where 1 = :one`)
+	test(nil, `select * from users where lower(name) = :one`)
+
+	test([]string{`:email users.email`}, `select * from users where email = :email`)
+	test([]string{`:email users.email`}, `select * from users where email=:email`)
+	test([]string{`:email users.email`}, `select * from users where :email = email`)
+	test([]string{`:email users.email`}, `select * from users u where u.email = :email`)
+	test([]string{`:email users.email`}, `select * from users as u where u.email <> :email::text`)
+	test([]string{`$1 users.id`, `$2 users.name`}, `select * from users where id = $1 and name = $2`)
+	test([]string{`:id Users.Id`}, `select * from "Users" where "Id" = :id`)
+	test([]string{`:id public.users.id`}, `select * from public.users where users.id = :id`)
+	test([]string{`:id public.users.id`}, `select * from public.users where public.users.id = :id`)
+	test([]string{`:one excluded.name`}, `select * from users where excluded.name = :one`)
+
+	test(
+		[]string{`:email users.email`, `:title posts.title`, `:user_id id`},
+		`select * from users u join posts p on p.user_id = u.id where u.email = :email and p.title like :title and id = :user_id`,
+	)
+
+	test(
+		[]string{`:one users.name`, `:two users.email`, `:three users.id`, `:four users.id`},
+		`select * from users where name not ilike :one and email not like :two and id between :three and :four`,
+	)
+
+	test(
+		[]string{`:ids users.id`, `:two users.id`, `:three users.name`, `:four users.tag`},
+		`select * from users where id in (:ids, :two) or name not in (:three) or tag = any(:four::text)`,
+	)
+
+	test(nil, `select * from users where id in (select user_id from posts)`)
+
+	test(
+		[]string{`:tag posts.tag`, `:name users.name`},
+		`select * from users where id in (select user_id from posts where tag = :tag) and name = :name`,
+	)
+
+	test(
+		[]string{`:id users.id`, `:name users.name`, `$1 users.email`},
+		`insert into users (id, name, email) values (:id, :name, now()), (:id, :name, $1)`,
+	)
+
+	test(
+		[]string{`:id users.id`, `:name users.name`},
+		`insert into users as u ("id", name) values (:id::uuid, :name) on conflict (id) do update set name = :name`,
+	)
+
+	test(
+		[]string{`:name users.name`, `:id users.id`},
+		`update users set name = :name, updated_at = now() where id = :id`,
+	)
+
+	test(
+		[]string{`:name users.name`},
+		`update users u set name = :name from posts p where u.id = p.user_id`,
+	)
+
+	test([]string{`:id users.id`}, `delete from users where id = :id`)
+	test([]string{`:id users.id`}, `select * from users where id = :id for update`)
+	test([]string{`:id users.id`, `:two users.id`}, `select * from users where id = :id or id = :two or name = :id`)
+}
+
+func TestParamDocs(_ *testing.T) {
+	test := func(exp []ParamDoc, src string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, ParamDocs(nodes))
+	}
+
+	test(nil, ``)
+	test(nil, `select 1 -- :one the first`)
+	test([]ParamDoc{{Name: `one`}}, `select :one`)
+	test([]ParamDoc{{Name: `one`}}, `-- :ones the first
+select :one`)
+	test([]ParamDoc{{Name: `one`}}, `select :one -- :one the first`)
+
+	test(
+		[]ParamDoc{{Name: `user_id`, Doc: `the account owner`}, {Name: `limit`, Doc: `max rows to return`}},
+		`-- :user_id the account owner
+-- :limit   max rows to return
+select * from posts where user_id = :user_id limit :limit`,
+	)
+
+	test(
+		[]ParamDoc{{Name: `one`, Doc: `first`}, {Name: `two`, Doc: `second`}, {Name: `three`}},
+		`/*
+ * Description of the query.
+ * :one - first
+ * :two: second
+ */
+select :one, :two, :three, :one`,
+	)
+
+	test(
+		[]ParamDoc{{Name: `id`, Doc: `second`}, {Name: `name`, Doc: `in subquery`}},
+		`-- :id first
+-- :id second
+select * from users where id = :id and exists (
+	-- @name in subquery
+	select 1 from posts where name = :name
+)`,
+	)
+
+	func() {
+		parser := Parser{Tokenizer: Tokenizer{
+			Source:  "-- @one the first\n-- :two the second\nselect @one, $two",
+			Dialect: DialectSQLite,
+		}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq([]ParamDoc{{Name: `one`, Doc: `the first`}, {Name: `two`, Doc: `the second`}}, ParamDocs(nodes))
+	}()
+}
+
+func TestPlugin(_ *testing.T) {
+	const typeHashComment = TypeCustom + 1
+
+	// Line comments beginning with "#", as in ClickHouse and MySQL.
+	type hashComment string
+
+	plugin := &Plugin{
+		Name: `test_hash`,
+		Recognizers: []Recognizer{func(tok *Tokenizer) (Token, bool) {
+			rest := tok.Rest()
+			if !strings.HasPrefix(rest, `#`) {
+				return Token{}, false
+			}
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			start := tok.Cursor()
+			return Token{Region{start, start + end}, typeHashComment}, true
+		}},
+		Factory: func(src string, tok Token) Node {
+			if tok.Type == typeHashComment {
+				return NodeCommentBlock(tok.Slice(src)[1:])
+			}
+			return nil
+		},
+		Renderer: func(buf []byte, node Node) ([]byte, bool) {
+			if node, ok := node.(NodeNamedParam); ok {
+				return append(append(buf, '@'), node...), true
+			}
+			return buf, false
+		},
+		Keywords: []string{`prewhere`, `FINAL`},
+	}
+
+	src := "select * from t final prewhere (a = :one) # comment\n{b = [:two]}"
+
+	nodes, err := plugin.Parse(src)
+	try(err)
+	eq(Nodes{
+		NodeText(`select`),
+		NodeWhitespace(` `),
+		NodeText(`*`),
+		NodeWhitespace(` `),
+		NodeText(`from`),
+		NodeWhitespace(` `),
+		NodeText(`t`),
+		NodeWhitespace(` `),
+		NodeText(`final`),
+		NodeWhitespace(` `),
+		NodeText(`prewhere`),
+		NodeWhitespace(` `),
+		ParenNodes{NodeText(`a`), NodeWhitespace(` `), NodeText(`=`), NodeWhitespace(` `), NodeNamedParam(`one`)},
+		NodeWhitespace(` `),
+		NodeCommentBlock(` comment`),
+		NodeWhitespace("\n"),
+		BraceNodes{NodeText(`b`), NodeWhitespace(` `), NodeText(`=`), NodeWhitespace(` `), BracketNodes{NodeNamedParam(`two`)}},
+	}, nodes)
+
+	eq("select * from t final prewhere (a = @one) /* comment*/\n{b = [@two]}", plugin.Render(nodes))
+	eq(`:one`, (&Plugin{}).Render(NodeNamedParam(`one`)))
+	eq(``, plugin.Render(nil))
+
+	// Application-specific recognizers take priority over plugins.
+	parser := Parser{Tokenizer: Tokenizer{
+		Source: `# one`,
+		Plugin: plugin,
+		Recognizers: []Recognizer{func(tok *Tokenizer) (Token, bool) {
+			start := tok.Cursor()
+			if strings.HasPrefix(tok.Rest(), `#`) {
+				return Token{Region{start, start + 1}, TypeText}, true
+			}
+			return Token{}, false
+		}},
+	}}
+	nodes, err = parser.Parse()
+	try(err)
+	eq(Nodes{NodeText(`#`), NodeWhitespace(` `), NodeText(`one`)}, nodes)
+
+	eq(true, plugin.IsKeyword(`PREWHERE`))
+	eq(true, plugin.IsKeyword(`final`))
+	eq(false, plugin.IsKeyword(`select`))
+	eq(false, (*Plugin)(nil).IsKeyword(`final`))
+
+	eq((*Plugin)(nil), LookupPlugin(plugin.Name))
+	RegisterPlugin(plugin)
+	eq(plugin, LookupPlugin(plugin.Name))
+	eq(true, hasString(PluginNames(), plugin.Name))
+
+	panics := func(msg string, fun func()) {
+		defer func() {
+			err, _ := recover().(error)
+			eq(true, err != nil)
+			eq(msg, err.Error())
+		}()
+		fun()
+	}
+	panics(`[sqlp] redundant registration of plugin "test_hash"`, func() { RegisterPlugin(&Plugin{Name: `test_hash`}) })
+	panics(`[sqlp] can't register plugin without name`, func() { RegisterPlugin(&Plugin{}) })
+	panics(`[sqlp] can't register plugin without name`, func() { RegisterPlugin(nil) })
+
+	eq(true, IsIdentStart('_'))
+	eq(false, IsIdentStart('1'))
+	eq(true, IsIdent('1'))
+	eq(false, IsIdent('-'))
+	eq(true, IsWhitespace('\t'))
+	eq(false, IsWhitespace('x'))
+}
+
+func TestFilterSoftDeleted(_ *testing.T) {
+	columns := map[string]string{`users`: `deleted_at`, `public.posts`: `removed_at`}
+
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		nodes, err = FilterSoftDeleted(nodes, columns)
+		try(err)
+		eq(exp, nodes.String())
+	}
+
+	fail := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		_, err = FilterSoftDeleted(nodes, columns)
+		eq(exp, err.Error())
+	}
+
+	test(`select * from other`, `select * from other`)
+	test(`select * from posts`, `select * from posts`)
+	test(`delete from users`, `delete from users`)
+	test(`select * from users`, `select * from users where (users.deleted_at is null)`)
+	test(`select * from app.users;`, `select * from app.users where (app.users.deleted_at is null);`)
+
+	test(
+		`select * from users u, public.posts where a or b order by id`,
+		`select * from users u, public.posts where (a or b) and (u.deleted_at is null and public.posts.removed_at is null) order by id`,
+	)
+
+	test(
+		`select * from users u left join public.posts p on p.user_id = u.id join users v on true`,
+		`select * from users u left join public.posts p on (p.user_id = u.id) and (p.removed_at is null) join users v on true where (u.deleted_at is null and v.deleted_at is null)`,
+	)
+
+	test(
+		`select * from other where exists (select 1 from users where id = other.user_id)`,
+		`select * from other where exists (select 1 from users where (id = other.user_id) and (users.deleted_at is null))`,
+	)
+
+	fail(`select 1; select 2`, `[sqlp] unsupported multiple statements`)
+	fail(`select * from other left join users using (id)`, `[sqlp] can't filter soft-deleted rows of table "users" in left join without ON`)
+	fail(`select * from users right join other on true`, `[sqlp] can't filter soft-deleted rows in query with right join`)
+	fail(`select * from users union select * from other`, `[sqlp] unsupported compound statement with "union"`)
+}
+
+func TestFilterPolicy(_ *testing.T) {
+	policy := FilterPolicy{
+		Columns:   []string{`name`, `age`, `u.email`, `Mixed`},
+		Operators: []string{`=`, `<>`, `>=`, `like`, `in`, `is`},
+	}
+
+	test := func(src string) {
+		_, err := policy.Parse(src)
+		try(err)
+	}
+
+	fail := func(src, exp string) {
+		_, err := policy.Parse(src)
+		if err == nil {
+			panic(fmt.Errorf(`expected error for %q`, src))
+		}
+		eq(exp, err.Error())
+	}
+
+	test(`name = $1`)
+	test(`NAME='one'`)
+	test(`name = 'it''s' and age>=-10`)
+	test(`age >= 1.5 or not (name like :pattern and u.email is not null)`)
+	test(`age in (1, 2, $3) and name not in ('one')`)
+	test(`"Mixed" <> true`)
+	test(`((name = 'one'))`)
+
+	fail(``, `[sqlp] invalid filter: empty filter`)
+	fail(`email = $1`, `[sqlp] invalid filter: column "email" is not allowed`)
+	fail(`"mixed" = $1`, `[sqlp] invalid filter: column "\"mixed\"" is not allowed`)
+	fail(`name < $1`, `[sqlp] invalid filter: operator "<" is not allowed`)
+	fail(`name ~ $1`, `[sqlp] invalid filter: unexpected "~"`)
+	fail(`name === $1`, `[sqlp] invalid filter: unsupported operator "==="`)
+	fail(`name = age`, `[sqlp] invalid filter: unexpected "age"`)
+	fail(`lower(name) = $1`, `[sqlp] invalid filter: column "lower" is not allowed`)
+	fail(`name = $1::text`, `[sqlp] invalid filter: unexpected "::"`)
+	fail(`name = $1 -- comment`, `[sqlp] invalid filter: unexpected "-- comment"`)
+	fail(`name = $1; drop table users`, `[sqlp] invalid filter: unexpected ";"`)
+	fail(`name = (select 1)`, `[sqlp] invalid filter: unexpected "(select 1)"`)
+	fail(`name in ()`, `[sqlp] invalid filter: unexpected end of filter`)
+	fail(`name = $1 and`, `[sqlp] invalid filter: unexpected end of filter`)
+	fail(`name = $1 age = $2`, `[sqlp] invalid filter: unexpected "age"`)
+	fail(`name = 'a\' or name = ' or true -- '`, `[sqlp] invalid filter: ambiguous backslash in "'a\\'"`)
+	fail(`"a\b" = $1`, `[sqlp] invalid filter: ambiguous backslash in "\"a\\b\""`)
+}
+
+func TestConformance(_ *testing.T) {
+	dialect := func(name string) Dialect {
+		for val := DialectAny; val <= DialectBigQuery; val++ {
+			if name == `` && val == DialectAny || name == val.String() {
+				return val
+			}
+		}
+		panic(fmt.Errorf(`unknown dialect %q`, name))
+	}
+
+	tokenize := func(src, name string) (out []testsuite.Token, err error) {
+		defer rec(&err)
+		tokenizer := Tokenizer{Source: src, Dialect: dialect(name)}
+		for {
+			tok := tokenizer.Token()
+			if tok.IsInvalid() {
+				return
+			}
+			out = append(out, testsuite.Token{Type: tok.Type.String(), Text: tok.Slice(src)})
+		}
+	}
+
+	parse := func(src, name string) error {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: dialect(name)}}
+		_, err := parser.Parse()
+		return err
+	}
+
+	for _, err := range testsuite.Verify(tokenize, parse) {
+		panic(err)
+	}
+
+	eq(true, len(testsuite.Cases()) > 0)
+	eq(0, len(testsuite.Verify(tokenize, nil)))
+}
+
+func TestType(_ *testing.T) {
+	// Values are permanent and must never change.
+	eq(Type(1), TypeText)
+	eq(Type(9), TypeOrdinalParam)
+	eq(Type(24), TypeNumericParam)
+	eq(Type(25), TypeQuoteSingleTriple)
+	eq(Type(26), TypeQuoteDoubleTriple)
+	eq(Type(27), TypeCommentHash)
+	eq(Type(28), TypeAtParam)
+	eq(Type(29), TypeQuestionParam)
+	eq(Type(30), TypeDollarParam)
+	eq(Type(31), TypeQuoteNational)
+	eq(Type(32), TypeSystemVariable)
+	eq(Type(33), TypeBatchSeparator)
+	eq(Type(34), TypeQuoteEscape)
+	eq(Type(35), TypeKeyword)
+	eq(Type(36), TypeIdent)
+	eq(Type(37), TypeSemicolon)
+
+	eq(false, TypeInvalid.IsKnown())
+	eq(true, TypeText.IsKnown())
+	eq(true, TypeSemicolon.IsKnown())
+	eq(false, Type(TypeSemicolon+1).IsKnown())
+	eq(false, TypeCustom.IsKnown())
+
+	for typ := TypeInvalid; typ < 255; typ++ {
+		text, err := typ.MarshalText()
+		try(err)
+
+		var out Type
+		try(out.UnmarshalText(text))
+		eq(typ, out)
+	}
+
+	body, err := json.Marshal([]Type{TypeText, TypeOrdinalParam, 200})
+	try(err)
+	eq(`["text","ordinal_param","type(200)"]`, string(body))
+
+	var types []Type
+	try(json.Unmarshal(body, &types))
+	eq([]Type{TypeText, TypeOrdinalParam, 200}, types)
+
+	_, err = ParseType(`unknown`)
+	eq(`[sqlp] unknown token type "unknown"`, err.Error())
+
+	_, err = ParseType(`type(256)`)
+	eq(`[sqlp] unknown token type "type(256)"`, err.Error())
+}
+
+func TestTokenizer_Next(_ *testing.T) {
+	const src = `select (one, [two]) from three -- four`
+	tokenizer := Tokenizer{Source: src}
+
+	var nodes Nodes
+	for {
+		node := tokenizer.Next()
+		if node == nil {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+
+	exp, err := Parse(src)
+	try(err)
+	eq(exp, nodes)
+
+	tokenizer = Tokenizer{Source: `:1 (:2)`, Dialect: DialectOracle}
+	eq(Token{Region{0, 2}, TypeNumericParam}, tokenizer.Token())
+	eq(Node(NodeWhitespace(` `)), tokenizer.Next())
+	eq(Node(ParenNodes{NodeNumericParam(2)}), tokenizer.Next())
+	eq(nil, tokenizer.Next())
+
+	tokenizer = Tokenizer{Source: `(one`}
+	eq(`[sqlp] missing closing delimiter ")"`, func() (err error) {
+		defer rec(&err)
+		tokenizer.Next()
+		return
+	}().Error())
+}
+
+func TestTokenizer_Peek(_ *testing.T) {
+	const src = `one'two' three`
+	tokenizer := Tokenizer{Source: src}
+
+	eq(Token{Region{0, 3}, TypeText}, tokenizer.Peek())
+	eq(Token{Region{0, 3}, TypeText}, tokenizer.Peek())
+	eq(Token{Region{0, 3}, TypeText}, tokenizer.Token())
+
+	// Pending token after text.
+	eq(Token{Region{3, 8}, TypeQuoteSingle}, tokenizer.Peek())
+	tok := tokenizer.Token()
+	eq(Token{Region{3, 8}, TypeQuoteSingle}, tok)
+	eq(8, tokenizer.Cursor())
+
+	tokenizer.Rewind(tok)
+	eq(3, tokenizer.Cursor())
+	eq(tok, tokenizer.Token())
+
+	eq(Token{Region{8, 9}, TypeWhitespace}, tokenizer.Token())
+	eq(Token{Region{9, 14}, TypeText}, tokenizer.Token())
+	eq(Token{}, tokenizer.Peek())
+	eq(Token{}, tokenizer.Token())
+
+	tokenizer.Rewind(Token{})
+	eq(len(src), tokenizer.Cursor())
+
+	tokenizer.SetPos(0)
+	eq(Token{Region{0, 3}, TypeText}, tokenizer.Token())
+	tokenizer.SetPos(9)
+	eq(Token{Region{9, 14}, TypeText}, tokenizer.Token())
+	tokenizer.SetPos(-1)
+	eq(0, tokenizer.Cursor())
+	tokenizer.SetPos(len(src) + 1)
+	eq(Token{}, tokenizer.Token())
+
+	// Discards the pending token.
+	tokenizer.SetPos(0)
+	tokenizer.Token()
+	tokenizer.SetPos(9)
+	eq(Token{Region{9, 14}, TypeText}, tokenizer.Token())
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `(one)`}}
+	eq(Token{Region{0, 1}, TypeParenOpen}, parser.Peek())
+	eq(Node(ParenNodes{NodeText(`one`)}), parser.Next())
+}
+
+func TestParser_Next(_ *testing.T) {
+	const src = `select :1, {{two}} from "three" where [four] -- five`
+
+	parser := func() Parser {
+		return Parser{
+			Tokenizer: Tokenizer{
+				Source:   src,
+				Dialect:  DialectOracle,
+				Template: TemplateGo,
+			},
+			Factory: func(src string, tok Token) Node {
+				if tok.Type == TypeQuoteDouble {
+					return nodeSigil(tok.Slice(src))
+				}
+				return nil
+			},
+		}
+	}
+
+	one := parser()
+	exp, err := one.Parse()
+	try(err)
+
+	two := parser()
+	var nodes Nodes
+	for {
+		node := two.Next()
+		if node == nil {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+
+	eq(exp, nodes)
+	eq(Node(NodeNumericParam(1)), nodes[2])
+	eq(Node(NodeTemplateAction(`two`)), nodes[5])
+	eq(Node(nodeSigil(`"three"`)), nodes[9])
+}
+
+func TestTokensString(_ *testing.T) {
+	const src = `select $1::int -- one`
+	tokens, err := Tokenize(src)
+	try(err)
+
+	eq(`[0,text] "select"
+[6,whitespace] " "
+[7,ordinal_param] "$1"
+[9,double_colon] "::"
+[11,text] "int"
+[14,whitespace] " "
+[15,comment_line] "-- one"
+`, TokensString(tokens, src))
+
+	eq(``, TokensString(nil, src))
+	eq(`type(255)`, Type(255).String())
+}
+
+type nodeSigil string
+
+func (self nodeSigil) AppendTo(buf []byte) []byte {
+	buf = append(buf, `%%`...)
+	buf = append(buf, self...)
+	buf = append(buf, `%%`...)
+	return buf
+}
+
+func (self nodeSigil) String() string { return string(self.AppendTo(nil)) }
+
+func recognizeSigil(tok *Tokenizer) (Token, bool) {
+	rest := tok.Rest()
+	if !strings.HasPrefix(rest, `%%`) {
+		return Token{}, false
+	}
+	end := strings.Index(rest[2:], `%%`)
+	if end < 0 {
+		return Token{}, false
+	}
+	start := tok.Cursor()
+	return Token{Region{start, start + end + 4}, TypeCustom}, true
+}
+
+func TestSnapshot(_ *testing.T) {
+	write := func(nodes Nodes) string {
+		var buf strings.Builder
+		try(WriteSnapshot(&buf, nodes))
+		return buf.String()
+	}
+
+	read := func(src string) Nodes {
+		nodes, err := ReadSnapshot(strings.NewReader(src))
+		try(err)
+		return nodes
+	}
+
+	fail := func(msg, src string) {
+		_, err := ReadSnapshot(strings.NewReader(src))
+		eq(true, err != nil)
+		eq(msg, err.Error())
+	}
+
+	roundtrip := func(tokenizer Tokenizer) {
+		parser := Parser{Tokenizer: tokenizer}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(nodes, read(write(nodes)))
+		eq(write(nodes), write(read(write(nodes))))
+	}
+
+	eq(``, write(nil))
+	eq(Nodes(nil), read(``))
+
+	const src = "select (:id, [$1]) from t -- one\n"
+	nodes, err := Parse(src)
+	try(err)
+
+	eq(
+		"text \"select\"\n"+
+			"whitespace \" \"\n"+
+			"paren\n"+
+			"\tnamed_param \":id\"\n"+
+			"\ttext \",\"\n"+
+			"\twhitespace \" \"\n"+
+			"\tbracket\n"+
+			"\t\tordinal_param \"$1\"\n"+
+			"whitespace \" \"\n"+
+			"text \"from\"\n"+
+			"whitespace \" \"\n"+
+			"text \"t\"\n"+
+			"whitespace \" \"\n"+
+			"comment_line \"-- one\\n\"\n",
+		write(nodes),
+	)
+
+	eq(
+		"nodes\n\ttext \"one\"\nparen\nbrace\n\tnodes\ndelim \"tag\" \"<%\" \"%>\"\n\tquote_dollar \"$a$\\\"$a$\"\n",
+		write(Nodes{
+			Nodes{NodeText(`one`), nil},
+			nil,
+			ParenNodes{},
+			BraceNodes{Nodes{}},
+			DelimNodes{Delim{`tag`, `<%`, `%>`}, Nodes{NodeQuoteDollar{`a`, `"`}}},
+		}),
+	)
+
+	eq(
+		Nodes{Nodes{NodeText(`one`)}, ParenNodes(nil), NodeWhitespace(` `)},
+		read("nodes\r\n\ttext \"one\"\r\n\r\nparen\n\nwhitespace \" \""),
+	)
+
+	roundtrip(Tokenizer{Source: src})
+	roundtrip(Tokenizer{Source: src, Keywords: DialectKeywords(DialectAny)})
+	roundtrip(Tokenizer{Source: src, Keywords: DialectKeywords(DialectAny), Idents: true})
+	roundtrip(Tokenizer{Source: `select 1; select (2;3);`, Semicolons: true})
+	roundtrip(Tokenizer{Source: `select E'a\'b', N'c', $tag$d$tag$, '', "e""f", x::int /* g */ from {h}`})
+	roundtrip(Tokenizer{Source: `select @a, @@b, ?, ?2, $c, 'd' # e`, Dialect: DialectSQLite})
+	roundtrip(Tokenizer{Source: `select '''a''', """b""", ` + "`c`", Dialect: DialectBigQuery})
+	roundtrip(Tokenizer{Source: `select :1 from dual`, Dialect: DialectOracle})
+	roundtrip(Tokenizer{Source: "select 1\nGO 2\n", Dialect: DialectMSSQL})
+	roundtrip(Tokenizer{Source: "\\copy t from 'f'\nselect 1", Script: true})
+	roundtrip(Tokenizer{Source: `select {{.A}} {% if b %} {# c #}`, Template: TemplateJinja})
+	roundtrip(Tokenizer{Source: `select <% (a) %>`, Delims: []Delim{{`erb`, `<%`, `%>`}}})
+
+	err = WriteSnapshot(&strings.Builder{}, Nodes{NodeText(`one`), nodeSigil(`two`)})
+	eq(`[sqlp] unsupported node type sqlp.nodeSigil in snapshot`, err.Error())
+
+	fail(`[sqlp] invalid snapshot at line 1: unexpected indentation`, "\ttext \"one\"")
+	fail(`[sqlp] invalid snapshot at line 2: unexpected indentation`, "text \"one\"\n\ttext \"two\"")
+	fail(`[sqlp] invalid snapshot at line 1: unknown node "unknown"`, `unknown "one"`)
+	fail(`[sqlp] invalid snapshot at line 1: "(" is not a valid paren_open`, `paren_open "("`)
+	fail(`[sqlp] invalid snapshot at line 1: ":one" is not a valid ordinal_param`, `ordinal_param ":one"`)
+	fail(`[sqlp] invalid snapshot at line 1: "'one" is not a valid quote_single`, `quote_single "'one"`)
+	fail(`[sqlp] invalid snapshot at line 1: expected 1 arguments, got 0`, `text`)
+	fail(`[sqlp] invalid snapshot at line 1: expected 0 arguments, got 1`, `paren "one"`)
+	fail(`[sqlp] invalid snapshot at line 1: expected 3 arguments, got 2`, `delim "one" "two"`)
+	fail(`[sqlp] invalid snapshot at line 1: invalid quoted argument "one`, `text "one`)
+	fail(`[sqlp] invalid snapshot at line 1: expected space before argument`, `text "one""two"`)
+}
+
+func TestParser_custom(_ *testing.T) {
+	const src = `select * from %%table%% where id = :id`
+
+	parser := Parser{
+		Tokenizer: Tokenizer{
+			Source:      src,
+			Recognizers: []Recognizer{recognizeSigil},
+		},
+		Factory: func(src string, tok Token) Node {
+			if tok.Type == TypeCustom {
+				return nodeSigil(strings.Trim(tok.Slice(src), `%`))
+			}
+			return nil
+		},
+	}
+
+	nodes, err := parser.Parse()
+	try(err)
+
+	eq(
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`*`), NodeWhitespace(` `),
+			NodeText(`from`), NodeWhitespace(` `), nodeSigil(`table`), NodeWhitespace(` `),
+			NodeText(`where`), NodeWhitespace(` `), NodeText(`id`), NodeWhitespace(` `),
+			NodeText(`=`), NodeWhitespace(` `), NodeNamedParam(`id`),
+		},
+		nodes,
+	)
+	eq(src, nodes.String())
+}
+
+type nodeTenantFilter struct{}
+
+func (self nodeTenantFilter) AppendTo(buf []byte) []byte {
+	return append(buf, `tenant_id = current_setting('app.tenant')::uuid`...)
+}
+
+func (self nodeTenantFilter) String() string { return string(self.AppendTo(nil)) }
+
+func TestParser_Factory(_ *testing.T) {
+	parser := Parser{
+		Tokenizer: Tokenizer{Source: `select * from items where (:tenant)`},
+		Factory: func(src string, tok Token) Node {
+			if tok.Type == TypeNamedParam && tok.NodeNamedParam(src) == `tenant` {
+				return nodeTenantFilter{}
+			}
+			return nil
+		},
+	}
+
+	nodes, err := parser.Parse()
+	try(err)
+
+	eq(
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`*`), NodeWhitespace(` `),
+			NodeText(`from`), NodeWhitespace(` `), NodeText(`items`), NodeWhitespace(` `),
+			NodeText(`where`), NodeWhitespace(` `), ParenNodes{nodeTenantFilter{}},
+		},
+		nodes,
+	)
+	eq(`select * from items where (tenant_id = current_setting('app.tenant')::uuid)`, nodes.String())
+}
+
+func TestParser_Delims(_ *testing.T) {
+	erb := Delim{Tag: `erb`, Open: `<%`, Close: `%>`}
+	tpl := Delim{Tag: `tpl`, Open: `{{`, Close: `}}`}
+
+	test := func(src string, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Delims: []Delim{erb, tpl}}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(
+		`select {{.Cols}} from <% table %>`,
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `),
+			DelimNodes{tpl, Nodes{NodeText(`.Cols`)}},
+			NodeWhitespace(` `), NodeText(`from`), NodeWhitespace(` `),
+			DelimNodes{erb, Nodes{NodeWhitespace(` `), NodeText(`table`), NodeWhitespace(` `)}},
+		},
+	)
+
+	test(
+		`{{ {one} <% (two) %> }}`,
+		Nodes{
+			DelimNodes{tpl, Nodes{
+				NodeWhitespace(` `),
+				BraceNodes{NodeText(`one`)},
+				NodeWhitespace(` `),
+				DelimNodes{erb, Nodes{NodeWhitespace(` `), ParenNodes{NodeText(`two`)}, NodeWhitespace(` `)}},
+				NodeWhitespace(` `),
+			}},
+		},
+	)
+
+	fail := func(src string) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Delims: []Delim{erb, tpl}}}
+		_, err := parser.Parse()
+		if err == nil {
+			panic(fmt.Errorf(`expected parsing of %q to fail`, src))
+		}
+	}
+
+	fail(`{{ one %>`)
+	fail(`{{ one`)
+	fail(`one }}`)
+}
+
+func TestParser_TemplateGo(_ *testing.T) {
+	type A = NodeTemplateAction
+
+	test := func(src string, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Template: TemplateGo}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(
+		`select * from {{.Table}} where id = $1`,
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`*`), NodeWhitespace(` `),
+			NodeText(`from`), NodeWhitespace(` `), A(`.Table`), NodeWhitespace(` `),
+			NodeText(`where`), NodeWhitespace(` `), NodeText(`id`), NodeWhitespace(` `),
+			NodeText(`=`), NodeWhitespace(` `), NodeOrdinalParam(1),
+		},
+	)
+
+	test(
+		`({{- if .Active }}'{{.Name}}'{{ end -}})`,
+		Nodes{ParenNodes{A(`- if .Active `), NodeQuoteSingle(`{{.Name}}`), A(` end -`)}},
+	)
+
+	test(
+		"{{ printf \"}}'\\\"\" `}}` '}' /* }} */ }}",
+		Nodes{A(" printf \"}}'\\\"\" `}}` '}' /* }} */ ")},
+	)
+
+	test(
+		`{one} {{two}}`,
+		Nodes{BraceNodes{NodeText(`one`)}, NodeWhitespace(` `), A(`two`)},
+	)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `{{ one`, Template: TemplateGo}}
+	_, err := parser.Parse()
+	if err == nil {
+		panic(fmt.Errorf(`expected unterminated template action to fail`))
+	}
+}
+
+func TestParser_TemplateJinja(_ *testing.T) {
+	type (
+		A = NodeTemplateAction
+		S = NodeTemplateStatement
+		C = NodeTemplateComment
+		T = NodeText
+		W = NodeWhitespace
+	)
+
+	test := func(src string, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Template: TemplateJinja}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(
+		`select * from {{ ref('orders') }} {# '%} #} {% if is_incremental() %}where ts > (select max(ts) from {{ this }}){% endif %}`,
+		Nodes{
+			T(`select`), W(` `), T(`*`), W(` `), T(`from`), W(` `), A(` ref('orders') `), W(` `),
+			C(` '%} `), W(` `), S(` if is_incremental() `),
+			T(`where`), W(` `), T(`ts`), W(` `), T(`>`), W(` `),
+			ParenNodes{T(`select`), W(` `), T(`max`), ParenNodes{T(`ts`)}, W(` `), T(`from`), W(` `), A(` this `)},
+			S(` endif `),
+		},
+	)
+
+	test(
+		`{% set x = "%}" %}{{ '}}' }}`,
+		Nodes{S(` set x = "%}" `), A(` '}}' `)},
+	)
+
+	for _, src := range []string{`{% one`, `{# one`, `{{ one`} {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Template: TemplateJinja}}
+		_, err := parser.Parse()
+		if err == nil {
+			panic(fmt.Errorf(`expected parsing of %q to fail`, src))
+		}
+	}
+}
+
+func TestDetectParamStyle(_ *testing.T) {
+	test := func(src string, exp ParamStyle) {
+		style, err := DetectParamStyle(src)
+		try(err)
+		eq(exp, style)
+	}
+
+	test(``, ParamStyleNone)
+	test(`select 1`, ParamStyleNone)
+	test(`select '$1', ':one', "?", '@one' -- $2 ? :two`, ParamStyleNone)
+	test(`select one::int`, ParamStyleNone)
+	test(`select @@version, data ?| array['one']`, ParamStyleNone)
+	test(`select $1, $2`, ParamStyleOrdinal)
+	test(`select :one::int, :two`, ParamStyleNamed)
+	test(`select * from one where two = ? and three = ?`, ParamStyleQuestion)
+	test(`select * from one where two=?`, ParamStyleQuestion)
+	test(`select * from one where two = @two and three=@three`, ParamStyleAt)
+	test(`select one@two`, ParamStyleNone)
+	test(`select :1, :2`, ParamStyleNone)
+
+	fail := func(src string) {
+		_, err := DetectParamStyle(src)
+		if err == nil {
+			panic(fmt.Errorf(`expected detection for %q to fail`, src))
+		}
+	}
+
+	fail(`select $1, :two`)
+	fail(`select ?, @two`)
+	fail(`select :one, ?`)
+	fail(`select 'one`)
+}
+
+func TestCacheKey(_ *testing.T) {
+	key := func(src string, dialect Dialect) uint64 {
+		nodes, err := Parse(src)
+		try(err)
+		return CacheKey(nodes, dialect)
+	}
+	same := func(one, two string) { eq(key(one, DialectAny), key(two, DialectAny)) }
+	diff := func(one, two string) { eq(false, key(one, DialectAny) == key(two, DialectAny)) }
+
+	same(``, ` -- comment`)
+	same(`select 1`, "  select /* one */\n\t1 -- two\n")
+	same(`select a,b from c where d=$1`, `select a, b from c where d = $1`)
+	same(`select f(a)`, `select f ( a )`)
+
+	diff(`select 1`, `SELECT 1`)
+	diff(`select a b`, `select ab`)
+	diff(`select a < = b`, `select a <= b`)
+	diff("select 'a'\n'b'", `select 'a''b'`)
+	diff(`select $1, $2`, `select $2, $1`)
+	diff(`select $1`, `select :one`)
+	diff(`select 'one'`, `select 'two'`)
+	diff(`select (a)`, `select [a]`)
+	eq(false, key(`select 1`, DialectPostgres) == key(`select 1`, DialectMySQL))
+
+	eq(key(`select 1`, DialectPostgres), key(`select 1`, DialectPostgres))
+	eq(uint64(0x8328807b4eb6fed), CacheKey(nil, DialectAny))
+	eq(uint64(0xa0d47f8d4c0bb49f), key(" select  1 ", DialectPostgres))
+
+	nodes, err := Parse(`select * from one where a = $1 and b in ('two', "three") -- four`)
+	try(err)
+	eq(true, testing.AllocsPerRun(100, func() { CacheKey(nodes, DialectPostgres) }) <= 1)
+}
+
+func TestCheckPlaceholderLimit(_ *testing.T) {
+	test := func(src string, dialect Dialect, exp int) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: dialect}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, CountPlaceholders(nodes))
+	}
+
+	test(`select 1`, DialectAny, 0)
+	test(`select $1, $1, $3`, DialectAny, 3)
+	test(`select :one, :two, :one, (:three)`, DialectAny, 3)
+	test(`select :1, :2`, DialectOracle, 2)
+	test(`select ?, ?5, ?, @one, $two, @one`, DialectSQLite, 8)
+
+	nodes, err := Parse(`insert into one values ($1, $2), ($3, $4), ($5, $6)`)
+	try(err)
+
+	try(CheckPlaceholderLimit(nodes, 6))
+	try(CheckPlaceholderLimit(nodes, MaxPlaceholdersMSSQL))
+
+	err = CheckPlaceholderLimit(nodes, 4)
+	eq(PlaceholderLimitError{Count: 6, Max: 4}, err)
+	eq(2, err.(PlaceholderLimitError).Batches())
+	eq(`[sqlp] query requires 6 arguments, exceeding the limit of 4 placeholders; consider splitting the batch into at least 2 parts`, err.Error())
+
+	eq(3, PlaceholderLimitError{Count: 7, Max: 3}.Batches())
+	eq(true, CheckPlaceholderLimit(nodes, 0) != nil)
+}
+
+func TestCheckParamConsistency(_ *testing.T) {
+	test := func(src string, ok bool) {
+		nodes, err := Parse(src)
+		try(err)
+
+		err = CheckParamConsistency(nodes)
+		if ok && err != nil {
+			panic(err)
+		}
+		if !ok && err == nil {
+			panic(fmt.Errorf(`expected consistency check for %q to fail`, src))
+		}
+	}
+
+	test(`select 1`, true)
+	test(`select $1, ($2, [$3])`, true)
+	test(`select :one, (:two)`, true)
+	test(`select @one, (@two)`, true)
+	test(`select $1, (:two)`, false)
+	test(`select :one, ((@two))`, false)
+	test(`select ?, $1`, false)
+
+	eq(nil, CheckParamConsistency(Nodes{NodeOrdinalParam(1), NodeOrdinalParam(2)}))
+}
+
+func TestAndWhere(_ *testing.T) {
+	cond := Nodes{NodeText(`tenant_id`), NodeWhitespace(` `), NodeText(`=`), NodeWhitespace(` `), NodeOrdinalParam(1)}
+
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := AndWhere(nodes, cond)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(
+		`select * from users`,
+		`select * from users where (tenant_id = $1)`,
+	)
+
+	test(
+		`select * from users;`,
+		`select * from users where (tenant_id = $1);`,
+	)
+
+	test(
+		"select * from users -- comment\n;\n",
+		"select * from users where (tenant_id = $1) -- comment\n;\n",
+	)
+
+	test(
+		`select * from users where a or b`,
+		`select * from users where (a or b) and (tenant_id = $1)`,
+	)
+
+	test(
+		`select * from users where a or b order by id limit 1`,
+		`select * from users where (a or b) and (tenant_id = $1) order by id limit 1`,
+	)
+
+	test(
+		`select * from users order by id`,
+		`select * from users where (tenant_id = $1) order by id`,
+	)
+
+	test(
+		`select count(*) from users where id in (select id from other where x order by y) group by id`,
+		`select count(*) from users where (id in (select id from other where x order by y)) and (tenant_id = $1) group by id`,
+	)
+
+	test(
+		`delete from users WHERE id = 1 RETURNING *`,
+		`delete from users WHERE (id = 1) and (tenant_id = $1) RETURNING *`,
+	)
+
+	test(
+		"select * from users\nwhere\n\ta\norder by id",
+		"select * from users\nwhere\n\t(a) and (tenant_id = $1)\norder by id",
+	)
+
+	nodes, err := Parse(`select 1 union select 2`)
+	try(err)
+	_, err = AndWhere(nodes, cond)
+	if err == nil {
+		panic(fmt.Errorf(`expected AndWhere to reject compound statements`))
+	}
+}
+
+func TestWrapCount(_ *testing.T) {
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, WrapCount(nodes).String())
+		eq(src, nodes.String())
+	}
+
+	test(`select * from users`, `select count(*) from (select * from users) as _`)
+	test(`select * from users order by id`, `select count(*) from (select * from users) as _`)
+	test(`select * from users order by id;`, `select count(*) from (select * from users) as _`)
+	test("select * from users -- comment\n", `select count(*) from (select * from users) as _`)
+	test(`select * from users order by id limit 10`, `select count(*) from (select * from users order by id limit 10) as _`)
+	test(
+		`select * from users where id in (select id from other order by id) order by id`,
+		`select count(*) from (select * from users where id in (select id from other order by id)) as _`,
+	)
+}
+
+func TestWrapExists(_ *testing.T) {
+	test := func(src, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, WrapExists(nodes).String())
+	}
+
+	test(`select * from users`, `select exists(select * from users)`)
+	test(` select * from users order by id desc; `, `select exists(select * from users)`)
+	test(`select * from users order by id offset 1`, `select exists(select * from users order by id offset 1)`)
+}
+
+func TestOrderBy(_ *testing.T) {
+	allowed := []string{`id`, `name`, `users.created_at`}
+
+	test := func(src string, specs []SortSpec, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := OrderBy(nodes, specs, allowed)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	specs := []SortSpec{{Column: `name`, Desc: true, NullsLast: true}, {Column: `id`}}
+
+	test(`select * from users`, specs, `select * from users order by "name" desc nulls last, "id"`)
+	test(`select * from users;`, specs, `select * from users order by "name" desc nulls last, "id";`)
+	test(`select * from users order by id desc`, specs, `select * from users order by "name" desc nulls last, "id"`)
+	test(`select * from users order by id desc limit 10`, specs, `select * from users order by "name" desc nulls last, "id" limit 10`)
+	test(`select * from users limit 10`, specs, `select * from users order by "name" desc nulls last, "id" limit 10`)
+	test("select * from users\nlimit 10", specs, "select * from users order by \"name\" desc nulls last, \"id\"\nlimit 10")
+	test(`select * from users order by (select 1) offset 1`, nil, `select * from users offset 1`)
+	test(`select * from users order by id`, nil, `select * from users`)
+	test(
+		`select * from users`,
+		[]SortSpec{{Column: `users.created_at`}},
+		`select * from users order by "users"."created_at"`,
+	)
+
+	nodes, err := Parse(`select * from users`)
+	try(err)
+	_, err = OrderBy(nodes, []SortSpec{{Column: `id; drop table users`}}, allowed)
+	if err == nil {
+		panic(fmt.Errorf(`expected OrderBy to reject columns not in allowlist`))
+	}
+}
+
+func TestQuoteIdent(_ *testing.T) {
+	eq(NodeQuoteDouble(`one`), QuoteIdent(`one`))
+	eq(`"one"`, QuoteIdent(`one`).String())
+	eq(`"one""two"`, QuoteIdent(`one"two`).String())
+	eq(`"one"."two"`, QuoteIdent(`one.two`).String())
+
+	nodes, err := Parse(QuoteIdent(`one"two`).String())
+	try(err)
+	eq(`"one""two"`, nodes.String())
+}
+
+func TestRenderParams(_ *testing.T) {
+	test := func(src string, style ParamStyle, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := RenderParams(nodes, style)
+		try(err)
+		eq(exp, out)
+		eq(src, nodes.String())
+	}
+
+	fail := func(src string, style ParamStyle) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = RenderParams(nodes, style)
+		if err == nil {
+			panic(fmt.Errorf(`expected rendering of %q in style %q to fail`, src, style))
+		}
+	}
+
+	const ordinal = `select * from users where id = $1 and (kind = $2 or '$3' = $3::text)`
+	test(ordinal, ParamStyleOrdinal, ordinal)
+	test(ordinal, ParamStyleQuestion, `select * from users where id = ? and (kind = ? or '$3' = ?::text)`)
+	test(ordinal, ParamStyleAt, `select * from users where id = @p1 and (kind = @p2 or '$3' = @p3::text)`)
+	fail(ordinal, ParamStyleNamed)
+	fail(ordinal, ParamStyleNone)
+	fail(`select $2, $1`, ParamStyleQuestion)
+	fail(`select $1, $1`, ParamStyleQuestion)
+
+	const named = `select * from users where id = :id and [kind = :kind]`
+	test(named, ParamStyleNamed, named)
+	test(named, ParamStyleAt, `select * from users where id = @id and [kind = @kind]`)
+	fail(named, ParamStyleOrdinal)
+	fail(named, ParamStyleQuestion)
+
+	test(`select 1`, ParamStyleNone, `select 1`)
+	test(`select $1, $2`, ParamStyleNumeric, `select :1, :2`)
+
+	oracle := func(src string, style ParamStyle, exp string) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectOracle}}
+		nodes, err := parser.Parse()
+		try(err)
+
+		out, err := RenderParams(nodes, style)
+		try(err)
+		eq(exp, out)
+	}
+
+	const numeric = `select * from users where id = :1 and kind = :2 and name = ':3'`
+	oracle(numeric, ParamStyleNumeric, numeric)
+	oracle(numeric, ParamStyleOrdinal, `select * from users where id = $1 and kind = $2 and name = ':3'`)
+	oracle(numeric, ParamStyleQuestion, `select * from users where id = ? and kind = ? and name = ':3'`)
+	oracle(numeric, ParamStyleAt, `select * from users where id = @p1 and kind = @p2 and name = ':3'`)
+
+	out, err := RenderParams(NodeOrdinalParam(1), ParamStyleQuestion)
+	try(err)
+	eq(`?`, out)
+
+	out, err = RenderParams(nil, ParamStyleQuestion)
+	try(err)
+	eq(``, out)
+}
+
+func TestDeepWalkNodePtr(_ *testing.T) {
+	var node Node = Nodes{
+		NodeText(`one`),
+		Nodes{NodeText(`two`), NodeOrdinalParam(3)},
+		ParenNodes{NodeText(`four`)},
+	}
+
+	DeepWalkNodePtr(&node, func(ptr *Node) {
+		if val, ok := (*ptr).(NodeText); ok {
+			*ptr = val + `_`
+		}
+	})
+
+	eq(
+		Nodes{
+			NodeText(`one_`),
+			Nodes{NodeText(`two_`), NodeOrdinalParam(3)},
+			ParenNodes{NodeText(`four_`)},
+		},
+		node,
+	)
+}
+
+func TestTranspileQuotes(_ *testing.T) {
+	test := func(src string, from, to Dialect, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := TranspileQuotes(nodes, from, to)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	fail := func(src string, from, to Dialect) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = TranspileQuotes(nodes, from, to)
+		if err == nil {
+			panic(fmt.Errorf(`expected transpiling %q from %q to %q to fail`, src, from, to))
+		}
+	}
+
+	test(
+		"select `one`, `two``three` from `four` where five = \"six\" and seven = 'it''s'",
+		DialectMySQL, DialectPostgres,
+		`select "one", "two`+"`"+`three" from "four" where five = 'six' and seven = 'it''s'`,
+	)
+
+	test(
+		`select "one", "two""three", ("four" || 'a\b')`,
+		DialectPostgres, DialectMySQL,
+		"select `one`, `two\"three`, (`four` || 'a\\\\b')",
+	)
+
+	test(
+		`select 'one\ntwo\\three\%'`,
+		DialectMySQL, DialectPostgres,
+		"select 'one\ntwo\\three\\%'",
+	)
+
+	test(
+		`select [one], "two" from [three four]`,
+		DialectMSSQL, DialectPostgres,
+		`select "one", "two" from "three four"`,
+	)
+
+	test(
+		`select "one" from two`,
+		DialectPostgres, DialectSQLite,
+		`select "one" from two`,
+	)
+
+	fail("select `one`", DialectPostgres, DialectMySQL)
+	fail(`select '\0'`, DialectMySQL, DialectPostgres)
+	fail(`select 1`, DialectAny, DialectPostgres)
+	fail(`select 1`, DialectPostgres, DialectAny)
+}
+
+func TestDialectBigQuery(_ *testing.T) {
+	const src = "select `proj.ds`.tbl.id, '''it's''' # comment\nfrom t where a = @one and b = 'x\\'y' and c = @@project_id"
+
+	parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectBigQuery}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(src, nodes.String())
+
+	eq(Nodes{
+		NodeText(`select`),
+		NodeWhitespace(` `),
+		NodeQuoteGrave(`proj.ds`),
+		NodeText(`.tbl.id,`),
+		NodeWhitespace(` `),
+		NodeQuoteSingleTriple(`it's`),
+		NodeWhitespace(` `),
+		NodeCommentHash(" comment\n"),
+		NodeText(`from`),
+		NodeWhitespace(` `),
+		NodeText(`t`),
+		NodeWhitespace(` `),
+		NodeText(`where`),
+		NodeWhitespace(` `),
+		NodeText(`a`),
+		NodeWhitespace(` `),
+		NodeText(`=`),
+		NodeWhitespace(` `),
+		NodeAtParam(`one`),
+		NodeWhitespace(` `),
+		NodeText(`and`),
+		NodeWhitespace(` `),
+		NodeText(`b`),
+		NodeWhitespace(` `),
+		NodeText(`=`),
+		NodeWhitespace(` `),
+		NodeQuoteSingle(`x\'y`),
+		NodeWhitespace(` `),
+		NodeText(`and`),
+		NodeWhitespace(` `),
+		NodeText(`c`),
+		NodeWhitespace(` `),
+		NodeText(`=`),
+		NodeWhitespace(` `),
+		NodeText(`@@project_id`),
+	}, nodes)
+
+	// Without the dialect, triple quotes are adjacent strings.
+	tokens, err := Tokenize(`'''one'''`)
+	try(err)
+	eq(3, len(tokens))
+
+	out, err := RenderDialect(nodes, DialectBigQuery)
+	try(err)
+	eq(src, out)
+
+	_, err = RenderDialect(nodes, DialectPostgres)
+	if err == nil {
+		panic(fmt.Errorf(`expected BigQuery syntax to be invalid in Postgres`))
+	}
+
+	out, err = RenderParams(nodes, ParamStyleNamed)
+	try(err)
+	eq(strings.Replace(src, `@one`, `:one`, 1), out)
+
+	out, err = RenderParams(Nodes{NodeNamedParam(`one`), NodeAtParam(`two`)}, ParamStyleAt)
+	try(err)
+	eq(`@one@two`, out)
+
+	_, err = RenderParams(nodes, ParamStyleOrdinal)
+	eq(`[sqlp] can't render param @one in style "ordinal"`, err.Error())
+
+	try(CheckParamConsistency(nodes))
+	eq(true, CheckParamConsistency(Nodes{NodeAtParam(`one`), NodeNamedParam(`two`)}) != nil)
+
+	transpiled, err := TranspileQuotes(nodes, DialectBigQuery, DialectPostgres)
+	try(err)
+	eq(
+		`select "proj.ds".tbl.id, 'it''s' -- comment`+"\n"+`from t where a = @one and b = 'x''y' and c = @@project_id`,
+		transpiled.String(),
+	)
+
+	transpiled, err = TranspileQuotes(
+		Nodes{NodeQuoteDouble(`a\nb`), NodeQuoteGrave("c\\`d"), NodeQuoteDoubleTriple(`\x41ü`)},
+		DialectBigQuery, DialectMySQL,
+	)
+	try(err)
+	eq("'a\nb'`c``d`'Aü'", transpiled.String())
+
+	transpiled, err = TranspileQuotes(
+		Nodes{NodeQuoteDouble(`one`), NodeQuoteSingle("it's\\\n")},
+		DialectPostgres, DialectBigQuery,
+	)
+	try(err)
+	eq("`one`'it\\'s\\\\\\n'", transpiled.String())
+
+	_, err = TranspileQuotes(Nodes{NodeQuoteSingle(`\q`)}, DialectBigQuery, DialectPostgres)
+	if err == nil {
+		panic(fmt.Errorf(`expected invalid escape sequence to fail`))
+	}
+
+	lit, err := Literal("it's\\\n", DialectBigQuery)
+	try(err)
+	eq(`'it\'s\\\n'`, lit.String())
+
+	lit, err = Literal(time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC), DialectBigQuery)
+	try(err)
+	eq(`timestamp '2020-01-02 03:04:05.123456+00:00'`, lit.String())
+
+	lit, err = Literal([]byte{0x01, 0xab}, DialectBigQuery)
+	try(err)
+	eq(`from_hex('01ab')`, lit.String())
+
+	eq(`bigquery`, DialectBigQuery.String())
+}
+
+func TestDialectSQLite(_ *testing.T) {
+	const src = `select ?, ?5, ?, :one, @two, $three, $1, @@four, a$b from t where x = '?'`
+
+	parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectSQLite}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(src, nodes.String())
+
+	var params []Node
+	DeepWalkNode(nodes, func(node Node) {
+		switch node.(type) {
+		case NodeQuestionParam, NodeNamedParam, NodeAtParam, NodeDollarParam, NodeOrdinalParam:
+			params = append(params, node)
+		}
+	})
+	eq([]Node{
+		NodeQuestionParam(0),
+		NodeQuestionParam(5),
+		NodeQuestionParam(0),
+		NodeNamedParam(`one`),
+		NodeAtParam(`two`),
+		NodeDollarParam(`three`),
+		NodeOrdinalParam(1),
+	}, params)
+
+	// Without the dialect, these are plain text.
+	nodes, err = Parse(`select ?, @one, $two`)
+	try(err)
+	eq(Nodes{NodeText(`select`), NodeWhitespace(` `), NodeText(`?,`), NodeWhitespace(` `), NodeText(`@one,`), NodeWhitespace(` `), NodeText(`$two`)}, nodes)
+
+	parse := func(src string) Nodes {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectSQLite}}
+		nodes, err := parser.Parse()
+		try(err)
+		return nodes
+	}
+
+	test := func(src string, style ParamStyle, exp string) {
+		out, err := RenderParams(parse(src), style)
+		try(err)
+		eq(exp, out)
+	}
+
+	test(`select ?, ?`, ParamStyleOrdinal, `select $1, $2`)
+	test(`select ?, ?5, ?`, ParamStyleOrdinal, `select $1, $5, $6`)
+	test(`select ?, ?`, ParamStyleQuestionNumbered, `select ?1, ?2`)
+	test(`select ?1, ?2`, ParamStyleQuestion, `select ?, ?`)
+	test(`select $1, $2`, ParamStyleQuestionNumbered, `select ?1, ?2`)
+	test(`select ?2`, ParamStyleNumeric, `select :2`)
+	test(`select :one, @two, $three`, ParamStyleNamed, `select :one, :two, :three`)
+	test(`select :one, @two, $three`, ParamStyleAt, `select @one, @two, @three`)
+	test(`select :one, @two, $three`, ParamStyleDollar, `select $one, $two, $three`)
+
+	_, err = RenderParams(parse(`select ?2, ?1`), ParamStyleQuestion)
+	eq(`[sqlp] can't render ordinal param $2 as "?": expected $1`, err.Error())
+
+	_, err = RenderParams(parse(`select $one`), ParamStyleQuestion)
+	eq(`[sqlp] can't render param $one in style "question"`, err.Error())
+
+	eq(`dollar`, ParamStyleDollar.String())
+	eq(`question_numbered`, ParamStyleQuestionNumbered.String())
+
+	try(CheckParamConsistency(parse(`select ?, ?`)))
+	try(CheckParamConsistency(parse(`select $one, $two`)))
+	eq(true, CheckParamConsistency(parse(`select ?, ?2`)) != nil)
+	eq(true, CheckParamConsistency(parse(`select :one, $two`)) != nil)
+
+	out, err := Interpolate(parse(`select ?, ?3, ?`), DialectSQLite, []interface{}{10, 20, 30, 40})
+	try(err)
+	eq(`select 10, 30, 40`, out)
+
+	_, err = Interpolate(parse(`select $one`), DialectSQLite, nil)
+	eq(`[sqlp] can't interpolate named parameter "$one"`, err.Error())
+
+	_, _, err = BindNamed(parse(`select :one, ?`), map[string]interface{}{`one`: 1})
+	eq(`[sqlp] can't bind named parameters in a query with parameter "?"`, err.Error())
+
+	out, err = RenderDialect(parse(`select ?, ?1, $one, @two`), DialectSQLite)
+	try(err)
+	eq(`select ?, ?1, $one, @two`, out)
+
+	_, err = RenderDialect(parse(`select ?1`), DialectMySQL)
+	eq(true, err != nil)
+	_, err = RenderDialect(parse(`select ?`), DialectMySQL)
+	try(err)
+}
+
+func TestQuoteEscape(_ *testing.T) {
+	const src = `select E'it\'s', e'a\\', E'it''s\n', 'b\', date'2020-01-01'`
+
+	nodes, err := Parse(src)
+	try(err)
+	eq(src, nodes.String())
+
+	eq(Nodes{
+		NodeText(`select`),
+		NodeWhitespace(` `),
+		NodeQuoteEscape{Text: `it\'s`},
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeQuoteEscape{Text: `a\\`, Lower: true},
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeQuoteEscape{Text: `it''s\n`},
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeQuoteSingle(`b\`),
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeText(`date`),
+		NodeQuoteSingle(`2020-01-01`),
+	}, nodes)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `select E'a\'`, Dialect: DialectMSSQL}}
+	nodes, err = parser.Parse()
+	try(err)
+	eq(Nodes{NodeText(`select`), NodeWhitespace(` `), NodeText(`E`), NodeQuoteSingle(`a\`)}, nodes)
+
+	_, err = Parse(`select E'one\'`)
+	eq(true, err != nil)
+
+	eq(`it's`, unescapePostgres(`it\'s`))
+	eq(`it's`, unescapePostgres(`it''s`))
+	eq("\b\f\n\r\t\\\"", unescapePostgres(`\b\f\n\r\t\\\"`))
+	eq("A\x07\x001", unescapePostgres(`\101\7\0001`))
+	eq("AAxxz", unescapePostgres(`\x41\x41x\xz`))
+	eq("é😀", unescapePostgres(`é\U0001F600`))
+
+	out, err := TranspileQuotes(Nodes{NodeQuoteEscape{Text: `it\'s\n`}}, DialectPostgres, DialectMySQL)
+	try(err)
+	eq("'it''s\n'", out.String())
+
+	out, err = TranspileQuotes(Nodes{NodeQuoteEscape{Text: `one`}}, DialectPostgres, DialectPostgres)
+	try(err)
+	eq(`E'one'`, out.String())
+
+	_, err = RenderDialect(Nodes{NodeQuoteEscape{Text: `one`}}, DialectMSSQL)
+	eq(true, err != nil)
+}
+
+func TestDialectMSSQL(_ *testing.T) {
+	const src = `select N'it''s', n'ü', @@rowcount, @@version() from t where a = @one`
+
+	parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectMSSQL}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(src, nodes.String())
+
+	eq(Nodes{
+		NodeText(`select`),
+		NodeWhitespace(` `),
+		NodeQuoteNational{Text: `it''s`},
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeQuoteNational{Text: `ü`, Lower: true},
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeSystemVariable(`rowcount`),
+		NodeText(`,`),
+		NodeWhitespace(` `),
+		NodeSystemVariable(`version`),
+		ParenNodes(nil),
+		NodeWhitespace(` `),
+		NodeText(`from`),
+		NodeWhitespace(` `),
+		NodeText(`t`),
+		NodeWhitespace(` `),
+		NodeText(`where`),
+		NodeWhitespace(` `),
+		NodeText(`a`),
+		NodeWhitespace(` `),
+		NodeText(`=`),
+		NodeWhitespace(` `),
+		NodeAtParam(`one`),
+	}, nodes)
+
+	// System variables are not mistaken for params.
+	try(CheckParamConsistency(Nodes{NodeSystemVariable(`rowcount`), NodeNamedParam(`one`)}))
+	eq(false, hasParam(Nodes{NodeSystemVariable(`rowcount`)}))
+
+	out, err := RenderDialect(nodes, DialectMSSQL)
+	try(err)
+	eq(src, out)
+
+	_, err = RenderDialect(Nodes{NodeQuoteNational{Text: `one`}}, DialectSQLite)
+	eq(true, err != nil)
+
+	transpiled, err := TranspileQuotes(Nodes{NodeQuoteNational{Text: `it''s`}}, DialectMSSQL, DialectSQLite)
+	try(err)
+	eq(`'it''s'`, transpiled.String())
+
+	transpiled, err = TranspileQuotes(Nodes{NodeQuoteNational{Text: `it''s`}}, DialectMSSQL, DialectPostgres)
+	try(err)
+	eq(`N'it''s'`, transpiled.String())
+
+	eq(`n''`, Anonymize(Nodes{NodeQuoteNational{Text: `one`, Lower: true}}).String())
+}
+
+func TestCheckANSI(_ *testing.T) {
+	test := func(src string, exp Diagnostics) {
+		diags, err := CheckANSI(src)
+		try(err)
+		eq(exp, diags)
+	}
+
+	codes := func(src string, exp ...string) {
+		diags, err := CheckANSI(src)
+		try(err)
+
+		var act []string
+		for _, diag := range diags {
+			act = append(act, diag.Code)
+		}
+		eq(exp, act)
+	}
+
+	test(`select "one", 'two' from three where four <> :five -- ok`, nil)
+
+	codes(
+		"select `one`, two::int, {three} from four where $1 != @five # comment",
+		CodeGraveQuote, CodeDoubleColon, CodeBrace, CodeOrdinalParam, CodeNotEqual, CodeAtParam, CodeHash,
+	)
+
+	codes(`select '#', "#", @@version, one@two -- #`)
+
+	diags, err := CheckANSI(`select one::int`)
+	try(err)
+	eq(Region{10, 12}, diags[0].Region)
+	eq(`10: error [E_DOUBLE_COLON] "::" casts are not standard SQL; use "cast(<expr> as <type>)"`, diags[0].String())
+
+	diags, err = CheckANSI(`select one!=two`)
+	try(err)
+	eq(Region{10, 12}, diags[0].Region)
+
+	_, err = CheckANSI(`select 'one`)
+	if err == nil {
+		panic(fmt.Errorf(`expected CheckANSI to fail on invalid input`))
+	}
+}
+
+func TestGuessDialect(_ *testing.T) {
+	test := func(src string, exp Dialect) {
+		act, conf := GuessDialect(src)
+		eq(exp, act)
+		if exp == DialectAny {
+			eq(0.0, conf)
+		} else if !(conf > 0.5 && conf <= 1) {
+			panic(fmt.Errorf(`unexpected confidence %v for %q`, conf, src))
+		}
+	}
+
+	test(``, DialectAny)
+	test(`select 1`, DialectAny)
+	test(`select '::', "$1" -- ?`, DialectAny)
+	test(`select id::text from users where id = $1`, DialectPostgres)
+	test(`select arr[1], '{}'::int[] from users where name ilike $1`, DialectPostgres)
+	test("select `id` from `users` where id = ?", DialectMySQL)
+	test(`select top 10 [id], [full name] from [users] where id = @id`, DialectMSSQL)
+	test(`select 'unterminated`, DialectAny)
+
+	_, conf := GuessDialect(`select id::text, @@version from users`)
+	if !(conf > 0 && conf < 1) {
+		panic(fmt.Errorf(`expected partial confidence for mixed evidence, got %v`, conf))
+	}
+}
+
+func TestRenderDialect(_ *testing.T) {
+	test := func(src string, dialect Dialect) {
+		nodes, err := Parse(src)
+		try(err)
+
+		out, err := RenderDialect(nodes, dialect)
+		try(err)
+		eq(src, out)
+	}
+
+	fail := func(src string, dialect Dialect) {
+		nodes, err := Parse(src)
+		try(err)
+
+		_, err = RenderDialect(nodes, dialect)
+		if err == nil {
+			panic(fmt.Errorf(`expected rendering %q for dialect %q to fail`, src, dialect))
+		}
+	}
+
+	test("select `one`, two::int", DialectAny)
+	test(`select "one", two::int`, DialectPostgres)
+	test("select `one`", DialectMySQL)
+	test("select `one`", DialectSQLite)
+	fail("select (`one`)", DialectPostgres)
+	fail(`select [two::int]`, DialectMySQL)
+	fail(`select two::int`, DialectANSI)
+
+	test(`select [some name] from [users]`, DialectMSSQL)
+	test(`select [some name] from [users]`, DialectSQLite)
+	test(`select [some name] from [users]`, DialectAny)
+	test(`select val[1], array[1, 2], '{}'::int[] from users`, DialectPostgres)
+	fail(`select [some name] from users`, DialectPostgres)
+	fail(`select id from [users]`, DialectMySQL)
+	fail(`select id from users where [id] = 1`, DialectANSI)
+
+	_, err := RenderDialect(Nodes{NodeText(`select `), BracketNodes{NodeText(`some name`)}}, DialectPostgres)
+	eq(`[sqlp] bracketed identifier "[some name]" is not valid in dialect "postgres"`, err.Error())
+
+	out, err := RenderDialect(nil, DialectPostgres)
+	try(err)
+	eq(``, out)
+
+	_, err = RenderDialect(Nodes{NodeTemplateAction(`.Table`)}, DialectPostgres)
+	if err == nil {
+		panic(fmt.Errorf(`expected rendering of template actions to fail`))
+	}
+}
+
+func TestParse_dollarQuote(_ *testing.T) {
+	test := func(src string, exp Nodes) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(`$$one$$`, Nodes{NodeQuoteDollar{Text: `one`}})
+	test(`$$$$`, Nodes{NodeQuoteDollar{}})
+	test(`$tag$one $$ 'two' $1 $tag$`, Nodes{NodeQuoteDollar{Tag: `tag`, Text: `one $$ 'two' $1 `}})
+	test(`$1 $tag$ $tag$`, Nodes{NodeOrdinalParam(1), NodeWhitespace(` `), NodeQuoteDollar{Tag: `tag`, Text: ` `}})
+	test(`$ $one`, Nodes{NodeText(`$`), NodeWhitespace(` `), NodeText(`$one`)})
+
+	_, err := Parse(`$$one`)
+	if err == nil {
+		panic(fmt.Errorf(`expected unterminated dollar quote to fail`))
+	}
+}
+
+func TestSplit(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		out, err := Split(src)
+		try(err)
+		eq(exp, out)
+	}
+
+	test(``)
+	test(` ; ;; -- comment`)
+	test(`select 1`, `select 1`)
+	test(`select 1;`, `select 1`)
+	test(`select 1; select 2`, `select 1`, `select 2`)
+	test("select ';'; -- ;\nselect \";\" /* ; */;", `select ';'`, "-- ;\nselect \";\" /* ; */")
+	test(`select (1);select[2];`, `select (1)`, `select[2]`)
+
+	test(
+		`select case when a then 1 else 2 end; select 3`,
+		`select case when a then 1 else 2 end`,
+		`select 3`,
+	)
+
+	test(
+		`begin; insert into one values (1); commit;`,
+		`begin`, `insert into one values (1)`, `commit`,
+	)
+
+	test(
+		`BEGIN TRANSACTION; select 1; END;`,
+		`BEGIN TRANSACTION`, `select 1`, `END`,
+	)
+
+	test(
+		`do $$ begin perform 1; end $$; select 2`,
+		`do $$ begin perform 1; end $$`,
+		`select 2`,
+	)
+
+	test(
+		`create function one() returns int as $body$ select 1; $body$ language sql; select one();`,
+		`create function one() returns int as $body$ select 1; $body$ language sql`,
+		`select one()`,
+	)
+
+	test(
+		`create procedure one() begin
+			if x then
+				select 1;
+			end if;
+			case y when 1 then select 2; end case;
+			while z do select 3; end while;
+			select case when a then 4 end;
+		end; select 5`,
+		`create procedure one() begin
+			if x then
+				select 1;
+			end if;
+			case y when 1 then select 2; end case;
+			while z do select 3; end while;
+			select case when a then 4 end;
+		end`,
+		`select 5`,
+	)
+
+	test(
+		`create trigger one before insert on two for each row begin set new.x = 1; end; select 3`,
+		`create trigger one before insert on two for each row begin set new.x = 1; end`,
+		`select 3`,
+	)
+
+	test(
+		`begin try select 1; end try begin catch select 2; end catch; select 3`,
+		`begin try select 1; end try begin catch select 2; end catch`,
+		`select 3`,
+	)
+
+	test(`select (case when a then 1 end); select 2`, `select (case when a then 1 end)`, `select 2`)
+	test(`select legend, ending from x; select 2`, `select legend, ending from x`, `select 2`)
+
+	_, err := Split(`select 'one`)
+	if err == nil {
+		panic(fmt.Errorf(`expected Split to fail on invalid input`))
+	}
+}
+
+func TestTokenizer_Oracle(_ *testing.T) {
+	const src = `select :1, :name, :22 from one`
+
+	nodes, err := Parse(src)
+	try(err)
+	eq(
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`:1,`), NodeWhitespace(` `),
+			NodeNamedParam(`name`), NodeText(`,`), NodeWhitespace(` `), NodeText(`:22`),
+			NodeWhitespace(` `), NodeText(`from`), NodeWhitespace(` `), NodeText(`one`),
+		},
+		nodes,
+	)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: DialectOracle}}
+	nodes, err = parser.Parse()
+	try(err)
+	eq(
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeNumericParam(1), NodeText(`,`),
+			NodeWhitespace(` `), NodeNamedParam(`name`), NodeText(`,`), NodeWhitespace(` `),
+			NodeNumericParam(22), NodeWhitespace(` `), NodeText(`from`), NodeWhitespace(` `),
+			NodeText(`one`),
+		},
+		nodes,
+	)
+	eq(src, nodes.String())
+	eq(0, NodeNumericParam(1).Index())
+
+	style, err := DetectParamStyle(src)
+	try(err)
+	eq(ParamStyleNamed, style)
+	eq(`oracle`, DialectOracle.String())
+
+	_, err = RenderDialect(nodes, DialectPostgres)
+	if err == nil {
+		panic(fmt.Errorf(`expected numeric params to be invalid in Postgres`))
+	}
+}
+
+func TestTokenizer_identDollar(_ *testing.T) {
+	test := func(dialect Dialect, src string, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: dialect}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(DialectAny, `select col$1, $1, ($2), x=$3`, Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeText(`col$1,`), NodeWhitespace(` `),
+		NodeOrdinalParam(1), NodeText(`,`), NodeWhitespace(` `), ParenNodes{NodeOrdinalParam(2)},
+		NodeText(`,`), NodeWhitespace(` `), NodeText(`x=`), NodeOrdinalParam(3),
+	})
+
+	test(DialectAny, `select ü$1, one$two$ from $two$ $$ $two$`, Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeText(`ü$1,`), NodeWhitespace(` `),
+		NodeText(`one$two$`), NodeWhitespace(` `), NodeText(`from`), NodeWhitespace(` `),
+		NodeQuoteDollar{Tag: `two`, Text: ` $$ `},
+	})
+
+	test(DialectAny, `select :one$two`, Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeNamedParam(`one`), NodeText(`$two`),
+	})
+
+	test(DialectOracle, `select :one$two, :1`, Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeNamedParam(`one$two`), NodeText(`,`),
+		NodeWhitespace(` `), NodeNumericParam(1),
+	})
+
+	test(DialectMySQL, `select :one$two`, Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeNamedParam(`one$two`),
+	})
+}
+
+func TestTokenizer_Bytewise(_ *testing.T) {
+	// Latin-1 encoded "café", which is not valid UTF-8.
+	const src = "select 'caf\xe9', \"\xe9\" from one -- \xe9\xe9\n/* \xff */ where x = $1\xe9"
+
+	for _, bytewise := range []bool{false, true} {
+		tokenizer := Tokenizer{Source: src, Bytewise: bytewise}
+		var tokens []Token
+		for {
+			tok := tokenizer.Token()
+			if tok.IsInvalid() {
+				break
+			}
+			tokens = append(tokens, tok)
+		}
+
+		eq(
+			`[0,text] "select"
+[6,whitespace] " "
+[7,quote_single] "'caf\xe9'"
+[13,text] ","
+[14,whitespace] " "
+[15,quote_double] "\"\xe9\""
+[18,whitespace] " "
+[19,text] "from"
+[23,whitespace] " "
+[24,text] "one"
+[27,whitespace] " "
+[28,comment_line] "-- \xe9\xe9\n"
+[34,comment_block] "/* \xff */"
+[41,whitespace] " "
+[42,text] "where"
+[47,whitespace] " "
+[48,text] "x"
+[49,whitespace] " "
+[50,text] "="
+[51,whitespace] " "
+[52,ordinal_param] "$1"
+[54,text] "\xe9"
+`,
+			TokensString(tokens, src),
+		)
+	}
+
+	recognize := func(tok *Tokenizer) (Token, bool) {
+		if tok.Rest()[0] == 0xa9 {
+			return Token{Region{tok.Cursor(), tok.Cursor() + 1}, TypeCustom}, true
+		}
+		return Token{}, false
+	}
+
+	// "©" in UTF-8 is "\xc2\xa9", while in Latin-1 it's "\xa9".
+	tokenizer := Tokenizer{Source: "©", Recognizers: []Recognizer{recognize}}
+	eq(Token{Region{0, 2}, TypeText}, tokenizer.Token())
+
+	tokenizer = Tokenizer{Source: "©", Recognizers: []Recognizer{recognize}, Bytewise: true}
+	eq(Token{Region{0, 1}, TypeText}, tokenizer.Token())
+	eq(Token{Region{1, 2}, TypeCustom}, tokenizer.Token())
+}
+
+func TestTokenizer_Dialect(_ *testing.T) {
+	const src = "select `one`, $$two$$, a::int, :three, b # four\n"
+
+	test := func(dialect Dialect, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: dialect}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(DialectAny, Nodes{
+		NodeText(`select`), NodeWhitespace(` `),
+		NodeQuoteGrave(`one`), NodeText(`,`), NodeWhitespace(` `),
+		NodeQuoteDollar{Text: `two`}, NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`a`), NodeDoubleColon{}, NodeText(`int,`), NodeWhitespace(` `),
+		NodeNamedParam(`three`), NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`b`), NodeWhitespace(` `), NodeText(`#`), NodeWhitespace(` `), NodeText(`four`), NodeWhitespace("\n"),
+	})
+
+	test(DialectPostgres, Nodes{
+		NodeText(`select`), NodeWhitespace(` `),
+		NodeText("`one`,"), NodeWhitespace(` `),
+		NodeQuoteDollar{Text: `two`}, NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`a`), NodeDoubleColon{}, NodeText(`int,`), NodeWhitespace(` `),
+		NodeNamedParam(`three`), NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`b`), NodeWhitespace(` `), NodeText(`#`), NodeWhitespace(` `), NodeText(`four`), NodeWhitespace("\n"),
+	})
+
+	test(DialectMySQL, Nodes{
+		NodeText(`select`), NodeWhitespace(` `),
+		NodeQuoteGrave(`one`), NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`$$two$$,`), NodeWhitespace(` `),
+		NodeText(`a::int,`), NodeWhitespace(` `),
+		NodeNamedParam(`three`), NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`b`), NodeWhitespace(` `), NodeCommentHash(" four\n"),
+	})
+
+	test(DialectANSI, Nodes{
+		NodeText(`select`), NodeWhitespace(` `),
+		NodeText("`one`,"), NodeWhitespace(` `),
+		NodeText(`$$two$$,`), NodeWhitespace(` `),
+		NodeText(`a::int,`), NodeWhitespace(` `),
+		NodeNamedParam(`three`), NodeText(`,`), NodeWhitespace(` `),
+		NodeText(`b`), NodeWhitespace(` `), NodeText(`#`), NodeWhitespace(` `), NodeText(`four`), NodeWhitespace("\n"),
+	})
+
+	// Backslash escapes in MySQL strings, but not in grave quotes.
+	parser := Parser{Tokenizer: Tokenizer{Source: "select 'it\\'s', \"a\\\"b\", `c\\`", Dialect: DialectMySQL}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(Nodes{
+		NodeText(`select`), NodeWhitespace(` `),
+		NodeQuoteSingle(`it\'s`), NodeText(`,`), NodeWhitespace(` `),
+		NodeQuoteDouble(`a\"b`), NodeText(`,`), NodeWhitespace(` `),
+		NodeQuoteGrave(`c\`),
+	}, nodes)
+
+	_, err = Parse(`select 'it\'s'`)
+	eq(true, err != nil)
+}
+
+func TestTokenizer_Keywords(_ *testing.T) {
+	keywords := NewKeywords(`select`, `FROM`, `where`)
+	eq(3, keywords.Len())
+	eq(true, keywords.Has(`select`))
+	eq(true, keywords.Has(`SeLeCt`))
+	eq(true, keywords.Has(`from`))
+	eq(false, keywords.Has(`sel`))
+	eq(false, keywords.Has(strings.Repeat(`a`, 64)))
+	eq(true, keywords.With(strings.Repeat(`A`, 64)).Has(strings.Repeat(`a`, 64)))
+	eq(4, keywords.With(`join`).Len())
+	eq(false, keywords.Has(`join`))
+	eq(false, (*Keywords)(nil).Has(`select`))
+	eq(0, (*Keywords)(nil).Len())
+
+	func() {
+		defer func() { eq(`[sqlp] invalid keyword "a.b": expected an unquoted identifier`, recover().(error).Error()) }()
+		NewKeywords(`a.b`)
+	}()
+
+	eq(true, DialectKeywords(DialectPostgres).Has(`returning`))
+	eq(false, DialectKeywords(DialectMySQL).Has(`returning`))
+	eq(true, DialectKeywords(DialectMSSQL).Has(`top`))
+	eq(false, DialectKeywords(DialectAny).Has(`top`))
+	eq(true, DialectKeywords(DialectAny).Has(`select`))
+	eq(DialectKeywords(DialectAny), DialectKeywords(Dialect(255)))
+
+	test := func(tokenizer Tokenizer, exp Nodes) {
+		parser := Parser{Tokenizer: tokenizer}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(tokenizer.Source, nodes.String())
+	}
+
+	test(
+		Tokenizer{Source: `SELECT u.from, "where", x_select, select$1, from_ from(select 1);`, Keywords: keywords},
+		Nodes{
+			NodeKeyword(`SELECT`), NodeWhitespace(` `), NodeText(`u.from,`), NodeWhitespace(` `),
+			NodeQuoteDouble(`where`), NodeText(`,`), NodeWhitespace(` `),
+			NodeText(`x_select,`), NodeWhitespace(` `),
+			NodeText(`select$1,`), NodeWhitespace(` `),
+			NodeText(`from_`), NodeWhitespace(` `),
+			NodeKeyword(`from`), ParenNodes{NodeKeyword(`select`), NodeWhitespace(` `), NodeText(`1`)}, NodeText(`;`),
+		},
+	)
+
+	test(
+		Tokenizer{Source: `select a.b from t where`, Keywords: keywords},
+		Nodes{
+			NodeKeyword(`select`), NodeWhitespace(` `), NodeText(`a.b`), NodeWhitespace(` `),
+			NodeKeyword(`from`), NodeWhitespace(` `), NodeText(`t`), NodeWhitespace(` `),
+			NodeKeyword(`where`),
+		},
+	)
+
+	test(
+		Tokenizer{Source: `select * from t`},
+		Nodes{
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`*`), NodeWhitespace(` `),
+			NodeText(`from`), NodeWhitespace(` `), NodeText(`t`),
+		},
+	)
+
+	test(
+		Tokenizer{Source: `select final`, Keywords: NewKeywords(), Plugin: &Plugin{Keywords: []string{`FINAL`}}},
+		Nodes{NodeText(`select`), NodeWhitespace(` `), NodeKeyword(`final`)},
+	)
+
+	test(
+		Tokenizer{Source: `select * from users limit 1`, Keywords: DialectKeywords(DialectPostgres), Dialect: DialectPostgres},
+		Nodes{
+			NodeKeyword(`select`), NodeWhitespace(` `), NodeText(`*`), NodeWhitespace(` `),
+			NodeKeyword(`from`), NodeWhitespace(` `), NodeText(`users`), NodeWhitespace(` `),
+			NodeKeyword(`limit`), NodeWhitespace(` `), NodeText(`1`),
+		},
+	)
+
+	tokenizer := Tokenizer{Source: `select`, Keywords: keywords}
+	eq(Token{Region{0, 6}, TypeKeyword}, tokenizer.Token())
+	eq(`keyword`, TypeKeyword.String())
+
+	parser := Parser{Tokenizer: Tokenizer{
+		Source:   `SELECT * FROM users WHERE a ORDER BY id`,
+		Keywords: DialectKeywords(DialectPostgres),
+	}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(StmtKindSelect, ClassifyStmt(nodes))
+
+	out, err := AndWhere(nodes, Nodes{NodeText(`b`)})
+	try(err)
+	eq(`SELECT * FROM users WHERE (a) and (b) ORDER BY id`, out.String())
+
+	splitter := Splitter{Tokenizer: Tokenizer{
+		Source:   `create procedure p as begin select 1; end; select 2`,
+		Keywords: DialectKeywords(DialectMSSQL),
+	}}
+	stmts, err := splitter.Split()
+	try(err)
+	eq([]string{`create procedure p as begin select 1; end`, `select 2`}, stmts)
+}
+
+func TestTokenizer_Idents(_ *testing.T) {
+	test := func(tokenizer Tokenizer, exp Nodes) {
+		parser := Parser{Tokenizer: tokenizer}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(tokenizer.Source, nodes.String())
+	}
+
+	test(
+		Tokenizer{Source: `select u.id, "Name", x::int8 from users u where id=$1 and 1e5 and a$b`, Idents: true},
+		Nodes{
+			NodeIdent(`select`), NodeWhitespace(` `),
+			NodeIdent(`u`), NodeText(`.`), NodeIdent(`id`), NodeText(`,`), NodeWhitespace(` `),
+			NodeQuoteDouble(`Name`), NodeText(`,`), NodeWhitespace(` `),
+			NodeIdent(`x`), NodeDoubleColon{}, NodeIdent(`int8`), NodeWhitespace(` `),
+			NodeIdent(`from`), NodeWhitespace(` `), NodeIdent(`users`), NodeWhitespace(` `), NodeIdent(`u`), NodeWhitespace(` `),
+			NodeIdent(`where`), NodeWhitespace(` `), NodeIdent(`id`), NodeText(`=`), NodeOrdinalParam(1), NodeWhitespace(` `),
+			NodeIdent(`and`), NodeWhitespace(` `), NodeText(`1e5`), NodeWhitespace(` `),
+			NodeIdent(`and`), NodeWhitespace(` `), NodeText(`a$b`),
+		},
+	)
+
+	test(
+		Tokenizer{Source: `SELECT t.from, a$b FROM t`, Idents: true, Keywords: DialectKeywords(DialectPostgres), Dialect: DialectPostgres},
+		Nodes{
+			NodeKeyword(`SELECT`), NodeWhitespace(` `),
+			NodeIdent(`t`), NodeText(`.`), NodeIdent(`from`), NodeText(`,`), NodeWhitespace(` `),
+			NodeIdent(`a$b`), NodeWhitespace(` `),
+			NodeKeyword(`FROM`), NodeWhitespace(` `), NodeIdent(`t`),
+		},
+	)
+
+	test(
+		Tokenizer{Source: `select :name, (ident), [other]`, Idents: true, Keywords: NewKeywords(`select`)},
+		Nodes{
+			NodeKeyword(`select`), NodeWhitespace(` `), NodeNamedParam(`name`), NodeText(`,`), NodeWhitespace(` `),
+			ParenNodes{NodeIdent(`ident`)}, NodeText(`,`), NodeWhitespace(` `), BracketNodes{NodeIdent(`other`)},
+		},
+	)
+
+	tokenizer := Tokenizer{Source: `one`, Idents: true}
+	eq(Token{Region{0, 3}, TypeIdent}, tokenizer.Token())
+	eq(`ident`, TypeIdent.String())
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `select * from users where a order by id`, Idents: true}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(StmtKindSelect, ClassifyStmt(nodes))
+
+	out, err := AndWhere(nodes, Nodes{NodeText(`b`)})
+	try(err)
+	eq(`select * from users where (a) and (b) order by id`, out.String())
+
+	splitter := Splitter{Tokenizer: Tokenizer{Source: `select case when a then b end; select 2`, Idents: true}}
+	stmts, err := splitter.Split()
+	try(err)
+	eq([]string{`select case when a then b end`, `select 2`}, stmts)
+}
+
+// Modifying the sets of "scan" must not affect the tokenizer.
+func TestTokenizer_scanCharsets(_ *testing.T) {
+	prevIdent, prevWhitespace := *scan.Ident, *scan.Whitespace
+	defer func() { *scan.Ident, *scan.Whitespace = prevIdent, prevWhitespace }()
+
+	scan.Ident.AddStr(`-`)
+	scan.Whitespace.AddStr(`x`)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `one-two x`, Idents: true}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(Nodes{NodeIdent(`one`), NodeText(`-`), NodeIdent(`two`), NodeWhitespace(` `), NodeIdent(`x`)}, nodes)
+	eq(`one`, prefixIdent(`one-two`))
+}
+
+func TestSplitter_commentHash(_ *testing.T) {
+	for _, dialect := range []Dialect{DialectMySQL, DialectBigQuery} {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: "select 1;\n# trailing\n", Dialect: dialect}}
+		stmts, err := splitter.Split()
+		try(err)
+		eq([]string{`select 1`}, stmts)
+
+		splitter = Splitter{Tokenizer: Tokenizer{Source: "# one\nselect 1; # two\nselect 2;", Dialect: dialect}}
+		stmts, err = splitter.Split()
+		try(err)
+		eq([]string{"# one\nselect 1", "# two\nselect 2"}, stmts)
+	}
+}
+
+func TestTokenizer_Unclosed(_ *testing.T) {
+	parse := func(tokenizer Tokenizer) (Nodes, Diagnostics) {
+		parser := Parser{Tokenizer: tokenizer}
+		nodes, err := parser.Parse()
+		try(err)
+		return nodes, parser.Diagnostics()
+	}
+
+	_, err := Parse(`select 'one`)
+	eq(`[sqlp] expected closing '\'', got unexpected EOF`, err.Error())
+
+	{
+		tokenizer := Tokenizer{Source: `select x'one`, Unclosed: UnclosedText}
+		tokens := []Token{tokenizer.Token(), tokenizer.Token(), tokenizer.Token(), tokenizer.Token()}
+		eq(`[0,text] "select"
+[6,whitespace] " "
+[7,text] "x'one"
+[0,invalid] ""
+`, TokensString(tokens, tokenizer.Source))
+		eq(Diagnostics(nil), tokenizer.Diagnostics())
+	}
+
+	{
+		nodes, diags := parse(Tokenizer{Source: `select /* one`, Unclosed: UnclosedText})
+		eq(Nodes{NodeText(`select`), NodeWhitespace(` `), NodeText(`/* one`)}, nodes)
+		eq(Diagnostics(nil), diags)
+	}
+
+	{
+		tokenizer := Tokenizer{Source: `select 'one`, Unclosed: UnclosedClose}
+		eq(Token{Region{0, 6}, TypeText}, tokenizer.Token())
+		eq(Token{Region{6, 7}, TypeWhitespace}, tokenizer.Token())
+		eq(Token{Region{7, 11}, TypeQuoteSingle}, tokenizer.Peek())
+		eq(Token{Region{7, 11}, TypeQuoteSingle}, tokenizer.Token())
+		eq(Token{}, tokenizer.Token())
+		eq(
+			Diagnostics{{CodeUnclosed, Region{7, 11}, `expected closing "'", got unexpected EOF`, SeverityError}},
+			tokenizer.Diagnostics(),
+		)
+	}
+
+	test := func(tokenizer Tokenizer, exp string, expClosing string) {
+		tokenizer.Unclosed = UnclosedClose
+		nodes, diags := parse(tokenizer)
+		eq(exp, nodes.String())
+		eq(1, len(diags))
+		eq(len(tokenizer.Source), diags[0].Region[1])
+		eq(fmt.Sprintf(`expected closing %q, got unexpected EOF`, expClosing), diags[0].Message)
+	}
+
+	test(Tokenizer{Source: `select /* one`}, `select /* one*/`, `*/`)
+	test(Tokenizer{Source: `select "one`}, `select "one"`, `"`)
+	test(Tokenizer{Source: `select $tag$ one`, Dialect: DialectPostgres}, `select $tag$ one$tag$`, `$tag$`)
+	test(Tokenizer{Source: `select 'one\`, Dialect: DialectMySQL}, `select 'one\\'`, `\'`)
+	test(Tokenizer{Source: `select 'one\\`, Dialect: DialectMySQL}, `select 'one\\'`, `'`)
+	test(Tokenizer{Source: `select E'one\`, Dialect: DialectPostgres}, `select E'one\\'`, `\'`)
+	test(Tokenizer{Source: `select {{ "one`, Template: TemplateGo}, `select {{ "one"}}`, `"}}`)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `select ('one`, Unclosed: UnclosedClose}}
+	_, err = parser.Parse()
+	eq(`[sqlp] missing closing delimiter ")"`, err.Error())
+}
+
+func TestTokenizer_Semicolons(_ *testing.T) {
+	const src = "select 1; select ';', x; -- ;\n(a;b);"
+
+	tokenizer := Tokenizer{Source: src, Semicolons: true}
+	var tokens []Token
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			break
+		}
+		tokens = append(tokens, tok)
+	}
+	try(CoverageCheck(src, tokens))
+	eq(`[0,text] "select"
+[6,whitespace] " "
+[7,text] "1"
+[8,semicolon] ";"
+[9,whitespace] " "
+[10,text] "select"
+[16,whitespace] " "
+[17,quote_single] "';'"
+[20,text] ","
+[21,whitespace] " "
+[22,text] "x"
+[23,semicolon] ";"
+[24,whitespace] " "
+[25,comment_line] "-- ;\n"
+[30,paren_open] "("
+[31,text] "a"
+[32,semicolon] ";"
+[33,text] "b"
+[34,paren_close] ")"
+[35,semicolon] ";"
+`, TokensString(tokens, src))
+	eq(`semicolon`, TypeSemicolon.String())
+
+	parser := Parser{Tokenizer: Tokenizer{Source: `delete from a; select (b;c);`, Semicolons: true}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(Nodes{
+		NodeText(`delete`), NodeWhitespace(` `), NodeText(`from`), NodeWhitespace(` `), NodeText(`a`), NodeSemicolon{},
+		NodeWhitespace(` `), NodeText(`select`), NodeWhitespace(` `),
+		ParenNodes{NodeText(`b`), NodeSemicolon{}, NodeText(`c`)}, NodeSemicolon{},
+	}, nodes)
+	eq(parser.Source, nodes.String())
+
+	eq(
+		`delete from a; select (b;c)`,
+		EnsureTrailingSemicolon(nodes, false).String(),
+	)
+	eq(
+		[]Diagnostic{{CodeNoWhere, Region{0, 6}, `DELETE without WHERE affects all rows`, SeverityError}},
+		RuleNoWhere.Check(nodes, parser.Source),
+	)
+
+	pat, err := ParsePattern(`a;`)
+	try(err)
+	eq([]Match{{Region{12, 14}, nil}}, pat.Match(nodes))
+
+	test := func(tokenizer Tokenizer, exp ...string) {
+		splitter := Splitter{Tokenizer: tokenizer}
+		out, err := splitter.Split()
+		try(err)
+		eq(exp, out)
+
+		tokenizer.Semicolons = true
+		splitter = Splitter{Tokenizer: tokenizer}
+		out, err = splitter.Split()
+		try(err)
+		eq(exp, out)
+	}
+
+	test(Tokenizer{Source: `begin select 1; end; select 2;;`}, `begin select 1; end`, `select 2`)
+	test(Tokenizer{Source: `begin; select 1`}, `begin`, `select 1`)
+	test(
+		Tokenizer{Source: "DELIMITER //\nselect 1; select 2//\nDELIMITER ;\nselect 3;", Script: true},
+		`DELIMITER //`, `select 1; select 2`, `DELIMITER ;`, `select 3`,
+	)
+}
+
+func TestTokenizer_MaxText(_ *testing.T) {
+	test := func(src string, max int, exp string) {
+		tokenizer := Tokenizer{Source: src, MaxText: max}
+		var tokens []Token
+		for {
+			tok := tokenizer.Token()
+			if tok.IsInvalid() {
+				break
+			}
+			tokens = append(tokens, tok)
+		}
+		eq(exp, TokensString(tokens, src))
+	}
+
+	test(`select 1,2,3,4`, 0, `[0,text] "select"
+[6,whitespace] " "
+[7,text] "1,2,3,4"
+`)
+
+	test(`select 1,2,3,4`, 3, `[0,text] "sel"
+[3,text] "ect"
+[6,whitespace] " "
+[7,text] "1,2"
+[10,text] ",3,"
+[13,text] "4"
+`)
+
+	test(`1,2,3'four'`, 6, `[0,text] "1,2,3"
+[5,quote_single] "'four'"
+`)
+
+	test(`üüü`, 3, `[0,text] "üü"
+[4,text] "ü"
+`)
+
+	const src = `select 1,2,3 from one where two = 'three'`
+	parser := Parser{Tokenizer: Tokenizer{Source: src, MaxText: 2}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(src, nodes.String())
+	eq(Nodes{NodeText(`se`), NodeText(`le`), NodeText(`ct`)}, nodes[:3])
+}
+
+func TestTokenizer_leadingJunk(_ *testing.T) {
+	test := func(src string, exp Nodes) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test("\ufeffselect 1", Nodes{
+		NodeWhitespace("\ufeff"), NodeText(`select`), NodeWhitespace(` `), NodeText(`1`),
+	})
+
+	test("\ufeff\n\u200b \u2060select", Nodes{
+		NodeWhitespace("\ufeff\n\u200b \u2060"), NodeText(`select`),
+	})
+
+	test("select \ufeff1", Nodes{
+		NodeText(`select`), NodeWhitespace(` `), NodeText("\ufeff1"),
+	})
+
+	test("\ufeff", Nodes{NodeWhitespace("\ufeff")})
+
+	nodes, err := Parse("\ufeffdelete from one")
+	try(err)
+	eq(StmtKindDelete, ClassifyStmt(nodes))
+}
+
+func TestCoverageCheck(_ *testing.T) {
+	tokenize := func(tokenizer Tokenizer) (out []Token) {
+		for {
+			tok := tokenizer.Token()
+			if tok.IsInvalid() {
+				return
+			}
+			out = append(out, tok)
+		}
+	}
+
+	srcs := []string{
+		``,
+		` `,
+		hugeQuery,
+		"\ufeff select 'one', \"two\", `three` -- four\n/* five */ from six::int",
+		`select $1, :two, :3, $tag$ seven $tag$, col$1, {{.Tmpl}} {% stmt %} {# comm #} (( [ ] { } ))`,
+		"\\copy one to stdout\nDELIMITER //\nselect 'caf\xe9'//",
+	}
+
+	configs := []Tokenizer{
+		{},
+		{Script: true},
+		{Template: TemplateJinja},
+		{Dialect: DialectOracle},
+		{Bytewise: true},
+		{Delims: []Delim{{Open: `((`, Close: `))`}}},
+		{Recognizers: []Recognizer{recognizeSigil}},
+	}
+
+	for _, src := range srcs {
+		for _, tokenizer := range configs {
+			tokenizer.Source = src
+			try(CoverageCheck(src, tokenize(tokenizer)))
+		}
+	}
+
+	fail := func(src string, tokens []Token, msg string) {
+		err := CoverageCheck(src, tokens)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			panic(fmt.Errorf(`expected error containing %q, got %v`, msg, err))
+		}
+	}
+
+	fail(`select`, nil, `tokens end at offset 0, expected to cover source length 6`)
+	fail(`select`, []Token{{Region{0, 3}, TypeText}}, `tokens end at offset 3`)
+	fail(`select`, []Token{{Region{0, 3}, TypeText}, {Region{4, 6}, TypeText}}, `doesn't start at expected offset 3`)
+	fail(`select`, []Token{{Region{0, 3}, TypeText}, {Region{2, 6}, TypeText}}, `doesn't start at expected offset 3`)
+	fail(`select`, []Token{{Region{0, 0}, TypeText}}, `is empty`)
+	fail(`select`, []Token{{Region{0, 7}, TypeText}}, `exceeds source length 6`)
+	fail(`select`, []Token{{Region{0, 6}, TypeInvalid}}, `has invalid type`)
+}
+
+func TestTokenizer_Script(_ *testing.T) {
+	test := func(src string, exp Nodes) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Script: true}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, nodes)
+		eq(src, nodes.String())
+	}
+
+	test(
+		"\\set ON_ERROR_STOP on\n  \\copy one from 'one.csv'\nselect 1 \\ 2;\n\\i two.sql",
+		Nodes{
+			NodeDirective(`\set ON_ERROR_STOP on`), NodeWhitespace("\n  "),
+			NodeDirective(`\copy one from 'one.csv'`), NodeWhitespace("\n"),
+			NodeText(`select`), NodeWhitespace(` `), NodeText(`1`), NodeWhitespace(` `),
+			NodeText(`\`), NodeWhitespace(` `), NodeText(`2;`), NodeWhitespace("\n"),
+			NodeDirective(`\i two.sql`),
+		},
+	)
+
+	nodes, err := Parse(`\copy one`)
+	try(err)
+	eq(Nodes{NodeText(`\copy`), NodeWhitespace(` `), NodeText(`one`)}, nodes)
+}
+
+func TestSplitter_Script(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: src, Script: true}}
+		out, err := splitter.Split()
+		try(err)
+		eq(exp, out)
+	}
+
+	test(
+		"\\set one 1\nselect :one;\n\\copy two from 'two;csv'\nselect 2\n\\i three.sql\n",
+		`\set one 1`,
+		`select :one`,
+		`\copy two from 'two;csv'`,
+		`select 2`,
+		`\i three.sql`,
+	)
+}
+
+func TestSplitter_BatchSeparator(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: src, Script: true, Dialect: DialectMSSQL}}
+		out, err := splitter.Split()
+		try(err)
+		eq(exp, out)
+	}
+
+	test("GO\ngo\n  Go  \n")
+
+	test(
+		"create procedure one as\nbegin\n\tselect 1;\n\tselect 2;\nend\nGO\nselect 3\ngo 5\nselect 4;\nGO",
+		"create procedure one as\nbegin\n\tselect 1;\n\tselect 2;\nend",
+		`select 3`,
+		`select 4`,
+	)
+
+	test(
+		"select 'GO'\ngoto one\nselect 1 go\nGO2\nGO",
+		"select 'GO'\ngoto one\nselect 1 go\nGO2",
+	)
+
+	// Only in MSSQL scripts.
+	splitter := Splitter{Tokenizer: Tokenizer{Source: "select 1\nGO\nselect 2", Script: true}}
+	out, err := splitter.Split()
+	try(err)
+	eq([]string{"select 1\nGO\nselect 2"}, out)
+
+	parser := Parser{Tokenizer: Tokenizer{Source: "select 1\nGO", Dialect: DialectMSSQL}}
+	nodes, err := parser.Parse()
+	try(err)
+	eq(NodeText(`GO`), nodes[len(nodes)-1])
+
+	eq(1, NodeBatchSeparator(`GO`).Count())
+	eq(1, NodeBatchSeparator(`go  `).Count())
+	eq(5, NodeBatchSeparator(`GO 5 `).Count())
+
+	parser = Parser{Tokenizer: Tokenizer{Source: "select 1\nGO\ndelete from one", Script: true, Dialect: DialectMSSQL}}
+	nodes, err = parser.Parse()
+	try(err)
+	eq(NodeBatchSeparator(`GO`), nodes[4])
+	eq(StmtKindSelect, ClassifyStmt(nodes))
+	eq(false, IsReadOnly(nodes))
+}
+
+func TestSplitter_Delimiter(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: src, Script: true}}
+		out, err := splitter.Split()
+		try(err)
+		eq(exp, out)
+	}
+
+	test(
+		`select 1;
+DELIMITER //
+create procedure one() begin select 1; if x then select 2; end if; end//
+create trigger two before insert on three for each row set new.x = '//'//
+delimiter ;
+select 4; select 5;`,
+		`select 1`,
+		`DELIMITER //`,
+		`create procedure one() begin select 1; if x then select 2; end if; end`,
+		`create trigger two before insert on three for each row set new.x = '//'`,
+		`delimiter ;`,
+		`select 4`,
+		`select 5`,
+	)
+
+	test(
+		`DELIMITER $$
+create function one() returns int begin return 1; end$$
+DELIMITER ;`,
+		`DELIMITER $$`,
+		`create function one() returns int begin return 1; end`,
+		`DELIMITER ;`,
+	)
+
+	test(`select delimiter from one; select 2`, `select delimiter from one`, `select 2`)
+
+	for _, dialect := range []Dialect{DialectMySQL, DialectPostgres, DialectMSSQL} {
+		splitter := Splitter{Tokenizer: Tokenizer{Source: "select 1;\ndelimiter //\nselect 2;", Script: true, Dialect: dialect}}
+		out, err := splitter.Split()
+		try(err)
+
+		if dialect == DialectMySQL {
+			eq([]string{`select 1`, `delimiter //`, `select 2;`}, out)
+		} else {
+			eq([]string{`select 1`, "delimiter //\nselect 2"}, out)
+		}
+	}
+}
+
+func TestFunctionBody(_ *testing.T) {
+	test := func(src string, exp string) {
+		region, err := FunctionBody(src)
+		try(err)
+		eq(exp, region.Slice(src))
+	}
+
+	fail := func(src string, msg string) {
+		_, err := FunctionBody(src)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			panic(fmt.Errorf(`expected error containing %q, got %v`, msg, err))
+		}
+	}
+
+	test(
+		`create or replace function one(val int) returns int language plpgsql as $$
+begin
+	return val + 1;
+end
+$$;`,
+		`
+begin
+	return val + 1;
+end
+`,
+	)
+
+	test(
+		`CREATE FUNCTION one() RETURNS trigger AS $body$ begin return new; end $body$ LANGUAGE plpgsql`,
+		` begin return new; end `,
+	)
+
+	test(
+		`create procedure one(in begin_date date)
+begin
+	declare val int default 0;
+	if val then select 'end'; end if;
+end`,
+		`begin
+	declare val int default 0;
+	if val then select 'end'; end if;
+end`,
+	)
+
+	test(
+		`create trigger one before insert on two for each row begin set new.x = 1; end;`,
+		`begin set new.x = 1; end`,
+	)
+
+	fail(`select 1`, `expected CREATE`)
+	fail(`create table one (id int)`, `expected CREATE FUNCTION`)
+	fail(`create function one() returns int as 'select 1'`, `failed to find function body`)
+}
+
+func TestParseFunctionBody(_ *testing.T) {
+	nodes, err := ParseFunctionBody(`create function one() returns int as $$ select $1::int $$`)
+	try(err)
+	eq(
+		Nodes{
+			NodeWhitespace(` `),
+			NodeText(`select`),
+			NodeWhitespace(` `),
+			NodeOrdinalParam(1),
+			NodeDoubleColon{},
+			NodeText(`int`),
+			NodeWhitespace(` `),
+		},
+		nodes,
+	)
+}
+
+func TestDiagnostics(_ *testing.T) {
+	one := Diagnostic{`E_ONE`, Region{10, 12}, `one`, SeverityWarning}
+	two := Diagnostic{`E_TWO`, Region{3, 4}, `two`, SeverityError}
+	three := Diagnostic{`E_THREE`, Region{10, 11}, `three`, SeverityInfo}
+	four := Diagnostic{`E_FOUR`, Region{10, 11}, `four`, SeverityWarning}
+
+	eq(Diagnostics(nil), Diagnostics(nil).Merge(nil, Diagnostics{}))
+
+	left := Diagnostics{one, two}
+	right := Diagnostics{three, four}
+	merged := left.Merge(right)
+
+	eq(Diagnostics{two, four, three, one}, merged)
+	eq(Diagnostics{one, two}, left)
+
+	eq(Diagnostics{two}, merged.Filter(SeverityError))
+	eq(Diagnostics{two, four, one}, merged.Filter(SeverityWarning))
+	eq(merged, merged.Filter(SeverityInfo))
+
+	eq(true, merged.HasErrors())
+	eq(false, right.HasErrors())
+
+	eq(nil, Diagnostics(nil).Err())
+	eq(`3: error [E_TWO] two
+10: warning [E_FOUR] four
+10: info [E_THREE] three
+10: warning [E_ONE] one`, merged.Err().Error())
+
+	eq(`Severity(7)`, Severity(7).String())
+}
+
+func TestDiagnostics_Suppress(_ *testing.T) {
+	test := func(src string, exp ...string) {
+		diags, err := CheckANSI(src)
+		try(err)
+		diags, err = diags.Suppress(src)
+		try(err)
+
+		var act []string
+		for _, diag := range diags {
+			act = append(act, diag.Code)
+		}
+		eq(exp, act)
+	}
+
+	test("select `one` -- sqlp:ignore E_GRAVE_QUOTE")
+	test("select `one` --sqlp:ignore E_DOUBLE_COLON", CodeGraveQuote)
+	test("select `one`, two::int -- sqlp:ignore")
+	test("select `one`, two::int /* sqlp:ignore E_GRAVE_QUOTE, E_DOUBLE_COLON */")
+	test("select `one`, two::int -- sqlp:ignore E_GRAVE_QUOTE", CodeDoubleColon)
+
+	test(
+		"select `one`\n-- sqlp:ignore E_GRAVE_QUOTE\nfrom `two`\nwhere `three`",
+		CodeGraveQuote, CodeGraveQuote,
+	)
+
+	test(
+		"-- sqlp:ignore E_GRAVE_QUOTE\nselect `one`\nfrom `two`",
+		CodeGraveQuote,
+	)
+
+	test(
+		"select `one` -- sqlp:ignore E_GRAVE_QUOTE\nfrom `two`",
+		CodeGraveQuote,
+	)
+
+	test(
+		"select 1\n  /* sqlp:ignore */\n  from `two`::int\n  where `three`",
+		CodeGraveQuote,
+	)
+
+	test("select '-- sqlp:ignore', `one`", CodeGraveQuote)
+
+	test(
+		"select `one`\r-- sqlp:ignore E_GRAVE_QUOTE\rfrom `two`\rwhere `three`",
+		CodeGraveQuote, CodeGraveQuote,
+	)
+}
+
+func TestVerifyCorpus(_ *testing.T) {
+	fsys := fstest.MapFS{
+		`one.sql`:           {Data: []byte(`select 1`)},
+		`two/three.SQL`:     {Data: []byte("select 1;\nselect (2")},
+		`two/four.sql`:      {Data: []byte("select 'one\n")},
+		`two/five.txt`:      {Data: []byte(`select (`)},
+		`two/six/seven.sql`: {Data: []byte("select 1)\n")},
+	}
+
+	eq(
+		`12: error [E_PARSE] two/four.sql:2:1: [sqlp] expected closing '\'', got unexpected EOF
+9: error [E_PARSE] two/six/seven.sql:1:10: [sqlp] unexpected closing ")"
+19: error [E_PARSE] two/three.SQL:2:10: [sqlp] missing closing delimiter ")"`,
+		VerifyCorpus(fsys).String(),
+	)
+
+	eq(0, len(VerifyCorpus(fstest.MapFS{`one.sql`: {Data: []byte(`select (1)`)}})))
+}
+
+func TestSeverityConfig(_ *testing.T) {
+	diags := Diagnostics{
+		{`E_ONE`, Region{1, 2}, `one`, SeverityError},
+		{`E_TWO`, Region{3, 4}, `two`, SeverityError},
+		{`E_THREE`, Region{5, 6}, `three`, SeverityInfo},
+	}
+
+	eq(
+		Diagnostics{
+			{`E_ONE`, Region{1, 2}, `one`, SeverityWarning},
+			{`E_THREE`, Region{5, 6}, `three`, SeverityInfo},
+		},
+		SeverityConfig{`E_ONE`: SeverityWarning, `E_TWO`: SeverityOff}.Apply(diags),
+	)
+
+	eq(SeverityError, diags[0].Severity)
+}
+
+func TestPosition(_ *testing.T) {
+	const src = "select 1\n\tfrom one\r\nwhere ü = 'two\nthree'"
+
+	eq(Position{1, 1}, PositionAt(src, 0))
+	eq(Position{1, 9}, PositionAt(src, 8))
+	eq(Position{2, 1}, PositionAt(src, 9))
+	eq(Position{2, 2}, PositionAt(src, 10))
+	eq(Position{2, 11}, PositionAt(src, 19))
+	eq(Position{3, 1}, PositionAt(src, 20))
+	eq(Position{4, 7}, PositionAt(src, len(src)))
+	eq(Position{1, 1}, PositionAt(src, -1))
+	eq(Position{4, 7}, PositionAt(src, len(src)+10))
+	eq(Position{1, 1}, PositionAt(``, 0))
+	eq(`2:11`, PositionAt(src, 19).String())
+	eq(Position{2, 1}, PositionAt("one\rtwo", 4))
+	eq(Position{3, 2}, PositionAt("one\r\rtwo", 6))
+
+	tokenizer := Tokenizer{Source: src}
+	var positions []string
+	for {
+		tok := tokenizer.Token()
+		if tok.IsInvalid() {
+			break
+		}
+		if tok.Type != TypeWhitespace {
+			positions = append(positions, tokenizer.Position(tok.Region[0]).String())
+		}
+	}
+	eq([]string{`1:1`, `1:8`, `2:2`, `2:7`, `3:1`, `3:7`, `3:10`, `3:12`}, positions)
+
+	tokenizer.Source = "one\ntwo"
+	eq(Position{2, 2}, tokenizer.Position(5))
+}
+
+func TestWalkNodeRegions(_ *testing.T) {
+	const src = `select (one, [two]) {{three}} /* four */`
+
+	parser := Parser{Tokenizer: Tokenizer{
+		Source: src,
+		Delims: []Delim{{Tag: `tmpl`, Open: `{{`, Close: `}}`}},
+	}}
+	nodes, err := parser.Parse()
+	try(err)
+
+	WalkNodeRegions(nodes, func(node Node, region Region) {
+		eq(node.String(), region.Slice(src))
+	})
+
+	var texts []Region
+	WalkNodeRegions(nodes, func(node Node, region Region) {
+		if _, ok := node.(NodeText); ok {
+			texts = append(texts, region)
+		}
+	})
+	eq([]Region{{0, 6}, {8, 12}, {14, 17}, {22, 27}}, texts)
+}
+
+func ruleBanText(code, text string) Rule {
+	return RuleFunc(func(nodes Nodes, _ string) (out []Diagnostic) {
+		WalkNodeRegions(nodes, func(node Node, region Region) {
+			if isKeyword(node, text) {
+				out = append(out, Diagnostic{Code: code, Region: region, Message: `banned: ` + text})
+			}
+		})
+		return
+	})
+}
+
+func TestLinter(_ *testing.T) {
+	linter := Linter{
+		Rules: []Rule{
+			ruleBanText(`E_ONE`, `one`),
+			ruleBanText(`E_TWO`, `two`),
+			nil,
+			RuleFunc(nil),
+		},
+		Severity: SeverityConfig{`E_TWO`: SeverityWarning},
+	}
+
+	diags, err := linter.Lint("select two from (one)\nfrom one -- sqlp:ignore E_ONE\nwhere two")
+	try(err)
+	eq(
+		Diagnostics{
+			{`E_TWO`, Region{7, 10}, `banned: two`, SeverityWarning},
+			{`E_ONE`, Region{17, 20}, `banned: one`, SeverityError},
+			{`E_TWO`, Region{58, 61}, `banned: two`, SeverityWarning},
+		},
+		diags,
+	)
+
+	_, err = linter.Lint(`select (`)
+	if err == nil {
+		panic(fmt.Errorf(`expected Lint to fail on invalid input`))
+	}
+
+	linter.Rules = []Rule{RuleFunc(func(Nodes, string) []Diagnostic {
+		panic(fmt.Errorf(`rule failure`))
+	})}
+	_, err = linter.Lint(`select 1`)
+	eq(`rule failure`, err.Error())
+}
+
+func TestPattern(_ *testing.T) {
+	test := func(pattern, src string, exp ...string) {
+		pat, err := ParsePattern(pattern)
+		try(err)
+		eq(pattern, pat.String())
+
+		nodes, err := Parse(src)
+		try(err)
+
+		var out []string
+		for _, match := range pat.Match(nodes) {
+			str := match.Region.Slice(src)
+			if match.Binds != nil {
+				str += fmt.Sprint(` `, match.Binds)
+			}
+			out = append(out, str)
+		}
+		eq(exp, out)
+	}
+
+	test(`count(*)`, `select count(*), COUNT( * ), count(id), count(*, 1)`, `count(*)`, `COUNT( * )`)
+	test(`count(:arg)`, `select count(id), count(*), count(a, b)`, `count(id) map[arg:id]`, `count(*) map[arg:*]`)
+	test(`count(...)`, `select count(), count(a, b)`, `count()`, `count(a, b)`)
+	test(`_ = :_`, `select * from t where a=$1 and b = 'two' and c<=d`, `a=$1`, `b = 'two'`)
+	test(`:one = :one`, `where a = a and b = c and (x) = (x)`, `a = a map[one:a]`, `(x) = (x) map[one:(x)]`)
+	test(`where ... order`, `select * from t where a = 1 and (b) order by c`, `where a = 1 and (b) order`)
+	test(`a.b`, `select a.b, a . b, a.bc, "a".b`, `a.b`, `a . b`)
+	test(`x::int`, `select x::int, x :: int, x::text`, `x::int`, `x :: int`)
+	test(`'one'`, `select 'one', 'two', "one"`, `'one'`)
+	test(`coalesce(_, _)`, `select coalesce(coalesce(a, b), c)`, `coalesce(a, b)`)
+	test(`coalesce(..., _)`, `select coalesce(coalesce(a, b), c)`, `coalesce(coalesce(a, b), c)`, `coalesce(a, b)`)
+	test(`in (...)`, `select 1 -- in (2)
+where a in (1, 2) and b in [1] and c in ( /* */ )`, `in (1, 2)`, `in ( /* */ )`)
+
+	_, err := ParsePattern(``)
+	eq(`[sqlp] invalid pattern "": expected at least one node`, err.Error())
+
+	_, err = ParsePattern(`count(`)
+	eq(`[sqlp] missing closing delimiter ")"`, err.Error())
+
+	pat, err := ParsePattern(`nvl(:val, :default)`)
+	try(err)
+
+	out, err := pat.Replace(`select nvl(a, 0), NVL((b), 'two') from t`, func(match Match) string {
+		return `coalesce(` + match.Binds[`val`].String() + `, ` + match.Binds[`default`].String() + `)`
+	})
+	try(err)
+	eq(`select coalesce(a, 0), coalesce((b), 'two') from t`, out)
+
+	{
+		pat, err := ParsePattern(`one(...)`)
+		try(err)
+		out, err := pat.Replace(`select one(one(a)), one(b)`, func(Match) string { return `two` })
+		try(err)
+		eq(`select two, two`, out)
+	}
+
+	linter := Linter{Rules: []Rule{pat.Rule(`E_NVL`, `use coalesce`, SeverityWarning)}}
+	diags, err := linter.Lint(`select nvl(a, 0)`)
+	try(err)
+	eq(Diagnostics{{`E_NVL`, Region{7, 16}, `use coalesce`, SeverityWarning}}, diags)
+}
+
+func TestStarterRules(_ *testing.T) {
+	test := func(rule Rule, src string, exp ...string) {
+		for _, nodes := range parseModes(src) {
+			diags := Diagnostics(rule.Check(nodes, src))
+			diags.Sort()
+
+			var act []string
+			for _, diag := range diags {
+				act = append(act, diag.Region.Slice(src))
+			}
+			eq(exp, act)
+		}
+	}
+
+	test(RuleSelectStar, `select one, two from three`)
+	test(RuleSelectStar, `select count(*), one * two from three`)
+	test(RuleSelectStar, `select * from one`, `*`)
+	test(RuleSelectStar, `select one.*, two from one`, `one.*`)
+	test(RuleSelectStar, `select "one".* from one`, `"one".*`)
+	test(RuleSelectStar, `select *, (select two.* from two) from one; select * from three`, `*`, `two.*`, `*`)
+	test(RuleSelectStar, `select '*' from one -- select *`)
+
+	test(RuleNoWhere, `delete from one where id = 1`)
+	test(RuleNoWhere, `delete from one`, `delete`)
+	test(RuleNoWhere, `UPDATE one SET two = 3; update one set two = 3 where id = 4`, `UPDATE`)
+	test(RuleNoWhere, `with one as (delete from two returning *) update three set four = 5`, `delete`, `update`)
+	test(RuleNoWhere, `select * from one for update`)
+	test(RuleNoWhere, `insert into one values (1) on conflict (id) do update set two = 3`)
+	test(RuleNoWhere, `create table one (two int references three on delete cascade)`)
+	test(RuleNoWhere, `update t set a = 1`, `update`)
+
+	test(RuleCrossJoin, `select * from one join two on one.id = two.id left join three using (id)`)
+	test(RuleCrossJoin, `select * from one join two where one.id = two.id`, `join`)
+	test(RuleCrossJoin, `select * from one left outer join two inner join three on true`, `join`)
+	test(RuleCrossJoin, `select * from one cross join two`, `cross join`)
+	test(RuleCrossJoin, `select * from one cross join two where one.id = two.id`)
+	test(RuleCrossJoin, `select * from one natural join two`)
+
+	test(RuleNaturalJoin, `select * from one join two using (id)`)
+	test(RuleNaturalJoin, `select * from one natural left join two`, `natural`)
+
+	test(RuleCastUnknown, `select $1::text, 'unknown'`)
+	test(RuleCastUnknown, `select $1::unknown, $2::UNKNOWN, (3::unknown)`, `::unknown`, `::UNKNOWN`, `::unknown`)
+	test(RuleCastUnknown, `select $1::unknown_type`)
+
+	linter := Linter{Rules: StarterRules()}
+	diags, err := linter.Lint(`delete from one; select * from two natural join three`)
+	try(err)
+	eq(
+		`0: error [E_NO_WHERE] DELETE without WHERE affects all rows
+24: warning [E_SELECT_STAR] avoid "select *"; list the columns explicitly
+35: warning [E_NATURAL_JOIN] avoid NATURAL JOIN; specify the join condition explicitly`,
+		diags.String(),
+	)
+}
+
+func TestExplained(_ *testing.T) {
+	test := func(src string, opts ExplainOptions, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, Explained(nodes, opts).String())
+		eq(src, nodes.String())
+	}
+
+	full := ExplainOptions{Analyze: true, Buffers: true, Format: `JSON`}
+
+	test(`select 1`, ExplainOptions{}, `explain select 1`)
+	test("\n  select 1;\n", full, "\n  explain (analyze, buffers, format json) select 1;\n")
+	test(`with one as (select 1) select * from one`, ExplainOptions{Buffers: true}, `explain (buffers) with one as (select 1) select * from one`)
+	test(`delete from one`, ExplainOptions{Format: `json`}, `explain (format json) delete from one`)
+	test(`delete from one;`, full, ``)
+	test(`delete from one;`, ExplainOptions{Analyze: true, WrapDML: true}, `begin; explain (analyze) delete from one; rollback;`)
+	test(`with one as (select 1) insert into two select * from one`, full, ``)
+	test(`with one as (delete from two returning *) select * from one`, full, ``)
+
+	mysql := full
+	mysql.Dialect = DialectMySQL
+	test(`select 1`, mysql, `explain analyze format=json select 1`)
+	test(`select 1`, ExplainOptions{Dialect: DialectMySQL}, `explain select 1`)
+	mysql.WrapDML = true
+	test(`update one set two = 3`, mysql, `begin; explain analyze format=json update one set two = 3; rollback;`)
+
+	test(`select 1`, ExplainOptions{Dialect: DialectSQLite, Analyze: true}, `explain query plan select 1`)
+	test(`select 1`, ExplainOptions{Dialect: DialectMSSQL}, ``)
+}
+
+func TestClassifyStmt(_ *testing.T) {
+	test := func(src string, exp StmtKind) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, ClassifyStmt(nodes))
+	}
+
+	test(``, StmtKindUnknown)
+	test(` -- comment`, StmtKindUnknown)
+	test(`;`, StmtKindUnknown)
+	test(`select 1`, StmtKindSelect)
+	test(`(select 1) union (select 2)`, StmtKindSelect)
+	test(`VALUES (1), (2)`, StmtKindSelect)
+	test(`with one as (delete from two returning *) select * from one`, StmtKindSelect)
+	test(`insert into one values (1)`, StmtKindInsert)
+	test(`with one as (select 1) update two set three = 4`, StmtKindUpdate)
+	test(`delete from one; select 1`, StmtKindDelete)
+	test(`merge into one using two on true when matched then delete`, StmtKindMerge)
+	test(`create table one (id int)`, StmtKindDDL)
+	test(`Drop Table one`, StmtKindDDL)
+	test(`vacuum`, StmtKindOther)
+	test(`with one as (select 1)`, StmtKindOther)
+
+	test(`begin`, StmtKindBegin)
+	test(`BEGIN;`, StmtKindBegin)
+	test(`begin isolation level serializable`, StmtKindBegin)
+	test(`begin tran`, StmtKindBegin)
+	test(`start transaction read only`, StmtKindBegin)
+	test(`begin select 1; end`, StmtKindOther)
+	test(`commit`, StmtKindCommit)
+	test(`COMMIT WORK;`, StmtKindCommit)
+	test(`end transaction`, StmtKindCommit)
+	test(`commit prepared 'one'`, StmtKindOther)
+	test(`rollback`, StmtKindRollback)
+	test(`abort;`, StmtKindRollback)
+	test(`rollback to one`, StmtKindRollbackTo)
+	test(`rollback work to savepoint one`, StmtKindRollbackTo)
+	test(`savepoint one`, StmtKindSavepoint)
+	test(`save tran one`, StmtKindSavepoint)
+	test(`release savepoint one`, StmtKindRelease)
+	test(`set transaction isolation level read committed`, StmtKindSetTransaction)
+	test(`set session characteristics as transaction read only`, StmtKindSetTransaction)
+	test(`set search_path = one`, StmtKindOther)
+
+	eq(`StmtKind(100)`, StmtKind(100).String())
+	eq(true, StmtKindBegin.IsTransaction())
+	eq(true, StmtKindSetTransaction.IsTransaction())
+	eq(false, StmtKindSelect.IsTransaction())
+	eq(false, StmtKindOther.IsTransaction())
+}
+
+func TestIsReadOnly(_ *testing.T) {
+	test := func(src string, exp bool) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, IsReadOnly(nodes))
+	}
+
+	test(``, false)
+	test(`select 1`, true)
+	test(`select * from one where id in (select id from two);`, true)
+	test(`with one as (select 1) select * from one`, true)
+	test(`select 1; select 2;`, true)
+	test(`select 1; delete from one`, false)
+	test(`insert into one values (1)`, false)
+	test(`with one as (delete from two returning *) select * from one`, false)
+	test(`with one as (with two as (update three set four = 5 returning *) select * from two) select * from one`, false)
+	test(`select * into one from two`, false)
+	test(`select * from one for update`, false)
+	test(`select * from one for no key update skip locked`, false)
+	test(`select * from one for share`, false)
+	test(`select 'delete', "update" from one -- insert`, true)
+	test(`create table one (id int)`, false)
+}
+
+func TestAssertReadOnly(_ *testing.T) {
+	test := func(src string) {
+		for _, nodes := range parseModes(src) {
+			try(AssertReadOnly(nodes))
+		}
+	}
+
+	fail := func(src, exp string) {
+		for _, nodes := range parseModes(src) {
+			err := AssertReadOnly(nodes)
+			if err == nil {
+				panic(fmt.Errorf(`expected error for %q`, src))
+			}
+			eq(exp, err.Error())
+		}
+	}
+
+	test(`select 1`)
+	test(`select 1; select 2;`)
+	test(`with one as (select 1) select * from one where id in (select id from two)`)
+	test(`select 'delete', "update" from one -- insert`)
+	test(`select 1 /* drop table one */`)
+
+	fail(``, `[sqlp] expected read-only statement, got no statements`)
+	fail(`select 1; delete from one`, `[sqlp] expected read-only statement, got delete statement`)
+	fail(`create table one (id int)`, `[sqlp] expected read-only statement, got ddl statement`)
+	fail(`with one as (delete from two returning *) select * from one`, `[sqlp] expected read-only statement, found keyword "delete"`)
+	fail(`select * into one from two`, `[sqlp] expected read-only statement, found keyword "into"`)
+	fail(`select * from one for update`, `[sqlp] expected read-only statement, found keyword "update"`)
+	fail(`select * from one where x=(select 1)+Update.id`, `[sqlp] expected read-only statement, found keyword "Update"`)
+	fail(`select $$; drop table one; $$`, `[sqlp] expected read-only statement, found keyword "drop"`)
+	fail("select `; drop table one; `", `[sqlp] expected read-only statement, found keyword "drop"`)
+	fail(`select '\'' ; delete from one; -- '`, `[sqlp] expected read-only statement, found ambiguous backslash in "'\\'"`)
+	fail("select 1 --x; delete from one\n", `[sqlp] expected read-only statement, found keyword "delete"`)
+	fail(`select 1 /*! ; delete from one */`, `[sqlp] expected read-only statement, found keyword "delete"`)
+	fail(`select 1 # delete from one`, `[sqlp] expected read-only statement, found keyword "delete"`)
+	fail(`select E'\' ; delete from users; --'`, `[sqlp] expected read-only statement, found ambiguous backslash in "E'\\' ; delete from users; --'"`)
+
+	// Quotes and comments specific to other dialects.
+	failDialect := func(dialect Dialect, src, exp string) {
+		parser := Parser{Tokenizer: Tokenizer{Source: src, Dialect: dialect}}
+		nodes, err := parser.Parse()
+		try(err)
+		eq(exp, AssertReadOnly(nodes).Error())
+	}
+
+	failDialect(DialectMSSQL, `select N'a\b'`, `[sqlp] expected read-only statement, found ambiguous backslash in "N'a\\b'"`)
+	failDialect(DialectBigQuery, `select '''x' ; delete from users; --'''`, `[sqlp] expected read-only statement, found keyword "delete"`)
+	failDialect(DialectBigQuery, `select """x" ; delete from users; --"""`, `[sqlp] expected read-only statement, found keyword "delete"`)
+	failDialect(DialectMySQL, "select 1 # ; delete from users\n", `[sqlp] expected read-only statement, found keyword "delete"`)
+}
+
+func TestSourceMap(_ *testing.T) {
+	const src = `select * from one where a or b order by id`
+
+	nodes, err := Parse(src)
+	try(err)
+
+	out, err := AndWhere(nodes, Nodes{NodeText(`tenant_id`), NodeText(`=`), NodeOrdinalParam(1)})
+	try(err)
+
+	rewritten := out.String()
+	eq(`select * from one where (a or b) and (tenant_id=$1) order by id`, rewritten)
+
+	srcMap := NewSourceMap(nodes, out)
+	eq(
+		SourceMap{
+			{Src: Region{0, 24}, Out: Region{0, 24}},
+			{Src: Region{24, 30}, Out: Region{25, 31}},
+			{Src: Region{30, 31}, Out: Region{32, 33}},
+			{Src: Region{31, 42}, Out: Region{52, 63}},
+		},
+		srcMap,
+	)
+
+	source := func(pos int, exp int, ok bool) {
+		act, actOk := srcMap.Source(pos)
+		eq(ok, actOk)
+		eq(exp, act)
+	}
+
+	source(0, 0, true)
+	source(strings.Index(rewritten, `b)`), strings.Index(src, `b `), true)
+	source(strings.Index(rewritten, `(a`), 0, false)
+	source(strings.Index(rewritten, `tenant_id`), 0, false)
+	source(strings.Index(rewritten, `order`), strings.Index(src, `order`), true)
+	source(len(rewritten)-1, len(src)-1, true)
+	source(len(rewritten), 0, false)
+
+	pos, ok := srcMap.Output(strings.Index(src, `id`))
+	eq(true, ok)
+	eq(strings.LastIndex(rewritten, `id`), pos)
+
+	eq(SourceMap(nil), NewSourceMap(nil, nil))
+
+	srcMap = NewSourceMap(Nodes{NodeText(`one`)}, Nodes{NodeText(`two`), NodeText(`one`)})
+	eq(SourceMap{{Src: Region{0, 3}, Out: Region{3, 6}}}, srcMap)
+}
+
+type pgconnError struct{ Position int32 }
+
+func (pgconnError) Error() string { return `pgconn error` }
+
+type pqError struct{ Position string }
+
+func (pqError) Error() string { return `pq error` }
+
+func TestMapErrorPosition(_ *testing.T) {
+	srcMap := SourceMap{
+		{Src: Region{0, 10}, Out: Region{5, 15}},
+		{Src: Region{10, 20}, Out: Region{25, 35}},
+	}
+
+	eq(0, MapErrorPosition(nil, 3))
+	eq(0, MapErrorPosition(srcMap, 2))
+	eq(0, MapErrorPosition(srcMap, 5))
+	eq(7, MapErrorPosition(srcMap, 12))
+	eq(10, MapErrorPosition(srcMap, 15))
+	eq(10, MapErrorPosition(srcMap, 20))
+	eq(15, MapErrorPosition(srcMap, 30))
+	eq(20, MapErrorPosition(srcMap, 40))
+
+	const src = `select * from one where é = 'x'`
+	nodes, err := Parse(src)
+	try(err)
+
+	out, err := AndWhere(nodes, Nodes{NodeText(`tenant_id`), NodeText(`=`), NodeOrdinalParam(1)})
+	try(err)
+
+	query := out.String()
+	eq(`select * from one where (é = 'x') and (tenant_id=$1)`, query)
+	srcMap = NewSourceMap(nodes, out)
+
+	// Postgres positions are 1-based and count characters rather than bytes.
+	test := func(err error, exp int, ok bool) {
+		act, actOk := PostgresErrorPosition(err, srcMap, query)
+		eq(ok, actOk)
+		eq(exp, act)
+	}
+
+	test(nil, 0, false)
+	test(fmt.Errorf(`no position`), 0, false)
+	test(&pgconnError{}, 0, false)
+	test(&pgconnError{Position: 100}, 0, false)
+	test(&pgconnError{Position: 1}, 0, true)
+	test(&pgconnError{Position: 28}, strings.Index(src, `=`), true)
+	test(pqError{Position: `28`}, strings.Index(src, `=`), true)
+	test(fmt.Errorf(`wrapped: %w`, &pgconnError{Position: 28}), strings.Index(src, `=`), true)
+	test(&pgconnError{Position: 42}, len(src), true)
+}
+
+func TestWithMeta(_ *testing.T) {
+	test := func(src string, meta map[string]string, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		out, err := WithMeta(nodes, meta)
+		try(err)
+		eq(exp, out.String())
+		eq(src, nodes.String())
+	}
+
+	test(``, map[string]string{`one`: `two`}, `/* sqlp: one=two */`)
+
+	test(
+		`select 1`,
+		map[string]string{`timeout`: `5s`, `app`: `checkout`},
+		`/* sqlp: app=checkout timeout=5s */ select 1`,
+	)
+
+	test(
+		`/* sqlp: app=checkout timeout=5s */ select 1`,
+		map[string]string{`timeout`: `10s`, `user`: `one two`},
+		`/* sqlp: app=checkout timeout=10s user=one+two */ select 1`,
+	)
+
+	test(
+		`/* other */ select 1`,
+		map[string]string{`note`: `*/ drop table one; /*`},
+		`/* sqlp: note=%2A%2F+drop+table+one%3B+%2F%2A */ /* other */ select 1`,
+	)
+
+	test(
+		`/* sqlp:ignore E_SELECT_STAR */ select * from one`,
+		map[string]string{`app`: `checkout`},
+		`/* sqlp: app=checkout */ /* sqlp:ignore E_SELECT_STAR */ select * from one`,
+	)
+
+	_, err := WithMeta(nil, map[string]string{``: `one`})
+	eq(`[sqlp] unexpected empty metadata key`, err.Error())
+
+	// Metadata and lint suppression on the same query.
+	for _, src := range []string{
+		`/* sqlp:ignore E_SELECT_STAR */` + "\n" + `select * from one`,
+		`select * from one -- sqlp:ignore E_SELECT_STAR`,
+	} {
+		nodes, err := Parse(src)
+		try(err)
+		nodes, err = WithMeta(nodes, map[string]string{`app`: `checkout`})
+		try(err)
+
+		meta, err := Meta(nodes.String())
+		try(err)
+		eq(map[string]string{`app`: `checkout`}, meta)
+
+		linter := Linter{Rules: []Rule{RuleSelectStar}}
+		diags, err := linter.Lint(nodes.String())
+		try(err)
+		eq(Diagnostics(nil), diags)
+	}
+}
+
+func TestMeta(_ *testing.T) {
+	test := func(src string, exp map[string]string) {
+		meta, err := Meta(src)
+		try(err)
+		eq(exp, meta)
+	}
+
+	test(``, nil)
+	test(`select 1`, nil)
+	test(`/* other */ select 1`, nil)
+	test(`select 1 /* sqlp: one=two */`, nil)
+	test(`/* sqlp: */ select 1`, map[string]string{})
+	test(`/*sqlp:*/ select 1`, map[string]string{})
+	test(`/* sqlp:ignore E_SELECT_STAR */ select * from one`, nil)
+	test("\n  /* sqlp: app=checkout timeout=5s */ select 1", map[string]string{`app`: `checkout`, `timeout`: `5s`})
+
+	nodes, err := WithMeta(nil, map[string]string{`note`: `*/ a=b %`})
+	try(err)
+	test(nodes.String(), map[string]string{`note`: `*/ a=b %`})
+
+	_, err = Meta(`/* sqlp: one */`)
+	eq(`[sqlp] malformed metadata pair "one": expected "key=value"`, err.Error())
+}
+
+func TestWithComment(_ *testing.T) {
+	test := func(src string, kv map[string]string, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, WithComment(nodes, kv).String())
+		eq(src, nodes.String())
+	}
+
+	kv := map[string]string{
+		`traceparent`: `00-abc-def-01`,
+		`route`:       `/polls 1000`,
+		`action`:      `it's`,
+	}
+	const comment = `/*action='it%27s',route='%2Fpolls%201000',traceparent='00-abc-def-01'*/`
+
+	test(`select 1`, nil, `select 1`)
+	test(``, kv, comment)
+	test(`select 1`, kv, `select 1 `+comment)
+	test(`select 1;`, kv, `select 1 `+comment+`;`)
+	test("select 1 ;\n", kv, `select 1 `+comment+" ;\n")
+	test("select 1 -- one\n;;", kv, `select 1 `+comment+" -- one\n;;")
+	test(`select (1);`, kv, `select (1) `+comment+`;`)
+}
+
+func TestStripSQLCommenter(_ *testing.T) {
+	test := func(src string, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, StripSQLCommenter(nodes).String())
+		eq(src, nodes.String())
+	}
+
+	test(``, ``)
+	test(`select 1`, `select 1`)
+	test(`select 1 /* other */;`, `select 1 /* other */;`)
+	test(`select 1 /* a=b */;`, `select 1 /* a=b */;`)
+	test(`select 1 /* a='b' c */;`, `select 1 /* a='b' c */;`)
+	test(`select 1 /*a='b'*/;`, `select 1;`)
+	test(`select 1 /*a='b'*/ ;`, `select 1 ;`)
+	test(`select 1 /*a='b',traceparent='00-abc-def-01'*/`, `select 1`)
+	test(`select 1 /* a='it\'s' */ -- one`, `select 1 -- one`)
+	test(`select /*a='b'*/ 1`, `select 1`)
+	test(`select/*a='b'*/1`, `select 1`)
+	test(`/*a='b'*/ select 1`, ` select 1`)
+
+	nodes, err := Parse(`select 1;`)
+	try(err)
+	eq(`select 1;`, StripSQLCommenter(WithComment(nodes, map[string]string{`one`: `two three`})).String())
+}
+
+func TestFingerprint(_ *testing.T) {
+	test := func(src string, exp string) {
+		nodes, err := Parse(src)
+		try(err)
+		eq(exp, Fingerprint(nodes))
+	}
+
+	test(``, ``)
+	test(`select 1`, `select 1`)
+	test("\n\tselect  *\n  from one\n\twhere id = $1 -- comment\n  ", "select * from one where id = $1 -- comment")
+	test(`select ( 1 ,  '  two  ' ) /* three  four */`, `select ( 1 , '  two  ' ) /* three  four */`)
+	test(`select  1 /*traceparent='00-abc-def-01'*/;`, `select 1;`)
+	test(`select 1 /*traceparent='00-ghi-jkl-01'*/;`, `select 1;`)
+	eq(``, Fingerprint(nil))
+}
+
+func TestStmtCache(_ *testing.T) {
+	var conn stmtConn
+	cache := StmtCache{DB: sql.OpenDB(&conn)}
+	ctx := context.Background()
+
+	prepare := func(src string) *sql.Stmt {
+		nodes, err := Parse(src)
+		try(err)
+		stmt, err := cache.Prepare(ctx, nodes)
+		try(err)
+		return stmt
+	}
+
+	one := prepare(`select * from one where id = $1`)
+	two := prepare("select *\n  from one\n  where id = $1")
+	three := prepare(`select * from two`)
+
+	eq(true, one == two)
+	eq(false, one == three)
+	eq(2, cache.Len())
+	eq([]string{`select * from one where id = $1`, `select * from two`}, conn.prepared)
+
+	try(cache.Close())
+	eq(0, cache.Len())
+	eq(2, conn.closed)
+
+	prepare(`select * from two`)
+	eq(1, cache.Len())
+
+	conn.prepared = nil
+	four := prepare(`select * from three /*traceparent='00-one-01'*/`)
+	five := prepare(`select * from three /*traceparent='00-two-01'*/`)
+
+	eq(true, four == five)
+	eq([]string{`select * from three`}, conn.prepared)
+}
+
+// Minimal `database/sql` driver that records prepared queries.
+type stmtConn struct {
+	prepared []string
+	closed   int
+}
+
+func (self *stmtConn) Connect(context.Context) (driver.Conn, error) { return self, nil }
+func (self *stmtConn) Driver() driver.Driver                        { return nil }
+func (self *stmtConn) Begin() (driver.Tx, error)                    { return nil, driver.ErrSkip }
+func (self *stmtConn) Close() error                                 { return nil }
+
+func (self *stmtConn) Prepare(query string) (driver.Stmt, error) {
+	self.prepared = append(self.prepared, query)
+	return stmtDriverStmt{self}, nil
+}
+
+type stmtDriverStmt struct{ conn *stmtConn }
+
+func (self stmtDriverStmt) Close() error {
+	self.conn.closed++
+	return nil
+}
+
+func (self stmtDriverStmt) NumInput() int { return -1 }
+
+func (self stmtDriverStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (self stmtDriverStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+// Parses the source with each opt-in tokenizer mode. Results of AST analysis
+// must not depend on the mode.
+func parseModes(src string) (out []Nodes) {
+	for _, tokenizer := range []Tokenizer{
+		{Source: src},
+		{Source: src, Keywords: DialectKeywords(DialectAny)},
+		{Source: src, Idents: true},
+		{Source: src, Keywords: DialectKeywords(DialectAny), Idents: true, Semicolons: true},
+	} {
+		parser := Parser{Tokenizer: tokenizer}
+		nodes, err := parser.Parse()
+		try(err)
+		out = append(out, nodes)
+	}
+	return
+}
+
 func try(err error) {
 	if err != nil {
 		panic(err)
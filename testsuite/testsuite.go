@@ -0,0 +1,171 @@
+/*
+Conformance cases for the tokenizer and parser of "github.com/mitranim/sqlp",
+in a machine-readable format. Intended for alternative implementations, ports
+to other languages, and dialect plugins, which can verify that they match the
+core behavior.
+
+The cases are stored in "cases.json", which is also published as part of this
+package. Each case has a source text, an optional dialect, and either the
+expected tokens, or a flag indicating that tokenizing must fail. Tokens are
+pairs of type and text, where the type is the result of "sqlp.Type.String".
+Token offsets are implied: tokens are contiguous and cover the entire source.
+
+This package doesn't depend on "sqlp", which allows to use it from tests of
+code which doesn't depend on "sqlp" either. The tests of "sqlp" verify the core
+implementation against these cases.
+*/
+package testsuite
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+)
+
+//go:embed cases.json
+var casesJSON []byte
+
+// Conformance case. See the package description.
+type Case struct {
+	// Unique name of the case.
+	Name string `json:"name"`
+
+	// Source text to tokenize and parse.
+	Src string `json:"src"`
+
+	// Name of the dialect, as returned by "sqlp.Dialect.String", or empty for
+	// the default dialect.
+	Dialect string `json:"dialect,omitempty"`
+
+	// Expected tokens. Empty if tokenizing must fail, or if the source is
+	// empty.
+	Tokens []Token `json:"tokens,omitempty"`
+
+	// True if tokenizing must fail.
+	TokenizeErr bool `json:"tokenize_err,omitempty"`
+
+	/*
+		True if parsing must fail, for example due to unbalanced parens, even
+		though tokenizing succeeds. Irrelevant when tokenizing must fail.
+	*/
+	ParseErr bool `json:"parse_err,omitempty"`
+}
+
+/*
+Verifies the output of tokenizing and parsing the source of the case. Returns
+a descriptive error for the first mismatch.
+*/
+func (self Case) Check(tokens []Token, tokenizeErr, parseErr error) error {
+	if self.TokenizeErr {
+		if tokenizeErr == nil {
+			return fmt.Errorf(`[testsuite] case %q: expected tokenizing to fail`, self.Name)
+		}
+		return nil
+	}
+	if tokenizeErr != nil {
+		return fmt.Errorf(`[testsuite] case %q: unexpected tokenizing error: %w`, self.Name, tokenizeErr)
+	}
+
+	for i := 0; i < len(tokens) || i < len(self.Tokens); i++ {
+		if i >= len(tokens) {
+			return fmt.Errorf(`[testsuite] case %q: missing token %v: expected %v`, self.Name, i, self.Tokens[i])
+		}
+		if i >= len(self.Tokens) {
+			return fmt.Errorf(`[testsuite] case %q: unexpected token %v: %v`, self.Name, i, tokens[i])
+		}
+		if tokens[i] != self.Tokens[i] {
+			return fmt.Errorf(`[testsuite] case %q: token %v mismatch: expected %v, got %v`, self.Name, i, self.Tokens[i], tokens[i])
+		}
+	}
+
+	if self.ParseErr && parseErr == nil {
+		return fmt.Errorf(`[testsuite] case %q: expected parsing to fail`, self.Name)
+	}
+	if !self.ParseErr && parseErr != nil {
+		return fmt.Errorf(`[testsuite] case %q: unexpected parsing error: %w`, self.Name, parseErr)
+	}
+	return nil
+}
+
+/*
+Token in a conformance case. Encoded in JSON as a pair of type and text, such
+as `["text", "select"]`.
+*/
+type Token struct {
+	Type string
+	Text string
+}
+
+// Implement `fmt.Stringer` for error messages.
+func (self Token) String() string { return fmt.Sprintf(`[%v %q]`, self.Type, self.Text) }
+
+// Implement `json.Marshaler`.
+func (self Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]string{self.Type, self.Text})
+}
+
+// Implement `json.Unmarshaler`.
+func (self *Token) UnmarshalJSON(src []byte) error {
+	var pair [2]string
+	err := json.Unmarshal(src, &pair)
+	if err != nil {
+		return err
+	}
+	self.Type, self.Text = pair[0], pair[1]
+	return nil
+}
+
+/*
+Returns all conformance cases, decoded from the embedded "cases.json". Each
+call returns a new slice.
+*/
+func Cases() []Case {
+	var out []Case
+	err := json.Unmarshal(casesJSON, &out)
+	if err != nil {
+		panic(fmt.Errorf(`[testsuite] failed to decode cases: %w`, err))
+	}
+	return out
+}
+
+/*
+Returns the raw content of "cases.json", for implementations which decode it
+themselves, for example in other languages.
+*/
+func CasesJSON() []byte { return append([]byte(nil), casesJSON...) }
+
+/*
+Verifies the given implementation against all cases via `Case.Check`, and
+returns all mismatches. The functions are invoked with the source and the
+dialect of each case. The parse function may be nil.
+
+Example:
+
+	func TestConformance(t *testing.T) {
+		for _, err := range testsuite.Verify(myTokenize, myParse) {
+			t.Error(err)
+		}
+	}
+*/
+func Verify(
+	tokenize func(src, dialect string) ([]Token, error),
+	parse func(src, dialect string) error,
+) []error {
+	var out []error
+	for _, val := range Cases() {
+		tokens, tokenizeErr := tokenize(val.Src, val.Dialect)
+
+		var parseErr error
+		if parse == nil {
+			val.ParseErr = false
+		} else if tokenizeErr == nil {
+			parseErr = parse(val.Src, val.Dialect)
+		}
+
+		err := val.Check(tokens, tokenizeErr, parseErr)
+		if err != nil {
+			out = append(out, err)
+		}
+	}
+	return out
+}